@@ -0,0 +1,61 @@
+package godef
+
+import (
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// adHocPackage is the import path of a package of files that cannot be
+// imported, as named by the go command.
+const adHocPackage = "command-line-arguments"
+
+// adHocFiles returns the files of the ad-hoc package of filename, a file
+// that is not part of an importable package, e.g. a scratch file outside
+// of GOPATH and any module or a file excluded by the "ignore" build tag:
+// filename and the Go files of its directory in ctxt that declare the
+// same package, so that references across the files of a scratch
+// directory are resolved.  Test files are only included if filename is
+// a test file.
+func adHocFiles(ctxt *build.Context, filename string) []string {
+	files := []string{filename}
+	fset := token.NewFileSet()
+	packageName := func(name string) string {
+		f, err := buildutil.ParseFile(fset, ctxt, nil, "", name, parser.PackageClauseOnly)
+		if err != nil {
+			return ""
+		}
+		return f.Name.Name
+	}
+	pkg := packageName(filename)
+	if pkg == "" {
+		return files
+	}
+	dir := filepath.Dir(filename)
+	fis, err := buildutil.ReadDir(ctxt, dir)
+	if err != nil {
+		return files
+	}
+	test := strings.HasSuffix(filename, "_test.go")
+	for _, fi := range fis {
+		name := fi.Name()
+		path := filepath.Join(dir, name)
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") || sameFile(path, filename) {
+			continue
+		}
+		if !test && strings.HasSuffix(name, "_test.go") {
+			continue
+		}
+		if ok, err := ctxt.MatchFile(dir, name); err != nil || !ok {
+			continue
+		}
+		if packageName(path) == pkg {
+			files = append(files, path)
+		}
+	}
+	return files
+}
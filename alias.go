@@ -0,0 +1,92 @@
+package godef
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// isAliasDecl reports whether obj, resolved by the parser, is declared
+// by a type alias declaration, e.g. "type A = B".
+func isAliasDecl(obj *ast.Object) bool {
+	spec, ok := obj.Decl.(*ast.TypeSpec)
+	return ok && spec.Assign.IsValid()
+}
+
+// resolveAlias follows obj, if it is a type alias, to the type name it
+// denotes, through any chain of aliases (type A = B; type B = C).  It
+// returns that type name, or obj if it is not an alias, and the aliases
+// that were followed in order.  An alias of an unnamed type, e.g.
+// "type A = []int", is not followed.
+func resolveAlias(lprog program, obj types.Object) (types.Object, []*types.TypeName) {
+	var chain []*types.TypeName
+	for {
+		tn, ok := obj.(*types.TypeName)
+		if !ok || !tn.IsAlias() {
+			break
+		}
+		next := aliasTarget(lprog, tn)
+		if next == nil || next == tn || len(chain) > 100 { // invalid cycle
+			break
+		}
+		chain = append(chain, tn)
+		obj = next
+	}
+	return obj, chain
+}
+
+// aliasTarget returns the type name on the right-hand side of the
+// declaration of alias, which may itself be an alias, or nil if it is
+// not a type name.  The type checker records only the final type of a
+// chain of aliases before Go 1.22, so the declaration is used if its
+// syntax and type information are loaded.
+func aliasTarget(lprog program, alias *types.TypeName) *types.TypeName {
+	if alias.Pos().IsValid() {
+		info, path, _ := lprog.PathEnclosingInterval(alias.Pos(), alias.Pos())
+		if info != nil && len(path) > 1 {
+			if spec, ok := path[1].(*ast.TypeSpec); ok && spec.Name == path[0] {
+				x := spec.Type
+				for {
+					paren, ok := x.(*ast.ParenExpr)
+					if !ok {
+						break
+					}
+					x = paren.X
+				}
+				var id *ast.Ident
+				switch x := x.(type) {
+				case *ast.Ident:
+					id = x
+				case *ast.SelectorExpr:
+					id = x.Sel
+				}
+				if tn, ok := info.Uses[id].(*types.TypeName); ok {
+					return tn
+				}
+			}
+		}
+	}
+	// The type of an alias is the type it denotes, or a *types.Alias
+	// in Go 1.22 and later, which is not named so that godef builds with
+	// older versions.
+	T := alias.Type()
+	if a, ok := T.(interface{ Rhs() types.Type }); ok {
+		T = a.Rhs()
+	}
+	if t, ok := T.(interface{ Obj() *types.TypeName }); ok && t.Obj() != alias {
+		return t.Obj()
+	}
+	return nil
+}
+
+// addAliases adds the declarations of aliases, resolved by resolveAlias,
+// to the result of q.
+func addAliases(q *Query, lprog program, qpos *queryPos, aliases []*types.TypeName) {
+	for _, a := range aliases {
+		q.result.aliases = append(q.result.aliases, definitionResult{
+			pos:   q.objectPos(lprog, a),
+			descr: qpos.objectString(a),
+			kind:  KindType,
+			id:    objectID(a),
+		})
+	}
+}
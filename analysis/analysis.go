@@ -0,0 +1,70 @@
+// Package analysis answers the definition queries of packages that were
+// type-checked by their callers, such as linters and refactoring tools,
+// with the resolution logic of godef but without loading the packages
+// again.
+//
+// Analyzer makes a Resolver available to other go/analysis analyzers
+// that require it:
+//
+//	var MyAnalyzer = &analysis.Analyzer{
+//		Requires: []*analysis.Analyzer{godefanalysis.Analyzer},
+//		Run: func(pass *analysis.Pass) (interface{}, error) {
+//			r := pass.ResultOf[godefanalysis.Analyzer].(*godefanalysis.Resolver)
+//			res, err := r.Definition(id.Pos())
+//			...
+//		},
+//	}
+//
+// Tools that type-check packages otherwise use NewResolver.
+package analysis
+
+import (
+	"go/ast"
+	"go/build"
+	"go/token"
+	"go/types"
+	"reflect"
+
+	"github.com/charlievieth/godef"
+	"golang.org/x/tools/go/analysis"
+)
+
+// Analyzer is an analyzer whose result is a *Resolver of the package of
+// the pass, configured with the default build context.
+var Analyzer = &analysis.Analyzer{
+	Name:       "godef",
+	Doc:        "answer definition queries of the package with godef",
+	Run:        run,
+	ResultType: reflect.TypeOf(new(Resolver)),
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	return NewResolver(nil, pass.Fset, pass.Files, pass.Pkg, pass.TypesInfo), nil
+}
+
+// A Resolver answers the definition queries of a type-checked package.
+// It may be used by multiple goroutines simultaneously.
+type Resolver struct {
+	engine *godef.Engine
+	pkg    *godef.CheckedPackage
+}
+
+// NewResolver returns a Resolver of the package pkg, whose files were
+// parsed with fset and type-checked with info, which must record at
+// least Defs, Uses and Selections.  Queries are configured with conf,
+// or the default build context if conf is nil.
+func NewResolver(conf *godef.Config, fset *token.FileSet, files []*ast.File, pkg *types.Package, info *types.Info) *Resolver {
+	if conf == nil {
+		conf = &godef.Config{Context: build.Default}
+	}
+	return &Resolver{
+		engine: godef.NewEngine(conf),
+		pkg:    &godef.CheckedPackage{Fset: fset, Files: files, Pkg: pkg, Info: info},
+	}
+}
+
+// Definition returns the definition of the identifier at pos, a
+// position in the files of the package of r.
+func (r *Resolver) Definition(pos token.Pos) (*godef.Result, error) {
+	return r.engine.LookupChecked(r.pkg, pos)
+}
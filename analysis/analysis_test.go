@@ -0,0 +1,83 @@
+package analysis
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/charlievieth/godef"
+	"golang.org/x/tools/go/analysis"
+)
+
+func TestResolver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const src = "package p\n\nimport \"strings\"\n\ntype T struct{ F int }\n\n" +
+		"func f(t T) {\n\t_ = t.F\n\t_ = strings.ToUpper(\"\")\n\tswitch y := interface{}(t).(type) {\n\tcase T:\n\t\t_ = y\n\t}\n}\n"
+	filename := filepath.Join(tmp, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Defs:       make(map[*ast.Ident]types.Object),
+		Uses:       make(map[*ast.Ident]types.Object),
+		Implicits:  make(map[ast.Node]types.Object),
+		Selections: make(map[*ast.SelectorExpr]*types.Selection),
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := conf.Check("p", fset, []*ast.File{f}, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pass := &analysis.Pass{Fset: fset, Files: []*ast.File{f}, Pkg: pkg, TypesInfo: info}
+	r, err := Analyzer.Run(pass)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		marker string // the query is of the identifier at marker in src
+		file   string
+		line   int // zero if it does not matter
+		kind   godef.Kind
+	}{
+		{"F\n", "p.go", 5, godef.KindProperty},
+		{"ToUpper", "strings.go", 0, godef.KindFunction},
+		{"y\n", "p.go", 11, godef.KindVariable},
+		{"T:", "p.go", 5, godef.KindType},
+	}
+	for _, test := range tests {
+		pos := token.Pos(fset.File(f.Pos()).Base() + strings.Index(src, test.marker))
+		res, err := r.(*Resolver).Definition(pos)
+		if err != nil {
+			t.Errorf("%q: %v", test.marker, err)
+			continue
+		}
+		if filepath.Base(res.Position.Filename) != test.file || test.line != 0 && res.Position.Line != test.line || res.Kind != test.kind {
+			t.Errorf("%q: got %s (%s); want: %s:%d (%s)", test.marker, res.Position, res.Kind, test.file, test.line, test.kind)
+		}
+		if res.Stats.Strategy != godef.StrategyTypeChecker || res.Stats.LoadTime != 0 {
+			t.Errorf("%q: got strategy %s, load time %v; want the type checker without loading", test.marker, res.Stats.Strategy, res.Stats.LoadTime)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package godef
+
+import (
+	"fmt"
+)
+
+// An Analyzer adds definitions related to the result of a definition
+// query to its candidates, e.g. the source a generated file was
+// generated from (see ProtoAnalyzer).  Analyzers are configured with
+// Config.Analyzers and must be safe for concurrent use if the Engine
+// is.
+type Analyzer interface {
+	// Name identifies the analyzer, it is the Reason of the
+	// candidates that do not have one.
+	Name() string
+
+	// Analyze returns the definitions related to res, which is found
+	// and whose positions have byte columns.  Its error is reported as
+	// a warning of the result.
+	Analyze(res *Result) ([]Candidate, error)
+}
+
+// runAnalyzers adds the candidates of analyzers for res to it.
+func runAnalyzers(analyzers []Analyzer, res *Result) {
+	for _, a := range analyzers {
+		candidates, err := a.Analyze(res)
+		if err != nil {
+			res.Warnings = append(res.Warnings, fmt.Errorf("analyzer %s: %w", a.Name(), err))
+			continue
+		}
+		for _, c := range candidates {
+			if c.Reason == "" {
+				c.Reason = a.Name()
+			}
+			res.Candidates = append(res.Candidates, c)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Package godef finds the definitions of Go identifiers.
+//
+// Queries are answered by an Engine, created from a Config with
+// NewEngine.  Config.Define and Config.Lookup remain as shims that
+// create an Engine for a single query.
+//
+// The stability of the exported API is divided into the following
+// tiers.
+//
+// Stable: changes are backwards compatible.  Position, Config.Context
+// and Config.Define.
+//
+// Beta: incompatible changes are preceded by a deprecation notice and
+// a shim for at least one release.  Engine, NewEngine, Config.Lookup,
+// Result, Candidate, Kind, Environment, OSEnvironment, BuildInfo,
+// Config.BuildInfoFor, Snippet and the errors: NotFoundError and the
+// errors it wraps (ErrNotFound, ErrNoIdentifier, etc.), ErrNotGoFile,
+// AmbiguousSelectionError, FileNotInPackageError and PathError.
+//
+// Experimental: may change or be removed without notice.  Resolver and
+// its implementations (GOPATHResolver, ModuleResolver, DriverResolver),
+// WorkspaceResolver, Engine.ReceiverTypeAt, Engine.AddRoot (and
+// Engine.AddIsolatedRoot), Engine.ParseFile, Engine.DescribeRange (and
+// Expression and ValueCategory), Engine.EnclosingDecl (and DeclRange),
+// Engine.LookupChecked (and CheckedPackage), Engine.Complete (and
+// Completion), Query (and QueryResult and Completions), ExitCode (and
+// the Exit constants), SymlinkPolicy, ColumnEncoding,
+// ImplementationScope, Analyzer (and ProtoAnalyzer), GoEnvContext,
+// VersionWarning, GOPATHError, PositionError, TypeCheckError,
+// ContextFromFS, QueryContext, Logger, Stats (and Strategy), Hooks (and
+// QueryInfo and the Span and Cache constants), ObjectID, ProgramCache,
+// ASTCache, PackageIndex, MemoryBudget, Config.Warm, NewHTTPHandler (and
+// HTTPHandler), Formatter and the formatter registry (RegisterFormatter,
+// LookupFormatter, FormatterNames, FormatJSON, JSONResult,
+// JSONTypeCheckError and JSONStats) and the Config fields not listed
+// above.
+//
+// Deprecated: identifiers whose documentation starts with "Deprecated:"
+// are kept for compatibility and should not be used in new code.
+package godef
@@ -0,0 +1,162 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// approximateDefinition is the fallback of definition, with
+// Config.BestEffort, for an identifier id that the type checker could
+// not resolve, e.g. because its package does not compile.  A
+// declaration of the name of id is searched for in the files of the
+// package imported by a qualified identifier, or otherwise of the
+// package of the queried file: a package-level declaration, or failing
+// that a method or struct field.  Only methods and struct fields are
+// searched for the selector of x.Sel, where x is not a package.  The
+// files are only parsed, so the declaration is merely plausible, it is
+// output with StrategyApproximate.  It reports whether a declaration
+// was found.
+func approximateDefinition(q *Query, qpos *queryPos, id *ast.Ident) bool {
+	srcdir := filepath.Dir(qpos.fset.File(qpos.start).Name())
+	dir, pkg := srcdir, ""
+	if pkg = packageForQualIdent(qpos.path, id); pkg != "" {
+		if dir = approximatePackageDir(q.Build, srcdir, pkg); dir == "" {
+			q.logf("no directory for package %q", pkg)
+			return false
+		}
+	}
+	member := false
+	if pkg == "" && len(qpos.path) > 1 {
+		sel, ok := qpos.path[1].(*ast.SelectorExpr)
+		member = ok && sel.Sel == id
+	}
+
+	// The package-level declarations of a package that can be imported
+	// are in its index.
+	if q.packageIndex != nil && !member {
+		if pi, err := q.packageIndex.get(q, dir); err == nil {
+			if m, ok := pi.members[id.Name]; ok {
+				if tok, pos, err := m.position(q.Build, qpos.fset); err == nil {
+					outputApproximate(q, qpos.fset, pos, tokenKind(tok), tok.String(), pkg, id.Name)
+					return true
+				}
+			}
+		}
+	}
+
+	// Otherwise parse the Go files of the directory, ignoring build
+	// constraints and package clauses, as they may be wrong.
+	fis, err := buildutil.ReadDir(q.Build, dir)
+	if err != nil {
+		q.logf("best effort: %v", err)
+		return false
+	}
+	var files []*ast.File
+	sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") || (pkg != "" && strings.HasSuffix(name, "_test.go")) {
+			continue
+		}
+		f, _ := parseFile(qpos.fset, q.Build, q.astCache, dir, filepath.Join(dir, name), parser.Mode(0))
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	q.stats.FilesParsed += len(files)
+
+	for _, f := range files {
+		if member {
+			break
+		}
+		var found *ast.Ident
+		var tok token.Token
+		packageDecls(f, func(t token.Token, decl *ast.Ident) bool {
+			if decl.Name == id.Name {
+				found, tok = decl, t
+			}
+			return found == nil
+		})
+		if found != nil {
+			outputApproximate(q, qpos.fset, found.Pos(), tokenKind(tok), tok.String(), pkg, id.Name)
+			return true
+		}
+	}
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil && fn.Name.Name == id.Name {
+				outputApproximate(q, qpos.fset, fn.Name.Pos(), KindMethod, "method", "", id.Name)
+				return true
+			}
+		}
+	}
+	for _, f := range files {
+		var found *ast.Ident
+		ast.Inspect(f, func(n ast.Node) bool {
+			if st, ok := n.(*ast.StructType); ok && found == nil {
+				for _, field := range st.Fields.List {
+					for _, name := range field.Names {
+						if name.Name == id.Name {
+							found = name
+						}
+					}
+				}
+			}
+			return found == nil
+		})
+		if found != nil {
+			outputApproximate(q, qpos.fset, found.Pos(), KindProperty, "field", "", id.Name)
+			return true
+		}
+	}
+	return false
+}
+
+// outputApproximate outputs the declaration of name at pos, described
+// as descr (e.g. "func"), as the approximate definition of q.  If pkg
+// is not empty it is the package that declares name.
+func outputApproximate(q *Query, fset *token.FileSet, pos token.Pos, kind Kind, descr, pkg, name string) {
+	q.stats.Strategy = StrategyApproximate
+	res := &definitionResult{
+		pos:   pos,
+		descr: fmt.Sprintf("%s %s", descr, name),
+		kind:  kind,
+	}
+	if pkg != "" {
+		res.descr = fmt.Sprintf("%s %s.%s", descr, pkg, name)
+		res.id = ObjectID{PkgPath: pkg, Name: name}
+	}
+	q.Output(fset, res)
+}
+
+// approximatePackageDir returns the directory of the package pkg
+// imported from srcdir: the directory found by ctxt or, if there is
+// none, the directory in the module enclosing srcdir whose import path
+// is pkg.  It returns "" if there is neither.
+func approximatePackageDir(ctxt *build.Context, srcdir, pkg string) string {
+	if bp, err := ctxt.Import(pkg, srcdir, build.FindOnly); err == nil {
+		return bp.Dir
+	}
+	gomod, data := findGoMod(ctxt, srcdir)
+	if gomod == "" {
+		return ""
+	}
+	modpath := moduleDirective(data)
+	switch {
+	case modpath == "":
+		return ""
+	case pkg == modpath:
+		return filepath.Dir(gomod)
+	case strings.HasPrefix(pkg, modpath+"/"):
+		return filepath.Join(filepath.Dir(gomod), filepath.FromSlash(pkg[len(modpath)+1:]))
+	}
+	return ""
+}
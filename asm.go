@@ -0,0 +1,102 @@
+package godef
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// bodylessCandidates returns the implementations of the function
+// declared at pos if it is declared without a body: the TEXT symbol of
+// the function in the assembly files of its package, the target of its
+// //go:linkname directive, and the function of the runtime package that
+// is linked to it by a //go:linkname directive.  The package path of
+// the function is that of the result of q.
+func bodylessCandidates(q *Query, pos token.Position) []Candidate {
+	fset := token.NewFileSet()
+	f, _ := buildutil.ParseFile(fset, q.Build, nil, "", pos.Filename, parser.ParseComments)
+	if f == nil {
+		return nil
+	}
+	var decl *ast.FuncDecl
+	for _, d := range f.Decls {
+		if fd, ok := d.(*ast.FuncDecl); ok && fset.Position(fd.Name.Pos()).Offset == pos.Offset {
+			decl = fd
+		}
+	}
+	if decl == nil || decl.Body != nil || decl.Recv != nil {
+		return nil
+	}
+	name := decl.Name.Name
+	dir := filepath.Dir(pos.Filename)
+
+	var results []Candidate
+	add := func(fset *token.FileSet, pos token.Pos, descr, reason string) {
+		results = append(results, Candidate{
+			Position:    tokenPosition(fset.Position(pos)),
+			Description: descr,
+			Kind:        KindFunction,
+			Reason:      reason,
+		})
+	}
+
+	// Pull: //go:linkname name path.target
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			fields := strings.Fields(c.Text)
+			if len(fields) != 3 || fields[0] != "//go:linkname" || fields[1] != name {
+				continue
+			}
+			i := strings.LastIndexByte(fields[2], '.')
+			if i <= 0 {
+				continue
+			}
+			pkg, member := fields[2][:i], fields[2][i+1:]
+			if tok, pos, err := findPackageMember(q, fset, dir, pkg, member); err == nil {
+				add(fset, pos, tok.String()+" "+fields[2], "go:linkname target")
+			}
+		}
+	}
+
+	// Assembly: TEXT ·name(SB)
+	if bp, err := q.Build.ImportDir(dir, 0); err == nil {
+		text := regexp.MustCompile(`(?m)^TEXT[ \t]+[^ \t·]*·(` + regexp.QuoteMeta(name) + `)(<[A-Za-z]+>)?\(SB\)`)
+		for _, sfile := range bp.SFiles {
+			filename := filepath.Join(bp.Dir, sfile)
+			src, err := readFile(q.Build, filename)
+			if err != nil {
+				continue
+			}
+			if m := text.FindSubmatchIndex(src); m != nil {
+				tf := fset.AddFile(filename, -1, len(src))
+				tf.SetLinesForContent(src)
+				add(fset, tf.Pos(m[2]), "TEXT ·"+name, "assembly implementation")
+			}
+		}
+	}
+
+	// Push: //go:linkname local path.name in the runtime
+	if path := q.result.id.PkgPath; path != "" && path != "runtime" {
+		if bp, err := q.Build.Import("runtime", "", 0); err == nil {
+			push := regexp.MustCompile(`(?m)^//go:linkname[ \t]+(\S+)[ \t]+` + regexp.QuoteMeta(path+"."+name) + `[ \t]*$`)
+			for _, gofile := range bp.GoFiles {
+				src, err := readFile(q.Build, filepath.Join(bp.Dir, gofile))
+				if err != nil {
+					continue
+				}
+				if m := push.FindSubmatch(src); m != nil {
+					if tok, pos, err := findPackageMember(q, fset, "", "runtime", string(m[1])); err == nil {
+						add(fset, pos, tok.String()+" runtime."+string(m[1]), "go:linkname implementation")
+					}
+					break
+				}
+			}
+		}
+	}
+	return results
+}
@@ -0,0 +1,153 @@
+package godef
+
+import (
+	"crypto/sha256"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"sync"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// DefaultASTCacheSize is the number of files kept by an ASTCache created
+// with a size of zero.
+const DefaultASTCacheSize = 1024
+
+// An ASTCache reuses the syntax trees of the files parsed by queries, so
+// that a file is not parsed again by a later step of the same query or
+// by later queries while its contents are unchanged.  Files are keyed
+// by their name, the hash of their contents and the parser mode.
+//
+// The syntax trees share the file set of the cache, which is used by
+// the queries that use the cache.  When the cache is full it is emptied
+// and a new file set is started, so that the file set does not grow
+// without bound.  The loader backend does not parse the packages it
+// loads with the cache.
+//
+// An ASTCache may be shared by Engines and used by multiple goroutines
+// simultaneously.
+type ASTCache struct {
+	mu    sync.Mutex
+	size  int
+	fset  *token.FileSet
+	files map[astKey]*cachedFile
+}
+
+type astKey struct {
+	filename string
+	hash     [sha256.Size]byte
+	mode     parser.Mode
+}
+
+// A cachedFile is the result of parsing a file.
+type cachedFile struct {
+	f   *ast.File
+	err error
+}
+
+// NewASTCache returns an ASTCache that keeps up to size files, or
+// DefaultASTCacheSize if size is zero.
+func NewASTCache(size int) *ASTCache {
+	if size <= 0 {
+		size = DefaultASTCacheSize
+	}
+	return &ASTCache{
+		size:  size,
+		fset:  token.NewFileSet(),
+		files: make(map[astKey]*cachedFile),
+	}
+}
+
+// Len returns the number of files in the cache.
+func (c *ASTCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.files)
+}
+
+// fileSet returns the file set of the files in c.
+func (c *ASTCache) fileSet() *token.FileSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.fset
+}
+
+// parseFile is like parser.ParseFile for the contents src of filename,
+// but returns the cached syntax tree if fset is the file set of c.
+func (c *ASTCache) parseFile(fset *token.FileSet, filename string, src []byte, mode parser.Mode) (*ast.File, error) {
+	key := astKey{filename, sha256.Sum256(src), mode}
+	c.mu.Lock()
+	if fset != c.fset {
+		// The cache was emptied since fset was returned by fileSet.
+		c.mu.Unlock()
+		return parser.ParseFile(fset, filename, src, mode)
+	}
+	if e := c.files[key]; e != nil {
+		c.mu.Unlock()
+		return e.f, e.err
+	}
+	c.mu.Unlock()
+
+	f, err := parser.ParseFile(fset, filename, src, mode)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fset != c.fset {
+		return f, err
+	}
+	if e := c.files[key]; e != nil {
+		return e.f, e.err // parsed concurrently
+	}
+	if len(c.files) >= c.size {
+		c.resetLocked()
+		return f, err
+	}
+	c.files[key] = &cachedFile{f, err}
+	return f, err
+}
+
+// reset empties c and starts a new file set.
+func (c *ASTCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.resetLocked()
+}
+
+// resetLocked is reset with c.mu held.
+func (c *ASTCache) resetLocked() {
+	c.fset = token.NewFileSet()
+	c.files = make(map[astKey]*cachedFile)
+}
+
+// newFileSet returns the file set to parse the files of q in: the file
+// set of q.astCache, if any.
+func (q *Query) newFileSet() *token.FileSet {
+	if q.astCache != nil {
+		return q.astCache.fileSet()
+	}
+	return token.NewFileSet()
+}
+
+// parseFile is like buildutil.ParseFile, but parses the file with cache,
+// if non-nil.
+func parseFile(fset *token.FileSet, ctxt *build.Context, cache *ASTCache, dir, filename string, mode parser.Mode) (*ast.File, error) {
+	if cache == nil {
+		return buildutil.ParseFile(fset, ctxt, nil, dir, filename, mode)
+	}
+	if !buildutil.IsAbsPath(ctxt, filename) {
+		filename = buildutil.JoinPath(ctxt, dir, filename)
+	}
+	rc, err := buildutil.OpenFile(ctxt, filename)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	return cache.parseFile(fset, filename, src, mode)
+}
@@ -0,0 +1,189 @@
+package godef
+
+import (
+	"fmt"
+	"go/build"
+	"sort"
+	"strings"
+
+	util "github.com/charlievieth/buildutil"
+	"github.com/charlievieth/godef/internal/constraint"
+)
+
+// BuildInfo describes the evaluation of a file's build constraints.
+type BuildInfo struct {
+	Filename string
+
+	// Builds reports whether the file is built by Config.Context.
+	Builds bool
+
+	// Matched and Failed are the terms of the file's name and build
+	// constraints (e.g. "linux" or "!cgo") that are and are not
+	// satisfied by Config.Context.
+	Matched []string
+	Failed  []string
+
+	// GOOS and GOARCH are those used to query the file.  They differ
+	// from Config.Context when the file is only built for another
+	// platform.
+	GOOS   string
+	GOARCH string
+}
+
+// A QueryContext is the build configuration a query was answered with:
+// Config.Context adjusted for the queried file, e.g. with the GOOS of a
+// _windows.go file or the workspace of a file outside of GOPATH.
+type QueryContext struct {
+	GOROOT     string
+	GOPATH     string
+	GOOS       string
+	GOARCH     string
+	CgoEnabled bool
+	BuildTags  []string
+
+	// Adjusted are the settings that differ from Config.Context, e.g.
+	// "GOOS=windows" or "tags=integration", as set for the queried file.
+	Adjusted []string
+}
+
+// newQueryContext returns the QueryContext of ctxt, derived from base.
+func newQueryContext(base, ctxt *build.Context) QueryContext {
+	qc := QueryContext{
+		GOROOT:     ctxt.GOROOT,
+		GOPATH:     ctxt.GOPATH,
+		GOOS:       ctxt.GOOS,
+		GOARCH:     ctxt.GOARCH,
+		CgoEnabled: ctxt.CgoEnabled,
+		BuildTags:  ctxt.BuildTags,
+	}
+	for _, s := range [...]struct{ name, old, new string }{
+		{"GOROOT", base.GOROOT, ctxt.GOROOT},
+		{"GOPATH", base.GOPATH, ctxt.GOPATH},
+		{"GOOS", base.GOOS, ctxt.GOOS},
+		{"GOARCH", base.GOARCH, ctxt.GOARCH},
+	} {
+		if s.old != s.new {
+			qc.Adjusted = append(qc.Adjusted, s.name+"="+s.new)
+		}
+	}
+	if fmt.Sprint(base.BuildTags) != fmt.Sprint(ctxt.BuildTags) {
+		qc.Adjusted = append(qc.Adjusted, "tags="+strings.Join(ctxt.BuildTags, ","))
+	}
+	return qc
+}
+
+// String returns qc in the form of the environment variables of the go
+// command, e.g. "GOOS=linux GOARCH=amd64 CGO_ENABLED=1 tags=[]".
+func (qc QueryContext) String() string {
+	cgo := 0
+	if qc.CgoEnabled {
+		cgo = 1
+	}
+	return fmt.Sprintf("GOROOT=%s GOPATH=%s GOOS=%s GOARCH=%s CGO_ENABLED=%d tags=%q",
+		qc.GOROOT, qc.GOPATH, qc.GOOS, qc.GOARCH, cgo, qc.BuildTags)
+}
+
+// BuildInfoFor returns the evaluation of the build constraints of
+// filename.  If src is non-nil it is used as the source of filename.
+func (c *Config) BuildInfoFor(filename string, src interface{}) (*BuildInfo, error) {
+	body, err := readSource(&c.Context, filename, src)
+	if err != nil {
+		return nil, err
+	}
+	base, err := c.buildContext()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]bool)
+	goodOSArch := util.GoodOSArchFile(base, filename, tags)
+	shouldBuild := shouldBuild(base, body, tags)
+
+	ctxt := *base // copy
+	updateContextForFile(&ctxt, c.env(), &c.Workspace, filename, body)
+
+	info := &BuildInfo{
+		Filename: filename,
+		Builds:   goodOSArch && shouldBuild,
+		GOOS:     ctxt.GOOS,
+		GOARCH:   ctxt.GOARCH,
+	}
+	for tag, positive := range tags {
+		term := tag
+		if !positive {
+			term = "!" + tag
+		}
+		if matchTag(base, tag) == positive {
+			info.Matched = append(info.Matched, term)
+		} else {
+			info.Failed = append(info.Failed, term)
+		}
+	}
+	sort.Strings(info.Matched)
+	sort.Strings(info.Failed)
+	return info, nil
+}
+
+// shouldBuild is like util.ShouldBuild, it reports whether the build
+// constraints of the Go source src are satisfied by ctxt and records
+// their tags in allTags, but if src has a //go:build line it evaluates
+// that line rather than its // +build lines.  Unlike util.ShouldBuild
+// the tags are matched with matchTag, so the tags implied by GOOS, such
+// as "unix", are satisfied.
+func shouldBuild(ctxt *build.Context, src []byte, allTags map[string]bool) bool {
+	var lines []string
+	var parse func(string) (constraint.Expr, error)
+	if line, ok := constraint.GoBuildLine(src); ok {
+		lines, parse = []string{line}, constraint.Parse
+	} else {
+		lines, parse = constraint.PlusBuildLines(src), constraint.ParsePlusBuild
+	}
+	builds := true
+	for _, line := range lines {
+		x, err := parse(line)
+		if err != nil {
+			return false
+		}
+		if allTags != nil {
+			constraint.Tags(x, func(tag string, negated bool) { allTags[tag] = !negated })
+		}
+		if !x.Eval(func(tag string) bool { return matchTag(ctxt, tag) }) {
+			builds = false
+		}
+	}
+	return builds
+}
+
+// unixOS are the values of GOOS that satisfy the "unix" build tag.
+var unixOS = map[string]bool{
+	"aix": true, "android": true, "darwin": true, "dragonfly": true,
+	"freebsd": true, "hurd": true, "illumos": true, "ios": true,
+	"linux": true, "netbsd": true, "openbsd": true, "solaris": true,
+}
+
+// matchTag reports whether the build tag name is satisfied by ctxt.
+func matchTag(ctxt *build.Context, name string) bool {
+	if ctxt.CgoEnabled && name == "cgo" {
+		return true
+	}
+	if name == ctxt.GOOS || name == ctxt.GOARCH || name == ctxt.Compiler {
+		return true
+	}
+	if ctxt.GOOS == "android" && name == "linux" ||
+		ctxt.GOOS == "illumos" && name == "solaris" ||
+		ctxt.GOOS == "ios" && name == "darwin" ||
+		name == "unix" && unixOS[ctxt.GOOS] {
+		return true
+	}
+	for _, tag := range ctxt.BuildTags {
+		if tag == name {
+			return true
+		}
+	}
+	for _, tag := range ctxt.ReleaseTags {
+		if tag == name {
+			return true
+		}
+	}
+	return false
+}
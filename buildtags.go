@@ -0,0 +1,192 @@
+package godef
+
+import (
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/charlievieth/godef/internal/constraint"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// A BuildTagStrategy determines the build tags of queries.
+type BuildTagStrategy int
+
+const (
+	// BuildTagsContext uses the tags of Config.Context.
+	BuildTagsContext BuildTagStrategy = iota
+
+	// BuildTagsFile adds the custom tags that the build constraints of
+	// the queried file require, e.g. "integration" for a file with a
+	// //go:build integration line, if the file is otherwise excluded.
+	BuildTagsFile
+
+	// BuildTagsPackage is like BuildTagsFile, but also adds the custom
+	// tags of the other files of the package, one at a time, if doing so
+	// includes more of its files and excludes none of them.
+	BuildTagsPackage
+)
+
+var buildTagStrategyNames = [...]string{
+	BuildTagsContext: "context",
+	BuildTagsFile:    "file",
+	BuildTagsPackage: "package",
+}
+
+func (s BuildTagStrategy) String() string {
+	if 0 <= int(s) && int(s) < len(buildTagStrategyNames) {
+		return buildTagStrategyNames[s]
+	}
+	return fmt.Sprintf("BuildTagStrategy(%d)", int(s))
+}
+
+// ParseBuildTagStrategy returns the BuildTagStrategy named s, which is
+// one of "context", "file" or "package".
+func ParseBuildTagStrategy(s string) (BuildTagStrategy, error) {
+	for i, name := range buildTagStrategyNames {
+		if s == name {
+			return BuildTagStrategy(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid build tag strategy: %q", s)
+}
+
+// inferBuildTags returns ctxt with the build tags chosen by strategy s
+// for the query of filename added, and the tags that were added.
+func (s BuildTagStrategy) inferBuildTags(ctxt *build.Context, filename string) (*build.Context, []string) {
+	if s == BuildTagsContext {
+		return ctxt, nil
+	}
+	dir, name := filepath.Split(filename)
+	var added []string
+	if ok, err := ctxt.MatchFile(dir, name); !ok && err == nil {
+		tags := customTags(ctxt, filename)
+		if ok, _ := withBuildTags(ctxt, tags...).MatchFile(dir, name); ok {
+			added = tags
+		} else {
+			for _, tag := range tags {
+				if ok, _ := withBuildTags(ctxt, tag).MatchFile(dir, name); ok {
+					added = []string{tag}
+					break
+				}
+			}
+		}
+	}
+	if s == BuildTagsPackage {
+		added = inferPackageTags(ctxt, dir, added)
+	}
+	if len(added) == 0 {
+		return ctxt, nil
+	}
+	return withBuildTags(ctxt, added...), added
+}
+
+// inferPackageTags returns added, the tags added to ctxt for the queried
+// file, and the custom tags of the Go files of dir that include more of
+// them without excluding any.
+func inferPackageTags(ctxt *build.Context, dir string, added []string) []string {
+	fis, err := buildutil.ReadDir(ctxt, dir)
+	if err != nil {
+		return added
+	}
+	var names []string
+	candidates := make(map[string]bool)
+	for _, fi := range fis {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".go") {
+			continue
+		}
+		names = append(names, fi.Name())
+		for _, tag := range customTags(ctxt, filepath.Join(dir, fi.Name())) {
+			candidates[tag] = true
+		}
+	}
+	matched := func(tags []string) map[string]bool {
+		c := withBuildTags(ctxt, tags...)
+		m := make(map[string]bool)
+		for _, name := range names {
+			if ok, _ := c.MatchFile(dir, name); ok {
+				m[name] = true
+			}
+		}
+		return m
+	}
+	for _, tag := range added {
+		delete(candidates, tag)
+	}
+	var sorted []string
+	for tag := range candidates {
+		sorted = append(sorted, tag)
+	}
+	sort.Strings(sorted)
+
+	built := matched(added)
+	for _, tag := range sorted {
+		tags := append(added[:len(added):len(added)], tag)
+		m := matched(tags)
+		if len(m) <= len(built) {
+			continue
+		}
+		excluded := false
+		for name := range built {
+			excluded = excluded || !m[name]
+		}
+		if !excluded {
+			added, built = tags, m
+		}
+	}
+	return added
+}
+
+// withBuildTags returns a copy of ctxt with tags added to its BuildTags.
+func withBuildTags(ctxt *build.Context, tags ...string) *build.Context {
+	c := *ctxt // copy
+	c.BuildTags = append(append([]string(nil), ctxt.BuildTags...), tags...)
+	return &c
+}
+
+// constraintWordRx matches the tags of a // +build line.
+var constraintWordRx = regexp.MustCompile(`[A-Za-z0-9_.]+`)
+
+// customTags returns the tags in the build constraints of filename that
+// are not satisfied by ctxt and are not the name of an operating system,
+// architecture, compiler or release, or "ignore".
+func customTags(ctxt *build.Context, filename string) []string {
+	rc, err := buildutil.OpenFile(ctxt, filename)
+	if err != nil {
+		return nil
+	}
+	src, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var tags []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		var words []string
+		if x, err := constraint.Parse(line); err == nil {
+			constraint.Tags(x, func(tag string, _ bool) { words = append(words, tag) })
+		} else if strings.HasPrefix(line, "// +build ") {
+			words = constraintWordRx.FindAllString(line[len("// +build "):], -1)
+		}
+		for _, tag := range words {
+			switch {
+			case seen[tag], knownOS[tag], knownArch[tag], matchTag(ctxt, tag),
+				tag == "cgo", tag == "unix", tag == "gc", tag == "gccgo", tag == "ignore",
+				strings.HasPrefix(tag, "go1."):
+				continue
+			}
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
@@ -0,0 +1,326 @@
+package godef
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// DefaultProgramCacheSize is the number of programs kept by a
+// ProgramCache created with a size of zero.
+const DefaultProgramCacheSize = 8
+
+// A ProgramCache reuses the programs loaded and type-checked by queries.
+// A query is answered from the program loaded by an earlier query of
+// the same file, with the same build configuration, if none of the files
+// the program was loaded from have changed.  If only the files of the
+// queried package have changed, the package is type-checked again
+// against the packages it imports in the cached program, which are
+// reused, up to maxRechecks times before the program is loaded again.
+//
+// Files are compared by the listings of their directories, i.e. by
+// their names, sizes and modification times, except for the files whose
+// contents the listings do not reflect, e.g. those of Config.Overlay,
+// which are compared by the hash of their contents.  The files in GOROOT
+// and the module cache are assumed not to change.
+//
+// A ProgramCache may be shared by Engines and used by multiple
+// goroutines simultaneously.
+type ProgramCache struct {
+	mu      sync.Mutex
+	size    int
+	clock   uint64 // incremented on each use of an entry
+	entries map[string]*cachedProgram
+}
+
+// A cachedProgram is a program and the state of the files it was loaded
+// from.
+type cachedProgram struct {
+	prog  program
+	files map[string][sha256.Size]byte // file name => hash of contents, see Query.contentFiles
+	dirs  map[string]map[string]string // directory => Go file => its size and modification time
+	pkg   *cachedPackage               // the queried package, nil if it cannot be re-checked
+	used  uint64
+}
+
+// NewProgramCache returns a ProgramCache that keeps the size most
+// recently used programs, or DefaultProgramCacheSize if size is zero.
+func NewProgramCache(size int) *ProgramCache {
+	if size <= 0 {
+		size = DefaultProgramCacheSize
+	}
+	return &ProgramCache{size: size, entries: make(map[string]*cachedProgram)}
+}
+
+// Len returns the number of programs in the cache.
+func (c *ProgramCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// programCacheKey returns the key of the program of q: the queried file
+// and the settings of the build context, resolver and implementation
+// scope that affect package loading.
+func programCacheKey(q *Query) (string, error) {
+	sp, err := parsePos(q.Pos)
+	if err != nil {
+		return "", err
+	}
+	filename, err := absPath(q.env(), sp.Filename)
+	if err != nil {
+		return "", err
+	}
+	ctxt := q.Build
+	return fmt.Sprintf("%s\x00%s\x00%s\x00%s/%s\x00%t\x00%q\x00%T\x00%t", filename,
+		ctxt.GOROOT, ctxt.GOPATH, ctxt.GOOS, ctxt.GOARCH, ctxt.CgoEnabled,
+		ctxt.BuildTags, q.Resolver, q.implementations == ImplementationsModule), nil
+}
+
+// get returns the program of key if it is cached and its files are
+// unchanged for q, or if only the files of its queried package have
+// changed, the program with the package type-checked again (see
+// recheck), which replaces the cached program.
+func (c *ProgramCache) get(q *Query, key string) program {
+	c.mu.Lock()
+	e := c.entries[key]
+	c.mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	// Entries are immutable, so they can be validated without the lock.
+	changed, ok := e.changes(q)
+	if ok && len(changed) != 0 {
+		var e1 *cachedProgram
+		if e.pkg != nil && e.pkg.contains(changed) {
+			e1 = e.recheck(q)
+		}
+		c.mu.Lock()
+		if c.entries[key] == e {
+			if e1 != nil {
+				c.entries[key] = e1
+			} else {
+				delete(c.entries, key)
+			}
+		}
+		c.mu.Unlock()
+		e = e1
+	} else if !ok {
+		c.mu.Lock()
+		if c.entries[key] == e {
+			delete(c.entries, key)
+		}
+		c.mu.Unlock()
+		e = nil
+	}
+	if e == nil {
+		return nil
+	}
+	c.mu.Lock()
+	c.clock++
+	e.used = c.clock
+	c.mu.Unlock()
+	return e.prog
+}
+
+// put adds prog, loaded by q, to the cache as key and evicts the least
+// recently used program if the cache is full.
+func (c *ProgramCache) put(q *Query, key string, prog program) {
+	e := newCachedProgram(q, prog)
+	if e == nil {
+		return // don't cache programs that can't be validated
+	}
+	e.pkg = newCachedPackage(q, prog)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock++
+	e.used = c.clock
+	c.entries[key] = e
+	c.trimLocked(c.size)
+}
+
+// newCachedProgram returns the cache entry of prog, loaded by q, without
+// its queried package, or nil if its files cannot be read.
+func newCachedProgram(q *Query, prog program) *cachedProgram {
+	e := &cachedProgram{
+		prog:  prog,
+		files: make(map[string][sha256.Size]byte),
+		dirs:  make(map[string]map[string]string),
+	}
+	var files []string
+	for _, name := range prog.Files() {
+		if q.immutableFile(name) {
+			continue
+		}
+		files = append(files, name)
+		dir := filepath.Dir(name)
+		if _, ok := e.dirs[dir]; !ok {
+			e.dirs[dir] = goListing(q.Build, dir)
+		}
+	}
+	for _, name := range q.contentFiles(files) {
+		h, err := hashFile(q.Build, name)
+		if err != nil {
+			return nil
+		}
+		e.files[name] = h
+	}
+	return e
+}
+
+// trim evicts the least recently used programs until n are left.
+func (c *ProgramCache) trim(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trimLocked(n)
+}
+
+// trimLocked is trim with c.mu held.
+func (c *ProgramCache) trimLocked(n int) {
+	for len(c.entries) > n {
+		var oldest string
+		for k, e := range c.entries {
+			if oldest == "" || e.used < c.entries[oldest].used {
+				oldest = k
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// changes returns the files of e that have changed for q.  It returns
+// false if the Go files of a directory of e were added or removed, in
+// which case the program must be loaded again.
+func (e *cachedProgram) changes(q *Query) (changed []string, ok bool) {
+	seen := make(map[string]bool)
+	for dir, files := range e.dirs {
+		listing := goListing(q.Build, dir)
+		if len(listing) != len(files) {
+			return nil, false
+		}
+		for name, stamp := range files {
+			cur, ok := listing[name]
+			if !ok {
+				return nil, false
+			}
+			if cur != stamp {
+				name = filepath.Join(dir, name)
+				changed = append(changed, name)
+				seen[name] = true
+			}
+		}
+	}
+	// The files whose contents the listings do not reflect, now or when
+	// e was cached, e.g. those of an overlay that was removed.
+	var files []string
+	for dir, list := range e.dirs {
+		for name := range list {
+			files = append(files, filepath.Join(dir, name))
+		}
+	}
+	hashed := q.contentFiles(files)
+	for name := range e.files {
+		hashed = append(hashed, name)
+	}
+	for _, name := range hashed {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		want, ok := e.files[name]
+		if h, err := hashFile(q.Build, name); err != nil || !ok || h != want {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed, true
+}
+
+// immutableFile reports whether filename is in GOROOT or the module
+// cache of q, whose files are assumed not to change, and is not in the
+// overlay of q.
+func (q *Query) immutableFile(filename string) bool {
+	if _, ok := q.overlay[filename]; ok {
+		return false
+	}
+	if _, ok := hasFilePathPrefix(filename, filepath.Join(q.Build.GOROOT, "src")); ok && q.Build.GOROOT != "" {
+		return true
+	}
+	if modcache := modCacheDir(q.Build, q.env()); modcache != "" {
+		if _, ok := hasFilePathPrefix(filename, modcache); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hashFile returns the hash of the contents of filename in ctxt.
+func hashFile(ctxt *build.Context, filename string) ([sha256.Size]byte, error) {
+	rc, err := buildutil.OpenFile(ctxt, filename)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	defer rc.Close()
+	b, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(b), nil
+}
+
+// contentFiles returns the files of filenames whose contents are not
+// reflected by the listings of their directories in q.Build, which the
+// caches compare by the hashes of their contents: the files of the
+// overlay of q, the queried file, whose contents may be given by
+// Query.Src, and the files listed without a modification time, e.g.
+// those of an fs.FS (see ContextFromFS).
+func (q *Query) contentFiles(filenames []string) []string {
+	var queried string
+	if sp, err := parsePos(q.Pos); err == nil && q.Pos != "" {
+		queried, _ = absPath(q.env(), sp.Filename)
+	}
+	listings := make(map[string]map[string]os.FileInfo)
+	var list []string
+	for _, name := range filenames {
+		if _, ok := q.overlay[name]; ok || name == queried {
+			list = append(list, name)
+			continue
+		}
+		dir := filepath.Dir(name)
+		listing, ok := listings[dir]
+		if !ok {
+			listing = make(map[string]os.FileInfo)
+			fis, _ := buildutil.ReadDir(q.Build, dir)
+			for _, fi := range fis {
+				listing[fi.Name()] = fi
+			}
+			listings[dir] = listing
+		}
+		if fi := listing[filepath.Base(name)]; fi == nil || fi.ModTime().IsZero() {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// goListing returns the sizes and modification times of the Go files of
+// dir in ctxt, by name.
+func goListing(ctxt *build.Context, dir string) map[string]string {
+	fis, _ := buildutil.ReadDir(ctxt, dir)
+	listing := make(map[string]string)
+	for _, fi := range fis {
+		if name := fi.Name(); strings.HasSuffix(name, ".go") && !fi.IsDir() {
+			listing[name] = fmt.Sprintf("%d %d", fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return listing
+}
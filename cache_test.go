@@ -0,0 +1,284 @@
+package godef
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestProgramCache(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pSrc = "package p\n\nfunc F() { _ = T{}.X }\n"
+	pfile := filepath.Join(tmp, "p.go")
+	tfile := filepath.Join(tmp, "t.go")
+	write := func(name, src string) {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(tmp, "go.mod"), "module example.com/p\n")
+	write(pfile, pSrc)
+	write(tfile, "package p\n\ntype T struct{ X int }\n")
+
+	cache := NewProgramCache(0)
+	conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, ProgramCache: cache}
+	offset := strings.Index(pSrc, "X }")
+	lookup := func(cacheHits, rechecks, line int) {
+		t.Helper()
+		res, err := conf.Lookup(pfile, offset, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Stats.CacheHits != cacheHits || res.Stats.Rechecks != rechecks {
+			t.Errorf("got %d cache hits and %d rechecks; want: %d and %d",
+				res.Stats.CacheHits, res.Stats.Rechecks, cacheHits, rechecks)
+		}
+		if res.Position.Filename != tfile || res.Position.Line != line {
+			t.Errorf("got position %s; want: %s:%d", res.Position, tfile, line)
+		}
+	}
+
+	lookup(0, 0, 3)
+	lookup(1, 0, 3)
+
+	// Changed file of the queried package
+	write(tfile, "package p\n\ntype T struct {\n\tX int\n}\n")
+	lookup(1, 1, 4)
+	lookup(1, 0, 4)
+
+	// Added file
+	write(filepath.Join(tmp, "u.go"), "package p\n\nvar U = 1\n")
+	lookup(0, 0, 4)
+	lookup(1, 0, 4)
+
+	// Overlay
+	conf.Overlay = map[string][]byte{tfile: []byte("package p\n\n\ntype T struct{ X int }\n")}
+	lookup(1, 1, 4)
+	lookup(1, 0, 4)
+
+	// Added import
+	conf.Overlay[tfile] = []byte("package p\n\nimport \"strings\"\n\ntype T struct{ X strings.Builder }\n")
+	lookup(0, 0, 5)
+	lookup(1, 0, 5)
+
+	// The program is loaded again after maxRechecks.
+	for i := 0; i < maxRechecks; i++ {
+		conf.Overlay[tfile] = []byte(fmt.Sprintf("package p\n\n// %d\ntype T struct{ X int }\n", i))
+		lookup(1, 1, 4)
+	}
+	conf.Overlay[tfile] = []byte("package p\n\n\ntype T struct{ X int }\n")
+	lookup(0, 0, 4)
+	lookup(1, 0, 4)
+
+	if n := cache.Len(); n != 1 {
+		t.Errorf("got %d cached programs; want: 1", n)
+	}
+}
+
+func TestProgramCache_Dependency(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pSrc = "package p\n\nimport (\n\t\"strings\"\n\n\t\"example.com/p/q\"\n)\n\nvar _ = strings.TrimSpace(q.T{}.X)\n"
+	pfile := filepath.Join(tmp, "p.go")
+	qfile := filepath.Join(tmp, "q", "q.go")
+	if err := os.Mkdir(filepath.Dir(qfile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, src string) {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(tmp, "go.mod"), "module example.com/p\n")
+	write(pfile, pSrc)
+	write(qfile, "package q\n\ntype T struct{ X string }\n")
+
+	// Files in GOROOT are not read to validate a cached program.
+	var (
+		mu     sync.Mutex // OpenFile is called by concurrent parsers
+		goroot []string
+	)
+	ctxt := build.Default
+	ctxt.OpenFile = func(name string) (io.ReadCloser, error) {
+		if _, ok := hasFilePathPrefix(name, filepath.Join(ctxt.GOROOT, "src")); ok {
+			mu.Lock()
+			goroot = append(goroot, name)
+			mu.Unlock()
+		}
+		return os.Open(name)
+	}
+	conf := Config{Context: ctxt, Resolver: &ModuleResolver{}, ProgramCache: NewProgramCache(0)}
+	lookup := func(cacheHits, line int) {
+		t.Helper()
+		res, err := conf.Lookup(pfile, strings.Index(pSrc, "X)"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Stats.CacheHits != cacheHits || res.Stats.Rechecks != 0 {
+			t.Errorf("got %d cache hits and %d rechecks; want: %d and 0",
+				res.Stats.CacheHits, res.Stats.Rechecks, cacheHits)
+		}
+		if res.Position.Filename != qfile || res.Position.Line != line {
+			t.Errorf("got position %s; want: %s:%d", res.Position, qfile, line)
+		}
+	}
+
+	lookup(0, 3)
+	mu.Lock()
+	goroot = nil
+	mu.Unlock()
+	lookup(1, 3)
+	mu.Lock()
+	if len(goroot) != 0 {
+		t.Errorf("read %q to validate a cached program", goroot)
+	}
+	mu.Unlock()
+
+	// A changed dependency reloads the program.
+	write(qfile, "package q\n\ntype T struct {\n\tX string\n}\n")
+	lookup(0, 4)
+	lookup(1, 4)
+}
+
+func TestProgramCache_Evict(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	const src = "package p\n\nvar V = struct{ X int }{}.X\n"
+	cache := NewProgramCache(2)
+	conf := Config{Context: build.Default, ProgramCache: cache}
+	var files []string
+	for _, name := range []string{"a", "b", "c"} {
+		filename := filepath.Join(tmp, name, "p.go")
+		if err := os.Mkdir(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filename)
+	}
+	for _, i := range []int{0, 1, 0, 2, 0} {
+		if _, err := conf.Lookup(files[i], strings.LastIndex(src, "X"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cache.Len(); n != 2 {
+		t.Errorf("got %d cached programs; want: 2", n)
+	}
+	// b was the least recently used program
+	res, err := conf.Lookup(files[1], strings.LastIndex(src, "X"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.CacheHits != 0 {
+		t.Errorf("got %d cache hits; want: 0", res.Stats.CacheHits)
+	}
+}
+
+func TestASTCache(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pSrc = "package p\n\nimport \"strings\"\n\nfunc F() { _ = T{}.X; strings.TrimSpace(\"\") }\n"
+	pfile := filepath.Join(tmp, "p.go")
+	tfile := filepath.Join(tmp, "t.go")
+	write := func(name, src string) {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(tmp, "go.mod"), "module example.com/p\n")
+	write(pfile, pSrc)
+	write(tfile, "package p\n\ntype T struct{ X int }\n")
+
+	cache := NewASTCache(0)
+	ctxt := &build.Default
+	fset := cache.fileSet()
+	f1, err := parseFile(fset, ctxt, cache, "", tfile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f2, _ := parseFile(fset, ctxt, cache, "", tfile, 0); f2 != f1 {
+		t.Error("unchanged file was parsed again")
+	}
+	if f2, _ := parseFile(fset, ctxt, cache, "", tfile, parser.ParseComments); f2 == f1 {
+		t.Error("file parsed with another mode was reused")
+	}
+	write(tfile, "package p\n\ntype T struct {\n\tX int\n}\n")
+	if f2, _ := parseFile(fset, ctxt, cache, "", tfile, 0); f2 == f1 {
+		t.Error("changed file was reused")
+	}
+
+	// Queries answered with and without the cache are the same.
+	for _, marker := range []string{"X;", "TrimSpace"} {
+		var results [2]*Result
+		for i, cache := range []*ASTCache{nil, cache} {
+			conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, ASTCache: cache}
+			res, err := conf.Lookup(pfile, strings.Index(pSrc, marker), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Stats = Stats{}
+			results[i] = res
+		}
+		if !reflect.DeepEqual(results[0], results[1]) {
+			t.Errorf("%s: got %+v with cache; want %+v", marker, results[1], results[0])
+		}
+	}
+	n := cache.Len()
+	conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, ASTCache: cache}
+	if _, err := conf.Lookup(pfile, strings.Index(pSrc, "TrimSpace"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != n {
+		t.Errorf("got %d cached files after repeating a query; want: %d", cache.Len(), n)
+	}
+}
+
+func TestASTCache_Full(t *testing.T) {
+	cache := NewASTCache(2)
+	fset := cache.fileSet()
+	for _, name := range []string{"a.go", "b.go", "c.go"} {
+		if _, err := cache.parseFile(fset, name, []byte("package p\n"), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := cache.Len(); n != 0 {
+		t.Errorf("got %d cached files; want: 0", n)
+	}
+	if cache.fileSet() == fset {
+		t.Error("file set was not replaced when the cache was full")
+	}
+}
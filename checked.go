@@ -0,0 +1,106 @@
+package godef
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// A CheckedPackage is a package that was parsed and type-checked by the
+// caller, e.g. by a linter, whose queries are answered with its syntax
+// and type information rather than by loading it again (see
+// Engine.LookupChecked).  Info must record at least Defs, Uses and
+// Selections, and Implicits for the variables of type switches.
+type CheckedPackage struct {
+	Fset  *token.FileSet
+	Files []*ast.File
+	Pkg   *types.Package
+	Info  *types.Info
+}
+
+// LookupChecked is like Lookup, but answers the query of the identifier
+// at pos in pkg with the type checker, using the syntax and type
+// information of pkg.  The file containing pos is read, or taken from
+// Config.Overlay, as by Lookup; the definitions in other packages are
+// those of the imports of pkg.Pkg, which are positioned in pkg.Fset.
+func (e *Engine) LookupChecked(pkg *CheckedPackage, pos token.Pos) (*Result, error) {
+	if pkg.Fset == nil || pkg.Info == nil {
+		return nil, errors.New("checked package without a file set or type information")
+	}
+	tf := pkg.Fset.File(pos)
+	if tf == nil {
+		return nil, &NotFoundError{Err: ErrNoIdentifier, Reason: "position not in the file set of the package"}
+	}
+	offset := tf.Offset(pos)
+	run := func(q *Query) error {
+		q.prog = &checkedProgram{pkg: pkg}
+		return checkedDefinition(q)
+	}
+	return e.lookup("definition", run, tf.Name(), offset, offset, nil)
+}
+
+// checkedProgram is the program of a CheckedPackage.
+type checkedProgram struct {
+	pkg  *CheckedPackage
+	info *packageInfo
+}
+
+func (p *checkedProgram) Fset() *token.FileSet { return p.pkg.Fset }
+
+func (p *checkedProgram) Files() []string {
+	var names []string
+	for _, f := range p.pkg.Files {
+		if tf := p.pkg.Fset.File(f.Pos()); tf != nil {
+			names = append(names, tf.Name())
+		}
+	}
+	return names
+}
+
+func (p *checkedProgram) PathEnclosingInterval(start, end token.Pos) (*packageInfo, []ast.Node, bool) {
+	for _, f := range p.pkg.Files {
+		if f.Pos() == token.NoPos {
+			continue // parse error
+		}
+		tf := p.pkg.Fset.File(f.Pos())
+		if tf == nil {
+			continue
+		}
+		if base := token.Pos(tf.Base()); base <= start && end <= base+token.Pos(tf.Size()) {
+			path, exact := astutil.PathEnclosingInterval(f, start, end)
+			if path != nil {
+				if p.info == nil {
+					p.info = &packageInfo{Pkg: p.pkg.Pkg, Files: p.pkg.Files, Info: *p.pkg.Info}
+				}
+				return p.info, path, exact
+			}
+		}
+	}
+	return nil, nil, false
+}
+
+// Packages returns the checked package and its transitive imports.
+func (p *checkedProgram) Packages() []*types.Package {
+	seen := make(map[*types.Package]bool)
+	var visit func(pkg *types.Package)
+	visit = func(pkg *types.Package) {
+		if pkg == nil || seen[pkg] {
+			return
+		}
+		seen[pkg] = true
+		for _, imp := range pkg.Imports() {
+			visit(imp)
+		}
+	}
+	visit(p.pkg.Pkg)
+	pkgs := make([]*types.Package, 0, len(seen))
+	for pkg := range seen {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Path() < pkgs[j].Path() })
+	return pkgs
+}
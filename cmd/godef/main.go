@@ -1,101 +1,599 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"go/build"
+	"io"
+	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
-	"runtime/pprof"
-	"strconv"
+	"sort"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/charlievieth/godef"
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
 )
 
-var cpuprofileFlag = flag.String("cpuprofile", "", "write CPU profile to `file`")
+var (
+	printDeclFlag  = flag.Bool("print-decl", false, "print the declaration of the definition")
+	goEnvFlag      = flag.Bool("goenv", true, "configure the build context with 'go env'")
+	inferGOPATH    = flag.Bool("infer-gopath", true, "add the workspace of files outside of GOPATH to GOPATH")
+	resolverFlag   = flag.String("resolver", "gopath", "import path resolver: gopath, module or driver")
+	wrappersFlag   = flag.Bool("wrappers", false, "also print the functions called by trivial wrapper functions")
+	asmFlag        = flag.Bool("asm", false, "also print the assembly or go:linkname implementations of functions without a body")
+	variantsFlag   = flag.Bool("variants", false, "also print the declarations for other platforms and build tags")
+	aliasesFlag    = flag.Bool("aliases", false, "print the declaration of the type denoted by a type alias rather than that of the alias")
+	implsFlag      = flag.String("implementations", "none", "also print the implementations of an interface method called at the offset: none, or those in the package or module")
+	docLinksFlag   = flag.Bool("doclinks", false, "resolve the doc link or identifier at an offset in a comment")
+	bestEffortFlag = flag.Bool("best-effort", false, "if the type checker fails, print a declaration of the name of the identifier found by parsing its package (approximate in the JSON output)")
+	protoFlag      = flag.Bool("proto", false, "also print the .proto definitions of declarations in .pb.go files")
+	inferTagsFlag  = flag.String("infer-tags", "context", "build tags of the query: context, or add the custom tags of the queried file or package")
+	symlinksFlag   = flag.String("symlinks", "preserve", "symlinks in result paths: preserve, resolve or workspace")
+	columnsFlag    = flag.String("columns", "byte", "unit of result columns: byte, rune or utf16")
+	physicalFlag   = flag.Bool("physical", false, "report positions in generated files rather than the files named by their //line directives")
+	checkoutsFlag  = checkouts{}
+	rootsFlag      = roots{}
+	fakeGorootFlag = flag.Bool("fake-goroot", false, "map files beneath a directory containing a .fake_goroot file to GOROOT")
+	gorootSrcFlag  = flag.String("goroot-src", "", "report results in GOROOT/src in the copy of the source tree `dir`")
+	strictGOPATH   = flag.Bool("strict-gopath", false, "fail if a GOPATH entry does not exist or cannot be read")
+	gorootDevFlag  = flag.Bool("goroot-dev", false, "use the Go source tree enclosing the queried file as GOROOT")
+	showErrorsFlag = flag.Bool("show-errors", false, "print the errors of the type checker for the package of the queried file to stderr, they may make the definition inaccurate (errors in the JSON output)")
+	probeFlag      = flag.Bool("probe", false, "print the result as JSON, including when no definition is found")
+	typeFlag       = flag.Bool("t", false, "print the type of the definition")
+	membersFlag    = flag.Bool("a", false, "print the exported members of the type of the definition")
+	allMembersFlag = flag.Bool("A", false, "like -a, but include unexported members")
+	completeFlag   = flag.Bool("complete", false, "print the members that may follow the selector x. at the offset, one per line")
+	fromFlag       = flag.Bool("from", false, "print the uses in its package of the definition at the offset, one per line as file:line:column: declaration")
+	timeoutFlag    = flag.Duration("timeout", 0, "fail the query if it takes longer than `duration`, 0 for no limit")
+	fileFlag       = flag.String("f", "", "`file` to query, instead of the position argument")
+	offsetFlag     = flag.Int("o", 0, "byte `offset` of the identifier to query in the -f file")
+	identEndFlag   = flag.Bool("ident-end", false, "also accept an offset immediately after the identifier to query")
+	markFlag       = flag.String("mark", "", "query the identifier following the unique `string` in the -f file, instead of -o")
+	stdinFlag      = flag.Bool("i", false, "read the source of the queried file from stdin")
+	overlayFlag    = flag.String("overlay", "", "read the contents of modified files from the go build -overlay JSON `file`")
+	modifiedFlag   = flag.Bool("modified", false, "read the contents of modified files from stdin, as an archive in the format of guru -modified")
+	jsonFlag       = flag.Bool("json", false, "print the result as JSON, as guru does with the guru query syntax")
+	verboseFlag    = flag.Bool("v", false, "print debug messages describing how the query is answered to stderr")
+	explainFlag    = flag.Bool("explain-context", false, "print the build configuration the query was answered with to stderr")
+	formatFlag     = flag.String("format", "", "output `format`: "+strings.Join(godef.FormatterNames(), ", ")+" (default plain, or json with -probe)")
+	warmFlag       = flag.Bool("warm", false, "index and parse the packages of the directory arguments, e.g. ./..., and exit; with -index the package index is saved for later queries")
+	indexFlag      = flag.String("index", "", "load the package index from `file`, or with -warm, and when -http is stopped by a signal, save it to file")
+	httpFlag       = flag.String("http", "", "serve definition queries as an HTTP JSON API on `addr`, e.g. :6060, instead of answering one query (see godef.NewHTTPHandler)")
+	restrictFlag   = flag.Bool("restrict", false, "with -http, reject queries and overlays of files outside the -root directories, GOROOT and the module cache")
+)
+
+func init() {
+	flag.BoolVar(verboseFlag, "debug", false, "same as -v")
+	flag.Var(checkoutsFlag, "checkout", "report module cache results of `module=dir` in the checkout dir (may be repeated)")
+	flag.Var(&rootsFlag, "root", "with -http, isolate the queries of files beneath the root `dir[=gopath]`: they have their own caches and, if given, GOPATH, and share the package index (may be repeated)")
+}
+
+// checkouts is a flag.Value mapping module paths to source checkouts.
+type checkouts map[string]string
+
+func (c checkouts) String() string {
+	var list []string
+	for mod, dir := range c {
+		list = append(list, mod+"="+dir)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
+
+func (c checkouts) Set(s string) error {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || i == len(s)-1 {
+		return fmt.Errorf("invalid checkout %q: want module=dir", s)
+	}
+	c[s[:i]] = s[i+1:]
+	return nil
+}
+
+// roots is a flag.Value listing the workspace roots of the -http server.
+type roots []root
+
+// A root is a workspace root and its GOPATH, if set.
+type root struct{ dir, gopath string }
+
+func (r *roots) String() string {
+	var list []string
+	for _, root := range *r {
+		if root.gopath != "" {
+			list = append(list, root.dir+"="+root.gopath)
+		} else {
+			list = append(list, root.dir)
+		}
+	}
+	return strings.Join(list, ",")
+}
+
+func (r *roots) Set(s string) error {
+	dir, gopath := s, ""
+	if i := strings.IndexByte(s, '='); i >= 0 {
+		dir, gopath = s[:i], s[i+1:]
+	}
+	if dir == "" {
+		return fmt.Errorf("invalid root %q: want dir or dir=gopath", s)
+	}
+	*r = append(*r, root{dir, gopath})
+	return nil
+}
 
 func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] file.go:#offset\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] -f file.go -o offset\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] -f file.go -mark string\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] definition file.go:#offset (the syntax of guru)\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] -warm dir[/...] ...\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s [flags] -http addr\n", os.Args[0])
 		flag.PrintDefaults()
-		os.Exit(2)
+		fmt.Fprintf(os.Stderr, "Exit status:\n")
+		fmt.Fprintf(os.Stderr, "  %d usage, %d no identifier, %d not found, %d timeout, %d parse error, %d other errors\n",
+			godef.ExitUsage, godef.ExitNoIdentifier, godef.ExitNotFound, godef.ExitTimeout, godef.ExitParseError, godef.ExitError)
+		os.Exit(godef.ExitUsage)
 	}
 	flag.Parse()
 
-	if flag.NArg() != 1 {
+	// As with guru, the query mode may precede the position, so that
+	// editor plugins written for "guru definition" can run godef.
+	args := flag.Args()
+	guru := len(args) == 2 && args[0] == "definition"
+	if guru {
+		args = args[1:]
+	}
+
+	if *warmFlag {
+		if len(args) == 0 || *fileFlag != "" {
+			flag.Usage()
+		}
+		warm(args)
+		return
+	}
+
+	if *httpFlag != "" {
+		// Queries are read from requests.
+		if len(args) != 0 || *fileFlag != "" || *stdinFlag || *modifiedFlag || (*restrictFlag && len(rootsFlag) == 0) {
+			flag.Usage()
+		}
+	} else if len(rootsFlag) != 0 || *restrictFlag {
+		flag.Usage()
+	} else if (*fileFlag == "") != (len(args) == 1) || (*markFlag != "" && *fileFlag == "") || (*stdinFlag && *modifiedFlag) {
+		// The -f and -o flags are supported for compatibility with the
+		// original godef.
 		flag.Usage()
-		os.Exit(2)
 	}
 
 	// Profiling support.
-	if *cpuprofileFlag != "" {
-		f, err := os.Create(*cpuprofileFlag)
+	if err := startProfiling(); err != nil {
+		stopProfiling()
+		log.Fatal(err)
+	}
+	defer stopProfiling()
+
+	filename, offset := *fileFlag, *offsetFlag
+	if filename == "" && *httpFlag == "" {
+		sp, err := span.Parse(args[0])
 		if err != nil {
-			log.Fatal(err)
+			Fatal(usageError{err})
 		}
-		pprof.StartCPUProfile(f)
-		defer pprof.StopCPUProfile()
+		filename, offset = sp.Filename, sp.Start.Offset
 	}
+	var src interface{}
+	if *stdinFlag {
+		b, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			Fatal(err)
+		}
+		src = b
+	}
+	conf := godef.Config{
+		Context:          build.Default,
+		UseGoEnv:         *goEnvFlag,
+		Workspace:        godef.WorkspaceResolver{Disabled: !*inferGOPATH},
+		ResolveWrappers:  *wrappersFlag,
+		ResolveAssembly:  *asmFlag,
+		Probe:            *probeFlag,
+		ModuleCheckouts:  checkoutsFlag,
+		EnableFakeGoroot: *fakeGorootFlag,
+		GOROOTSource:     *gorootSrcFlag,
+		StrictGOPATH:     *strictGOPATH,
+		GOROOTDev:        *gorootDevFlag,
 
-	filename, startOffset, _, err := parsePos(flag.Arg(0))
+		Describe:          *typeFlag || *membersFlag || *allMembersFlag,
+		UnexportedMembers: *allMembersFlag,
+	}
+	var err error
+	if *indexFlag != "" {
+		conf.PackageIndex, err = loadIndex(*indexFlag)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+	if *overlayFlag != "" {
+		conf.Overlay, err = readOverlay(*overlayFlag)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+	if *modifiedFlag {
+		modified, err := readModified(os.Stdin)
+		if err != nil {
+			Fatal(err)
+		}
+		if conf.Overlay == nil {
+			conf.Overlay = make(map[string][]byte, len(modified))
+		}
+		for name, src := range modified {
+			conf.Overlay[name] = src
+		}
+	}
+	if *verboseFlag {
+		conf.Logger = log.New(os.Stderr, "godef: ", log.Lmicroseconds)
+	}
+	conf.SymlinkPolicy, err = godef.ParseSymlinkPolicy(*symlinksFlag)
 	if err != nil {
+		Fatal(usageError{err})
+	}
+	conf.ColumnEncoding, err = godef.ParseColumnEncoding(*columnsFlag)
+	if err != nil {
+		Fatal(usageError{err})
+	}
+	conf.IgnoreLineDirectives = *physicalFlag
+	conf.AcceptIdentifierEnd = *identEndFlag
+	conf.ResolveVariants = *variantsFlag
+	conf.ResolveAliases = *aliasesFlag
+	conf.ResolveDocLinks = *docLinksFlag
+	conf.BestEffort = *bestEffortFlag
+	if *protoFlag {
+		conf.Analyzers = append(conf.Analyzers, godef.ProtoAnalyzer{})
+	}
+	conf.Implementations, err = godef.ParseImplementationScope(*implsFlag)
+	if err != nil {
+		Fatal(usageError{err})
+	}
+	conf.BuildTagStrategy, err = godef.ParseBuildTagStrategy(*inferTagsFlag)
+	if err != nil {
+		Fatal(usageError{err})
+	}
+	switch *resolverFlag {
+	case "gopath":
+		// default
+	case "module":
+		conf.Resolver = &godef.ModuleResolver{}
+	case "driver":
+		conf.Resolver = &godef.DriverResolver{}
+	default:
+		Fatal(usageError{fmt.Errorf("invalid -resolver: %q", *resolverFlag)})
+	}
+	if *httpFlag != "" {
+		serveHTTP(*httpFlag, &conf)
+		return
+	}
+
+	format := *formatFlag
+	if format == "" {
+		switch {
+		case guru && *jsonFlag:
+			format = "guru-json"
+		case guru:
+			format = "guru"
+		case *probeFlag, *jsonFlag:
+			format = "json"
+		default:
+			format = "plain"
+		}
+	}
+	formatter, ok := godef.LookupFormatter(format)
+	if !ok {
+		Fatal(usageError{fmt.Errorf("invalid -format: %q", format)})
+	}
+
+	if *markFlag != "" {
+		offset, err = markOffset(conf.Overlay, filename, src, *markFlag)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+
+	query := &godef.Query{
+		Mode:   "definition",
+		Pos:    span.New(filename, offset).String(),
+		Engine: godef.NewEngine(&conf),
+		Src:    src,
+	}
+	if *completeFlag {
+		query.Mode = "complete"
+	}
+	if *fromFlag {
+		query.Mode = "references"
+	}
+	ctx := context.Background()
+	if *timeoutFlag > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeoutFlag)
+		defer cancel()
+	}
+	qres, err := query.Run(ctx)
+	if err != nil {
+		var nf *godef.NotFoundError
+		if *showErrorsFlag && errors.As(err, &nf) {
+			for _, e := range nf.Errors {
+				fmt.Fprintf(os.Stderr, "type error: %s\n", e)
+			}
+		}
 		Fatal(err)
 	}
+	if list, ok := qres.(godef.Completions); ok {
+		for _, c := range list {
+			fmt.Printf("%s\t%s\t%s\n", c.Name, c.Kind, c.Signature)
+		}
+		return
+	}
+	res := qres.(*godef.Result)
+	if *fromFlag {
+		for _, r := range res.References {
+			fmt.Printf("%s: %s\n", r.Position, r.Description)
+		}
+		return
+	}
+	if *explainFlag {
+		fmt.Fprintf(os.Stderr, "build context: %s\n", res.Context)
+		if len(res.Context.Adjusted) != 0 {
+			fmt.Fprintf(os.Stderr, "adjusted for %s: %s\n", filename, strings.Join(res.Context.Adjusted, " "))
+		}
+	}
+	if !*typeFlag {
+		res.Type = ""
+	}
+	if !*membersFlag && !*allMembersFlag {
+		res.Members = nil
+	}
+	if !*showErrorsFlag {
+		res.Errors = nil
+	}
+	if !*probeFlag {
+		// Warnings are part of the result with -probe.
+		for _, w := range res.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+		for _, e := range res.Errors {
+			fmt.Fprintf(os.Stderr, "type error: %s\n", e)
+		}
+		switch {
+		case res.TypeError != nil:
+			fmt.Fprintf(os.Stderr, "Warning: %s found by name, the package has type errors: %v\n", res.Description, res.TypeError)
+		case res.Approximate:
+			fmt.Fprintf(os.Stderr, "Warning: approximate definition of %s found by a textual search\n", res.Description)
+		}
+	}
+	if err := formatter.Format(os.Stdout, res); err != nil {
+		Fatal(err)
+	}
+	pos := res.Position
+	if *printDeclFlag && res.Found {
+		src, err := ioutil.ReadFile(pos.Filename)
+		if err != nil {
+			Fatal(err)
+		}
+		decl, err := godef.Snippet(pos.Filename, src, pos.Offset, godef.DefaultSnippetLines)
+		if err != nil {
+			Fatal(err)
+		}
+		fmt.Println(decl)
+	}
+}
+
+// warm warms the package index and AST cache of a Config with the
+// packages of dirs and reports how many were read and how long it took.
+func warm(dirs []string) {
 	conf := godef.Config{
-		Context: build.Default,
+		Context:      build.Default,
+		UseGoEnv:     *goEnvFlag,
+		PackageIndex: godef.NewPackageIndex(0),
+		ASTCache:     godef.NewASTCache(0),
+	}
+	if *verboseFlag {
+		conf.Logger = log.New(os.Stderr, "godef: ", log.Lmicroseconds)
+	}
+	start := time.Now()
+	n, err := conf.Warm(dirs)
+	if err != nil {
+		Fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "warmed %d packages, %d files in %s\n", n, conf.ASTCache.Len(), time.Since(start).Round(time.Millisecond))
+	if *indexFlag != "" {
+		if err := saveIndex(*indexFlag, conf.PackageIndex); err != nil {
+			Fatal(err)
+		}
 	}
+}
 
-	pos, _, err := conf.Define(filename, startOffset, nil)
+// serveHTTP serves the queries of HTTP requests to addr with conf and
+// caches that are kept for the life of the server.  The queries of the
+// files beneath each -root are isolated from the others: they have
+// their own caches and, if it is given, GOPATH, so that the workspace
+// inferred for one project is not used by another.  With -restrict only
+// the files beneath the roots can be queried.
+func serveHTTP(addr string, conf *godef.Config) {
+	conf.ProgramCache = godef.NewProgramCache(0)
+	conf.ASTCache = godef.NewASTCache(0)
+	if conf.PackageIndex == nil {
+		conf.PackageIndex = godef.NewPackageIndex(0)
+	}
+	e := godef.NewEngine(conf)
+	h := &godef.HTTPHandler{Engine: e}
+	for _, r := range rootsFlag {
+		if err := e.AddIsolatedRoot(r.dir, r.gopath); err != nil {
+			Fatal(err)
+		}
+		if *restrictFlag {
+			h.AllowedDirs = append(h.AllowedDirs, r.dir)
+		}
+	}
+	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		Fatal(err)
 	}
-	fmt.Println(pos)
+	fmt.Fprintf(os.Stderr, "serving definition queries on http://%s/definition\n", ln.Addr())
+
+	// On SIGINT or SIGTERM, finish the queries in progress and save the
+	// package index for later runs.
+	srv := &http.Server{Handler: h}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		signal.Stop(sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "godef: shutdown:", err)
+		}
+		if *indexFlag != "" {
+			if err := saveIndex(*indexFlag, conf.PackageIndex); err != nil {
+				Fatal(err)
+			}
+		}
+	}()
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		Fatal(err)
+	}
+	<-done
+}
+
+// loadIndex returns the package index saved in filename, or an empty
+// index if the file does not exist.
+func loadIndex(filename string) (*godef.PackageIndex, error) {
+	index := godef.NewPackageIndex(0)
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := index.Load(f); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return index, nil
 }
 
-// parseOctothorpDecimal returns the numeric value if s matches "#%d",
-// otherwise -1.
-func parseOctothorpDecimal(s string) int {
-	if s != "" && s[0] == '#' {
-		if s, err := strconv.ParseInt(s[1:], 10, 32); err == nil {
-			return int(s)
+// saveIndex saves index to filename, replacing it atomically so that
+// concurrent queries do not read a partial index.
+func saveIndex(filename string, index *godef.PackageIndex) error {
+	f, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := index.Save(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// markOffset returns the offset following marker in the source of
+// filename: src, if read from stdin, its overlay or the file.
+func markOffset(overlay map[string][]byte, filename string, src interface{}, marker string) (int, error) {
+	b, _ := src.([]byte)
+	if b == nil {
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			return -1, err
 		}
+		if b = overlay[abs]; b == nil {
+			if b, err = ioutil.ReadFile(filename); err != nil {
+				return -1, err
+			}
+		}
+	}
+	off, err := span.MarkerOffset(b, marker)
+	if err != nil {
+		return -1, fmt.Errorf("%s: %w", filename, err)
 	}
-	return -1
+	return off, nil
 }
 
-func parsePos(pos string) (filename string, startOffset, endOffset int, err error) {
-	if pos == "" {
-		err = fmt.Errorf("no source position specified")
-		return
+// readModified reads the contents of modified files from r, an archive
+// in the format of the -modified flag of guru (see
+// buildutil.ParseOverlayArchive), and returns them by absolute file
+// name.
+func readModified(r io.Reader) (map[string][]byte, error) {
+	archive, err := buildutil.ParseOverlayArchive(r)
+	if err != nil {
+		return nil, err
 	}
+	files := make(map[string][]byte, len(archive))
+	for name, src := range archive {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return nil, err
+		}
+		files[abs] = src
+	}
+	return files, nil
+}
 
-	colon := strings.LastIndex(pos, ":")
-	if colon < 0 {
-		err = fmt.Errorf("bad position syntax %q", pos)
-		return
+// readOverlay reads an overlay in the format of the go build -overlay
+// flag, a JSON object whose Replace field maps file names to the names
+// of files containing their contents, and returns the contents of the
+// files by absolute file name.
+func readOverlay(name string) (map[string][]byte, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
 	}
-	filename, offset := pos[:colon], pos[colon+1:]
-	startOffset = -1
-	endOffset = -1
-	if comma := strings.Index(offset, ","); comma < 0 {
-		// e.g. "foo.go:#123"
-		startOffset = parseOctothorpDecimal(offset)
-		endOffset = startOffset
-	} else {
-		// e.g. "foo.go:#123,#456"
-		startOffset = parseOctothorpDecimal(offset[:comma])
-		endOffset = parseOctothorpDecimal(offset[comma+1:])
-	}
-	if startOffset < 0 || endOffset < 0 {
-		err = fmt.Errorf("invalid offset %q in query position", offset)
-		return
+	var overlay struct {
+		Replace map[string]string
+	}
+	if err := json.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("parsing overlay %s: %w", name, err)
 	}
-	return
+	files := make(map[string][]byte, len(overlay.Replace))
+	for from, to := range overlay.Replace {
+		if to == "" {
+			return nil, fmt.Errorf("overlay %s: deleting %s is not supported", name, from)
+		}
+		src, err := ioutil.ReadFile(to)
+		if err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(from)
+		if err != nil {
+			return nil, err
+		}
+		files[abs] = src
+	}
+	return files, nil
 }
 
+// A usageError is an invalid command line, reported with the
+// godef.ExitUsage exit code.
+type usageError struct{ error }
+
+// Fatal prints err and exits with the exit code of err (see
+// godef.ExitCode), or godef.ExitError if it is not an error.
 func Fatal(err interface{}) {
 	if err == nil {
 		return
@@ -110,5 +608,13 @@ func Fatal(err interface{}) {
 	default:
 		fmt.Fprintf(os.Stderr, "%s: %#v\n", errMsg, e)
 	}
-	os.Exit(1)
+	code := godef.ExitError
+	switch e := err.(type) {
+	case usageError:
+		code = godef.ExitUsage
+	case error:
+		code = godef.ExitCode(e)
+	}
+	stopProfiling() // deferred calls are not run by os.Exit
+	os.Exit(code)
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
+)
+
+var (
+	cpuprofileFlag   = flag.String("cpuprofile", "", "write CPU profile to `file`")
+	memprofileFlag   = flag.String("memprofile", "", "write memory profile to `file`")
+	blockprofileFlag = flag.String("blockprofile", "", "write goroutine blocking profile to `file`")
+	traceFlag        = flag.String("trace", "", "write execution trace to `file`")
+)
+
+// profileStops are the functions that stop the profiles started by
+// startProfiling, in the order they were started.
+var profileStops []func() error
+
+// startProfiling starts the profiles and execution trace requested by
+// the command line flags.
+func startProfiling() error {
+	if *cpuprofileFlag != "" {
+		f, err := os.Create(*cpuprofileFlag)
+		if err != nil {
+			return err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return err
+		}
+		profileStops = append(profileStops, func() error {
+			pprof.StopCPUProfile()
+			return f.Close()
+		})
+	}
+	if *memprofileFlag != "" {
+		f, err := os.Create(*memprofileFlag)
+		if err != nil {
+			return err
+		}
+		profileStops = append(profileStops, func() error {
+			runtime.GC() // materialize all statistics
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+	if *blockprofileFlag != "" {
+		f, err := os.Create(*blockprofileFlag)
+		if err != nil {
+			return err
+		}
+		runtime.SetBlockProfileRate(1)
+		profileStops = append(profileStops, func() error {
+			if err := pprof.Lookup("block").WriteTo(f, 0); err != nil {
+				f.Close()
+				return err
+			}
+			return f.Close()
+		})
+	}
+	if *traceFlag != "" {
+		f, err := os.Create(*traceFlag)
+		if err != nil {
+			return err
+		}
+		if err := trace.Start(f); err != nil {
+			f.Close()
+			return err
+		}
+		profileStops = append(profileStops, func() error {
+			trace.Stop()
+			return f.Close()
+		})
+	}
+	return nil
+}
+
+// stopProfiling stops the profiles started by startProfiling and writes
+// them to their files.  It is safe to call more than once.
+func stopProfiling() {
+	for i := len(profileStops) - 1; i >= 0; i-- {
+		if err := profileStops[i](); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing profile: %s\n", err)
+		}
+	}
+	profileStops = nil
+}
@@ -0,0 +1,64 @@
+package godef
+
+import (
+	"fmt"
+
+	"github.com/charlievieth/godef/internal/span"
+)
+
+// A ColumnEncoding determines the unit of the columns of the positions
+// of results.
+type ColumnEncoding int
+
+const (
+	// ColumnByte counts columns in bytes, as go/token does.
+	ColumnByte ColumnEncoding = iota
+
+	// ColumnRune counts columns in Unicode code points.
+	ColumnRune
+
+	// ColumnUTF16 counts columns in UTF-16 code units, as the Language
+	// Server Protocol and many editors do.
+	ColumnUTF16
+)
+
+var columnEncodingNames = [...]string{
+	ColumnByte:  "byte",
+	ColumnRune:  "rune",
+	ColumnUTF16: "utf16",
+}
+
+func (e ColumnEncoding) String() string {
+	if 0 <= int(e) && int(e) < len(columnEncodingNames) {
+		return columnEncodingNames[e]
+	}
+	return fmt.Sprintf("ColumnEncoding(%d)", int(e))
+}
+
+// ParseColumnEncoding returns the ColumnEncoding named s, which is one
+// of "byte", "rune" or "utf16".
+func ParseColumnEncoding(s string) (ColumnEncoding, error) {
+	for e, name := range columnEncodingNames {
+		if s == name {
+			return ColumnEncoding(e), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid column encoding: %q", s)
+}
+
+// columnUnits maps each ColumnEncoding to its span.Unit.
+var columnUnits = [...]span.Unit{
+	ColumnByte:  span.Bytes,
+	ColumnRune:  span.Runes,
+	ColumnUTF16: span.UTF16,
+}
+
+// column returns the column of pos, a position in src with a byte
+// column, in encoding e.  The byte column is returned if pos is not in
+// src, e.g. because the file changed since it was loaded.
+func (e ColumnEncoding) column(src []byte, pos Position) int {
+	if e < 0 || int(e) >= len(columnUnits) {
+		return pos.Column
+	}
+	return pos.point().ColumnIn(src, columnUnits[e])
+}
@@ -0,0 +1,58 @@
+package godef
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestColumnEncoding(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The definition of X follows a 2 byte rune, a tab and a rune that
+	// is 4 bytes in UTF-8 and 2 code units in UTF-16.
+	const src = "package p\n\nvar é, \t𝔸, X = 1, 2, 3\n\nvar _ = X\n"
+	filename := filepath.Join(tmp, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []struct {
+		enc    ColumnEncoding
+		column int
+	}{
+		{ColumnByte, 16},
+		{ColumnRune, 12},
+		{ColumnUTF16, 13},
+	} {
+		conf := Config{Context: build.Default, ColumnEncoding: x.enc}
+		res, err := conf.Lookup(filename, strings.LastIndex(src, "X"), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", x.enc, err)
+		}
+		pos := res.Position
+		if pos.Line != 3 || pos.Column != x.column || pos.Offset != strings.Index(src, "X") {
+			t.Errorf("%s: got %d:%d (offset %d); want 3:%d", x.enc, pos.Line, pos.Column, pos.Offset, x.column)
+		}
+	}
+}
+
+func TestParseColumnEncoding(t *testing.T) {
+	for _, enc := range []ColumnEncoding{ColumnByte, ColumnRune, ColumnUTF16} {
+		if got, err := ParseColumnEncoding(enc.String()); err != nil || got != enc {
+			t.Errorf("ParseColumnEncoding(%q) = %v, %v; want: %v", enc, got, err, enc)
+		}
+	}
+	if _, err := ParseColumnEncoding("bytes"); err == nil {
+		t.Error("ParseColumnEncoding(\"bytes\"): expected an error")
+	}
+}
@@ -0,0 +1,191 @@
+package godef
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/charlievieth/godef/internal/span"
+)
+
+// A Completion is a member of a package or type that may follow the
+// selector at the queried position (see Engine.Complete).
+type Completion struct {
+	Name      string
+	Kind      Kind
+	Signature string // type of the member, e.g. "func(s string) string", empty if not declared
+}
+
+// complete returns the members that may follow the selector x. at the
+// query position, whose names start with the part of the selector
+// before the position.  The members of an imported package are found by
+// scanning its declarations, as for qualified identifiers by
+// definition, falling back on the type checker, which is used for all
+// other operands.  It also outputs the operand as the definition of q.
+func complete(q *Query) ([]Completion, error) {
+	start := q.env().Now()
+	qpos, err := q.parseQueryPos(true)
+	if err != nil {
+		return nil, err
+	}
+	q.stats.ParseTime = since(q.env(), start)
+	q.stats.FilesParsed = 1
+
+	sel, prefix := completionSelector(qpos.path, queryCursor(q, qpos))
+	if sel == nil {
+		return nil, &NotFoundError{Err: ErrNoSelector}
+	}
+	if id, ok := sel.X.(*ast.Ident); ok && id.Obj == nil {
+		f := qpos.path[len(qpos.path)-1].(*ast.File)
+		if pkg := importedPackage(f, id.Name); pkg != "" {
+			srcdir := filepath.Dir(qpos.fset.File(qpos.start).Name())
+			base := qpos.fset.Base()
+			list, err := scanPackageMembers(q, qpos.fset, srcdir, pkg, prefix)
+			q.stats.FilesParsed += countFiles(qpos.fset, base)
+			if err == nil {
+				q.logf("completed %s. by scanning package %q in %v", id.Name, pkg, since(q.env(), start))
+				q.stats.Strategy = StrategyPackageScan
+				q.Output(qpos.fset, &definitionResult{pos: id.Pos(), descr: "package " + pkg})
+				return list, nil
+			}
+			// The package may only be found with q.Resolver (e.g.
+			// a module), fall back on the type checker.
+			q.logf("scanning package %q: %v", pkg, err)
+		}
+	}
+
+	lprog, err := q.loadProgram()
+	if err != nil {
+		return nil, err
+	}
+	qpos, err = parseQueryPos(lprog, q.Pos, false, true)
+	if err != nil {
+		return nil, err
+	}
+	sel, prefix = completionSelector(qpos.path, queryCursor(q, qpos))
+	if sel == nil {
+		return nil, &NotFoundError{Err: ErrNoSelector}
+	}
+
+	var members []types.Object
+	var descr string
+	var pkgName *types.PkgName
+	if id, ok := sel.X.(*ast.Ident); ok {
+		pkgName, _ = qpos.info.Uses[id].(*types.PkgName)
+	}
+	if pkgName != nil {
+		members = objectMembers(pkgName, false)
+		descr = "package " + pkgName.Imported().Path()
+	} else {
+		tv, ok := qpos.info.Types[sel.X]
+		if !ok || tv.Type == nil {
+			return nil, &NotFoundError{Err: ErrNoObject}
+		}
+		// Unexported members are accessible in their own package.
+		for _, m := range typeMembers(tv.Type) {
+			if m.Exported() || m.Pkg() == qpos.info.Pkg {
+				members = append(members, m)
+			}
+		}
+		descr = qpos.typeString(tv.Type)
+	}
+
+	qf := types.RelativeTo(qpos.info.Pkg)
+	var list []Completion
+	for _, m := range members {
+		if !strings.HasPrefix(m.Name(), prefix) {
+			continue
+		}
+		sig := types.TypeString(m.Type(), qf)
+		if _, ok := m.(*types.TypeName); ok {
+			sig = types.TypeString(m.Type().Underlying(), qf)
+		}
+		list = append(list, Completion{Name: m.Name(), Kind: objectKind(m, nil), Signature: sig})
+	}
+	q.Output(lprog.Fset(), &definitionResult{pos: sel.X.Pos(), descr: descr})
+	return list, nil
+}
+
+// completionSelector returns the selector x.Sel of path whose Sel, or
+// the position just after its period, is at pos, and the part of Sel
+// before pos.  It returns nil if pos is not after the period, e.g. if
+// it is in x.
+func completionSelector(path []ast.Node, pos token.Pos) (sel *ast.SelectorExpr, prefix string) {
+	sel = enclosingSelector(path)
+	if sel == nil || pos <= sel.X.End() {
+		return nil, ""
+	}
+	// The parser fills in a missing Sel, e.g. "x." at the end of a
+	// line, with the next identifier or "_".
+	if n := int(pos - sel.Sel.Pos()); n > 0 && n <= len(sel.Sel.Name) {
+		prefix = sel.Sel.Name[:n]
+	}
+	return sel, prefix
+}
+
+// queryCursor returns the position of the query in the file of qpos,
+// which is not adjusted for a cursor at the end of an identifier (see
+// Config.AcceptIdentifierEnd) unlike qpos.start.
+func queryCursor(q *Query, qpos *queryPos) token.Pos {
+	sp, err := span.Parse(q.Pos)
+	if err != nil {
+		return qpos.start
+	}
+	start, _, err := sp.Range(qpos.fset.File(qpos.start))
+	if err != nil {
+		return qpos.start
+	}
+	return start
+}
+
+// scanPackageMembers returns the exported package-level declarations of
+// pkg whose names start with prefix, sorted by name, by parsing the
+// files of the package.  srcdir is the directory in which the import
+// appears.
+func scanPackageMembers(q *Query, fset *token.FileSet, srcdir, pkg, prefix string) ([]Completion, error) {
+	bp, err := q.Build.Import(pkg, srcdir, 0)
+	if err != nil {
+		return nil, err // no files for package
+	}
+	filenames := make([]string, len(bp.GoFiles))
+	for i, name := range bp.GoFiles {
+		filenames[i] = filepath.Join(bp.Dir, name)
+	}
+	var list []Completion
+	add := func(id *ast.Ident, kind Kind, typ ast.Expr) {
+		if !id.IsExported() || !strings.HasPrefix(id.Name, prefix) {
+			return
+		}
+		c := Completion{Name: id.Name, Kind: kind}
+		if typ != nil {
+			c.Signature = types.ExprString(typ)
+		}
+		list = append(list, c)
+	}
+	for _, f := range parseFiles(fset, q.Build, q.astCache, filenames, 0, q.parseWorkers) {
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					add(decl.Name, KindFunction, decl.Type)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						add(spec.Name, KindType, spec.Type)
+					case *ast.ValueSpec:
+						for _, name := range spec.Names {
+							add(name, tokenKind(decl.Tok), spec.Type)
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list, nil
+}
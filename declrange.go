@@ -0,0 +1,201 @@
+package godef
+
+import (
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// A DeclRange is the source extent of a declaration (see
+// Engine.EnclosingDecl).
+type DeclRange struct {
+	Start, End Position // extent of the declaration, excluding its doc comment
+	Kind       Kind     // kind of the declared objects, e.g. KindFunction
+	Name       string   // name of the first declared object, empty for a blank or grouped declaration
+}
+
+// declResult is the result of enclosingDecl.
+type declResult struct {
+	end  token.Position
+	kind Kind
+	name string
+}
+
+// enclosingDecl returns the extent of the innermost declaration
+// enclosing the query position: a function or method declaration, a
+// declaration statement or a top-level declaration, or a single
+// specification of a parenthesized declaration, e.g. one type of a
+// "type (...)" block.  A position in the parentheses but outside any
+// specification selects the whole block.  It also outputs the start of
+// the declaration as the definition of q.  Only the queried file is
+// parsed.
+func enclosingDecl(q *Query) (*declResult, error) {
+	qpos, err := q.parseQueryPos(q.identEnd)
+	if err != nil {
+		return nil, err
+	}
+	q.stats.FilesParsed = 1
+	q.stats.Strategy = StrategyParser
+
+	var decl ast.Node
+	var res declResult
+path:
+	for i, n := range qpos.path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			decl = n
+			res.name = n.Name.Name
+			res.kind = KindFunction
+			if n.Recv != nil {
+				res.kind = KindMethod
+			}
+			break path
+		case *ast.GenDecl:
+			decl = n
+			res.kind = genDeclKind(n.Tok)
+			if n.Lparen.IsValid() {
+				if i > 0 {
+					// Select the specification enclosing the position.
+					decl = qpos.path[i-1]
+					res.name = specName(qpos.path[i-1].(ast.Spec))
+				}
+			} else if len(n.Specs) == 1 {
+				res.name = specName(n.Specs[0])
+			}
+			break path
+		}
+	}
+	if decl == nil {
+		return nil, &NotFoundError{Err: ErrNoDecl}
+	}
+	res.end = q.position(qpos.fset, decl.End())
+	descr := "declaration"
+	if res.name != "" {
+		descr += " of " + res.name
+	}
+	// The kind is left out of the output, so that Config.ResolveWrappers
+	// and the like do not look for the candidates of a function.
+	q.Output(qpos.fset, &definitionResult{
+		pos:   decl.Pos(),
+		descr: descr,
+	})
+	return &res, nil
+}
+
+// genDeclKind returns the kind of the objects declared by a declaration
+// with token tok, KindUnknown for imports.
+func genDeclKind(tok token.Token) Kind {
+	switch tok {
+	case token.TYPE:
+		return KindType
+	case token.VAR:
+		return KindVariable
+	case token.CONST:
+		return KindConst
+	}
+	return KindUnknown
+}
+
+// specName returns the name of the first object declared by spec, or
+// the empty string if it is blank or spec is an import.
+func specName(spec ast.Spec) string {
+	var name string
+	switch spec := spec.(type) {
+	case *ast.TypeSpec:
+		name = spec.Name.Name
+	case *ast.ValueSpec:
+		name = spec.Names[0].Name
+	}
+	if name == "_" {
+		return ""
+	}
+	return name
+}
+
+// targetExtent returns the end of the identifier, or the import path of
+// a package name, at pos, the position of the definition of q, and the
+// extent of its declaration (see Result.End and Result.DeclStart).  The
+// syntax trees of the query are used if they contain pos, otherwise
+// the file is parsed again, so that the extent is available whatever
+// the strategy of the query; positions are invalid if it cannot be
+// parsed or there is no identifier at pos.
+func targetExtent(q *Query, pos token.Pos) (end, declStart, declEnd token.Position) {
+	p := q.Fset.PositionFor(pos, false)
+	if !p.IsValid() || !strings.HasSuffix(p.Filename, ".go") {
+		return
+	}
+	fset, start := q.Fset, pos
+	path := q.syntaxPath(pos)
+	if path == nil {
+		fset = token.NewFileSet()
+		if q.astCache != nil {
+			fset = q.astCache.fileSet()
+		}
+		f, _ := parseFile(fset, q.Build, q.astCache, "", p.Filename, 0)
+		if f == nil {
+			return
+		}
+		tf := fset.File(f.Pos())
+		if p.Offset >= tf.Size() {
+			return
+		}
+		start = tf.Pos(p.Offset)
+		path, _ = astutil.PathEnclosingInterval(f, start, start)
+	}
+	if len(path) < 2 || path[0].Pos() != start {
+		return
+	}
+	if _, ok := path[1].(*ast.ImportSpec); !isIdent(path) && !ok {
+		return // neither an identifier nor the path of an import
+	}
+	end = q.position(fset, path[0].End())
+	if decl := declNode(path); decl != nil {
+		declStart = q.position(fset, decl.Pos())
+		declEnd = q.position(fset, decl.End())
+	}
+	return
+}
+
+// syntaxPath returns the AST path to pos, a position in q.Fset, in the
+// syntax trees of q (see Query.files), or nil if they do not contain
+// pos.
+func (q *Query) syntaxPath(pos token.Pos) []ast.Node {
+	if q.lprog != nil && q.lprog.Fset() == q.Fset {
+		if _, path, _ := q.lprog.PathEnclosingInterval(pos, pos); len(path) != 0 {
+			return path
+		}
+	}
+	for _, pf := range q.files {
+		if pf.fset == q.Fset && q.Fset.File(pf.f.Pos()) == q.Fset.File(pos) {
+			path, _ := astutil.PathEnclosingInterval(pf.f, pos, pos)
+			return path
+		}
+	}
+	return nil
+}
+
+// declNode returns the node of the declaration of path[0]:
+// a function declaration, a field or parameter, a specification of a
+// parenthesized declaration or else the whole declaration, or the
+// statement declaring a local variable or label.  It returns nil for
+// the name of the package.
+func declNode(path []ast.Node) ast.Node {
+	for i, n := range path {
+		switch n := n.(type) {
+		case *ast.FuncDecl, *ast.Field:
+			return n
+		case ast.Spec:
+			if d, ok := path[i+1].(*ast.GenDecl); ok && !d.Lparen.IsValid() {
+				return d
+			}
+			return n
+		case ast.Stmt:
+			return n
+		case *ast.File:
+			return nil
+		}
+	}
+	return nil
+}
@@ -14,6 +14,8 @@ import (
 	"strings"
 
 	util "github.com/charlievieth/buildutil"
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
 )
 
 var knownOS = make(map[string]bool)
@@ -35,12 +37,23 @@ type Position struct {
 	Column   int    // column number, starting at 1 (character count)
 }
 
-func newPosition(tp token.Position) *Position {
-	p := Position(tp)
-	return &p
+func (p Position) IsValid() bool { return p.point().HasPosition() }
+
+// point returns the span.Point of p.
+func (p Position) point() span.Point {
+	return span.Point{Offset: p.Offset, Line: p.Line, Column: p.Column}
 }
 
-func (p Position) IsValid() bool { return p.Line > 0 }
+// pointPosition returns the Position of point p in filename.
+func pointPosition(filename string, p span.Point) Position {
+	return Position{Filename: filename, Offset: p.Offset, Line: p.Line, Column: p.Column}
+}
+
+// tokenPosition returns the Position of pos.
+func tokenPosition(pos token.Position) Position {
+	s := span.FromPosition(pos)
+	return pointPosition(s.Filename, s.Start)
+}
 
 func (p Position) String() string {
 	s := p.Filename
@@ -56,26 +69,233 @@ func (p Position) String() string {
 	return s
 }
 
+// A Config configures definition queries (see NewEngine).
 type Config struct {
+	// Deprecated: UseOffset has no effect, cursors are always byte
+	// offsets.
 	UseOffset bool
-	Context   build.Context
+
+	// Context is the build configuration of the queries.  Its file
+	// system functions (OpenFile, ReadDir, IsDir, etc.), if set, are
+	// used in place of the operating system to read the queried files
+	// and the packages they import, e.g. so that queries can be made
+	// against an editor's in-memory file system, a sandbox or an
+	// archive (see ContextFromFS).  Overlay takes precedence over them.
+	// The packages backend and DriverResolver load packages with the
+	// go command or a driver, which use the operating system.
+	Context build.Context
+	Env     Environment // (optional) process environment, defaults to OSEnvironment
+
+	// UseGoEnv updates Context with the settings reported by
+	// `go env -json` (see GoEnvContext), run in Dir with the GOPATH of
+	// Env.
+	UseGoEnv bool
+
+	// Workspace infers the GOPATH workspace of queried files.
+	Workspace WorkspaceResolver
+
+	// Resolver resolves the import path of queried files (see
+	// ModuleResolver and DriverResolver), if nil import paths are
+	// resolved relative to GOPATH.
+	Resolver Resolver
+
+	// ResolveWrappers adds the functions called by trivial wrapper
+	// functions (functions whose body is a single call or return of a
+	// call) to the candidates of function definitions.
+	ResolveWrappers bool
+
+	// Probe reports queries for which no definition is found (see
+	// NotFoundError) as a Result with Found set to false rather than
+	// as an error.
+	Probe bool
+
+	// SymlinkPolicy determines how symbolic links in the paths of the
+	// results are handled.
+	SymlinkPolicy SymlinkPolicy
+
+	// ColumnEncoding is the unit of the columns of the positions of the
+	// results, bytes by default.  Other encodings are computed from the
+	// line of the position, which requires reading its file; offsets
+	// are always in bytes.
+	ColumnEncoding ColumnEncoding
+
+	// IgnoreLineDirectives reports positions in the files that were
+	// parsed, e.g. a file generated by goyacc, rather than in the files
+	// named by their //line directives.  Positions in the output of cgo
+	// are then in temporary files or the build cache.
+	IgnoreLineDirectives bool
+
+	// AcceptIdentifierEnd resolves a query offset immediately after an
+	// identifier, where most editors place the cursor "on" a word, as
+	// the identifier if there is none at the offset itself.
+	AcceptIdentifierEnd bool
+
+	// ModuleCheckouts maps module paths to source checkouts of the
+	// module.  Results in the module cache are reported in the checkout
+	// of their module, if it contains the file.
+	ModuleCheckouts map[string]string
+
+	// EnableFakeGoroot enables the remapping of queried files beneath a
+	// directory containing a ".fake_goroot" file to the same file in
+	// GOROOT/src, and of results in GOROOT/src back to that directory.
+	EnableFakeGoroot bool
+
+	// GOROOTSource is a copy of the GOROOT source tree (GOROOT/src),
+	// e.g. a vendored standard library.  Results in GOROOT/src are
+	// reported in the copy if it contains the file.
+	GOROOTSource string
+
+	// Overlay maps absolute file names to contents that are used in
+	// place of the file on disk.  Files that do not exist on disk are
+	// added to the package of their directory, so that queries can be
+	// made against files that have not been written yet.
+	Overlay map[string][]byte
+
+	// StrictGOPATH causes queries to fail with a *GOPATHError if a
+	// GOPATH entry cannot be used.  By default such entries are
+	// skipped and reported in Result.Warnings.
+	StrictGOPATH bool
+
+	// GOROOTDev enables querying files in a Go source tree (e.g. a
+	// checkout of the Go repository) other than Context.GOROOT: the
+	// tree enclosing the queried file is used as the GOROOT so that
+	// the standard library, including the runtime and internal
+	// packages, is loaded from it.  With the go/packages backend the
+	// tree must be complete, as it is passed to the go command as the
+	// GOROOT.
+	GOROOTDev bool
+
+	// Describe adds the type of the definition and the members of its
+	// type to the result (see Result.Type).  Only exported members are
+	// included unless UnexportedMembers is set.  The type checker is
+	// always used to describe definitions, so queries are slower.
+	Describe          bool
+	UnexportedMembers bool
+
+	// Logger, if non-nil, receives debug messages describing how
+	// queries are answered.
+	Logger Logger
+
+	// Hooks observe the queries made with the Config.
+	Hooks Hooks
+
+	// ProgramCache, if non-nil, reuses the programs loaded by earlier
+	// queries of the same file.
+	ProgramCache *ProgramCache
+
+	// ExportData type-checks the imports of the queried package with
+	// the export data produced by the go command, rather than parsing
+	// them, which is faster for packages with many dependencies.  It
+	// is only supported by the go/packages backend (the godef_packages
+	// build tag), the default backend parses the imports and adds a
+	// warning to Result.Warnings.  Changes to imported packages in
+	// Overlay are not observed.
+	ExportData bool
+
+	// ParseWorkers is the maximum number of files that are parsed
+	// concurrently, zero means runtime.NumCPU().  The loader backend
+	// parses the files of the packages it loads with its own
+	// concurrency.
+	ParseWorkers int
+
+	// ASTCache, if non-nil, reuses the files parsed by queries.
+	ASTCache *ASTCache
+
+	// PackageIndex, if non-nil, reuses the declarations of the packages
+	// scanned by queries.
+	PackageIndex *PackageIndex
+
+	// MemoryBudget, if non-nil, trims ProgramCache, ASTCache and
+	// PackageIndex after queries that leave the heap above its limit.
+	MemoryBudget *MemoryBudget
+
+	// ResolveAssembly adds the implementations of functions declared
+	// without a body to Result.Candidates: the TEXT symbol in the
+	// assembly files of the package and the targets of //go:linkname
+	// directives, in the package or in the runtime.
+	ResolveAssembly bool
+
+	// ResolveVariants adds the declarations of the result in the files
+	// of its package that are excluded by the build context, e.g. those
+	// for other operating systems, to Result.Candidates.  The Reason of
+	// each is the constraint that selects its file.
+	ResolveVariants bool
+
+	// ResolveAliases reports the declaration of the type denoted by a
+	// type alias, e.g. B for "type A = B", rather than that of the
+	// alias.  Chains of aliases are followed to the end, and the
+	// aliases are listed in Result.Aliases.  Aliases of unnamed types,
+	// e.g. "type A = []int", are not followed.
+	ResolveAliases bool
+
+	// Implementations, if not ImplementationsNone, adds the concrete
+	// methods that may be called by an interface method call x.f at
+	// the query position to Result.Candidates: the methods f of the
+	// named types in its scope that implement the type of x.  The
+	// Reason of each is the interface.  ImplementationsModule loads
+	// every package of the module, except with ExportData, which only
+	// loads the queried package.
+	Implementations ImplementationScope
+
+	// ResolveDocLinks resolves queries in comments: the doc link, e.g.
+	// [bytes.Buffer] or [Reader.Read], or the possibly qualified
+	// identifier at the query offset is resolved to the package member,
+	// or its field or method, that it names.
+	ResolveDocLinks bool
+
+	// BestEffort returns an approximate definition, rather than an
+	// error, for an identifier that neither the parser nor the type
+	// checker resolve, e.g. in a package that does not compile: a
+	// declaration of its name found by parsing the files of the package
+	// it is imported from, or of the queried package, with
+	// Result.Approximate set.  The declaration may not be the one the
+	// identifier denotes.
+	BestEffort bool
+
+	// Analyzers add definitions related to the results of queries to
+	// Result.Candidates, in order.
+	Analyzers []Analyzer
+
+	// BuildTagStrategy determines the build tags of queries, by default
+	// those of Context.  Other strategies add the custom tags of the
+	// queried file or its package, e.g. "integration", so that files
+	// that are only built with them can be queried.
+	BuildTagStrategy BuildTagStrategy
+
+	// Dir is the working directory of queries, it defaults to that of
+	// Env.  Relative file names are resolved against it, so that
+	// queries do not depend on the working directory of the process.
+	// The Context.Dir of a query, which go/build uses to find the main
+	// module, is the module root of the queried file.
+	Dir string
 }
 
-func updateGOPATH(ctxt *build.Context, filename string) string {
-	_, _, err := guessImportPath(filename, ctxt)
-	if err == nil {
-		return ctxt.GOPATH
+func (c *Config) env() Environment {
+	env := OSEnvironment
+	if c.Env != nil {
+		env = c.Env
 	}
-	if e, ok := err.(*PathError); ok && strings.Contains(e.Dir, "src") {
-		dirs := segments(e.Dir)
-		for i := len(dirs) - 1; i > 0; i-- {
-			if dirs[i] == "src" {
-				return strings.Join(dirs[:i], string(filepath.Separator)) +
-					string(os.PathListSeparator) + ctxt.GOPATH
+	return withDir(env, c.Dir)
+}
+
+// buildContext returns the build.Context of queries, which must not be
+// modified.
+func (c *Config) buildContext() (*build.Context, error) {
+	ctxt := &c.Context
+	if c.UseGoEnv {
+		env := c.env()
+		var dir string
+		var err error
+		if c.Dir != "" {
+			if dir, err = env.Getwd(); err != nil {
+				return nil, err
 			}
 		}
+		if ctxt, err = goEnvContext(ctxt, env, dir); err != nil {
+			return nil, err
+		}
 	}
-	return ctxt.GOPATH
+	return ctxt, nil
 }
 
 func updateGOOS(ctxt *build.Context, tags map[string]bool) string {
@@ -118,13 +338,13 @@ func updateGOARCH(ctxt *build.Context, tags map[string]bool) string {
 	return ctxt.GOARCH
 }
 
-func updateContextForFile(ctxt *build.Context, filename string, src []byte) *build.Context {
+func updateContextForFile(ctxt *build.Context, env Environment, ws *WorkspaceResolver, filename string, src []byte) *build.Context {
 	tags := make(map[string]bool)
-	if !util.GoodOSArchFile(ctxt, filename, tags) || !util.ShouldBuild(ctxt, src, tags) {
+	if !util.GoodOSArchFile(ctxt, filename, tags) || !shouldBuild(ctxt, src, tags) {
 		ctxt.GOOS = updateGOOS(ctxt, tags)
 		ctxt.GOARCH = updateGOARCH(ctxt, tags)
 	}
-	ctxt.GOPATH = updateGOPATH(ctxt, filename)
+	ctxt.GOPATH = ws.Resolve(ctxt, env, filename)
 	return ctxt
 }
 
@@ -159,43 +379,166 @@ func updateFilename(ctxt *build.Context, filename string) (string, string, bool)
 	return filename, "", false
 }
 
-func (c *Config) Define(filename string, cursor int, src interface{}) (*Position, []byte, error) {
-	body, err := readSource(filename, src)
+// A Result is the result of a definition query.
+type Result struct {
+	// Found reports whether a definition was found, it is only false
+	// if Config.Probe is set, in which case Reason is why it was not.
+	Found  bool
+	Reason string
+
+	Position    Position // position of the definition, invalid for built-ins without source
+	Description string   // description of the object it denotes
+	Kind        Kind     // semantic classification of the identifier
+	ReadOnly    bool     // the definition is in the (read-only) module cache
+	Approximate bool     // the definition was found by a textual search (see Config.BestEffort) or by name (see TypeError)
+
+	// End is the end of the identifier at Position, or of the import
+	// path for an implicit package name, and DeclStart and DeclEnd are
+	// the extent of its declaration, e.g. of a function declaration, a
+	// struct field or a specification of a "var (...)" block, excluding
+	// its doc comment.  They are invalid if the file of the definition
+	// is not a Go source file, and DeclStart and DeclEnd for the package
+	// clause.
+	End                Position
+	DeclStart, DeclEnd Position
+
+	// Object identifies the object denoted by the result independently
+	// of its position, it is the zero ObjectID for local objects.
+	Object ObjectID
+
+	// Candidates are additional definitions that may be the intended
+	// target of the query (see Config.ResolveWrappers).
+	Candidates []Candidate
+
+	// Aliases are the type aliases followed to the definition, from the
+	// queried identifier on (see Config.ResolveAliases).
+	Aliases []Candidate
+
+	// References are the uses of the definition in the package of the
+	// queried file, only set by Engine.References.  The Description of
+	// each is the declaration containing it, e.g. "func F".
+	References []Candidate
+
+	// Type and Members are only set if Config.Describe is set.  Type is
+	// the type of the definition, e.g. "F func(n int) int", and Members
+	// are the fields and methods of its type, or the members of the
+	// package it denotes, sorted by name.
+	Type    string
+	Members []Candidate
+
+	// Context is the build configuration the query was answered with.
+	Context QueryContext
+
+	// Stats are the timings and counts of the phases of the query.
+	Stats Stats
+
+	// TypeError is set if the queried identifier was not resolved by
+	// the type checker because its package has type errors, in which
+	// case the definition is that of an object of the same name in
+	// scope (or of a field or method for a selector) and Approximate is
+	// set.  It is the type error nearest to the identifier.
+	TypeError error
+
+	// Errors are the errors reported by the type checker for the
+	// package of the queried file, e.g. because it does not compile, in
+	// which case the definition may be inaccurate.  They are only set
+	// if the query was answered with the type checker (see Stats).
+	Errors []TypeCheckError
+
+	// Warnings are non-fatal problems encountered during the query
+	// that may make the result inaccurate (e.g. *VersionWarning).
+	Warnings []error
+
+	overlay map[string][]byte // overlay of the query, see ReadSource
+	ctxt    *build.Context    // file system of the query, see ReadSource
+}
+
+// ReadSource returns the contents of the file containing the definition,
+// as seen by the query: the queried source or Config.Overlay are used in
+// place of the file system for the files they contain.  A *ReadError is
+// returned if the file cannot be read.
+func (r *Result) ReadSource() ([]byte, error) {
+	return readOverlayFile(r.ctxt, r.overlay, r.Position.Filename)
+}
+
+// readOverlayFile returns the contents of filename in overlay or, if it
+// is not there, the file system of ctxt.
+func readOverlayFile(ctxt *build.Context, overlay map[string][]byte, filename string) ([]byte, error) {
+	if b, ok := overlay[filename]; ok {
+		return b, nil
+	}
+	b, err := readFile(ctxt, filename)
 	if err != nil {
-		return nil, nil, err
+		return nil, &ReadError{Filename: filename, Err: err}
 	}
+	return b, nil
+}
 
-	ctxt := useModifiedFile(&c.Context, filename, body)
+// A Candidate is an additional definition related to the result of a
+// query.
+type Candidate struct {
+	Position    Position // position of the definition
+	Description string   // description of the object it denotes
+	Kind        Kind     // semantic classification of the object
+	ReadOnly    bool     // the definition is in the (read-only) module cache
+	Reason      string   // why the candidate was included
+}
 
-	// TODO: replace with buildutil.MatchContext()
-	ctxt = updateContextForFile(ctxt, filename, body)
+// Define returns the position of the definition of the identifier at
+// byte offset cursor in filename and the contents of the file that
+// contains it (see Result.ReadSource).  If src is non-nil it is used as
+// the source of filename.  If the file containing the definition cannot
+// be read, its position is returned with a *ReadError.
+//
+// Define is equivalent to NewEngine(c).Define.
+func (c *Config) Define(filename string, cursor int, src interface{}) (*Position, []byte, error) {
+	return NewEngine(c).Define(filename, cursor, src)
+}
 
-	name, fake, replaceRoot := updateFilename(ctxt, filename)
+// Lookup is like Define, but returns a Result and does not read the
+// file containing the definition.
+//
+// Lookup is equivalent to NewEngine(c).Lookup.
+func (c *Config) Lookup(filename string, cursor int, src interface{}) (*Result, error) {
+	return NewEngine(c).Lookup(filename, cursor, src)
+}
 
-	query := &Query{
-		Mode:  "definition",
-		Pos:   fmt.Sprintf("%s:#%d", name, cursor),
-		Build: ctxt,
+// overlay returns Config.Overlay with the queried file added, as it may
+// not exist on disk.
+func (c *Config) overlay(filename string, src []byte) map[string][]byte {
+	abs, err := absPath(c.env(), filename)
+	if err != nil {
+		return c.Overlay
 	}
-	if err := definition(query); err != nil {
-		return nil, nil, err
+	if buildutil.FileExists(&c.Context, abs) && len(c.Overlay) == 0 {
+		return nil // the queried file is handled by useModifiedFile
 	}
-	pos := query.Fset.Position(query.result.pos)
-
-	// Replace real GOROOT with fake GOROOT
-	if replaceRoot && fake != "" {
-		old := ctxt.GOROOT + string(filepath.Separator) + "src"
-		pos.Filename = strings.Replace(pos.Filename, old, fake, 1)
+	overlay := make(map[string][]byte, len(c.Overlay)+1)
+	for name, content := range c.Overlay {
+		overlay[name] = content
 	}
+	overlay[abs] = src
+	return overlay
+}
 
-	b, err := ioutil.ReadFile(pos.Filename)
-	if err != nil {
-		return nil, nil, err
+// remapGOROOTFile returns the path of filename, if it is in the GOROOT
+// source tree of ctxt, in the copy of the source tree src if the copy
+// contains the file.
+func remapGOROOTFile(ctxt *build.Context, filename, src string) string {
+	rel, ok := hasFilePathPrefix(filename, filepath.Join(ctxt.GOROOT, "src"))
+	if !ok {
+		return filename
 	}
-	return newPosition(pos), b, nil
+	name := filepath.Join(src, rel)
+	if !fileExists(name) {
+		return filename
+	}
+	return name
 }
 
-func readSource(filename string, src interface{}) ([]byte, error) {
+// readSource returns src, if non-nil, or the contents of filename in
+// the file system of ctxt.
+func readSource(ctxt *build.Context, filename string, src interface{}) ([]byte, error) {
 	if src != nil {
 		switch s := src.(type) {
 		case string:
@@ -216,5 +559,20 @@ func readSource(filename string, src interface{}) ([]byte, error) {
 		}
 		return nil, errors.New("invalid source")
 	}
-	return ioutil.ReadFile(filename)
+	return readFile(ctxt, filename)
+}
+
+// readFile is ioutil.ReadFile in the file system of ctxt (see
+// build.Context.OpenFile), or that of the operating system if ctxt is
+// nil.
+func readFile(ctxt *build.Context, filename string) ([]byte, error) {
+	if ctxt == nil {
+		return ioutil.ReadFile(filename)
+	}
+	rc, err := buildutil.OpenFile(ctxt, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
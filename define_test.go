@@ -1,12 +1,22 @@
 package godef
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/build"
+	"go/parser"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
+
+	"golang.org/x/tools/go/buildutil"
 )
 
 var haveGoSrc bool
@@ -195,3 +205,2279 @@ func BenchmarkDefine_ImportedDecl(b *testing.B) {
 		}
 	}
 }
+
+type testEnv struct {
+	env map[string]string
+	wd  string
+	now time.Time
+}
+
+func (e *testEnv) Getenv(key string) string { return e.env[key] }
+func (e *testEnv) Getwd() (string, error)   { return e.wd, nil }
+func (e *testEnv) Now() time.Time           { return e.now }
+
+// writeFiles writes files, keyed by slash-separated names, to a new
+// temporary directory, which is removed when the test ends, and returns
+// the directory with its symbolic links evaluated.
+func writeFiles(t testing.TB, files map[string]string) string {
+	t.Helper()
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestWorkspaceResolver(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"src/p/p.go": "package p\n"})
+	dir := filepath.Join(tmp, "src", "p")
+
+	join := func(list ...string) string {
+		return strings.Join(list, string(os.PathListSeparator))
+	}
+	gopath := filepath.Join(tmp, "gopath")
+	tests := []struct {
+		resolver WorkspaceResolver
+		gopath   string
+		exp      string
+	}{
+		{WorkspaceResolver{}, gopath, join(tmp, gopath)},
+		{WorkspaceResolver{Append: true}, gopath, join(gopath, tmp)},
+		{WorkspaceResolver{Disabled: true}, gopath, gopath},
+		{WorkspaceResolver{Disabled: true}, join(gopath, "", gopath+"/"), gopath},
+		{WorkspaceResolver{Append: true}, join(gopath, tmp+"/"), join(gopath, tmp+"/")},
+	}
+	// Relative filenames are resolved against the working directory
+	// of the Environment.
+	env := &testEnv{wd: dir}
+	for _, x := range tests {
+		ctxt := build.Default
+		ctxt.GOPATH = x.gopath
+		got := x.resolver.Resolve(&ctxt, env, "p.go")
+		if got != x.exp {
+			t.Errorf("%+v: exp %q got %q", x, x.exp, got)
+		}
+	}
+}
+
+const kindSrc = `package p
+
+import "fmt"
+
+const C = 1
+
+type T struct{ F int }
+
+func (t T) M(a int) int { return a + t.F }
+
+func G() {
+	var v T
+	fmt.Println(C, v.F, v.M(1))
+}
+`
+
+func TestLookup_ObjectID(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"src/p/p.go": kindSrc})
+	filename := filepath.Join(tmp, "src", "p", "p.go")
+	tests := []struct {
+		marker string
+		id     string
+	}{
+		{"fmt.Println", "fmt"},
+		{"Println", "fmt.Println"},
+		{"C, v", "p.C"},
+		{"T\n", "p.T"},
+		{"F, v", "p.T.F"},
+		{"M(1)", "p.T.M"},
+		{"v.F", ""},
+		{"a + t", ""},
+	}
+	conf := Config{Context: build.Default}
+	conf.Context.GOPATH = tmp
+	for _, x := range tests {
+		res, err := conf.Lookup(filename, strings.Index(kindSrc, x.marker), nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if id := res.Object.String(); id != x.id {
+			t.Errorf("(%+v): object: exp %q got %q", x, x.id, id)
+		}
+	}
+}
+
+func TestLookup_Directive(t *testing.T) {
+	const src = "package p\n\nimport _ \"embed\"\n\n" +
+		"//go:embed data/a.txt \"data/b c.txt\" data/*.md\n" +
+		"var s string\n\n" +
+		"//go:generate go run gen.go -o $GOFILE\n\n" +
+		"//go:embed static/*.html\n" +
+		"var h string\n\n" +
+		"//go:generate go run tool.go missing.go\n\n" +
+		"type T struct {\n\tF int `json:\"f\"`\n}\n"
+	files := map[string]string{
+		"p.go":           src,
+		"gen.go":         "package main\n",
+		"data/a.txt":     "a\n",
+		"data/b c.txt":   "b\n",
+		"data/x.md":      "x\n",
+		"data/y.md":      "y\n",
+		"data/z.md.orig": "z\n",
+	}
+	tmp := writeFiles(t, files)
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker string
+		file   string // expected file, relative to tmp
+		err    error
+	}{
+		{"a.txt", "data/a.txt", nil},
+		{"c.txt", "data/b c.txt", nil},
+		{"*.md", "data/x.md", nil},
+		{"gen.go", "gen.go", nil},
+		{"$GOFILE", "p.go", nil},
+		{"-o", "", ErrNotFound},
+		{"json", "", ErrStructTag},
+		// Only in the overlay, the files are those of the build context.
+		{"static", "static/index.html", nil},
+		{"tool.go", "tool.go", nil},
+		{"missing.go", "", ErrNotFound},
+	}
+	conf := Config{
+		Context: build.Default,
+		Overlay: map[string][]byte{
+			filepath.Join(tmp, "static", "index.html"): []byte("<html>\n"),
+			filepath.Join(tmp, "tool.go"):              []byte("package main\n"),
+		},
+	}
+	for _, x := range tests {
+		res, err := conf.Lookup(filename, strings.Index(src, x.marker), nil)
+		if x.err != nil {
+			if !errors.Is(err, x.err) {
+				t.Errorf("(%+v): error: exp %v got %v", x, x.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		want := filepath.Join(tmp, filepath.FromSlash(x.file))
+		if res.Position.Filename != want || res.Position.Line != 1 || res.Kind != KindFile {
+			t.Errorf("(%+v): exp %s:1 (%s) got %s (%s)", x, want, KindFile, res.Position, res.Kind)
+		}
+	}
+}
+
+func TestLookup_ResolveAssembly(t *testing.T) {
+	const src = "package p\n\nimport _ \"unsafe\"\n\n" +
+		"func Add(a, b int) int\n\n" +
+		"//go:linkname nanotime runtime.nanotime\n" +
+		"func nanotime() int64\n\n" +
+		"var _, _ = Add(1, 2), nanotime()\n"
+	const asm = "#include \"textflag.h\"\n\nTEXT ·Add(SB),NOSPLIT,$0-24\n\tRET\n"
+	tmp := writeFiles(t, map[string]string{"p.go": src, "add.s": asm})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker string
+		file   string // expected file of the candidate
+		line   int
+		reason string
+	}{
+		{"Add(1", filepath.Join(tmp, "add.s"), 3, "assembly implementation"},
+		{"nanotime()", "", 0, "go:linkname target"},
+	}
+	conf := Config{Context: build.Default, ResolveAssembly: true}
+	for _, x := range tests {
+		if x.file == "" && !haveGoSrc {
+			continue // the runtime is not available
+		}
+		res, err := conf.Lookup(filename, strings.LastIndex(src, x.marker), nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if len(res.Candidates) != 1 {
+			t.Errorf("(%+v): candidates: exp 1 got %+v", x, res.Candidates)
+			continue
+		}
+		c := res.Candidates[0]
+		if c.Reason != x.reason || x.file != "" && (c.Position.Filename != x.file || c.Position.Line != x.line) {
+			t.Errorf("(%+v): got candidate %+v", x, c)
+		}
+	}
+}
+
+func TestLookup_ResolveVariants(t *testing.T) {
+	const src = "package p\n\nvar _ = F()\n\nvar _ = T{}.M\n"
+	files := map[string]string{
+		"go.mod":       "module example.com/p\n",
+		"p.go":         src,
+		"f_linux.go":   "package p\n\nfunc F() int { return 0 }\n\ntype T struct{}\n\nfunc (T) M() {}\n",
+		"f_windows.go": "package p\n\nfunc F() int { return 1 }\n\ntype T struct{}\n\nfunc (*T) M() {}\n",
+		"f_other.go":   "//go:build !linux && !windows\n// +build !linux,!windows\n\npackage p\n\nfunc F() int { return 2 }\n",
+		"f_test.go":    "package p\n\nfunc F() int { return 3 }\n",
+		"gen.go":       "//go:build ignore\n// +build ignore\n\npackage main\n\nfunc F() {}\n",
+	}
+	tmp := writeFiles(t, files)
+	ctxt := build.Default
+	ctxt.GOOS, ctxt.GOARCH = "linux", "amd64"
+	conf := Config{Context: ctxt, Resolver: &ModuleResolver{}, ResolveVariants: true}
+	tests := []struct {
+		marker string
+		want   []string // file and reason of each candidate
+	}{
+		{"F()", []string{
+			"f_other.go: //go:build !linux && !windows",
+			"f_windows.go: file name: windows",
+		}},
+		{"M\n", []string{
+			"f_windows.go: file name: windows",
+		}},
+	}
+	for _, x := range tests {
+		res, err := conf.Lookup(filepath.Join(tmp, "p.go"), strings.Index(src, x.marker), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", x.marker, err)
+		}
+		if res.Position.Filename != filepath.Join(tmp, "f_linux.go") {
+			t.Errorf("%s: exp the declaration in f_linux.go got %s", x.marker, res.Position)
+		}
+		var got []string
+		for _, c := range res.Candidates {
+			got = append(got, filepath.Base(c.Position.Filename)+": "+c.Reason)
+		}
+		if !reflect.DeepEqual(got, x.want) {
+			t.Errorf("%s: candidates: exp %q got %q", x.marker, x.want, got)
+		}
+	}
+}
+
+func TestLookup_ResolveAliases(t *testing.T) {
+	const src = "package p\n\nimport \"example.com/m/q\"\n\n" +
+		"type A = B\n\ntype B = q.C\n\n" +
+		"var _ A\n\nvar _ q.C\n\nvar _ q.D\n\nvar a A\n\nvar _ = a.F\n"
+	const qSrc = "package q\n\ntype C = T\n\ntype T struct{ F int }\n\ntype D = []int\n"
+	files := map[string]string{
+		"go.mod":   "module example.com/m\n",
+		"p/p.go":   src,
+		"q/q.go":   qSrc,
+		"q/doc.go": "// Package q declares aliases.\npackage q\n",
+	}
+	tmp := writeFiles(t, files)
+	filename := filepath.Join(tmp, "p", "p.go")
+	qfile := filepath.Join(tmp, "q", "q.go")
+	tests := []struct {
+		marker  string // queried identifier, its last occurrence in src
+		file    string
+		line    int
+		aliases []int // lines of the aliases, in p.go then q.go
+	}{
+		{"A\n", qfile, 5, []int{5, 7, 3}},
+		{"C\n", qfile, 5, []int{3}},
+		{"D\n", qfile, 7, nil},
+	}
+	for _, resolve := range []bool{false, true} {
+		conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, ResolveAliases: resolve}
+		for _, test := range tests {
+			res, err := conf.Lookup(filename, strings.LastIndex(src, test.marker), nil)
+			if err != nil {
+				t.Errorf("%q: %v", test.marker, err)
+				continue
+			}
+			file, line, aliases := test.file, test.line, test.aliases
+			if !resolve && len(aliases) != 0 {
+				// The first alias
+				file, line, aliases = filename, aliases[0], nil
+				if test.marker != "A\n" {
+					file = qfile
+				}
+			}
+			if res.Position.Filename != file || res.Position.Line != line {
+				t.Errorf("%q (resolve %v): exp %s:%d got %s", test.marker, resolve, file, line, res.Position)
+			}
+			var got []int
+			for _, a := range res.Aliases {
+				got = append(got, a.Position.Line)
+			}
+			if !reflect.DeepEqual(got, aliases) {
+				t.Errorf("%q (resolve %v): aliases: exp %v got %v", test.marker, resolve, aliases, res.Aliases)
+			}
+		}
+	}
+
+	// The type of a value declared with an alias.
+	e := NewEngine(&Config{Context: build.Default, Resolver: &ModuleResolver{}, ResolveAliases: true})
+	res, err := e.ReceiverTypeAt(filename, strings.LastIndex(src, "F"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for _, a := range res.Aliases {
+		got = append(got, a.Position.Line)
+	}
+	if res.Position.Filename != qfile || res.Position.Line != 5 || !reflect.DeepEqual(got, []int{5, 7, 3}) {
+		t.Errorf("ReceiverTypeAt: exp %s:5, aliases [5 7 3] got %s, aliases %v", qfile, res.Position, got)
+	}
+}
+
+func TestLookup_Implementations(t *testing.T) {
+	const src = "package p\n\ntype I interface{ M() }\n\ntype T struct{}\n\nfunc (T) M() {}\n\n" +
+		"type U struct{}\n\nfunc (*U) M() {}\n\nfunc f(i I) { i.M() }\n"
+	files := map[string]string{
+		"go.mod": "module example.com/m\n",
+		"p/p.go": src,
+		"q/q.go": "package q\n\ntype V struct{}\n\nfunc (V) M() {}\n\nfunc (V) N() {}\n",
+	}
+	tmp := writeFiles(t, files)
+	filename := filepath.Join(tmp, "p", "p.go")
+	tests := []struct {
+		scope ImplementationScope
+		want  []string // file:line of the candidates
+	}{
+		{ImplementationsNone, nil},
+		{ImplementationsPackage, []string{"p.go:7", "p.go:11"}},
+		{ImplementationsModule, []string{"p.go:7", "p.go:11", "q.go:5"}},
+	}
+	for _, test := range tests {
+		conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, Implementations: test.scope}
+		res, err := conf.Lookup(filename, strings.LastIndex(src, "M"), nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.scope, err)
+			continue
+		}
+		if res.Position.Filename != filename || res.Position.Line != 3 {
+			t.Errorf("%s: exp %s:3 got %s", test.scope, filename, res.Position)
+		}
+		var got []string
+		for _, c := range res.Candidates {
+			got = append(got, fmt.Sprintf("%s:%d", filepath.Base(c.Position.Filename), c.Position.Line))
+			if c.Kind != KindMethod || c.Reason != "implements I" {
+				t.Errorf("%s: candidate: exp a method implementing I got %+v", test.scope, c)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: candidates: exp %v got %v", test.scope, test.want, got)
+		}
+	}
+}
+
+func TestLookup_BuildTagStrategy(t *testing.T) {
+	const pSrc = "package p\n\nvar _ = Fixture\n"
+	const dbSrc = "//go:build integration\n\npackage p\n\nvar _ = Helper\n"
+	files := map[string]string{
+		"go.mod":     "module example.com/p\n",
+		"p.go":       pSrc,
+		"db.go":      dbSrc,
+		"helper.go":  "//go:build integration\n// +build integration\n\npackage p\n\nvar Helper int\n",
+		"other.go":   "//go:build !integration\n// +build !integration\n\npackage p\n\nvar Helper int\n",
+		"fixture.go": "//go:build e2e\n// +build e2e\n\npackage p\n\nvar Fixture int\n",
+		"fast.go":    "//go:build !slow\n// +build !slow\n\npackage p\n",
+		"slow.go":    "//go:build slow\n// +build slow\n\npackage p\n",
+	}
+	tmp := writeFiles(t, files)
+	tests := []struct {
+		strategy BuildTagStrategy
+		file     string
+		offset   int
+		want     string // file of the definition, empty if not found
+		tags     []string
+	}{
+		{BuildTagsFile, "db.go", strings.Index(dbSrc, "Helper"), "helper.go", []string{"integration"}},
+		{BuildTagsFile, "p.go", strings.Index(pSrc, "Fixture"), "", nil},
+		{BuildTagsPackage, "p.go", strings.Index(pSrc, "Fixture"), "fixture.go", []string{"e2e"}},
+	}
+	for _, x := range tests {
+		conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, BuildTagStrategy: x.strategy}
+		res, err := conf.Lookup(filepath.Join(tmp, x.file), x.offset, nil)
+		if x.want == "" {
+			if err == nil {
+				t.Errorf("%s: %s: exp an error got %s", x.strategy, x.file, res.Position)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %s: %v", x.strategy, x.file, err)
+		}
+		if res.Position.Filename != filepath.Join(tmp, x.want) {
+			t.Errorf("%s: %s: exp the declaration in %s got %s", x.strategy, x.file, x.want, res.Position)
+		}
+		if !reflect.DeepEqual(res.Context.BuildTags, x.tags) {
+			t.Errorf("%s: %s: tags: exp %q got %q", x.strategy, x.file, x.tags, res.Context.BuildTags)
+		}
+	}
+}
+
+func TestLookup_Dir(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{
+		"m/go.mod": "module example.com/m\n",
+		"m/p.go":   kindSrc,
+	})
+	mod := filepath.Join(tmp, "m")
+	filename := filepath.Join(mod, "p.go")
+	// The relative name is not found in the working directory of the
+	// process, only in Dir.
+	conf := Config{Context: build.Default, Dir: tmp}
+	res, err := conf.Lookup(filepath.Join("m", "p.go"), strings.Index(kindSrc, "C, v"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Position.Filename != filename || res.Position.Line != 5 {
+		t.Errorf("exp %s:5 got %s", filename, res.Position)
+	}
+	ctxt, err := conf.buildContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The main module is that of the queried file, not that of Dir.
+	if ctxt.Dir != "" {
+		t.Errorf("Context.Dir: exp \"\" got %q", ctxt.Dir)
+	}
+	if dir := moduleRoot(ctxt, mod); dir != mod {
+		t.Errorf("module root: exp %q got %q", mod, dir)
+	}
+	if dir := moduleRoot(ctxt, tmp); dir != "" {
+		t.Errorf("exp \"\" got module root %q of %s", dir, tmp)
+	}
+}
+
+func TestLookup_LineDirective(t *testing.T) {
+	// p.go is generated from p.y, the declaration of Parse is on line 7
+	// of p.y, indented by 5 columns.
+	const ySrc = "%{\npackage p\n%}\n\n%%\n\n     func Parse() int {\n"
+	const src = "package p\n\n//line p.y:7:6\nfunc Parse() int { return 0 }\n\n//line p.go:6:1\nvar _ = Parse()\n"
+	tmp := writeFiles(t, map[string]string{"p.go": src, "p.y": ySrc})
+	conf := Config{Context: build.Default}
+	res, err := conf.Lookup(filepath.Join(tmp, "p.go"), strings.LastIndex(src, "Parse"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Position{
+		Filename: filepath.Join(tmp, "p.y"),
+		Offset:   strings.Index(ySrc, "Parse"),
+		Line:     7,
+		Column:   11,
+	}
+	if res.Position != want {
+		t.Errorf("exp %+v got %+v", want, res.Position)
+	}
+
+	conf.IgnoreLineDirectives = true
+	res, err = conf.Lookup(filepath.Join(tmp, "p.go"), strings.LastIndex(src, "Parse"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = Position{
+		Filename: filepath.Join(tmp, "p.go"),
+		Offset:   strings.Index(src, "Parse"),
+		Line:     4,
+		Column:   6,
+	}
+	if res.Position != want {
+		t.Errorf("IgnoreLineDirectives: exp %+v got %+v", want, res.Position)
+	}
+}
+
+func TestLookup_TypeSwitch(t *testing.T) {
+	const src = `package p
+
+func f(x interface{}) {
+	switch y := x.(type) {
+	case int, uint:
+		_ = y
+	default:
+		_ = y + 1
+	}
+}
+`
+	tmp := writeFiles(t, map[string]string{"p.go": src})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		offset     int
+		line, col  int
+		candidates []string // clause line: reason, only found by the type checker
+	}{
+		{strings.Index(src, "y :="), 4, 9, []string{"5: case int, uint", "7: default"}},
+		{strings.Index(src, "y\n"), 5, 2, nil},
+		{strings.Index(src, "y + 1"), 7, 2, nil},
+	}
+	for _, describe := range []bool{false, true} {
+		conf := Config{Context: build.Default, Describe: describe}
+		for _, test := range tests {
+			res, err := conf.Lookup(filename, test.offset, nil)
+			if err != nil {
+				t.Errorf("%d (describe %v): %v", test.offset, describe, err)
+				continue
+			}
+			if res.Position.Line != test.line || res.Position.Column != test.col {
+				t.Errorf("%d (describe %v): exp %d:%d got %s", test.offset, describe, test.line, test.col, res.Position)
+			}
+			var got []string
+			for _, c := range res.Candidates {
+				got = append(got, fmt.Sprintf("%d: %s", c.Position.Line, c.Reason))
+			}
+			want := test.candidates
+			if !describe {
+				want = nil // resolved by the parser
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%d (describe %v): candidates: exp %q got %q", test.offset, describe, want, got)
+			}
+		}
+	}
+}
+
+func TestLookup_DocLinks(t *testing.T) {
+	const src = `package p
+
+import "strings"
+
+// T wraps a [strings.Builder] and a [*bytes.Buffer], see [T.Len],
+// [T.N] and Helper.  Unknown names nothing.
+type T struct{ N int }
+
+func (T) Len() int { return 0 }
+
+func Helper() {}
+
+var _ strings.Builder
+`
+	tmp := writeFiles(t, map[string]string{"p.go": src})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker string // the query offset is in the marker
+		file   string // base name of the file of the definition, empty if not found
+		line   int    // line in p.go
+		kind   Kind
+	}{
+		{"Builder]", "builder.go", 0, KindType},
+		{"Buffer]", "buffer.go", 0, KindType},
+		{"T.Len", "p.go", 9, KindMethod},
+		{"N]", "p.go", 7, KindProperty},
+		{"elper", "p.go", 11, KindFunction},
+		{"nknown", "", 0, ""},
+	}
+	conf := Config{Context: build.Default, ResolveDocLinks: true}
+	for _, test := range tests {
+		res, err := conf.Lookup(filename, strings.Index(src, test.marker)+1, nil)
+		if test.file == "" {
+			if err == nil {
+				t.Errorf("%q: exp an error got %s", test.marker, res.Position)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.marker, err)
+			continue
+		}
+		if filepath.Base(res.Position.Filename) != test.file || test.line != 0 && res.Position.Line != test.line || res.Kind != test.kind {
+			t.Errorf("%q: exp %s:%d (%s) got %s (%s)", test.marker, test.file, test.line, test.kind, res.Position, res.Kind)
+		}
+	}
+
+	// Comments are not resolved by default.
+	conf.ResolveDocLinks = false
+	if res, err := conf.Lookup(filename, strings.Index(src, "elper"), nil); err == nil {
+		t.Errorf("exp an error got %s for a comment", res.Position)
+	}
+}
+
+// errAnalyzer is an Analyzer that fails.
+type errAnalyzer struct{}
+
+func (errAnalyzer) Name() string                         { return "err" }
+func (errAnalyzer) Analyze(*Result) ([]Candidate, error) { return nil, errors.New("failed") }
+
+func TestLookup_ProtoAnalyzer(t *testing.T) {
+	const pbSrc = `// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: api/user.proto
+
+package api
+
+type User struct {
+	Name  string     ` + "`" + `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"` + "`" + `
+	Color User_Color ` + "`" + `protobuf:"varint,2,opt,name=color,proto3,enum=api.User_Color" json:"color,omitempty"` + "`" + `
+}
+
+type User_Color int32
+
+const (
+	User_RED  User_Color = 0
+	User_BLUE User_Color = 1
+)
+`
+	const protoSrc = `syntax = "proto3";
+
+package api;
+
+message Group {
+  string name = 1;
+}
+
+message User {
+  string name = 1;
+  Color color = 2;
+
+  enum Color {
+    RED = 0;
+    BLUE = 1;
+  }
+}
+`
+	const src = "package api\n\nvar u User\n\nvar _ = u.Name\n\nvar _ = User_BLUE\n\nvar _ User_Color\n"
+	files := map[string]string{"go.mod": "module example.com/api\n", "user.pb.go": pbSrc, "user.proto": protoSrc, "p.go": src}
+	tmp := writeFiles(t, files)
+	tests := []struct {
+		marker string // the query offset is that of marker in src
+		line   int    // line of the candidate in user.proto
+		descr  string
+	}{
+		{"User\n", 9, "message or enum User"},
+		{"Name", 10, "field name"},
+		{"User_BLUE", 15, "enum value BLUE"},
+		{"User_Color", 13, "message or enum Color"},
+	}
+	conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, Analyzers: []Analyzer{ProtoAnalyzer{}, errAnalyzer{}}}
+	filename := filepath.Join(tmp, "p.go")
+	for _, test := range tests {
+		res, err := conf.Lookup(filename, strings.Index(src, test.marker), nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.marker, err)
+			continue
+		}
+		if len(res.Candidates) != 1 {
+			t.Errorf("%q: candidates: exp 1 got %+v", test.marker, res.Candidates)
+			continue
+		}
+		c := res.Candidates[0]
+		if c.Position.Filename != filepath.Join(tmp, "user.proto") || c.Position.Line != test.line ||
+			c.Description != test.descr || c.Reason != "proto" {
+			t.Errorf("%q: candidate: exp user.proto:%d %q got %+v", test.marker, test.line, test.descr, c)
+		}
+		if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0].Error(), "analyzer err: failed") {
+			t.Errorf("%q: warnings: exp the error of the analyzer got %v", test.marker, res.Warnings)
+		}
+	}
+
+	// The .proto file is found in the build context, e.g. its overlay.
+	protoFile := filepath.Join(tmp, "user.proto")
+	if err := os.Remove(protoFile); err != nil {
+		t.Fatal(err)
+	}
+	conf.Overlay = map[string][]byte{protoFile: []byte(protoSrc)}
+	res, err := conf.Lookup(filename, strings.Index(src, "Name"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Candidates) != 1 || res.Candidates[0].Position.Filename != protoFile {
+		t.Errorf("candidates: exp the field in the overlay of %s got %+v", protoFile, res.Candidates)
+	}
+}
+
+func TestLookup_AcceptIdentifierEnd(t *testing.T) {
+	const src = "package p\n\nimport \"strings\"\n\nvar Global = 1\n\n" +
+		"func f(s string) int {\n\tx := strings.TrimSpace(s)\n\t_ = x\n\treturn Global\n}\n"
+	tmp := writeFiles(t, map[string]string{"p.go": src})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		word, context string
+		file          string
+		line          int
+	}{
+		{"strings", "strings.Trim", "strings.go", 0},
+		{"TrimSpace", "TrimSpace(", "strings.go", 0},
+		{"s", "(s)", filename, 7},
+		{"x", "_ = x", filename, 8},
+		{"Global", "return Global", filename, 5},
+	}
+	for _, test := range tests {
+		offset := strings.Index(src, test.context) + strings.Index(test.context, test.word) + len(test.word)
+		conf := Config{Context: build.Default}
+		if _, err := conf.Lookup(filename, offset, nil); err == nil {
+			t.Errorf("%s: expected an error without AcceptIdentifierEnd", test.word)
+		}
+		conf.AcceptIdentifierEnd = true
+		res, err := conf.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.word, err)
+			continue
+		}
+		if test.line == 0 {
+			if filepath.Base(res.Position.Filename) != test.file {
+				t.Errorf("%s: exp %s got %s", test.word, test.file, res.Position)
+			}
+			continue
+		}
+		if res.Position.Filename != test.file || res.Position.Line != test.line {
+			t.Errorf("%s: exp %s:%d got %s", test.word, test.file, test.line, res.Position)
+		}
+	}
+}
+
+func TestLookup_Extent(t *testing.T) {
+	const src = "package p\n\nimport \"strings\"\n\n" +
+		"var (\n\tA = 1\n\tB = 2\n)\n\n" +
+		"type T struct {\n\tField int\n}\n\n" +
+		"func F(t T) int {\n\tlocal := strings.TrimSpace(\"\")\n" +
+		"\treturn A + B + t.Field + len(local)\n}\n"
+	tmp := writeFiles(t, map[string]string{"p.go": src})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		query string // the identifier queried, at the last occurrence of query in src
+		ident string // its definition, in decl
+		decl  string // its declaration
+	}{
+		{"A", "A", "A = 1"},
+		{"Field", "Field", "Field int"},
+		{"t.", "t", "t T"},
+		{"local", "local", `local := strings.TrimSpace("")`},
+		{"T)", "T", "type T struct {\n\tField int\n}"},
+	}
+	conf := Config{Context: build.Default}
+	for _, test := range tests {
+		res, err := conf.Lookup(filename, strings.LastIndex(src, test.query), nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		start := strings.Index(src, test.decl)
+		ident := start + strings.Index(test.decl, test.ident)
+		if res.Position.Offset != ident || res.End.Offset != ident+len(test.ident) {
+			t.Errorf("%s: exp #%d,#%d got identifier #%d,#%d",
+				test.query, ident, ident+len(test.ident), res.Position.Offset, res.End.Offset)
+		}
+		if res.DeclStart.Offset != start || res.DeclEnd.Offset != start+len(test.decl) || res.DeclEnd.Filename != filename {
+			t.Errorf("%s: exp %s:#%d,#%d got declaration %s:#%d,#%d",
+				test.query, filename, start, start+len(test.decl), res.DeclEnd.Filename, res.DeclStart.Offset, res.DeclEnd.Offset)
+		}
+	}
+
+	// The definition is in another file, whose syntax tree in the
+	// program is reused rather than parsing the file again.
+	opens := make(map[string]int)
+	conf.Context.OpenFile = func(name string) (io.ReadCloser, error) {
+		opens[name]++
+		return os.Open(name)
+	}
+	res, err := conf.Lookup(filename, strings.Index(src, "TrimSpace"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.End.Line != res.Position.Line || res.End.Column != res.Position.Column+len("TrimSpace") {
+		t.Errorf("TrimSpace: got identifier %s,%s", res.Position, res.End)
+	}
+	if res.DeclStart.Line != res.Position.Line || res.DeclStart.Column != 1 || res.DeclEnd.Line <= res.Position.Line {
+		t.Errorf("TrimSpace: got declaration %s,%s", res.DeclStart, res.DeclEnd)
+	}
+	// Once by go/build for its build constraints, once by the parser.
+	if n := opens[res.Position.Filename]; n != 2 {
+		t.Errorf("TrimSpace: %s opened: exp 2 got %d times", res.Position.Filename, n)
+	}
+}
+
+func TestResult_ReadSource(t *testing.T) {
+	const src = "package p\n\nfunc F() int { return 1 }\n\nvar _ = F()\n"
+	tmp := writeFiles(t, nil)
+	filename := filepath.Join(tmp, "p.go")
+	conf := Config{Context: build.Default}
+	offset := strings.LastIndex(src, "F()")
+
+	// The queried file is not on disk, its source is that of the query.
+	pos, b, err := conf.Define(filename, offset, []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Filename != filename || pos.Line != 3 || string(b) != src {
+		t.Errorf("exp %s:3 and %q got %v and %q", filename, src, pos, b)
+	}
+
+	// The file is read when ReadSource is called, not by Lookup.
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	res, err := conf.Lookup(filename, offset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filename); err != nil {
+		t.Fatal(err)
+	}
+	b, err = res.ReadSource()
+	var rerr *ReadError
+	if !errors.As(err, &rerr) || rerr.Filename != filename || !os.IsNotExist(rerr.Err) {
+		t.Fatalf("error: exp a *ReadError for %s got %v", filename, err)
+	}
+	if b != nil {
+		t.Errorf("got %d bytes with error", len(b))
+	}
+}
+
+func TestBuildInfoFor(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.CgoEnabled = false
+	conf := Config{Context: ctxt}
+
+	src := "// +build windows,!cgo\n\npackage os\n"
+	info, err := conf.BuildInfoFor("testdata/os/x_windows.go", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Builds {
+		t.Error("Builds: expected false")
+	}
+	if info.GOOS != "windows" || info.GOARCH != "amd64" {
+		t.Errorf("GOOS/GOARCH: exp windows/amd64 got %s/%s", info.GOOS, info.GOARCH)
+	}
+	if !reflect.DeepEqual(info.Matched, []string{"!cgo"}) {
+		t.Errorf("Matched: exp [!cgo] got %q", info.Matched)
+	}
+	if !reflect.DeepEqual(info.Failed, []string{"windows"}) {
+		t.Errorf("Failed: exp [windows] got %q", info.Failed)
+	}
+
+	// Files with only a //go:build line.
+	for _, x := range []struct {
+		src    string
+		builds bool
+		goos   string
+	}{
+		{"//go:build windows && !cgo\n\npackage os\n", false, "windows"},
+		{"//go:build (darwin || linux) && amd64\n\npackage os\n", true, "linux"},
+		{"//go:build unix && !android\n\npackage os\n", true, "linux"},
+		{"//go:build !linux && !windows\n\npackage os\n", false, "linux"},
+		{"//go:build freebsd\n// +build linux\n\npackage os\n", false, "freebsd"},
+		{"// +build unix,!android\n\npackage os\n", true, "linux"},
+		{"// +build darwin linux\n// +build !amd64\n\npackage os\n", false, "linux"},
+		{"// +build windows\npackage os\n", true, "linux"},
+	} {
+		info, err := conf.BuildInfoFor("testdata/os/x.go", x.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Builds != x.builds || info.GOOS != x.goos {
+			t.Errorf("%q: Builds, GOOS: exp %t %s got %t %s", x.src, x.builds, x.goos, info.Builds, info.GOOS)
+		}
+	}
+
+	// GOOS=ios satisfies darwin, so the context is left alone.
+	conf.Context.GOOS = "ios"
+	conf.Context.GOARCH = "arm64"
+	info, err = conf.BuildInfoFor("testdata/os/x.go", "// +build darwin\n\npackage os\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Builds || info.GOOS != "ios" {
+		t.Errorf("ios: Builds, GOOS: exp true ios got %t %s", info.Builds, info.GOOS)
+	}
+}
+
+func TestLookup_Context(t *testing.T) {
+	ctxt := build.Default
+	ctxt.GOOS = "linux"
+	ctxt.GOARCH = "amd64"
+	ctxt.BuildTags = []string{"integration"}
+	conf := Config{Context: ctxt, Workspace: WorkspaceResolver{Disabled: true}}
+
+	const src = "package p\n\nvar x int\n\nvar _ = x\n"
+	offset := strings.LastIndex(src, "x")
+	tests := []struct {
+		filename string
+		goos     string
+		adjusted []string
+	}{
+		{"testdata/p/p.go", "linux", nil},
+		{"testdata/p/p_windows.go", "windows", []string{"GOOS=windows"}},
+	}
+	for _, x := range tests {
+		res, err := conf.Lookup(x.filename, offset, src)
+		if err != nil {
+			t.Fatalf("%s: %v", x.filename, err)
+		}
+		qc := res.Context
+		if qc.GOOS != x.goos || qc.GOARCH != "amd64" || !reflect.DeepEqual(qc.BuildTags, ctxt.BuildTags) {
+			t.Errorf("%s: context: exp GOOS=%s GOARCH=amd64 tags=%q got %s", x.filename, x.goos, ctxt.BuildTags, qc)
+		}
+		if !reflect.DeepEqual(qc.Adjusted, x.adjusted) {
+			t.Errorf("%s: Adjusted: exp %q got %q", x.filename, x.adjusted, qc.Adjusted)
+		}
+	}
+}
+
+const wrapperSrc = `package p
+
+type T struct{}
+
+func (T) M(n int) int { return F(n) }
+
+func F(n int) int { return G(n, 1) }
+
+func G(n, m int) int {
+	n += m
+	return n
+}
+
+func H() { T{}.M(1) }
+`
+
+func TestLookup_ResolveWrappers(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": wrapperSrc})
+	filename := filepath.Join(tmp, "p.go")
+	conf := Config{Context: build.Default, ResolveWrappers: true}
+	res, err := conf.Lookup(filename, strings.Index(wrapperSrc, "M(1)"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Position.Line != 5 {
+		t.Errorf("Position: exp line 5 got %s", res.Position)
+	}
+	var lines []int
+	for _, c := range res.Candidates {
+		lines = append(lines, c.Position.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{7, 9}) {
+		t.Errorf("Candidates: exp lines [7 9] got %v", lines)
+	}
+}
+
+func TestLookup_Probe(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker string
+		found  bool
+		reason string
+	}{
+		{"C, v", true, ""},
+		{"package", false, "no identifier here"},
+	}
+	for _, x := range tests {
+		offset := strings.Index(kindSrc, x.marker)
+		if offset < 0 {
+			t.Fatalf("marker %q not found", x.marker)
+		}
+		conf := Config{Context: build.Default, Probe: true}
+		res, err := conf.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if res.Found != x.found || res.Reason != x.reason {
+			t.Errorf("(%+v): got Found=%t Reason=%q", x, res.Found, res.Reason)
+		}
+
+		// Without Probe a *NotFoundError is returned
+		conf.Probe = false
+		_, err = conf.Lookup(filename, offset, nil)
+		var nf *NotFoundError
+		if found := !errors.As(err, &nf); found != x.found {
+			t.Errorf("(%+v): unexpected error: %v", x, err)
+		}
+	}
+}
+
+func TestLookup_GOROOTSource(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	offset := strings.Index(kindSrc, "Println")
+
+	conf := Config{Context: build.Default}
+	res, err := conf.Lookup(filename, offset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, ok := hasFilePathPrefix(res.Position.Filename, filepath.Join(conf.Context.GOROOT, "src"))
+	if !ok {
+		t.Skipf("definition not in GOROOT: %s", res.Position.Filename)
+	}
+
+	src := filepath.Join(tmp, "goroot")
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(src, rel)), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, rel), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	conf.GOROOTSource = src
+	res2, err := conf.Lookup(filename, offset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(src, rel); res2.Position.Filename != want {
+		t.Errorf("Filename: exp %q got %q", want, res2.Position.Filename)
+	}
+	if res2.Position.Line != res.Position.Line {
+		t.Errorf("Line: exp %d got %d", res.Position.Line, res2.Position.Line)
+	}
+}
+
+func TestLookup_FileSystem(t *testing.T) {
+	// The packages exist only in the file system of the build context.
+	const psrc = "package p\n\nimport \"q\"\n\nvar _ = q.X\n"
+	const qsrc = "package q\n\n// X is declared in q.\nvar X = 1\n"
+	conf := Config{
+		Context: *buildutil.FakeContext(map[string]map[string]string{
+			"p": {"p.go": psrc},
+			"q": {"q.go": qsrc},
+		}),
+		Workspace: WorkspaceResolver{Disabled: true},
+	}
+	filename := "/go/src/p/p.go"
+	tests := []struct {
+		marker string
+		file   string
+		line   int
+	}{
+		{"X\n", "/go/src/q/q.go", 4},
+		{`"q"`, "/go/src/q/q.go", 1},
+	}
+	for _, x := range tests {
+		pos, src, err := conf.Define(filename, strings.Index(psrc, x.marker), nil)
+		if err != nil {
+			t.Errorf("%q: %v", x.marker, err)
+			continue
+		}
+		if pos.Filename != x.file || pos.Line != x.line {
+			t.Errorf("%q: exp %s:%d got %s:%d", x.marker, x.file, x.line, pos.Filename, pos.Line)
+		}
+		if string(src) != qsrc {
+			t.Errorf("%q: source: exp %q got %q", x.marker, qsrc, src)
+		}
+	}
+}
+
+func TestLookup_Overlay(t *testing.T) {
+	const pSrc = "package p\n\nfunc F() { Helper() }\n"
+	const helperSrc = "package p\n\nfunc Helper() { F() }\n"
+	files := map[string]string{
+		"go.mod": "module example.com/p\n",
+		"p.go":   pSrc,
+	}
+	tmp := writeFiles(t, files)
+	pfile := filepath.Join(tmp, "p.go")
+	helper := filepath.Join(tmp, "helper_gen.go") // does not exist
+	conf := Config{
+		Context:  build.Default,
+		Resolver: &ModuleResolver{},
+		Overlay:  map[string][]byte{helper: []byte(helperSrc)},
+	}
+	tests := []struct {
+		filename string
+		offset   int
+		want     Position
+	}{
+		{pfile, strings.Index(pSrc, "Helper"), Position{Filename: helper, Line: 3, Column: 6}},
+		{helper, strings.Index(helperSrc, "F()"), Position{Filename: pfile, Line: 3, Column: 6}},
+	}
+	for _, x := range tests {
+		res, err := conf.Lookup(x.filename, x.offset, nil)
+		if err != nil {
+			t.Errorf("%s:#%d: %v", x.filename, x.offset, err)
+			continue
+		}
+		got := res.Position
+		got.Offset = 0
+		if got != x.want {
+			t.Errorf("%s:#%d: exp %+v got %+v", x.filename, x.offset, x.want, got)
+		}
+	}
+}
+
+func TestEngine(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	offset := strings.Index(kindSrc, "C, v")
+
+	conf := Config{Context: build.Default}
+	e := NewEngine(&conf)
+	conf.Probe = true // does not affect e
+	if e.Config().Probe {
+		t.Error("NewEngine did not copy the Config")
+	}
+	for i := 0; i < 2; i++ {
+		res, err := e.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := conf.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Stats, want.Stats = Stats{}, Stats{} // timings differ
+		if !reflect.DeepEqual(res, want) {
+			t.Errorf("Engine.Lookup: exp %+v got %+v", want, res)
+		}
+	}
+}
+
+func TestEngine_AddRoot(t *testing.T) {
+	const querySrc = "package p\n\nvar _ = Q\nvar _ = R\n"
+	files := map[string]string{
+		"a/go.mod": "module example.com/a\n",
+		"a/p.go":   querySrc,
+		"a/r.go":   "// +build b\n\npackage p\n\nvar R = 1\n",
+		"b/go.mod": "module example.com/b\n",
+		"b/p.go":   querySrc,
+		"b/q.go":   "// +build b\n\npackage p\n\nvar Q, R = 1, 2\n",
+	}
+	tmp := writeFiles(t, files)
+	a := filepath.Join(tmp, "a")
+	b := filepath.Join(tmp, "b")
+
+	// The overlay of the Engine must not be observed by queries of
+	// files in root a.
+	e := NewEngine(&Config{
+		Context:  build.Default,
+		Resolver: &ModuleResolver{},
+		Overlay: map[string][]byte{
+			filepath.Join(a, "q.go"): []byte("package p\n\n\n\nvar Q = 1\n"),
+		},
+	})
+	confA := Config{
+		Context:  build.Default,
+		Resolver: &ModuleResolver{},
+		Overlay: map[string][]byte{
+			filepath.Join(a, "q.go"): []byte("package p\n\nvar Q = 1\n"),
+		},
+	}
+	confB := Config{Context: build.Default, Resolver: &ModuleResolver{}}
+	confB.Context.BuildTags = []string{"b"}
+	if err := e.AddRoot(a, &confA); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddRoot(b, &confB); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		dir    string
+		marker string
+		file   string // empty if not found
+		line   int
+	}{
+		{a, "Q\n", "q.go", 3},
+		{a, "R\n", "", 0}, // the build tag of root b is not set
+		{b, "Q\n", "q.go", 5},
+		{b, "R\n", "q.go", 5},
+	}
+	for _, x := range tests {
+		filename := filepath.Join(x.dir, "p.go")
+		res, err := e.Lookup(filename, strings.Index(querySrc, x.marker), nil)
+		if x.file == "" {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("(%+v): exp %v got result %+v, %v", x, ErrNotFound, res, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		want := filepath.Join(x.dir, x.file)
+		if res.Position.Filename != want || res.Position.Line != x.line {
+			t.Errorf("(%+v): position: exp %s:%d got %s", x, want, x.line, res.Position)
+		}
+	}
+}
+
+func TestEngine_AddIsolatedRoot(t *testing.T) {
+	const querySrc = "package p\n\nimport \"lib\"\n\nvar _ = lib.X\n"
+	files := map[string]string{
+		"a/p.go":               querySrc,
+		"b/p.go":               querySrc,
+		"gopatha/src/lib/x.go": "package lib\n\nvar X = 1\n",
+		"gopathb/src/lib/x.go": "package lib\n\n\n\nvar X = 2\n",
+	}
+	tmp := writeFiles(t, files)
+
+	index := NewPackageIndex(0)
+	e := NewEngine(&Config{
+		Context:      build.Default,
+		UseGoEnv:     true,
+		ProgramCache: NewProgramCache(0),
+		ASTCache:     NewASTCache(0),
+		PackageIndex: index,
+	})
+	for _, root := range []string{"a", "b"} {
+		gopath := filepath.Join(tmp, "gopath"+root)
+		if err := e.AddIsolatedRoot(filepath.Join(tmp, root), gopath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, x := range []struct {
+		root string
+		line int
+	}{
+		{"a", 3},
+		{"b", 5},
+		{"a", 3}, // not the program of root b
+	} {
+		gopath := filepath.Join(tmp, "gopath"+x.root)
+		filename := filepath.Join(tmp, x.root, "p.go")
+		res, err := e.Lookup(filename, strings.Index(querySrc, "X"), nil)
+		if err != nil {
+			t.Errorf("%s: %v", x.root, err)
+			continue
+		}
+		want := filepath.Join(gopath, "src", "lib", "x.go")
+		if res.Position.Filename != want || res.Position.Line != x.line {
+			t.Errorf("%s: position: exp %s:%d got %s", x.root, want, x.line, res.Position)
+		}
+		// The GOPATH of the root is also that of go env.
+		if res.Context.GOPATH != gopath {
+			t.Errorf("%s: GOPATH: exp %q got %q", x.root, gopath, res.Context.GOPATH)
+		}
+	}
+	if c := e.Config(); c.PackageIndex != index {
+		t.Error("the package index of the Engine was replaced")
+	}
+	for _, r := range e.roots {
+		if r.conf.PackageIndex != index || r.conf.ProgramCache == e.conf.ProgramCache || r.conf.ASTCache == e.conf.ASTCache {
+			t.Errorf("%s: exp the package index %p and other caches got caches %p %p %p",
+				r.dir, index, r.conf.PackageIndex, r.conf.ProgramCache, r.conf.ASTCache)
+		}
+	}
+}
+
+func TestEngine_ParseFile(t *testing.T) {
+	tmp := writeFiles(t, nil)
+	filename := filepath.Join(tmp, "p.go") // only in the overlay
+	e := NewEngine(&Config{
+		Context: build.Default,
+		Overlay: map[string][]byte{filename: []byte("// Package p.\npackage p\n")},
+	})
+	for _, mode := range []parser.Mode{0, parser.ParseComments} {
+		f, fset, err := e.ParseFile(filename, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name.Name != "p" || fset.File(f.Pos()).Name() != filename {
+			t.Errorf("mode %d: exp p in %s got package %s in %s",
+				mode, filename, f.Name.Name, fset.File(f.Pos()).Name())
+		}
+		if hasDoc := f.Doc != nil; hasDoc != (mode == parser.ParseComments) {
+			t.Errorf("mode %d: doc: exp %v got %v", mode, !hasDoc, hasDoc)
+		}
+	}
+	if _, _, err := e.ParseFile(filepath.Join(tmp, "missing.go"), 0); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	// The file is parsed once with an ASTCache.
+	conf := e.Config()
+	conf.ASTCache = NewASTCache(0)
+	e = NewEngine(&conf)
+	f1, _, err := e.ParseFile(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, fset, err := e.ParseFile(filename, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f1 != f2 || fset != conf.ASTCache.fileSet() || conf.ASTCache.Len() != 1 {
+		t.Errorf("exp the cached file got files %p, %p in %d cached files", f1, f2, conf.ASTCache.Len())
+	}
+}
+
+// testLogger is a Logger that records messages.
+type testLogger struct {
+	msgs []string
+}
+
+func (l *testLogger) Printf(format string, args ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestLookup_Clock(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	clock := &clockEnv{Environment: OSEnvironment, now: time.Unix(1e9, 0), step: time.Millisecond}
+	conf := Config{Context: build.Default, Env: clock}
+	res, err := conf.Lookup(filename, strings.Index(kindSrc, "C, v"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Stats.ParseTime != time.Millisecond {
+		t.Errorf("ParseTime: exp %v got %v", time.Millisecond, res.Stats.ParseTime)
+	}
+	if res.Stats.Total < 2*time.Millisecond || res.Stats.Total%time.Millisecond != 0 {
+		t.Errorf("Total: exp a multiple of %v got %v", time.Millisecond, res.Stats.Total)
+	}
+}
+
+func TestLookup_Logger(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker   string
+		path     string // expected resolution path
+		strategy Strategy
+	}{
+		{"C, v", "resolved C with the parser", StrategyParser},
+		{"F, v.M", "loaded program with the " + programBackend + " backend", StrategyTypeChecker},
+		{"Println", "by scanning package", StrategyPackageScan},
+	}
+	for _, x := range tests {
+		var logger testLogger
+		conf := Config{Context: build.Default, Logger: &logger}
+		res, err := conf.Lookup(filename, strings.Index(kindSrc, x.marker), nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if res.Stats.Strategy != x.strategy || res.Stats.FilesParsed == 0 || res.Stats.Total == 0 {
+			t.Errorf("(%+v): stats: exp strategy %q got %+v", x, x.strategy, res.Stats)
+		}
+		log := strings.Join(logger.msgs, "\n")
+		for _, want := range []string{"build context: GOROOT=", x.path, "definition query: found"} {
+			if !strings.Contains(log, want) {
+				t.Errorf("(%+v): log does not contain %q:\n%s", x, want, log)
+			}
+		}
+	}
+}
+
+func TestEngine_Hooks(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	var events []string
+	e := NewEngine(&Config{
+		Context: build.Default,
+		Hooks: Hooks{
+			OnQueryStart: func(info QueryInfo) {
+				events = append(events, fmt.Sprintf("start %s #%d", info.Mode, info.Start))
+			},
+			OnQueryEnd: func(info QueryInfo, stats Stats, err error) {
+				events = append(events, fmt.Sprintf("end %s #%d %s %v", info.Mode, info.Start, stats.Strategy, err))
+				if stats.Total == 0 {
+					t.Errorf("%+v: zero Stats.Total", info)
+				}
+			},
+		},
+	})
+	offset := strings.Index(kindSrc, "C, v")
+	if _, err := e.Lookup(filename, offset, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Lookup(filename, 0, nil); err == nil {
+		t.Fatal("expected an error for a query of the package keyword")
+	}
+	want := []string{
+		fmt.Sprintf("start definition #%d", offset),
+		fmt.Sprintf("end definition #%d parser <nil>", offset),
+		"start definition #0",
+		"end definition #0  " + ErrNoIdentifier.Error(),
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("exp events:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(events, "\n"))
+	}
+}
+
+// spanKey is the key of the name of the span in the contexts returned
+// by the Hooks.StartSpan of TestEngine_StartSpan.
+type spanKey struct{}
+
+func TestEngine_StartSpan(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	var spans []string
+	e := NewEngine(&Config{
+		Context:      build.Default,
+		ProgramCache: NewProgramCache(0),
+		PackageIndex: NewPackageIndex(0),
+		Hooks: Hooks{
+			StartSpan: func(ctx context.Context, name string, info QueryInfo) (context.Context, func(error)) {
+				parent, _ := ctx.Value(spanKey{}).(string)
+				return context.WithValue(ctx, spanKey{}, name), func(err error) {
+					spans = append(spans, fmt.Sprintf("%s < %s: %v", name, parent, err))
+				}
+			},
+			OnCacheEvent: func(info QueryInfo, cache string, hit bool) {
+				spans = append(spans, fmt.Sprintf("%s cache hit: %t", cache, hit))
+			},
+		},
+	})
+	ctx := context.WithValue(context.Background(), spanKey{}, "request")
+	tests := []struct {
+		marker string
+		spans  []string
+	}{
+		{"F, v", []string{
+			"godef.parse < godef.query: <nil>",
+			"godef.cache < godef.query: <nil>",
+			"program cache hit: false",
+			"godef.load < godef.query: <nil>",
+			"godef.query < request: <nil>",
+		}},
+		{"F, v", []string{ // reused from the ProgramCache
+			"godef.parse < godef.query: <nil>",
+			"godef.cache < godef.query: <nil>",
+			"program cache hit: true",
+			"godef.query < request: <nil>",
+		}},
+		{"Println", []string{
+			"godef.parse < godef.query: <nil>",
+			"index cache hit: false",
+			"godef.index < godef.query: <nil>",
+			"godef.query < request: <nil>",
+		}},
+		{"Println", []string{ // reused from the PackageIndex
+			"godef.parse < godef.query: <nil>",
+			"index cache hit: true",
+			"godef.index < godef.query: <nil>",
+			"godef.query < request: <nil>",
+		}},
+	}
+	for _, x := range tests {
+		spans = nil
+		q := Query{Pos: fmt.Sprintf("%s:#%d", filename, strings.Index(kindSrc, x.marker)), Engine: e}
+		if _, err := q.Run(ctx); err != nil {
+			t.Fatalf("%q: %v", x.marker, err)
+		}
+		if !reflect.DeepEqual(spans, x.spans) {
+			t.Errorf("%q: exp spans:\n%s\ngot:\n%s", x.marker, strings.Join(x.spans, "\n"), strings.Join(spans, "\n"))
+		}
+	}
+
+	// Queries made with the Engine are traced from the background
+	// context.
+	spans = nil
+	if _, err := e.Lookup(filename, 0, nil); err == nil {
+		t.Fatal("expected an error for a query of the package keyword")
+	}
+	want := []string{
+		"godef.parse < godef.query: <nil>",
+		"godef.query < : " + (&NotFoundError{Err: ErrNoIdentifier}).Error(),
+	}
+	if !reflect.DeepEqual(spans, want) {
+		t.Errorf("exp spans:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(spans, "\n"))
+	}
+}
+
+func TestLookup_Position(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		mode string
+		pos  string
+	}{
+		{"definition", ""},
+		{"definition", filename},
+		{"definition", filename + ":12"},
+		{"definition", filename + ":#-1"},
+		{"definition", fmt.Sprintf("%s:#%d", filename, len(kindSrc)+1)},
+		{"definition", filename + ":#99999999999"},
+		{"describe", filename + ":#5,#3"},
+		{"describe", fmt.Sprintf("%s:#0,#%d", filename, len(kindSrc)+1)},
+		{"complete", filename + ":#-1"},
+		{"decl", fmt.Sprintf("%s:#%d", filename, len(kindSrc)+1)},
+	}
+	e := NewEngine(&Config{Context: build.Default})
+	for _, x := range tests {
+		q := Query{Mode: x.mode, Pos: x.pos, Engine: e}
+		_, err := q.Run(context.Background())
+		var perr *PositionError
+		if !errors.As(err, &perr) {
+			t.Errorf("%s %q: error: exp a *PositionError got %v", x.mode, x.pos, err)
+		}
+	}
+	if _, err := e.Lookup(filename, -1, nil); ExitCode(err) != ExitUsage {
+		t.Errorf("Lookup(-1): error: exp a *PositionError got %v", err)
+	}
+}
+
+func TestLookup_BestEffort(t *testing.T) {
+	// Package a does not compile: a2.go has the wrong package clause,
+	// and nor does its import b.
+	const src = `package a
+
+import "example.com/m/b"
+
+func F() {
+	b.Helper()
+	G()
+	var t T
+	t.Method()
+	_ = t.Field
+	t.Close()
+	Close()
+	Missing()
+}
+`
+	files := map[string]string{
+		"go.mod":  "module example.com/m\n",
+		"a/a.go":  src,
+		"a/a2.go": "package z\n\nfunc G() {}\n\ntype T struct{ Field int }\n\nfunc (T) Method() {}\n\nfunc Close() {}\n\nfunc (T) Close() {}\n",
+		"b/b.go":  "package b\n\nfunc Helper() {}\n\nvar _ = undefined\n",
+		"b/b2.go": "package c\n",
+	}
+	tmp := writeFiles(t, files)
+	filename := filepath.Join(tmp, "a", "a.go")
+	tests := []struct {
+		marker string
+		pos    string // file:line:column, "" if not found
+		descr  string
+	}{
+		{"Helper", "b/b.go:3:6", ""}, // the packages backend type-checks b
+		{"G()", "a/a2.go:3:6", "func G"},
+		{"Method", "a/a2.go:7:10", "method Method"},
+		{"Field", "a/a2.go:5:16", "field Field"},
+		{"Close()\n\tClose", "a/a2.go:11:10", "method Close"}, // not the func Close
+		{"Close()\n\tMissing", "a/a2.go:9:6", "func Close"},
+		{"Missing", "", ""},
+	}
+	for _, x := range tests {
+		conf := Config{Context: build.Default, Resolver: &ModuleResolver{}}
+		offset := strings.Index(src, x.marker)
+		if _, err := conf.Lookup(filename, offset, nil); x.descr != "" && err == nil {
+			t.Errorf("%q: expected an error without BestEffort", x.marker)
+		}
+		conf.BestEffort = true
+		res, err := conf.Lookup(filename, offset, nil)
+		if x.pos == "" {
+			if err == nil {
+				t.Errorf("%q: exp an error got %s", x.marker, res.Position)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.marker, err)
+			continue
+		}
+		if got := res.Position.String(); got != filepath.Join(tmp, x.pos) {
+			t.Errorf("%q: exp %s got %s", x.marker, x.pos, got)
+		}
+		if x.descr != "" && (!res.Approximate || res.Description != x.descr || res.Stats.Strategy != StrategyApproximate) {
+			t.Errorf("%q: exp %q approximate got %q approximate: %t strategy: %q",
+				x.marker, x.descr, res.Description, res.Approximate, res.Stats.Strategy)
+		}
+	}
+}
+
+func TestLookup_TypeErrors(t *testing.T) {
+	const src = `package p
+
+type T struct{ Field int }
+
+func (T) Method() {}
+
+func F() {
+	x := missing()
+	x.Method()
+	_ = x.Field
+	_ = Undefined{N: 1}
+	_ = Undefined{Field: N}
+	x.Nothing()
+	var ok T
+	ok.Method()
+}
+`
+	files := map[string]string{
+		"go.mod":  "module example.com/m\n",
+		"p/p.go":  src,
+		"p/p2.go": "package p\n\nvar N = 1\n",
+	}
+	tmp := writeFiles(t, files)
+	filename := filepath.Join(tmp, "p", "p.go")
+	tests := []struct {
+		marker  string
+		pos     string // file:line:column, "" if not found
+		descr   string
+		typeErr string // substring of the type error, "" if resolved
+	}{
+		{"Method()\n\t_", "p.go:5:10", "func (T).Method()", "missing"},
+		{"Field\n", "p.go:3:16", "field Field int", "missing"},
+		{"N: 1", "p2.go:3:5", "var N int", "Undefined"},
+		{"Field: N", "p.go:3:16", "field Field int", "Undefined"},
+		{"Nothing", "", "", ""},
+		{"Method()\n}", "p.go:5:10", "func (T).Method()", ""},
+	}
+	for _, x := range tests {
+		conf := Config{Context: build.Default, Resolver: &ModuleResolver{}}
+		res, err := conf.Lookup(filename, strings.Index(src, x.marker), nil)
+		if x.pos == "" {
+			var nf *NotFoundError
+			if !errors.As(err, &nf) {
+				t.Errorf("%q: exp a *NotFoundError got %v, %v", x.marker, res, err)
+				continue
+			}
+			// The errors of a failed query are those of its error.
+			checkTypeCheckErrors(t, x.marker, nf.Errors)
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", x.marker, err)
+			continue
+		}
+		if got := fmt.Sprintf("%s:%d:%d", filepath.Base(res.Position.Filename), res.Position.Line, res.Position.Column); got != x.pos || res.Description != x.descr {
+			t.Errorf("%q: exp %s %q got %s %q", x.marker, x.pos, x.descr, got, res.Description)
+		}
+		switch {
+		case x.typeErr == "" && (res.TypeError != nil || res.Approximate):
+			t.Errorf("%q: unexpected type error %v approximate: %t", x.marker, res.TypeError, res.Approximate)
+		case x.typeErr != "" && (res.TypeError == nil || !strings.Contains(res.TypeError.Error(), x.typeErr)):
+			t.Errorf("%q: type error: exp %q got %v", x.marker, x.typeErr, res.TypeError)
+		case x.typeErr != "" && (!res.Approximate || res.Stats.Strategy != StrategyRecovered):
+			t.Errorf("%q: exp a recovered result got approximate: %t strategy: %q", x.marker, res.Approximate, res.Stats.Strategy)
+		}
+		checkTypeCheckErrors(t, x.marker, res.Errors)
+	}
+
+	// The errors are also those of a probe that found nothing.
+	conf := Config{Context: build.Default, Resolver: &ModuleResolver{}, Probe: true}
+	res, err := conf.Lookup(filename, strings.Index(src, "Nothing"), nil)
+	if err != nil || res.Found {
+		t.Fatalf("probe: exp a result that was not found got %+v, %v", res, err)
+	}
+	checkTypeCheckErrors(t, "probe", res.Errors)
+}
+
+// checkTypeCheckErrors checks the Result.Errors of the queries of
+// TestLookup_TypeErrors.
+func checkTypeCheckErrors(t *testing.T, marker string, errs []TypeCheckError) {
+	t.Helper()
+	if len(errs) < 3 {
+		t.Errorf("%q: errors: exp at least 3 got %v", marker, errs)
+		return
+	}
+	e := errs[0]
+	if filepath.Base(e.Position.Filename) != "p.go" || e.Position.Line != 8 || e.Position.Column != 7 ||
+		!strings.Contains(e.Message, "missing") || e.Soft {
+		t.Errorf("%q: exp p.go:8:7: undefined: missing got first error %v (soft: %t)", marker, e, e.Soft)
+	}
+}
+
+func TestLookup_Errors(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker string
+		err    error
+		msg    string
+	}{
+		{"package", ErrNoIdentifier, "no identifier here"},
+	}
+	conf := Config{Context: build.Default}
+	for _, x := range tests {
+		offset := strings.Index(kindSrc, x.marker)
+		if offset < 0 {
+			t.Fatalf("marker %q not found", x.marker)
+		}
+		_, err := conf.Lookup(filename, offset, nil)
+		if !errors.Is(err, x.err) || !errors.Is(err, ErrNotFound) {
+			t.Errorf("(%+v): error: exp %v got %#v", x, x.err, err)
+			continue
+		}
+		if err.Error() != x.msg {
+			t.Errorf("(%+v): message: exp %q got %q", x, x.msg, err.Error())
+		}
+	}
+
+	txt := filepath.Join(tmp, "p.txt")
+	if err := ioutil.WriteFile(txt, []byte("not go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conf.Lookup(txt, 0, nil); !errors.Is(err, ErrNotGoFile) {
+		t.Errorf("%s: error: exp %v got %v", txt, ErrNotGoFile, err)
+	}
+}
+
+const builtinSrc = `package p
+
+import "unsafe"
+
+func F(s []int) error {
+	_ = unsafe.Pointer(&s)
+	return nil
+}
+
+var n = len([]int{})
+`
+
+func TestLookup_Builtin(t *testing.T) {
+	if !haveGoSrc {
+		t.Skip("GOROOT/src not found")
+	}
+	tmp := writeFiles(t, map[string]string{"p.go": builtinSrc})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker string
+		file   string
+		kind   Kind
+	}{
+		{"len(", "builtin/builtin.go", KindFunction},
+		{"int) error", "builtin/builtin.go", KindType},
+		{"error {", "builtin/builtin.go", KindType},
+		{"nil\n", "builtin/builtin.go", KindConst},
+		{"Pointer", "unsafe/unsafe.go", KindType},
+	}
+	conf := Config{Context: build.Default}
+	for _, x := range tests {
+		offset := strings.Index(builtinSrc, x.marker)
+		if offset < 0 {
+			t.Fatalf("marker %q not found", x.marker)
+		}
+		res, err := conf.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		want := filepath.Join(conf.Context.GOROOT, "src", filepath.FromSlash(x.file))
+		if res.Position.Filename != want || !res.Position.IsValid() {
+			t.Errorf("(%+v): position: exp %s got %s", x, want, res.Position)
+		}
+		if res.Kind != x.kind {
+			t.Errorf("(%+v): kind: exp %q got %q", x, x.kind, res.Kind)
+		}
+	}
+
+	// Without GOROOT/src only a description is returned
+	conf.Context.GOROOT = tmp
+	offset := strings.Index(builtinSrc, "len(")
+	res, err := conf.Lookup(filename, offset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Position.IsValid() || res.Description != "builtin len" {
+		t.Errorf("exp - and %q got position %s and description %q",
+			"builtin len", res.Position, res.Description)
+	}
+	if _, _, err := conf.Define(filename, offset, nil); !errors.Is(err, ErrBuiltin) {
+		t.Errorf("Define: error: exp %v got %v", ErrBuiltin, err)
+	}
+}
+
+func TestLookup_PackageQualifier(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": kindSrc})
+	filename := filepath.Join(tmp, "p.go")
+	offset := strings.Index(kindSrc, "fmt.Println")
+	want := filepath.Join(build.Default.GOROOT, "src", "fmt", "doc.go")
+	// With Describe the qualifier is resolved by the type checker.
+	for _, describe := range []bool{false, true} {
+		conf := Config{Context: build.Default, Describe: describe}
+		res, err := conf.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Errorf("describe %t: %v", describe, err)
+			continue
+		}
+		if res.Position.Filename != want || res.Description != `package fmt ("fmt")` || res.Kind != KindNamespace {
+			t.Errorf("describe %t: exp %s %q %s got %s %q %s",
+				describe, want, `package fmt ("fmt")`, KindNamespace, res.Position, res.Description, res.Kind)
+		}
+		if describe && len(res.Members) == 0 {
+			t.Errorf("describe %t: no members", describe)
+		}
+	}
+}
+
+func TestLookup_GOROOTDev(t *testing.T) {
+	if programBackend == "packages" {
+		t.Skip("the go command requires a complete Go source tree")
+	}
+	const src = "package runtime\n\nimport \"internal/godefx\"\n\nfunc F() { godefx.X(); G() }\n"
+	root := filepath.Join(writeFiles(t, map[string]string{
+		"go/src/go.mod":               "module std\n",
+		"go/src/internal/godefx/x.go": "package godefx\n\nfunc X() {}\n",
+		"go/src/runtime/r.go":         src,
+		"go/src/runtime/g.go":         "package runtime\n\nfunc G() {}\n",
+	}), "go")
+	if got := goSourceTree(&build.Default, filepath.Join(root, "src", "runtime", "r.go")); got != root {
+		t.Errorf("goSourceTree: exp %q got %q", root, got)
+	}
+	// The files are read through the build context, e.g. its overlay.
+	ctxt := useOverlay(&build.Default, map[string][]byte{
+		filepath.Join(root, "src", "go.mod"): []byte("module example.com/m\n"),
+	})
+	if got := goSourceTree(ctxt, filepath.Join(root, "src", "runtime", "r.go")); got != "" {
+		t.Errorf("goSourceTree (overlay): exp \"\" got %q", got)
+	}
+
+	filename := filepath.Join(root, "src", "runtime", "r.go")
+	tests := []struct {
+		marker string
+		want   string
+	}{
+		{"X()", "src/internal/godefx/x.go"},
+		{"G()", "src/runtime/g.go"},
+	}
+	conf := Config{Context: build.Default, GOROOTDev: true}
+	for _, x := range tests {
+		res, err := conf.Lookup(filename, strings.Index(src, x.marker), nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if want := filepath.Join(root, filepath.FromSlash(x.want)); res.Position.Filename != want {
+			t.Errorf("(%+v): exp %s got %s", x, want, res.Position)
+		}
+	}
+}
+
+const receiverSrc = `package p
+
+import "strings"
+
+type T struct{ F int }
+
+func (t *T) M() {}
+
+func G(t *T, b *strings.Builder, s []int, n int) {
+	t.M()
+	_ = t.F
+	b.Len()
+	_ = s[0]
+	_ = n
+	_ = strings.ToUpper
+	var err error
+	_ = err.Error
+	_ = T.M
+}
+`
+
+func TestEngine_ReceiverTypeAt(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": receiverSrc})
+	filename := filepath.Join(tmp, "p.go")
+	e := NewEngine(&Config{Context: build.Default})
+
+	tests := []struct {
+		marker string
+		file   string // file of the definition, relative to GOROOT/src if not "p.go"
+		line   int
+		err    error
+	}{
+		{marker: "M()\n\t_", file: "p.go", line: 5},
+		{marker: "t.M()", file: "p.go", line: 5},
+		{marker: "F\n", file: "p.go", line: 5},
+		{marker: "M\n}", file: "p.go", line: 5},
+		{marker: "Len()", file: "strings/builder.go"},
+		{marker: "Error\n", file: "builtin/builtin.go"},
+		{marker: "ToUpper", err: ErrNoSelector},
+		{marker: "s[0]", err: ErrNoSelector},
+		{marker: "n\n", err: ErrNoSelector},
+	}
+	for _, x := range tests {
+		offset := strings.Index(receiverSrc, x.marker)
+		if offset < 0 {
+			t.Fatalf("marker %q not found", x.marker)
+		}
+		res, err := e.ReceiverTypeAt(filename, offset, nil)
+		if x.err != nil {
+			if !errors.Is(err, x.err) {
+				t.Errorf("(%+v): error: exp %v got %v", x, x.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		want := filename
+		if x.file != "p.go" {
+			if !haveGoSrc {
+				continue
+			}
+			want = filepath.Join(build.Default.GOROOT, "src", filepath.FromSlash(x.file))
+		}
+		if res.Position.Filename != want || (x.line != 0 && res.Position.Line != x.line) {
+			t.Errorf("(%+v): position: exp %s:%d got %s", x, want, x.line, res.Position)
+		}
+		if res.Kind != KindType {
+			t.Errorf("(%+v): kind: exp %q got %q", x, KindType, res.Kind)
+		}
+	}
+}
+
+const describeSrc = `package p
+
+import "strings"
+
+type T struct {
+	F int
+	g string
+}
+
+func (T) M() {}
+
+func (*T) m() {}
+
+const C = 1
+
+func G(t T) {
+	t.M()
+	_ = C
+	_ = strings.NewReader
+}
+`
+
+func TestLookup_Describe(t *testing.T) {
+	if !haveGoSrc {
+		t.Skip("GOROOT/src not found")
+	}
+	tmp := writeFiles(t, map[string]string{"p.go": describeSrc})
+	filename := filepath.Join(tmp, "p.go")
+	tests := []struct {
+		marker  string
+		all     bool
+		typ     string
+		members []string
+	}{
+		{
+			marker:  "t T)",
+			typ:     "t T",
+			members: []string{"F int", "M func()"},
+		},
+		{
+			marker:  "t T)",
+			all:     true,
+			typ:     "t T",
+			members: []string{"F int", "M func()", "g string", "m func()"},
+		},
+		{
+			marker: "M()\n\t_",
+			typ:    "M func()",
+		},
+		{
+			marker: "C\n",
+			typ:    "const C untyped int = 1",
+		},
+		{
+			marker: "NewReader",
+			typ:    "NewReader func(s string) *strings.Reader",
+		},
+	}
+	for _, x := range tests {
+		offset := strings.Index(describeSrc, x.marker)
+		if offset < 0 {
+			t.Fatalf("marker %q not found", x.marker)
+		}
+		conf := Config{Context: build.Default, Describe: true, UnexportedMembers: x.all}
+		res, err := conf.Lookup(filename, offset, nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if res.Type != x.typ {
+			t.Errorf("(%+v): type: exp %q got %q", x, x.typ, res.Type)
+		}
+		var members []string
+		for _, m := range res.Members {
+			members = append(members, m.Description)
+			if m.Position.Filename != filename {
+				t.Errorf("(%+v): member %s: position: exp %s got %s", x, m.Description, filename, m.Position)
+			}
+		}
+		if !reflect.DeepEqual(members, x.members) {
+			t.Errorf("(%+v): members: exp %q got %q", x, x.members, members)
+		}
+	}
+}
+
+const exprSrc = `package p
+
+type T struct{ F []int }
+
+const C = 1 << 2
+
+func F() {}
+
+func G(m map[string]int, t *T, v interface{}) int {
+	F()
+	_ = T{F: nil}
+	_ = m["a"] + C
+	_ = t.F[0]
+	_, _ = v.(int)
+	return len(t.F)
+}
+`
+
+func TestEngine_DescribeRange(t *testing.T) {
+	tmp := writeFiles(t, map[string]string{"p.go": exprSrc})
+	filename := filepath.Join(tmp, "p.go")
+	e := NewEngine(&Config{Context: build.Default})
+
+	tests := []struct {
+		expr     string // selected expression
+		typ      string
+		category ValueCategory
+		value    string
+	}{
+		{expr: "F()", category: CategoryNoValue},
+		{expr: "T{F: nil}", typ: "T", category: CategoryValue},
+		{expr: "nil", typ: "untyped nil", category: CategoryNil},
+		{expr: `m["a"]`, typ: "int", category: CategoryMapIndex},
+		{expr: `m["a"] + C`, typ: "int", category: CategoryValue},
+		{expr: "C\n", typ: "int", category: CategoryConstant, value: "4"}, // converted
+		{expr: "t.F[0]", typ: "int", category: CategoryVariable},
+		{expr: "v.(int)", typ: "(int, bool)", category: CategoryCommaOK},
+		{expr: "len", category: CategoryBuiltin, typ: "func([]int) int"},
+		{expr: "T{F", typ: "T", category: CategoryType},
+	}
+	for _, x := range tests {
+		expr := strings.TrimSpace(x.expr)
+		if x.expr == "T{F" {
+			expr = "T"
+		}
+		start := strings.LastIndex(exprSrc, x.expr)
+		if start < 0 {
+			t.Fatalf("expression %q not found", x.expr)
+		}
+		end := start + len(expr)
+		res, err := e.DescribeRange(filename, start, end, nil)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		got := Expression{Source: res.Source, Type: res.Type, Category: res.Category, Value: res.Value}
+		want := Expression{Source: expr, Type: x.typ, Category: x.category, Value: x.value}
+		if got != want {
+			t.Errorf("(%+v): exp %+v got %+v", x, want, got)
+		}
+		if res.Start.Offset != start || res.End.Offset != end || res.End.Filename != filename {
+			t.Errorf("(%+v): exp %s:#%d,#%d got range %s:#%d,#%d",
+				x, filename, start, end, res.Start.Filename, res.Start.Offset, res.End.Offset)
+		}
+	}
+
+	// The range must select an expression exactly
+	start := strings.Index(exprSrc, "m[")
+	var amb *AmbiguousSelectionError
+	if _, err := e.DescribeRange(filename, start, start+3, nil); !errors.As(err, &amb) {
+		t.Errorf("error: exp %T got %v", amb, err)
+	}
+}
+
+func TestEngine_EnclosingDecl(t *testing.T) {
+	const src = "package p\n\n" +
+		"// F is a function.\n" +
+		"func F() int {\n\tvar x = 1\n\treturn x\n}\n\n" +
+		"type (\n\tA int\n\tB struct{ X int }\n)\n\n" +
+		"const C = 1\n\n" +
+		"func (B) M() {}\n"
+	tmp := writeFiles(t, map[string]string{"p.go": src})
+	filename := filepath.Join(tmp, "p.go")
+	e := NewEngine(&Config{Context: build.Default})
+
+	tests := []struct {
+		cursor string // text following the cursor
+		decl   string // the enclosing declaration
+		kind   Kind
+		name   string
+	}{
+		{"return x", "func F() int {\n\tvar x = 1\n\treturn x\n}", KindFunction, "F"},
+		{"F()", "func F() int {\n\tvar x = 1\n\treturn x\n}", KindFunction, "F"},
+		{"x = 1", "var x = 1", KindVariable, "x"},
+		{"X int", "B struct{ X int }", KindType, "B"},
+		{"\n)", "type (\n\tA int\n\tB struct{ X int }\n)", KindType, ""},
+		{"= 1\n\nfunc", "const C = 1", KindConst, "C"},
+		{"M()", "func (B) M() {}", KindMethod, "M"},
+	}
+	for _, test := range tests {
+		cursor := strings.Index(src, test.cursor)
+		res, err := e.EnclosingDecl(filename, cursor, nil)
+		if err != nil {
+			t.Errorf("%q: %v", test.cursor, err)
+			continue
+		}
+		start := strings.Index(src, test.decl)
+		end := start + len(test.decl)
+		if res.Start.Offset != start || res.End.Offset != end || res.End.Filename != filename {
+			t.Errorf("%q: exp %s:#%d,#%d got range %s:#%d,#%d",
+				test.cursor, filename, start, end, res.Start.Filename, res.Start.Offset, res.End.Offset)
+		}
+		if res.Kind != test.kind || res.Name != test.name {
+			t.Errorf("%q: exp %s %q got %s %q", test.cursor, test.kind, test.name, res.Kind, res.Name)
+		}
+	}
+
+	for _, cursor := range []string{"package", "// F"} {
+		_, err := e.EnclosingDecl(filename, strings.Index(src, cursor), nil)
+		if !errors.Is(err, ErrNoDecl) {
+			t.Errorf("%q: error: exp %v got %v", cursor, ErrNoDecl, err)
+		}
+	}
+}
+
+func TestEngine_Complete(t *testing.T) {
+	// The selectors are incomplete, as they are while typing.
+	const src = "package p\n\nimport \"strings\"\n\n" +
+		"type T struct{ x, Y int }\n\nfunc (T) M() {}\n\n" +
+		"func f() {\n\tstrings.TrimS\n}\n\n" +
+		"func g(b *strings.Builder) {\n\tb.\n}\n\n" +
+		"func h(t T) {\n\tt.\n}\n"
+	tmp := writeFiles(t, map[string]string{"p.go": src})
+	filename := filepath.Join(tmp, "p.go")
+	e := NewEngine(&Config{Context: build.Default})
+
+	tests := []struct {
+		selector string // the cursor is at its end
+		want     []Completion
+	}{
+		{"strings.TrimS", []Completion{
+			{"TrimSpace", KindFunction, "func(s string) string"},
+			{"TrimSuffix", KindFunction, "func(s, suffix string) string"},
+		}},
+		{"t.", []Completion{
+			{"M", KindMethod, "func()"},
+			{"Y", KindProperty, "int"},
+			{"x", KindProperty, "int"},
+		}},
+	}
+	for _, test := range tests {
+		list, err := e.Complete(filename, strings.Index(src, test.selector)+len(test.selector), nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.selector, err)
+			continue
+		}
+		if !reflect.DeepEqual(list, test.want) {
+			t.Errorf("%s: exp %v got %v", test.selector, test.want, list)
+		}
+	}
+
+	// Exported methods of a type in another package
+	list, err := e.Complete(filename, strings.Index(src, "b.")+2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range list {
+		if c.Kind != KindMethod || strings.ToUpper(c.Name[:1]) != c.Name[:1] {
+			t.Errorf("b.: exp an exported method got %+v", c)
+		}
+		names = append(names, c.Name)
+	}
+	if !strings.Contains(strings.Join(names, " "), "WriteString") {
+		t.Errorf("b.: exp WriteString got %v", names)
+	}
+
+	if _, err := e.Complete(filename, strings.Index(src, "strings."), nil); !errors.Is(err, ErrNoSelector) {
+		t.Errorf("error: exp %v got %v", ErrNoSelector, err)
+	}
+}
+
+func TestEngine_References(t *testing.T) {
+	const asrc = "package p\n\ntype T struct{ X int }\n\nfunc (t *T) M() int {\n\treturn t.X\n}\n\nvar V = T{X: 1}\n"
+	const bsrc = "package p\n\nfunc F(t T) int {\n\tt.X++\n\treturn t.X\n}\n"
+	tmp := writeFiles(t, map[string]string{
+		"go.mod": "module example.com/p\n",
+		"a.go":   asrc,
+		"b.go":   bsrc,
+	})
+	afile := filepath.Join(tmp, "a.go")
+	bfile := filepath.Join(tmp, "b.go")
+	e := NewEngine(&Config{Context: build.Default, Resolver: &ModuleResolver{}})
+
+	type ref struct {
+		file  string
+		line  int
+		descr string
+	}
+	tests := []struct {
+		name   string
+		file   string
+		offset int
+		want   []ref
+	}{
+		{"Declaration", afile, strings.Index(asrc, "X int"), []ref{
+			{afile, 6, "method (*T).M"},
+			{afile, 9, "var V"},
+			{bfile, 4, "func F"},
+			{bfile, 5, "func F"},
+		}},
+		{"Use", bfile, strings.Index(bsrc, "T)"), []ref{
+			{afile, 5, "method (*T).M"},
+			{afile, 9, "var V"},
+			{bfile, 3, "func F"},
+		}},
+		{"Local", bfile, strings.LastIndex(bsrc, "t.X"), []ref{
+			{bfile, 4, "func F"},
+			{bfile, 5, "func F"},
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := e.References(test.file, test.offset, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Position.Filename != afile && test.name != "Local" {
+				t.Errorf("definition: exp in %s got %s", afile, res.Position)
+			}
+			var got []ref
+			for _, r := range res.References {
+				got = append(got, ref{r.Position.Filename, r.Position.Line, r.Description})
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("exp references:\n%v\ngot:\n%v", test.want, got)
+			}
+		})
+	}
+
+	// Lookup does not search for references
+	res, err := e.Lookup(afile, strings.Index(asrc, "X int"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.References) != 0 {
+		t.Errorf("Lookup: exp 0 got %d references", len(res.References))
+	}
+}
+
+func TestLookup_ExportData(t *testing.T) {
+	// Backends without export data parse the imports, with a warning.
+	const src = `package p
+
+import (
+	"go/build"
+	"strings"
+)
+
+func F(c *build.Context) string {
+	var b strings.Builder
+	b.WriteString(c.GOOS)
+	return b.String()
+}
+`
+	files := map[string]string{
+		"go.mod": "module example.com/p\n",
+		"p.go":   src,
+	}
+	tmp := writeFiles(t, files)
+	filename := filepath.Join(tmp, "p.go")
+	markers := []string{"WriteString", "GOOS", "String()"}
+	if !exportDataSupported {
+		markers = markers[:1] // parsing the imports is slow
+	}
+	for _, marker := range markers {
+		var results [2]*Result
+		for i, exportData := range []bool{false, true} {
+			conf := Config{
+				Context:    build.Default,
+				Resolver:   &ModuleResolver{},
+				ExportData: exportData,
+			}
+			res, err := conf.Lookup(filename, strings.Index(src, marker), nil)
+			if err != nil {
+				t.Fatalf("%s (ExportData: %t): %v", marker, exportData, err)
+			}
+			if warned := len(res.Warnings) != 0; warned != (exportData && !exportDataSupported) {
+				t.Errorf("%s (ExportData: %t): got warnings %v with the %s backend", marker, exportData, res.Warnings, programBackend)
+			}
+			res.Stats, res.Warnings = Stats{}, nil
+			results[i] = res
+		}
+		if !reflect.DeepEqual(results[0], results[1]) {
+			t.Errorf("%s: exp %+v got %+v with export data", marker, results[0], results[1])
+		}
+	}
+}
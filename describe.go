@@ -0,0 +1,100 @@
+package godef
+
+import (
+	"fmt"
+	"go/types"
+	"sort"
+)
+
+// describeDefinition adds the type and members of obj, the object of the
+// query, to the result of q if q.describe is set.
+func describeDefinition(q *Query, lprog program, qpos *queryPos, obj types.Object) {
+	if !q.describe {
+		return
+	}
+	qf := types.RelativeTo(qpos.info.Pkg)
+	q.result.typ = describeObject(obj, qf)
+	for _, m := range objectMembers(obj, q.allMembers) {
+		q.result.members = append(q.result.members, definitionResult{
+			pos:   q.objectPos(lprog, m),
+			descr: describeObject(m, qf),
+			kind:  objectKind(m, nil),
+		})
+	}
+}
+
+// describeObject returns the type of obj in the format of the -t flag
+// of the original godef, e.g. "F func(n int) int" or "type T struct{}".
+func describeObject(obj types.Object, qf types.Qualifier) string {
+	switch obj := obj.(type) {
+	case *types.PkgName:
+		return fmt.Sprintf("import (%s %q)", obj.Name(), obj.Imported().Path())
+	case *types.Const:
+		return fmt.Sprintf("const %s %s = %s", obj.Name(), types.TypeString(obj.Type(), qf), obj.Val())
+	case *types.TypeName:
+		return fmt.Sprintf("type %s %s", obj.Name(), types.TypeString(obj.Type().Underlying(), qf))
+	case *types.Label:
+		return "label " + obj.Name()
+	case *types.Builtin:
+		return "builtin " + obj.Name()
+	case *types.Nil:
+		return "nil"
+	}
+	return fmt.Sprintf("%s %s", obj.Name(), types.TypeString(obj.Type(), qf))
+}
+
+// objectMembers returns the members of obj sorted by name: those of the
+// package for an imported package name, otherwise the fields and
+// methods of the type of obj, or of the type obj denotes.  Pointers are
+// followed to their element type.  Unexported members are omitted
+// unless all is set.
+func objectMembers(obj types.Object, all bool) []types.Object {
+	var members []types.Object
+	switch obj := obj.(type) {
+	case *types.PkgName:
+		scope := obj.Imported().Scope()
+		for _, name := range scope.Names() {
+			members = append(members, scope.Lookup(name))
+		}
+	case *types.Builtin, *types.Label, *types.Nil:
+		// no members
+	default:
+		members = typeMembers(obj.Type())
+	}
+	if all {
+		return members
+	}
+	exported := members[:0]
+	for _, m := range members {
+		if m.Exported() {
+			exported = append(exported, m)
+		}
+	}
+	return exported
+}
+
+// typeMembers returns the fields and methods of T sorted by name.  A
+// pointer is followed to its element type.
+func typeMembers(T types.Type) []types.Object {
+	if ptr, ok := T.(*types.Pointer); ok {
+		T = ptr.Elem()
+	}
+	var members []types.Object
+	if st, ok := T.Underlying().(*types.Struct); ok {
+		for i := 0; i < st.NumFields(); i++ {
+			members = append(members, st.Field(i))
+		}
+	}
+	// The method set of *T includes that of T.
+	mset := types.NewMethodSet(T)
+	if !types.IsInterface(T) {
+		mset = types.NewMethodSet(types.NewPointer(T))
+	}
+	for i := 0; i < mset.Len(); i++ {
+		members = append(members, mset.At(i).Obj())
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Name() < members[j].Name()
+	})
+	return members
+}
@@ -0,0 +1,126 @@
+package godef
+
+import (
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// determinismSrc is a GOPATH package "d".  Package "d/a" declares the
+// same member in two files, as happens in packages that are being
+// edited, so that findPackageMember has more than one answer.
+var determinismSrc = map[string]string{
+	"a/a.go": "package a\n\nconst X = 1\n\nfunc F() int { return X }\n",
+	"a/b.go": "package a\n\nconst X = 2\n\ntype T struct{ F int }\n",
+	"a/c.go": "package a\n\nfunc (T) M() {}\n",
+	"d.go": `package d
+
+import "d/a"
+
+var v = a.X + a.F()
+
+func G(t a.T) { t.M(); _ = t.F; _ = len("") }
+`,
+}
+
+// resultJSON returns the canonical encoding of a Lookup result.
+func resultJSON(t *testing.T, res *Result, err error) string {
+	type canonical struct {
+		Result   *Result
+		Warnings []string
+		Err      string
+	}
+	c := canonical{Result: res}
+	if res != nil {
+		for _, w := range res.Warnings {
+			c.Warnings = append(c.Warnings, w.Error())
+		}
+		r := *res
+		r.Warnings = nil
+		// Only the statistics of a query may differ, packages are
+		// scanned concurrently so the number of files parsed varies.
+		r.Stats = Stats{Strategy: r.Stats.Strategy}
+		c.Result = &r
+	}
+	if err != nil {
+		c.Err = err.Error()
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// TestDeterminism runs queries sequentially and then concurrently in a
+// shuffled order with different GOMAXPROCS settings and checks that the
+// results are identical.
+func TestDeterminism(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for name, src := range determinismSrc {
+		name = filepath.Join(tmp, "src", "d", filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filename := filepath.Join(tmp, "src", "d", "d.go")
+	src := determinismSrc["d.go"]
+	var offsets []int
+	for _, marker := range []string{"a.X", "X +", "F()", "T)", "M()", "F;", "len", "a\"\n"} {
+		i := strings.Index(src, marker)
+		if i < 0 {
+			t.Fatalf("marker %q not found", marker)
+		}
+		offsets = append(offsets, i)
+	}
+
+	conf := Config{
+		Context:         build.Default,
+		ResolveWrappers: true,
+		Probe:           true,
+	}
+	conf.Context.GOPATH = tmp
+	e := NewEngine(&conf)
+	want := make([]string, len(offsets))
+	for i, offset := range offsets {
+		res, err := e.Lookup(filename, offset, nil)
+		want[i] = resultJSON(t, res, err)
+	}
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+	rr := rand.New(rand.NewSource(1))
+	for _, procs := range []int{1, 2, runtime.NumCPU() * 2} {
+		runtime.GOMAXPROCS(procs)
+		got := make([]string, len(offsets))
+		var wg sync.WaitGroup
+		for _, i := range rr.Perm(len(offsets)) {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				runtime.Gosched()
+				res, err := e.Lookup(filename, offsets[i], nil)
+				got[i] = resultJSON(t, res, err)
+			}(i)
+		}
+		wg.Wait()
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("GOMAXPROCS=%d: query %d:\ngot:  %s\nwant: %s", procs, i, got[i], want[i])
+			}
+		}
+	}
+}
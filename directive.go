@@ -0,0 +1,191 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// directiveDefinition outputs the file named by the argument at qpos of
+// a //go:embed or //go:generate directive, which are not identifiers
+// but are navigable, or reports that a struct tag has no definition.
+// It reports whether qpos is in a directive or struct tag.
+func directiveDefinition(q *Query, qpos *queryPos) (bool, error) {
+	if lit, ok := qpos.path[0].(*ast.BasicLit); ok {
+		if field, ok := qpos.path[1].(*ast.Field); ok && field.Tag == lit {
+			return true, &NotFoundError{Err: ErrStructTag}
+		}
+		return false, nil // directives are comments
+	}
+
+	tf := qpos.fset.File(qpos.start)
+	filename := tf.Name()
+	rc, err := buildutil.OpenFile(q.Build, filename)
+	if err != nil {
+		return false, nil
+	}
+	src, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return false, nil
+	}
+	offset := tf.Offset(qpos.start)
+	if offset > len(src) {
+		return false, nil
+	}
+	start := strings.LastIndexByte(string(src[:offset]), '\n') + 1
+	end := len(src)
+	if i := strings.IndexByte(string(src[offset:]), '\n'); i >= 0 {
+		end = offset + i
+	}
+	line := string(src[start:end])
+	indent := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+
+	var directive string
+	for _, d := range []string{"//go:embed", "//go:generate"} {
+		if strings.HasPrefix(line[indent:], d+" ") || strings.HasPrefix(line[indent:], d+"\t") {
+			directive = d
+		}
+	}
+	if directive == "" {
+		return false, nil
+	}
+	argsStart := start + indent + len(directive)
+	var arg string
+	for _, a := range directiveArgs(string(src[argsStart:end])) {
+		if argsStart+a.start <= offset && offset <= argsStart+a.end {
+			arg = a.text
+		}
+	}
+	if arg == "" {
+		return true, &NotFoundError{Err: ErrNotFound, Reason: "no file name here"}
+	}
+
+	dir := filepath.Dir(filename)
+	var matches []string
+	if directive == "//go:embed" {
+		pattern := strings.TrimPrefix(arg, "all:")
+		matches = globContext(q.Build, dir, pattern)
+		sort.Strings(matches)
+	} else {
+		name := os.Expand(arg, func(v string) string {
+			switch v {
+			case "GOFILE":
+				return filepath.Base(filename)
+			case "GOOS":
+				return q.Build.GOOS
+			case "GOARCH":
+				return q.Build.GOARCH
+			}
+			return "$" + v
+		})
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(dir, filepath.FromSlash(name))
+		}
+		if buildutil.FileExists(q.Build, name) || buildutil.IsDir(q.Build, name) {
+			matches = []string{name}
+		}
+	}
+	if len(matches) == 0 {
+		return true, &NotFoundError{
+			Err:    ErrNotFound,
+			Reason: fmt.Sprintf("no file matches %q", arg),
+		}
+	}
+
+	descr := "file " + filepath.ToSlash(arg)
+	if len(matches) > 1 {
+		descr += fmt.Sprintf(" (%d files)", len(matches))
+	}
+	fset := token.NewFileSet()
+	q.Output(fset, &definitionResult{
+		pos:   fset.AddFile(matches[0], -1, 0).Pos(0),
+		descr: descr,
+		kind:  KindFile,
+	})
+	return true, nil
+}
+
+// globContext returns the files and directories in ctxt that match the
+// slash-separated pattern (see path.Match) relative to dir, like
+// filepath.Glob in the file system of ctxt.
+func globContext(ctxt *build.Context, dir, pattern string) []string {
+	matches := []string{dir}
+	for _, elem := range strings.Split(pattern, "/") {
+		var next []string
+		for _, m := range matches {
+			if !strings.ContainsAny(elem, `*?[\`) {
+				name := filepath.Join(m, elem)
+				if buildutil.FileExists(ctxt, name) || buildutil.IsDir(ctxt, name) {
+					next = append(next, name)
+				}
+				continue
+			}
+			fis, err := buildutil.ReadDir(ctxt, m)
+			if err != nil {
+				continue
+			}
+			for _, fi := range fis {
+				if ok, _ := path.Match(elem, fi.Name()); ok {
+					next = append(next, filepath.Join(m, fi.Name()))
+				}
+			}
+		}
+		matches = next
+	}
+	return matches
+}
+
+// A directiveArg is an argument of a directive and its extent in the
+// arguments.
+type directiveArg struct {
+	text       string
+	start, end int
+}
+
+// directiveArgs splits the arguments of a directive at white space.
+// Quoted arguments, which may contain spaces, are unquoted.
+func directiveArgs(s string) []directiveArg {
+	var args []directiveArg
+	for i := 0; i < len(s); {
+		if s[i] == ' ' || s[i] == '\t' {
+			i++
+			continue
+		}
+		j := i + 1
+		switch s[i] {
+		case '"', '`':
+			for j < len(s) && s[j] != s[i] {
+				if s[i] == '"' && s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j < len(s) {
+				j++ // closing quote
+			}
+		default:
+			for j < len(s) && s[j] != ' ' && s[j] != '\t' {
+				j++
+			}
+		}
+		text := s[i:j]
+		if unquoted, err := strconv.Unquote(text); err == nil {
+			text = unquoted
+		}
+		args = append(args, directiveArg{text, i, j})
+		i = j
+	}
+	return args
+}
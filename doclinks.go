@@ -0,0 +1,204 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// docLinkDefinition answers a query in a comment of the package member
+// named by the doc link, e.g. [bytes.Buffer] or [Reader.Read], or the
+// plain identifier, e.g. Buffer or json.Decoder, at qpos.  A name
+// qualified by a package name that is not imported by the file is
+// taken to be an import path, as for doc links.  It reports whether
+// qpos is in a comment.
+func docLinkDefinition(q *Query, qpos *queryPos) (bool, error) {
+	f := qpos.path[len(qpos.path)-1].(*ast.File)
+	tf := qpos.fset.File(f.Pos())
+	src, err := readFile(q.Build, tf.Name())
+	if err != nil || len(src) != tf.Size() {
+		return false, nil
+	}
+	offset := tf.Offset(qpos.start)
+	comment, start := commentAt(src, offset)
+	if comment == "" {
+		return false, nil
+	}
+	name := docLinkAt(comment, offset-start)
+	if name == "" {
+		return true, &NotFoundError{Err: ErrNoIdentifier}
+	}
+	q.logf("resolving %s in comment", name)
+
+	srcdir := filepath.Dir(tf.Name())
+	pkg, member, sel := splitDocLink(name)
+	if pkg != "" && !strings.Contains(pkg, "/") {
+		if path := importedPackage(f, pkg); path != "" {
+			pkg = path
+		} else if sel == "" {
+			// [T.M] of a type of the package of the file?
+			if tok, _, err := findPackageMember(q, token.NewFileSet(), srcdir, ".", pkg); err == nil && tok == token.TYPE {
+				pkg, member, sel = "", pkg, member
+			}
+		}
+	}
+	if pkg == "" {
+		pkg = "." // the package of the file
+	}
+	fset := token.NewFileSet()
+	tok, pos, err := findPackageMember(q, fset, srcdir, pkg, member)
+	if err != nil {
+		return true, &NotFoundError{Err: ErrNotFound, Reason: fmt.Sprintf("%s in comment: %v", name, err)}
+	}
+	descr := fmt.Sprintf("%s %s", tok, member)
+	if pkg != "." {
+		descr = fmt.Sprintf("%s %s.%s", tok, pkg, member)
+	}
+	kind := tokenKind(tok)
+	if sel != "" {
+		if tok != token.TYPE {
+			return true, &NotFoundError{Err: ErrNotFound, Reason: fmt.Sprintf("%s in comment: %s is not a type", name, member)}
+		}
+		pos, descr = findSelection(q, fset, pos, member, sel)
+		if !pos.IsValid() {
+			return true, &NotFoundError{Err: ErrNotFound, Reason: fmt.Sprintf("%s in comment: no field or method %s", name, sel)}
+		}
+		kind = KindMethod
+		if strings.HasPrefix(descr, "field ") {
+			kind = KindProperty
+		}
+	}
+	q.stats.Strategy = StrategyPackageScan
+	q.Output(fset, &definitionResult{
+		pos:   pos,
+		descr: descr,
+		kind:  kind,
+	})
+	return true, nil
+}
+
+// commentAt returns the text of the comment of src that contains offset
+// and its offset, or "" if offset is not in a comment.
+func commentAt(src []byte, offset int) (string, int) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments) // syntax errors are expected
+	for {
+		pos, tok, lit := s.Scan()
+		off := file.Offset(pos)
+		if tok == token.EOF || off > offset {
+			return "", 0
+		}
+		if tok == token.COMMENT && offset < off+len(lit) {
+			return lit, off
+		}
+	}
+}
+
+// docLinkAt returns the doc link, without its brackets and the * of a
+// pointer, or the possibly qualified identifier at offset in text, or
+// "" if there is none.
+func docLinkAt(text string, offset int) string {
+	if i := strings.LastIndexByte(text[:offset], '['); i >= 0 && !strings.ContainsAny(text[i:offset], "] \t\n") {
+		if j := strings.IndexByte(text[offset:], ']'); j >= 0 && !strings.ContainsAny(text[offset:offset+j], "[ \t\n") {
+			link := strings.TrimPrefix(text[i+1:offset+j], "*")
+			if isDocLink(link) {
+				return link
+			}
+		}
+	}
+	isWord := func(r rune) bool {
+		return r == '_' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	start, end := offset, offset
+	for start > 0 {
+		r, size := utf8.DecodeLastRuneInString(text[:start])
+		if !isWord(r) {
+			break
+		}
+		start -= size
+	}
+	for end < len(text) {
+		r, size := utf8.DecodeRuneInString(text[end:])
+		if !isWord(r) {
+			break
+		}
+		end += size
+	}
+	word := strings.Trim(text[start:end], ".") // e.g. the end of a sentence
+	if !isDocLink(word) {
+		return ""
+	}
+	return word
+}
+
+// isDocLink reports whether s has the syntax of the target of a doc
+// link: identifiers separated by periods, optionally preceded by an
+// import path.
+func isDocLink(s string) bool {
+	if i := strings.LastIndexByte(s, '/'); i >= 0 {
+		s = s[i+1:]
+	}
+	for _, id := range strings.Split(s, ".") {
+		if !token.IsIdentifier(id) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitDocLink splits link into the package it is qualified by, the
+// name of the package member and the field or method of it that is
+// selected.  Links of the form A.B are split as a qualified member.
+func splitDocLink(link string) (pkg, member, sel string) {
+	var path string
+	if i := strings.LastIndexByte(link, '/'); i >= 0 {
+		j := strings.IndexByte(link[i:], '.')
+		if j < 0 {
+			return "", "", "" // a package
+		}
+		path, link = link[:i+j], link[i+j+1:]
+	}
+	parts := strings.Split(link, ".")
+	if path != "" {
+		parts = append([]string{path}, parts...)
+	}
+	switch len(parts) {
+	case 1:
+		return "", parts[0], ""
+	case 2:
+		return parts[0], parts[1], ""
+	case 3:
+		return parts[0], parts[1], parts[2]
+	}
+	return "", "", ""
+}
+
+// findSelection returns the position and a description of the field or
+// method sel of the type named recv declared at pos in fset, which is
+// searched for in the files of the package of the declaration.
+func findSelection(q *Query, fset *token.FileSet, pos token.Pos, recv, sel string) (token.Pos, string) {
+	dir := filepath.Dir(fset.Position(pos).Filename)
+	bp, err := q.Build.ImportDir(dir, 0)
+	if err != nil {
+		return token.NoPos, ""
+	}
+	id := ObjectID{Recv: recv, Name: sel}
+	for _, name := range bp.GoFiles {
+		f, err := parseFile(fset, q.Build, q.astCache, ".", filepath.Join(dir, name), parser.Mode(0))
+		if f == nil && err != nil {
+			continue
+		}
+		if pos, descr := findVariantDecl(f, id); pos.IsValid() {
+			return pos, descr
+		}
+	}
+	return token.NoPos, ""
+}
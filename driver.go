@@ -0,0 +1,182 @@
+package godef
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// GOPACKAGESDRIVER load modes, these match golang.org/x/tools/go/packages.
+const (
+	driverModeName            = 1 << 0
+	driverModeFiles           = 1 << 1
+	driverModeCompiledGoFiles = 1 << 2
+	driverModeImports         = 1 << 3
+	driverModeDeps            = 1 << 4
+)
+
+// driverRequest is the JSON request sent to a GOPACKAGESDRIVER on stdin.
+type driverRequest struct {
+	Mode       int               `json:"mode"`
+	Env        []string          `json:"env"`
+	BuildFlags []string          `json:"build_flags"`
+	Tests      bool              `json:"tests"`
+	Overlay    map[string][]byte `json:"overlay"`
+}
+
+// driverResponse is the JSON response of a GOPACKAGESDRIVER.
+type driverResponse struct {
+	NotHandled bool
+	Roots      []string `json:",omitempty"`
+	Packages   []*driverPackage
+}
+
+// driverPackage is the subset of packages.Package used by godef.
+type driverPackage struct {
+	ID              string
+	Name            string
+	PkgPath         string
+	GoFiles         []string
+	CompiledGoFiles []string
+	Imports         map[string]string // import path => package ID
+}
+
+// files returns the absolute names of the files compiled into p.
+func (p *driverPackage) files() []string {
+	if len(p.CompiledGoFiles) != 0 {
+		return p.CompiledGoFiles
+	}
+	return p.GoFiles
+}
+
+// isTestVariant reports whether p is a test variant of a package, e.g.
+// "example.com/p [example.com/p.test]", or a test main package.
+func (p *driverPackage) isTestVariant() bool {
+	return strings.Contains(p.ID, " [") || strings.HasSuffix(p.ID, ".test")
+}
+
+func (p *driverPackage) containsFile(filename string) bool {
+	for _, list := range [][]string{p.CompiledGoFiles, p.GoFiles} {
+		for _, name := range list {
+			if name == filename || sameFile(name, filename) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findDriver returns driver, if set, or the GOPACKAGESDRIVER program of
+// env.
+func findDriver(env Environment, driver string) (string, error) {
+	if driver != "" {
+		return driver, nil
+	}
+	if driver = env.Getenv("GOPACKAGESDRIVER"); driver == "off" {
+		return "", fmt.Errorf("gopackagesdriver: disabled by GOPACKAGESDRIVER=off")
+	}
+	if driver != "" {
+		return driver, nil
+	}
+	driver, err := exec.LookPath("gopackagesdriver")
+	if err != nil {
+		return "", fmt.Errorf("gopackagesdriver: %v", err)
+	}
+	return driver, nil
+}
+
+// runDriver runs the GOPACKAGESDRIVER driver in dir with patterns.
+func runDriver(driver, dir string, env []string, mode int, patterns ...string) (*driverResponse, error) {
+	req, err := json.Marshal(&driverRequest{Mode: mode, Env: env, Tests: true})
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(driver, patterns...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdin = bytes.NewReader(req)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %v: %s", filepath.Base(driver), err, msg)
+		}
+		return nil, fmt.Errorf("%s: %v", filepath.Base(driver), err)
+	}
+	var resp driverResponse
+	if err := json.Unmarshal(out, &resp); err != nil {
+		return nil, fmt.Errorf("%s: invalid response: %v", filepath.Base(driver), err)
+	}
+	if resp.NotHandled {
+		return nil, fmt.Errorf("%s: request not handled", filepath.Base(driver))
+	}
+	return &resp, nil
+}
+
+// findPackageFunc is the type of loader.Config.FindPackage.
+type findPackageFunc func(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error)
+
+// driverFindPackage returns a findPackageFunc that finds the packages of
+// resp, the response of a GOPACKAGESDRIVER, and all other packages with
+// ctxt.Import.  The files of the returned packages are absolute as
+// generated files need not be in the package directory.
+func driverFindPackage(resp *driverResponse) findPackageFunc {
+	pkgs := make(map[string]*build.Package) // import path => package
+	byID := make(map[string]*build.Package)
+	for _, p := range resp.Packages {
+		if p.isTestVariant() || len(p.files()) == 0 {
+			continue
+		}
+		files := p.files()
+		bp := &build.Package{
+			Dir:        filepath.Dir(files[0]),
+			Name:       p.Name,
+			ImportPath: p.PkgPath,
+			GoFiles:    files,
+		}
+		pkgs[p.PkgPath] = bp
+		byID[p.ID] = bp
+	}
+	for _, p := range resp.Packages {
+		if !p.isTestVariant() {
+			// Imports may use paths other than the PkgPath of the package.
+			for path, id := range p.Imports {
+				if pkgs[path] == nil && byID[id] != nil {
+					pkgs[path] = byID[id]
+				}
+			}
+			continue
+		}
+		// Add the test files of test variants to their package.
+		path := strings.TrimSuffix(p.PkgPath, "_test")
+		bp := pkgs[path]
+		if bp == nil {
+			continue
+		}
+		if path != p.PkgPath {
+			bp.XTestGoFiles = p.files()
+			continue
+		}
+		seen := make(map[string]bool, len(bp.GoFiles))
+		for _, name := range bp.GoFiles {
+			seen[name] = true
+		}
+		for _, name := range p.files() {
+			if !seen[name] {
+				bp.TestGoFiles = append(bp.TestGoFiles, name)
+			}
+		}
+	}
+	return func(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
+		if bp := pkgs[importPath]; bp != nil {
+			copy := *bp
+			return &copy, nil
+		}
+		return ctxt.Import(importPath, fromDir, mode)
+	}
+}
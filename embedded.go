@@ -0,0 +1,84 @@
+package godef
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// promotionPath returns the chain of embedded fields through which the
+// field or method selected by the identifier at qpos is promoted, e.g.
+// "S -> Base -> Meta.Foo" for s.Foo where S embeds Base, which embeds
+// Meta, which declares Foo.  It returns "" if it is not promoted.
+func promotionPath(qpos *queryPos) string {
+	sel := enclosingSelector(qpos.path)
+	if sel == nil || len(qpos.path) == 0 || qpos.path[0] != ast.Node(sel.Sel) {
+		return ""
+	}
+	s := qpos.info.Selections[sel]
+	if s == nil || len(s.Index()) < 2 {
+		return ""
+	}
+	T := deref(s.Recv())
+	names := []string{qpos.typeString(T)}
+	for _, index := range s.Index()[:len(s.Index())-1] {
+		st, ok := deref(T).Underlying().(*types.Struct)
+		if !ok {
+			return ""
+		}
+		field := st.Field(index)
+		names = append(names, field.Name())
+		T = field.Type()
+	}
+	return strings.Join(names, " -> ") + "." + s.Obj().Name()
+}
+
+// methodSelection describes how the method selected by the identifier
+// at qpos is referenced if it is not simply called: "method expression
+// of T" for T.M, where T is the receiver type of the expression, e.g.
+// *S for (*S).M, or "method value of T" for x.M where x is of type T.
+// It returns "" for calls and other selections.
+func methodSelection(qpos *queryPos) string {
+	sel := enclosingSelector(qpos.path)
+	if sel == nil || len(qpos.path) < 2 || qpos.path[0] != ast.Node(sel.Sel) {
+		return ""
+	}
+	s := qpos.info.Selections[sel]
+	if s == nil {
+		return ""
+	}
+	switch s.Kind() {
+	case types.MethodExpr:
+		return "method expression of " + qpos.typeString(s.Recv())
+	case types.MethodVal:
+		if !isCalled(qpos.path[2:], sel) {
+			return "method value of " + qpos.typeString(s.Recv())
+		}
+	}
+	return ""
+}
+
+// isCalled reports whether the expression x is the function of a call,
+// possibly parenthesized, e.g. x.f in (x.f)(), where path is the AST
+// path enclosing x, excluding x.
+func isCalled(path []ast.Node, x ast.Expr) bool {
+	for _, n := range path {
+		switch n := n.(type) {
+		case *ast.ParenExpr:
+			x = n
+		case *ast.CallExpr:
+			return n.Fun == x
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// deref returns the element type of T if it is a pointer, else T.
+func deref(T types.Type) types.Type {
+	if ptr, ok := T.Underlying().(*types.Pointer); ok {
+		return ptr.Elem()
+	}
+	return T
+}
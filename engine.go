@@ -0,0 +1,663 @@
+package godef
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/charlievieth/godef/internal/span"
+)
+
+// An Engine answers definition queries.  An Engine may be used for any
+// number of queries and by multiple goroutines simultaneously.
+type Engine struct {
+	conf Config
+
+	mu    sync.RWMutex
+	roots []engineRoot // innermost first
+
+	ctx context.Context // (optional) parent of the spans of queries, see Hooks.StartSpan
+}
+
+// An engineRoot is the Config of the queries of files beneath dir.
+type engineRoot struct {
+	dir  string
+	conf Config
+}
+
+// NewEngine returns an Engine that answers queries with a copy of conf.
+// Later changes to conf do not affect the Engine, but the maps it refers
+// to (e.g. Config.Overlay) are shared and must not be modified.
+func NewEngine(conf *Config) *Engine {
+	return &Engine{conf: *conf}
+}
+
+// Config returns a copy of the Config of e.
+func (e *Engine) Config() Config { return e.conf }
+
+// AddRoot configures the queries of files beneath the directory root,
+// e.g. a module or workspace root, with a copy of conf instead of the
+// Config of e.  Roots are isolated: a query only observes the overlays
+// and build settings (e.g. Context.BuildTags) of the innermost root
+// containing the queried file, never those of other roots.  Adding a
+// root again replaces its Config.  The Dir of the copy of conf defaults
+// to root.
+func (e *Engine) AddRoot(root string, conf *Config) error {
+	dir, err := absPath(e.conf.env(), root)
+	if err != nil {
+		return err
+	}
+	c := *conf // make a copy
+	if c.Dir == "" {
+		c.Dir = dir
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i := range e.roots {
+		if e.roots[i].dir == dir {
+			e.roots[i].conf = c
+			return nil
+		}
+	}
+	e.roots = append(e.roots, engineRoot{dir: dir, conf: c})
+	sort.SliceStable(e.roots, func(i, j int) bool {
+		return len(e.roots[i].dir) > len(e.roots[j].dir)
+	})
+	return nil
+}
+
+// AddIsolatedRoot is AddRoot with a copy of the Config of e whose
+// queries do not share programs and files with those of e: it has its
+// own ProgramCache and ASTCache, of the same sizes, if e has them.  If
+// gopath is not empty it is the GOPATH of the root, as if it were set
+// in Env (so that it is also that of go env and the go command), and
+// the workspaces of queried files are not inferred.  The PackageIndex
+// of e, which indexes packages by directory and build configuration,
+// is shared.
+func (e *Engine) AddIsolatedRoot(root, gopath string) error {
+	c := e.conf // make a copy
+	if c.ProgramCache != nil {
+		c.ProgramCache = NewProgramCache(c.ProgramCache.size)
+	}
+	if c.ASTCache != nil {
+		c.ASTCache = NewASTCache(c.ASTCache.size)
+	}
+	if gopath != "" {
+		c.Env = withEnv(c.Env, map[string]string{"GOPATH": gopath})
+		c.Context.GOPATH = gopath
+		c.Workspace.Disabled = true
+	}
+	return e.AddRoot(root, &c)
+}
+
+// checkRange returns an error if [start, end] is not a range of byte
+// offsets in src.
+func checkRange(src []byte, start, end int) error {
+	switch {
+	case start < 0:
+		return fmt.Errorf("offset %d is negative", start)
+	case end < start:
+		return fmt.Errorf("range #%d,#%d ends before it starts", start, end)
+	case end > len(src):
+		return fmt.Errorf("offset %d is beyond end of file (%d bytes)", end, len(src))
+	}
+	return nil
+}
+
+// withContext returns a copy of e whose queries are traced as children
+// of the span in ctx.
+func (e *Engine) withContext(ctx context.Context) *Engine {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return &Engine{
+		conf:  e.conf,
+		roots: append([]engineRoot(nil), e.roots...),
+		ctx:   ctx,
+	}
+}
+
+// configFor returns the Config of the queries of filename: that of the
+// innermost root containing it or, if there is none, the Config of e.
+func (e *Engine) configFor(filename string) *Config {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if len(e.roots) == 0 {
+		return &e.conf
+	}
+	abs, err := absPath(e.conf.env(), filename)
+	if err != nil {
+		return &e.conf
+	}
+	for i := range e.roots {
+		if _, ok := hasFilePathPrefix(abs, e.roots[i].dir); ok {
+			conf := e.roots[i].conf // copy, the root may be replaced
+			return &conf
+		}
+	}
+	return &e.conf
+}
+
+// Define returns the position of the definition of the identifier at
+// byte offset cursor in filename and the contents of the file that
+// contains it (see Result.ReadSource).  If src is non-nil it is used as
+// the source of filename.  If the file containing the definition cannot
+// be read, its position is returned with a *ReadError, callers that only
+// need the position can use it or call Lookup, which does not read the
+// file.
+func (e *Engine) Define(filename string, cursor int, src interface{}) (*Position, []byte, error) {
+	res, err := e.Lookup(filename, cursor, src)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !res.Found {
+		return nil, nil, &NotFoundError{Err: ErrNotFound, Reason: res.Reason}
+	}
+	if !res.Position.IsValid() {
+		return nil, nil, &NotFoundError{Err: ErrBuiltin, Reason: "no source for " + res.Description}
+	}
+	b, err := res.ReadSource()
+	if err != nil {
+		return &res.Position, nil, err
+	}
+	return &res.Position, b, nil
+}
+
+// Lookup is like Define, but returns a Result and does not read the
+// file containing the definition, use Result.ReadSource to read it.
+// A *PositionError is returned if cursor is not a byte offset in the
+// file, and by the other queries of e for offsets that are not.
+func (e *Engine) Lookup(filename string, cursor int, src interface{}) (*Result, error) {
+	return e.lookup("definition", definition, filename, cursor, cursor, src)
+}
+
+// ReceiverTypeAt returns the definition of the type of x for the
+// selector x.Sel at byte offset cursor in filename, rather than that of
+// Sel.  Pointers are followed, so the definition of T is returned for an
+// x of type *T.  If src is non-nil it is used as the source of filename.
+//
+// A *NotFoundError wrapping ErrNoSelector is returned if there is no
+// selector at cursor or x is a package.
+func (e *Engine) ReceiverTypeAt(filename string, cursor int, src interface{}) (*Result, error) {
+	return e.lookup("receivertype", receiverTypeDefinition, filename, cursor, cursor, src)
+}
+
+// References is like Lookup, but also returns the uses of the definition
+// in the package of filename in Result.References, sorted by file and
+// position, e.g. so that editors can list the local uses of the
+// definition at cursor.  Unlike Lookup it always uses the type checker.
+// Uses in other packages are not searched for, so the list is not
+// exhaustive for exported definitions.
+func (e *Engine) References(filename string, cursor int, src interface{}) (*Result, error) {
+	return e.lookup("references", references, filename, cursor, cursor, src)
+}
+
+// DescribeRange returns the type and value category of the expression
+// at the byte offsets [start, end] of filename, e.g. a call expression
+// or composite literal.  The range must select the expression exactly,
+// give or take surrounding white space, otherwise an
+// *AmbiguousSelectionError is returned.  If src is non-nil it is used as
+// the source of filename.
+func (e *Engine) DescribeRange(filename string, start, end int, src interface{}) (*Expression, error) {
+	var expr *exprResult
+	run := func(q *Query) (err error) {
+		expr, err = describeExpr(q)
+		return err
+	}
+	res, err := e.lookup("describe", run, filename, start, end, src)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Found {
+		return nil, &NotFoundError{Err: ErrNotFound, Reason: res.Reason}
+	}
+	x := &Expression{
+		Start:    res.Position,
+		End:      tokenPosition(expr.end),
+		Source:   res.Description,
+		Type:     expr.typ,
+		Category: expr.category,
+		Value:    expr.value,
+	}
+	x.End.Filename = x.Start.Filename
+	if enc := e.configFor(filename).ColumnEncoding; enc != ColumnByte {
+		if src, err := res.ReadSource(); err == nil {
+			x.End.Column = enc.column(src, x.End)
+		}
+	}
+	return x, nil
+}
+
+// EnclosingDecl returns the extent of the innermost declaration
+// enclosing the byte offset cursor in filename, e.g. to select it in an
+// editor or to extract the body of a definition returned by Lookup.
+// Functions, methods and declarations of types, variables and constants,
+// including those local to a function, are declarations; in a
+// parenthesized declaration the specification enclosing cursor is
+// selected.  Only filename is parsed.  If src is non-nil it is used as
+// the source of filename.
+//
+// A *NotFoundError wrapping ErrNoDecl is returned if cursor is not in a
+// declaration, e.g. in the package clause or a comment between
+// declarations.
+func (e *Engine) EnclosingDecl(filename string, cursor int, src interface{}) (*DeclRange, error) {
+	var decl *declResult
+	run := func(q *Query) (err error) {
+		decl, err = enclosingDecl(q)
+		return err
+	}
+	res, err := e.lookup("decl", run, filename, cursor, cursor, src)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Found {
+		return nil, &NotFoundError{Err: ErrNotFound, Reason: res.Reason}
+	}
+	r := &DeclRange{
+		Start: res.Position,
+		End:   tokenPosition(decl.end),
+		Kind:  decl.kind,
+		Name:  decl.name,
+	}
+	r.End.Filename = r.Start.Filename
+	if enc := e.configFor(filename).ColumnEncoding; enc != ColumnByte {
+		if src, err := res.ReadSource(); err == nil {
+			r.End.Column = enc.column(src, r.End)
+		}
+	}
+	return r, nil
+}
+
+// Complete returns the members that may follow the selector x. at byte
+// offset cursor in filename, that is, just after its period or in the
+// selected name, whose names start with the part of the name before
+// cursor.  They are the exported members of an imported package, or the
+// accessible fields and methods of the type of the expression x, sorted
+// by name.  If src is non-nil it is used as the source of filename,
+// which may have syntax errors, as it does while typing.
+//
+// A *NotFoundError wrapping ErrNoSelector is returned if there is no
+// selector at cursor.
+func (e *Engine) Complete(filename string, cursor int, src interface{}) ([]Completion, error) {
+	var list []Completion
+	run := func(q *Query) (err error) {
+		list, err = complete(q)
+		return err
+	}
+	res, err := e.lookup("complete", run, filename, cursor, cursor, src)
+	if err != nil {
+		return nil, err
+	}
+	if !res.Found {
+		return nil, &NotFoundError{Err: ErrNotFound, Reason: res.Reason}
+	}
+	return list, nil
+}
+
+// lookup runs the query mode, implemented by run, on the byte offsets
+// [start, end] of filename.
+func (e *Engine) lookup(mode string, run func(*Query) error, filename string, start, end int, src interface{}) (_ *Result, err error) {
+	c := e.configFor(filename)
+	env := c.env()
+	began := env.Now()
+	defer c.MemoryBudget.enforceAsync(c)
+
+	info := QueryInfo{Mode: mode, Filename: filename, Start: start, End: end}
+	if c.Hooks.OnQueryStart != nil {
+		c.Hooks.OnQueryStart(info)
+	}
+	ctx := e.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if c.Hooks.StartSpan != nil {
+		var end func(error)
+		ctx, end = c.Hooks.StartSpan(ctx, SpanQuery, info)
+		defer func() { end(err) }()
+	}
+	var query *Query
+	if c.Hooks.OnQueryEnd != nil {
+		defer func() {
+			var stats Stats
+			if query != nil {
+				stats = query.stats
+			}
+			stats.Total = since(env, began)
+			c.Hooks.OnQueryEnd(info, stats, err)
+		}()
+	}
+	// Resolve relative names against Config.Dir, not the working
+	// directory of the process; filename is only absolute if isAbs.
+	isAbs := false
+	if abs, err := absPath(env, filename); err == nil {
+		filename, isAbs = abs, true
+	}
+	if src == nil && isAbs && c.Overlay[filename] != nil {
+		src = c.Overlay[filename]
+	}
+	body, err := readSource(&c.Context, filename, src)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRange(body, start, end); err != nil {
+		return nil, &PositionError{Pos: span.Span{
+			Filename: filename,
+			Start:    span.Point{Offset: start},
+			End:      span.Point{Offset: end},
+		}.String(), Err: err}
+	}
+
+	var warnings []error
+	if isAbs {
+		if w := checkGoVersion(&c.Context, filename); w != nil {
+			warnings = append(warnings, w)
+		}
+	}
+
+	if c.ExportData && !exportDataSupported {
+		warnings = append(warnings, fmt.Errorf("export data is not supported by the %s backend, the imports are parsed", programBackend))
+	}
+
+	base, err := c.buildContext()
+	if err != nil {
+		return nil, err
+	}
+	overlay := c.overlay(filename, body)
+	ctxt := useModifiedFile(useOverlay(base, overlay), filename, body)
+
+	if c.GOROOTDev && isAbs {
+		if root := goSourceTree(ctxt, filename); root != "" {
+			ctxt.GOROOT = root
+		}
+	}
+
+	ctxt, tags := c.BuildTagStrategy.inferBuildTags(ctxt, filename)
+	if len(tags) != 0 {
+		c.logf("inferred build tags: %q", tags)
+	}
+
+	// TODO: replace with buildutil.MatchContext()
+	ctxt = updateContextForFile(ctxt, env, &c.Workspace, filename, body)
+
+	if isAbs {
+		if dir := moduleRoot(ctxt, filepath.Dir(filename)); dir != "" {
+			ctxt.Dir = dir
+		}
+	}
+
+	if gopath, errs := checkGOPATH(base, env, ctxt.GOPATH); len(errs) != 0 {
+		if c.StrictGOPATH {
+			return nil, errs[0]
+		}
+		ctxt.GOPATH = gopath
+		warnings = append(warnings, errs...)
+	}
+
+	c.logContext(ctxt)
+	qctxt := newQueryContext(base, ctxt)
+
+	name, fake, replaceRoot := filename, "", false
+	if c.EnableFakeGoroot {
+		name, fake, replaceRoot = updateFilename(ctxt, filename)
+	}
+
+	sp := span.Span{
+		Filename: name,
+		Start:    span.Point{Offset: start},
+		End:      span.Point{Offset: end},
+	}
+	programCache := c.ProgramCache
+	if c.MemoryBudget.Exceeded() {
+		programCache = nil // don't grow the heap further
+	}
+	query = &Query{
+		Mode:     mode,
+		Pos:      sp.String(),
+		Build:    ctxt,
+		Env:      env,
+		Resolver: c.Resolver,
+		overlay:  overlay,
+		logger:   c.Logger,
+		cache:    programCache,
+		hooks:    &c.Hooks,
+		spanCtx:  ctx,
+		info:     info,
+
+		exportData:      c.ExportData,
+		parseWorkers:    c.ParseWorkers,
+		astCache:        c.ASTCache,
+		packageIndex:    c.PackageIndex,
+		physical:        c.IgnoreLineDirectives,
+		identEnd:        c.AcceptIdentifierEnd,
+		resolveAliases:  c.ResolveAliases,
+		implementations: c.Implementations,
+		docLinks:        c.ResolveDocLinks,
+		bestEffort:      c.BestEffort,
+
+		describe:   c.Describe,
+		allMembers: c.UnexportedMembers,
+	}
+	c.logf("%s query: %s", mode, query.Pos)
+	if err := run(query); err != nil {
+		c.logf("%s query failed after %v: %v", mode, since(env, began), err)
+		var nf *NotFoundError
+		if errors.As(err, &nf) {
+			nf.Errors = query.typeCheckErrors()
+			if c.Probe {
+				query.stats.Total = since(env, began)
+				return &Result{Reason: nf.Error(), Errors: nf.Errors, Context: qctxt, Stats: query.stats, Warnings: warnings}, nil
+			}
+		}
+		for _, w := range warnings {
+			err = fmt.Errorf("%w (warning: %v)", err, w)
+		}
+		return nil, err
+	}
+	pos := query.position(query.Fset, query.result.pos)
+	c.logf("%s query: found %s at %s in %v", mode, query.result.descr, pos, since(env, began))
+
+	var candidates []Candidate
+	if c.ResolveWrappers {
+		switch query.result.kind {
+		case KindFunction, KindMethod:
+			candidates = followWrappers(query, pos)
+		}
+	}
+	if c.ResolveAssembly && query.result.kind == KindFunction && pos.IsValid() {
+		candidates = append(candidates, bodylessCandidates(query, pos)...)
+	}
+	if c.ResolveVariants && pos.IsValid() {
+		candidates = append(candidates, variantCandidates(query, pos, query.result.id)...)
+	}
+
+	identEnd, declStart, declEnd := targetExtent(query, query.result.pos)
+	var members []Candidate
+	for _, m := range query.result.members {
+		members = append(members, Candidate{
+			Position:    tokenPosition(query.position(query.Fset, m.pos)),
+			Description: m.descr,
+			Kind:        m.kind,
+		})
+	}
+	var aliases []Candidate
+	for _, a := range query.result.aliases {
+		aliases = append(aliases, Candidate{
+			Position:    tokenPosition(query.position(query.Fset, a.pos)),
+			Description: a.descr,
+			Kind:        a.kind,
+		})
+	}
+	var references []Candidate
+	for _, r := range query.result.references {
+		references = append(references, Candidate{
+			Position:    tokenPosition(query.position(query.Fset, r.pos)),
+			Description: r.descr,
+			Kind:        r.kind,
+		})
+	}
+	for _, m := range query.result.candidates {
+		candidates = append(candidates, Candidate{
+			Position:    tokenPosition(query.position(query.Fset, m.pos)),
+			Description: m.descr,
+			Kind:        m.kind,
+			Reason:      m.reason,
+		})
+	}
+
+	res := &Result{
+		Found:       true,
+		Position:    tokenPosition(pos),
+		Description: query.result.descr,
+		Kind:        query.result.kind,
+		Approximate: query.stats.Strategy == StrategyApproximate || query.stats.Strategy == StrategyRecovered,
+		TypeError:   query.result.typeErr,
+		Errors:      query.typeCheckErrors(),
+		End:         tokenPosition(identEnd),
+		DeclStart:   tokenPosition(declStart),
+		DeclEnd:     tokenPosition(declEnd),
+		Object:      query.result.id,
+		Candidates:  candidates,
+		Aliases:     aliases,
+		References:  references,
+		Type:        query.result.typ,
+		Members:     members,
+		Context:     qctxt,
+		Stats:       query.stats,
+		Warnings:    warnings,
+		overlay:     overlay,
+		ctxt:        base,
+	}
+	// Analyzers read the files of the results, which the post-processed
+	// paths may not name, e.g. GOROOTSource.
+	runAnalyzers(c.Analyzers, res)
+
+	// Post-process the paths of the results
+	var workspaces []string
+	if c.SymlinkPolicy == SymlinkPreferWorkspace {
+		workspaces = symlinkWorkspaces(env, filename, ctxt.GOPATH)
+	}
+	modcache := modCacheDir(ctxt, env)
+	fixPath := func(name string) (string, bool) {
+		name = c.SymlinkPolicy.apply(name, workspaces)
+		name, readOnly := remapModCacheFile(modcache, name, c.ModuleCheckouts)
+		if c.GOROOTSource != "" {
+			name = remapGOROOTFile(ctxt, name, c.GOROOTSource)
+		}
+		// Replace real GOROOT with fake GOROOT
+		if replaceRoot && fake != "" {
+			old := ctxt.GOROOT + string(filepath.Separator) + "src"
+			name = strings.Replace(name, old, fake, 1)
+		}
+		return name, readOnly
+	}
+	res.Position.Filename, res.ReadOnly = fixPath(res.Position.Filename)
+	for _, p := range []*Position{&res.End, &res.DeclStart, &res.DeclEnd} {
+		if p.IsValid() {
+			p.Filename, _ = fixPath(p.Filename)
+		}
+	}
+	for i := range res.Errors {
+		if p := &res.Errors[i].Position; p.IsValid() {
+			p.Filename, _ = fixPath(p.Filename)
+		}
+	}
+	for _, list := range [][]Candidate{res.Candidates, res.Members, res.Aliases, res.References} {
+		for i := range list {
+			c := &list[i]
+			if c.Position.IsValid() {
+				c.Position.Filename, c.ReadOnly = fixPath(c.Position.Filename)
+			}
+		}
+	}
+	if c.ColumnEncoding != ColumnByte {
+		c.encodeColumns(res)
+	}
+	res.Stats.Total = since(env, began)
+	return res, nil
+}
+
+// encodeColumns converts the byte columns of the positions of res to
+// Config.ColumnEncoding.  Columns in files that cannot be read are left
+// unchanged.
+func (c *Config) encodeColumns(res *Result) {
+	sources := make(map[string][]byte)
+	encode := func(pos *Position) {
+		if !pos.IsValid() {
+			return
+		}
+		src, ok := sources[pos.Filename]
+		if !ok {
+			var err error
+			if src, err = readOverlayFile(res.ctxt, res.overlay, pos.Filename); err != nil {
+				c.logf("column encoding: %v", err)
+			}
+			sources[pos.Filename] = src
+		}
+		if src != nil {
+			pos.Column = c.ColumnEncoding.column(src, *pos)
+		}
+	}
+	for _, pos := range []*Position{&res.Position, &res.End, &res.DeclStart, &res.DeclEnd} {
+		encode(pos)
+	}
+	for _, list := range [][]Candidate{res.Candidates, res.Members, res.Aliases, res.References} {
+		for i := range list {
+			encode(&list[i].Position)
+		}
+	}
+	for i := range res.Errors {
+		encode(&res.Errors[i].Position)
+	}
+}
+
+// typeCheckErrors returns the type errors of the queried package of q
+// (see Result.Errors).
+func (q *Query) typeCheckErrors() []TypeCheckError {
+	var list []TypeCheckError
+	for _, err := range q.typeErrors {
+		list = append(list, TypeCheckError{
+			Position: tokenPosition(q.position(err.Fset, err.Pos)),
+			Message:  err.Msg,
+			Soft:     err.Soft,
+		})
+	}
+	return list
+}
+
+// ParseFile parses filename as the queries of e do: its contents are
+// read from the Config.Overlay of the root containing it (see AddRoot),
+// or the file system.  The mode controls the parser, e.g. comments are
+// only retained with parser.ParseComments.  As with parser.ParseFile, a
+// partial AST may be returned along with a syntax error.  With a
+// Config.ASTCache the AST and file set are those of the cache, shared
+// with the queries, and must not be modified.
+func (e *Engine) ParseFile(filename string, mode parser.Mode) (*ast.File, *token.FileSet, error) {
+	c := e.configFor(filename)
+	base, err := c.buildContext()
+	if err != nil {
+		return nil, nil, err
+	}
+	ctxt := useOverlay(base, c.Overlay)
+	cwd, err := c.env().Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	fset := token.NewFileSet()
+	if c.ASTCache != nil {
+		fset = c.ASTCache.fileSet()
+	}
+	f, err := parseFile(fset, ctxt, c.ASTCache, cwd, filename, mode)
+	return f, fset, err
+}
+
+// BuildInfoFor returns the evaluation of the build constraints of
+// filename (see Config.BuildInfoFor).
+func (e *Engine) BuildInfoFor(filename string, src interface{}) (*BuildInfo, error) {
+	return e.configFor(filename).BuildInfoFor(filename, src)
+}
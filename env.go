@@ -0,0 +1,82 @@
+package godef
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// An Environment provides access to the process environment.  Replacing
+// it makes queries independent of the process environment, working
+// directory and clock, which is useful for tests and hermetic builds.
+type Environment interface {
+	Getenv(key string) string
+	Getwd() (string, error)
+	Now() time.Time
+}
+
+// OSEnvironment is the Environment of the current process.
+var OSEnvironment Environment = osEnvironment{}
+
+type osEnvironment struct{}
+
+func (osEnvironment) Getenv(key string) string { return os.Getenv(key) }
+func (osEnvironment) Getwd() (string, error)   { return os.Getwd() }
+func (osEnvironment) Now() time.Time           { return time.Now() }
+
+// since returns the time elapsed since t by the clock of env.
+func since(env Environment, t time.Time) time.Duration {
+	return env.Now().Sub(t)
+}
+
+// withDir returns env with the working directory dir, relative to the
+// working directory of env, or env if dir is empty.
+func withDir(env Environment, dir string) Environment {
+	if dir == "" {
+		return env
+	}
+	return dirEnvironment{env, dir}
+}
+
+// withEnv returns env, or OSEnvironment if env is nil, with the
+// variables in vars replaced.
+func withEnv(env Environment, vars map[string]string) Environment {
+	if env == nil {
+		env = OSEnvironment
+	}
+	return varEnvironment{env, vars}
+}
+
+// varEnvironment is an Environment with some variables replaced.
+type varEnvironment struct {
+	Environment
+	vars map[string]string
+}
+
+func (e varEnvironment) Getenv(key string) string {
+	if v, ok := e.vars[key]; ok {
+		return v
+	}
+	return e.Environment.Getenv(key)
+}
+
+// dirEnvironment is an Environment with another working directory.
+type dirEnvironment struct {
+	Environment
+	dir string
+}
+
+func (e dirEnvironment) Getwd() (string, error) { return absPath(e.Environment, e.dir) }
+
+// absPath returns an absolute representation of path, relative paths
+// are resolved against the working directory of env.
+func absPath(env Environment, path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+	wd, err := env.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(wd, path), nil
+}
@@ -0,0 +1,112 @@
+package godef
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors wrapped by a *NotFoundError, they describe why there is no
+// definition for the identifier at the queried position.
+var (
+	ErrNotFound     = errors.New("definition not found")
+	ErrNoIdentifier = errors.New("no identifier here")
+	ErrNoObject     = errors.New("no object for identifier")
+	ErrBuiltin      = errors.New("identifier is built in")
+	ErrNoSyntax     = errors.New("no syntax here")
+	ErrNoSelector   = errors.New("no selector here")
+	ErrStructTag    = errors.New("struct tags have no definition")
+	ErrNoDecl       = errors.New("no declaration here")
+)
+
+// ErrNotGoFile is returned when the queried file is not a Go source file.
+var ErrNotGoFile = errors.New("not a Go source file")
+
+// A NotFoundError is returned when there is no definition for the
+// identifier at a position, e.g. because there is no identifier there
+// or it denotes a built-in object.  All NotFoundErrors match ErrNotFound
+// with errors.Is.
+type NotFoundError struct {
+	Err    error  // ErrNoIdentifier, ErrNoObject, ErrBuiltin, ErrNoSyntax, ErrNoSelector, ErrStructTag, ErrNoDecl or ErrNotFound
+	Reason string // (optional) detailed description, defaults to Err.Error()
+
+	// Errors are the type errors of the package of the query, if any,
+	// which may be why there is no definition (see Result.Errors).
+	Errors []TypeCheckError
+}
+
+func (e *NotFoundError) Error() string {
+	if e.Reason != "" || e.Err == nil {
+		return e.Reason
+	}
+	return e.Err.Error()
+}
+
+func (e *NotFoundError) Unwrap() error { return e.Err }
+
+func (e *NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// An AmbiguousSelectionError is returned when the queried position does
+// not exactly select an identifier.
+type AmbiguousSelectionError struct {
+	Node string // description of the innermost node enclosing the position
+}
+
+func (e *AmbiguousSelectionError) Error() string {
+	return "ambiguous selection within " + e.Node
+}
+
+// A TypeCheckError is an error reported by the type checker for the
+// package of a query (see Result.Errors).
+type TypeCheckError struct {
+	Position Position // position of the error
+	Message  string   // description of the error, without its position
+	Soft     bool     // the error does not affect type information, e.g. an unused variable
+}
+
+func (e TypeCheckError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
+// A PositionError is returned when a query position is malformed, e.g.
+// "file.go:123" without the '#', or is not in the queried file, e.g. a
+// negative offset, an offset beyond the end of the file or a range that
+// ends before it starts.
+type PositionError struct {
+	Pos string // the query position, e.g. "file.go:#123"
+	Err error  // what is wrong with it
+}
+
+func (e *PositionError) Error() string {
+	return fmt.Sprintf("invalid query position %s: %v", e.Pos, e.Err)
+}
+
+func (e *PositionError) Unwrap() error { return e.Err }
+
+// A FileNotInPackageError is returned when the queried file is not part
+// of the package that was loaded for it, e.g. because the file is
+// excluded by build constraints or declares a different package.
+type FileNotInPackageError struct {
+	Filename   string
+	ImportPath string // import path of the package, if known
+}
+
+func (e *FileNotInPackageError) Error() string {
+	if e.ImportPath == "" {
+		return fmt.Sprintf("file %s not found in loaded program", e.Filename)
+	}
+	return fmt.Sprintf("package %q doesn't contain file %s", e.ImportPath, e.Filename)
+}
+
+// A ReadError is returned by Define when the definition was found but
+// the file containing it could not be read.  The position of the
+// definition is returned along with it.
+type ReadError struct {
+	Filename string
+	Err      error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("reading %s: %v", e.Filename, e.Err)
+}
+
+func (e *ReadError) Unwrap() error { return e.Err }
@@ -0,0 +1,50 @@
+package godef
+
+import (
+	"context"
+	"errors"
+	"go/scanner"
+)
+
+// Exit codes of cmd/godef, see ExitCode.  Editor plugins can branch on
+// them instead of matching error messages.
+const (
+	ExitOK           = 0 // the query succeeded
+	ExitError        = 1 // any other error, e.g. a package that cannot be loaded
+	ExitUsage        = 2 // invalid command line
+	ExitNoIdentifier = 3 // nothing to query at the position
+	ExitNotFound     = 4 // no definition for the identifier, e.g. a built-in
+	ExitTimeout      = 5 // the query did not finish in time
+	ExitParseError   = 6 // the queried file is not Go source
+)
+
+// ExitCode returns the exit code of cmd/godef for err: ExitOK if err is
+// nil, ExitNoIdentifier for a *NotFoundError wrapping ErrNoIdentifier,
+// ErrNoSyntax, ErrNoSelector or ErrNoDecl, or an
+// *AmbiguousSelectionError, ExitNotFound for any other *NotFoundError,
+// ExitTimeout for context.DeadlineExceeded, ExitParseError for
+// ErrNotGoFile and syntax errors, ExitUsage for a *PositionError, and
+// ExitError otherwise.
+func ExitCode(err error) int {
+	var amb *AmbiguousSelectionError
+	var perr *PositionError
+	var list scanner.ErrorList
+	var serr scanner.Error
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrNoIdentifier), errors.Is(err, ErrNoSyntax),
+		errors.Is(err, ErrNoSelector), errors.Is(err, ErrNoDecl),
+		errors.As(err, &amb):
+		return ExitNoIdentifier
+	case errors.Is(err, ErrNotFound):
+		return ExitNotFound
+	case errors.Is(err, context.DeadlineExceeded):
+		return ExitTimeout
+	case errors.Is(err, ErrNotGoFile), errors.As(err, &list), errors.As(err, &serr):
+		return ExitParseError
+	case errors.As(err, &perr):
+		return ExitUsage
+	}
+	return ExitError
+}
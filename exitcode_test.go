@@ -0,0 +1,35 @@
+package godef
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	syntax := scanner.ErrorList{{Pos: token.Position{Filename: "p.go", Line: 1}, Msg: "expected 'package'"}}
+	tests := []struct {
+		err  error
+		code int
+	}{
+		{nil, ExitOK},
+		{errors.New("cannot load package"), ExitError},
+		{&NotFoundError{Err: ErrNoIdentifier}, ExitNoIdentifier},
+		{&NotFoundError{Err: ErrNoSelector}, ExitNoIdentifier},
+		{&AmbiguousSelectionError{Node: "call"}, ExitNoIdentifier},
+		{&NotFoundError{Err: ErrBuiltin}, ExitNotFound},
+		{&NotFoundError{Err: ErrNotFound, Reason: "no definition"}, ExitNotFound},
+		{context.DeadlineExceeded, ExitTimeout},
+		{fmt.Errorf("p.go: %w", ErrNotGoFile), ExitParseError},
+		{fmt.Errorf("parsing: %w", syntax), ExitParseError},
+		{&PositionError{Pos: "p.go:#-1", Err: errors.New("negative offset")}, ExitUsage},
+	}
+	for _, test := range tests {
+		if code := ExitCode(test.err); code != test.code {
+			t.Errorf("ExitCode(%v) = %d; want: %d", test.err, code, test.code)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package godef
+
+import (
+	"go/build"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// objectPos returns the position of obj in lprog.  The positions of
+// objects imported from export data (see Config.ExportData) only record
+// the line of the declaration, for them the source file is added to the
+// file set of lprog and the position of the name of obj on that line is
+// returned.
+func (q *Query) objectPos(lprog program, obj types.Object) token.Pos {
+	pos := obj.Pos()
+	if !q.exportData || !pos.IsValid() {
+		return pos
+	}
+	if info, _, _ := lprog.PathEnclosingInterval(pos, pos); info != nil {
+		return pos // parsed from source
+	}
+	fset := lprog.Fset()
+	posn := fset.Position(pos)
+	filename := expandGOROOT(q.Build, posn.Filename)
+	rc, err := buildutil.OpenFile(q.Build, filename)
+	if err != nil {
+		return pos
+	}
+	src, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return pos
+	}
+	start, err := span.OffsetOf(src, posn.Line, 1)
+	if err != nil {
+		return pos
+	}
+	line := src[start:]
+	if i := strings.IndexByte(string(line), '\n'); i >= 0 {
+		line = line[:i]
+	}
+	col := indexIdent(string(line), obj.Name())
+	if col < 0 {
+		col = 0 // e.g. an embedded field of a qualified type
+	}
+	tf := fset.AddFile(filename, -1, len(src))
+	tf.SetLinesForContent(src)
+	return tf.Pos(start + col)
+}
+
+// indexIdent returns the index of the first occurrence of the identifier
+// name in s, or -1.
+func indexIdent(s, name string) int {
+	for i := 0; ; {
+		j := strings.Index(s[i:], name)
+		if j < 0 {
+			return -1
+		}
+		j += i
+		end := j + len(name)
+		if (j == 0 || !isIdentByte(s[j-1])) && (end == len(s) || !isIdentByte(s[end])) {
+			return j
+		}
+		i = end
+	}
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' || c >= 0x80
+}
+
+// expandGOROOT replaces the "$GOROOT" prefix that the go command records
+// in the export data of standard library packages with the GOROOT of
+// ctxt.
+func expandGOROOT(ctxt *build.Context, name string) string {
+	const prefix = "$GOROOT"
+	if !strings.HasPrefix(name, prefix+"/") {
+		return name
+	}
+	return filepath.Join(ctxt.GOROOT, filepath.FromSlash(name[len(prefix):]))
+}
@@ -0,0 +1,122 @@
+package godef
+
+import (
+	"bytes"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// A ValueCategory classifies the value of an expression.
+type ValueCategory string
+
+const (
+	CategoryNoValue  ValueCategory = "no value" // e.g. a call of a function without results
+	CategoryBuiltin  ValueCategory = "built-in" // a built-in function
+	CategoryType     ValueCategory = "type"
+	CategoryConstant ValueCategory = "constant"
+	CategoryNil      ValueCategory = "nil"
+	CategoryVariable ValueCategory = "variable"  // an addressable value
+	CategoryMapIndex ValueCategory = "map index" // an assignable, but not addressable, value
+	CategoryCommaOK  ValueCategory = "comma, ok" // e.g. a type assertion or channel receive
+	CategoryValue    ValueCategory = "value"
+)
+
+// valueCategory returns the ValueCategory of an expression.
+func valueCategory(tv types.TypeAndValue) ValueCategory {
+	switch {
+	case tv.IsVoid():
+		return CategoryNoValue
+	case tv.IsBuiltin():
+		return CategoryBuiltin
+	case tv.IsType():
+		return CategoryType
+	case tv.Value != nil:
+		return CategoryConstant
+	case tv.IsNil():
+		return CategoryNil
+	case tv.Addressable():
+		return CategoryVariable
+	case tv.Assignable():
+		return CategoryMapIndex
+	case tv.HasOk():
+		return CategoryCommaOK
+	}
+	return CategoryValue
+}
+
+// An Expression describes the expression selected by a range query (see
+// Engine.DescribeRange).
+type Expression struct {
+	Start, End Position      // extent of the expression
+	Source     string        // the expression, e.g. "f(x)", as formatted by go/printer
+	Type       string        // type of the expression, empty if it has no value
+	Category   ValueCategory // category of the value of the expression
+	Value      string        // value of a constant expression
+}
+
+// exprResult is the result of describeExpr.
+type exprResult struct {
+	end      token.Position
+	typ      string
+	category ValueCategory
+	value    string
+}
+
+// describeExpr returns the type and value category of the expression
+// exactly selected by the query range.  It also outputs the position
+// and source of the expression as the definition of q.  The type of a
+// comma, ok expression is that of the pair, e.g. "(int, bool)".
+func describeExpr(q *Query) (*exprResult, error) {
+	lprog, err := q.loadProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	qpos, err := parseQueryPos(lprog, q.Pos, true, q.identEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	var expr ast.Expr
+	switch n := qpos.path[0].(type) {
+	case ast.Expr:
+		expr = n
+	case *ast.ExprStmt:
+		expr = n.X
+	default:
+		return nil, &NotFoundError{
+			Err:    ErrNoSyntax,
+			Reason: "no expression in " + astutil.NodeDescription(n),
+		}
+	}
+	tv, ok := qpos.info.Types[expr]
+	if !ok {
+		// e.g. the name of a declaration or an import
+		return nil, &NotFoundError{Err: ErrNoObject}
+	}
+
+	res := &exprResult{
+		end:      q.position(lprog.Fset(), expr.End()),
+		category: valueCategory(tv),
+	}
+	if !tv.IsVoid() && tv.Type != nil {
+		res.typ = qpos.typeString(tv.Type)
+	}
+	if tv.Value != nil {
+		res.value = tv.Value.String()
+	}
+	var src bytes.Buffer
+	if err := printer.Fprint(&src, lprog.Fset(), expr); err != nil {
+		src.Reset()
+		src.WriteString(types.ExprString(expr))
+	}
+	q.Output(lprog.Fset(), &definitionResult{
+		pos:   expr.Pos(),
+		descr: src.String(),
+	})
+	return res, nil
+}
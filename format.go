@@ -0,0 +1,280 @@
+package godef
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Formatter writes the result of a query in an editor specific format.
+type Formatter interface {
+	Format(w io.Writer, res *Result) error
+}
+
+// The FormatterFunc type is an adapter to allow the use of ordinary
+// functions as Formatters.
+type FormatterFunc func(w io.Writer, res *Result) error
+
+func (f FormatterFunc) Format(w io.Writer, res *Result) error { return f(w, res) }
+
+var formatters = struct {
+	sync.RWMutex
+	m map[string]Formatter
+}{
+	m: map[string]Formatter{
+		"plain": FormatterFunc(formatPlain),
+		"acme":  FormatterFunc(formatAcme),
+		"vim":   FormatterFunc(formatVim),
+		"json":  FormatterFunc(formatJSON),
+
+		"guru":      FormatterFunc(formatGuru),
+		"guru-json": FormatterFunc(formatGuruJSON),
+	},
+}
+
+// RegisterFormatter registers the Formatter f as name, replacing any
+// Formatter previously registered as name.
+func RegisterFormatter(name string, f Formatter) {
+	formatters.Lock()
+	formatters.m[name] = f
+	formatters.Unlock()
+}
+
+// LookupFormatter returns the Formatter registered as name.  The
+// built-in formatters are:
+//
+//	plain  file:line:column
+//	acme   file:#offset (an acme address)
+//	vim    file:line:column: description (a Vim quickfix entry)
+//	json   a JSON object, see FormatJSON
+//	guru       file:line:column: defined here as description
+//	guru-json  {"objpos": "file:line:column", "desc": "description"}
+//
+// The guru formatters write the output of the plain and -json
+// definition queries of guru, so that tools written for guru can use
+// godef.  The other formatters write the position of the result followed
+// by those of its candidates, one per line.  The plain formatter also writes the
+// type and members of described results (see Config.Describe) after
+// the position of the result.
+func LookupFormatter(name string) (Formatter, bool) {
+	formatters.RLock()
+	f, ok := formatters.m[name]
+	formatters.RUnlock()
+	return f, ok
+}
+
+// FormatterNames returns the sorted names of the registered Formatters.
+func FormatterNames() []string {
+	formatters.RLock()
+	names := make([]string, 0, len(formatters.m))
+	for name := range formatters.m {
+		names = append(names, name)
+	}
+	formatters.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// formatPositions calls fn with the position and description of res and
+// each of its candidates.  It returns a *NotFoundError if res was not
+// found.
+func formatPositions(res *Result, fn func(pos Position, descr string) error) error {
+	if !res.Found {
+		return &NotFoundError{Err: ErrNotFound, Reason: res.Reason}
+	}
+	if err := fn(res.Position, res.Description); err != nil {
+		return err
+	}
+	for _, c := range res.Candidates {
+		descr := c.Description
+		if c.Reason != "" {
+			descr += " (" + c.Reason + ")"
+		}
+		if err := fn(c.Position, descr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// formatPlain writes the positions of res and, if present, its type and
+// members in the format of the -t and -a flags of the original godef.
+func formatPlain(w io.Writer, res *Result) error {
+	var buf bytes.Buffer
+	first := true
+	err := formatPositions(res, func(pos Position, _ string) error {
+		fmt.Fprintln(&buf, pos)
+		if !first {
+			return nil
+		}
+		first = false
+		if res.Type != "" {
+			fmt.Fprintln(&buf, res.Type)
+		}
+		for _, m := range res.Members {
+			fmt.Fprintf(&buf, "\t%s\n\t\t%s\n", m.Description, m.Position)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func formatAcme(w io.Writer, res *Result) error {
+	return formatPositions(res, func(pos Position, _ string) error {
+		_, err := fmt.Fprintf(w, "%s:#%d\n", pos.Filename, pos.Offset)
+		return err
+	})
+}
+
+func formatVim(w io.Writer, res *Result) error {
+	return formatPositions(res, func(pos Position, descr string) error {
+		_, err := fmt.Fprintf(w, "%s:%d:%d: %s\n", pos.Filename, pos.Line, pos.Column, descr)
+		return err
+	})
+}
+
+// guruDefinition is the output of the "guru-json" Formatter, the
+// serial.Definition of guru.
+type guruDefinition struct {
+	ObjPos string `json:"objpos,omitempty"` // location of the definition
+	Desc   string `json:"desc"`             // description of the denoted object
+}
+
+// guruResult returns the guru definition of res.  As with guru, built-in
+// objects, which have no position, are an error.
+func guruResult(res *Result) (*guruDefinition, error) {
+	if !res.Found {
+		return nil, &NotFoundError{Err: ErrNotFound, Reason: res.Reason}
+	}
+	if !res.Position.IsValid() {
+		return nil, &NotFoundError{Err: ErrBuiltin, Reason: res.Description + " is built in"}
+	}
+	return &guruDefinition{ObjPos: res.Position.String(), Desc: res.Description}, nil
+}
+
+func formatGuru(w io.Writer, res *Result) error {
+	def, err := guruResult(res)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s: defined here as %s\n", def.ObjPos, def.Desc)
+	return err
+}
+
+func formatGuruJSON(w io.Writer, res *Result) error {
+	def, err := guruResult(res)
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(def, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// JSONResult is the output of the "json" Formatter.  Paths use forward
+// slashes so that the output is the same on all platforms.
+type JSONResult struct {
+	Found       bool                 `json:"found"`
+	Reason      string               `json:"reason,omitempty"`
+	Filename    string               `json:"filename,omitempty"`
+	Line        int                  `json:"line,omitempty"`
+	Column      int                  `json:"column,omitempty"`
+	Offset      int                  `json:"offset,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Kind        Kind                 `json:"kind,omitempty"`
+	ReadOnly    bool                 `json:"readonly,omitempty"`
+	Approximate bool                 `json:"approximate,omitempty"`
+	TypeError   string               `json:"type_error,omitempty"`
+	Errors      []JSONTypeCheckError `json:"errors,omitempty"`
+	Object      string               `json:"object,omitempty"`
+	Type        string               `json:"type,omitempty"`
+	Stats       *JSONStats           `json:"stats,omitempty"`
+	Warnings    []string             `json:"warnings,omitempty"`
+}
+
+// JSONTypeCheckError is a TypeCheckError of a JSONResult.
+type JSONTypeCheckError struct {
+	Filename string `json:"filename"`
+	Line     int    `json:"line"`
+	Column   int    `json:"column"`
+	Message  string `json:"message"`
+	Soft     bool   `json:"soft,omitempty"`
+}
+
+// JSONStats are the Stats of a JSONResult, durations are in milliseconds.
+type JSONStats struct {
+	Strategy    Strategy `json:"strategy,omitempty"`
+	ParseTime   float64  `json:"parse_ms"`
+	LoadTime    float64  `json:"load_ms"`
+	Total       float64  `json:"total_ms"`
+	FilesParsed int      `json:"files_parsed"`
+	CacheHits   int      `json:"cache_hits,omitempty"`
+	Rechecks    int      `json:"rechecks,omitempty"`
+}
+
+// FormatJSON returns the JSONResult of res.  Unlike the other formats,
+// results that were not found and warnings are included in the output.
+func FormatJSON(res *Result) *JSONResult {
+	out := &JSONResult{
+		Found:       res.Found,
+		Reason:      res.Reason,
+		Description: res.Description,
+		Kind:        res.Kind,
+		ReadOnly:    res.ReadOnly,
+		Approximate: res.Approximate,
+		Type:        res.Type,
+	}
+	if res.TypeError != nil {
+		out.TypeError = res.TypeError.Error()
+	}
+	for _, e := range res.Errors {
+		out.Errors = append(out.Errors, JSONTypeCheckError{
+			Filename: filepath.ToSlash(e.Position.Filename),
+			Line:     e.Position.Line,
+			Column:   e.Position.Column,
+			Message:  e.Message,
+			Soft:     e.Soft,
+		})
+	}
+	if !res.Object.IsZero() {
+		out.Object = res.Object.String()
+	}
+	if res.Found {
+		out.Filename = filepath.ToSlash(res.Position.Filename)
+		out.Line = res.Position.Line
+		out.Column = res.Position.Column
+		out.Offset = res.Position.Offset
+	}
+	if res.Stats != (Stats{}) {
+		ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+		out.Stats = &JSONStats{
+			Strategy:    res.Stats.Strategy,
+			ParseTime:   ms(res.Stats.ParseTime),
+			LoadTime:    ms(res.Stats.LoadTime),
+			Total:       ms(res.Stats.Total),
+			FilesParsed: res.Stats.FilesParsed,
+			CacheHits:   res.Stats.CacheHits,
+			Rechecks:    res.Stats.Rechecks,
+		}
+	}
+	for _, w := range res.Warnings {
+		out.Warnings = append(out.Warnings, w.Error())
+	}
+	return out
+}
+
+func formatJSON(w io.Writer, res *Result) error {
+	return json.NewEncoder(w).Encode(FormatJSON(res))
+}
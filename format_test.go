@@ -0,0 +1,167 @@
+package godef
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFormatters(t *testing.T) {
+	res := &Result{
+		Found:       true,
+		Position:    Position{Filename: "/src/p/p.go", Line: 3, Column: 6, Offset: 21},
+		Description: "func F()",
+		Kind:        KindFunction,
+		Candidates: []Candidate{{
+			Position:    Position{Filename: "/src/p/q.go", Line: 7, Column: 2, Offset: 40},
+			Description: "func g()",
+			Reason:      "called by F",
+		}},
+		Warnings: []error{errors.New("w")},
+	}
+	tests := []struct {
+		format string
+		exp    string
+	}{
+		{"plain", "/src/p/p.go:3:6\n/src/p/q.go:7:2\n"},
+		{"acme", "/src/p/p.go:#21\n/src/p/q.go:#40\n"},
+		{"vim", "/src/p/p.go:3:6: func F()\n/src/p/q.go:7:2: func g() (called by F)\n"},
+		{"json", `{"found":true,"filename":"/src/p/p.go","line":3,"column":6,"offset":21,` +
+			`"description":"func F()","kind":"function","warnings":["w"]}` + "\n"},
+		{"guru", "/src/p/p.go:3:6: defined here as func F()\n"},
+		{"guru-json", "{\n\t\"objpos\": \"/src/p/p.go:3:6\",\n\t\"desc\": \"func F()\"\n}\n"},
+	}
+	for _, test := range tests {
+		f, ok := LookupFormatter(test.format)
+		if !ok {
+			t.Errorf("%s: formatter not registered", test.format)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := f.Format(&buf, res); err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if got := buf.String(); got != test.exp {
+			t.Errorf("%s: got: %q want: %q", test.format, got, test.exp)
+		}
+	}
+}
+
+func TestFormatters_Describe(t *testing.T) {
+	res := &Result{
+		Found:    true,
+		Position: Position{Filename: "/src/p/p.go", Line: 3, Column: 6, Offset: 21},
+		Type:     "type T struct{F int}",
+		Members: []Candidate{{
+			Position:    Position{Filename: "/src/p/p.go", Line: 4, Column: 2, Offset: 30},
+			Description: "F int",
+		}},
+		Candidates: []Candidate{{
+			Position: Position{Filename: "/src/p/q.go", Line: 7, Column: 2, Offset: 40},
+		}},
+	}
+	exp := "/src/p/p.go:3:6\n" +
+		"type T struct{F int}\n" +
+		"\tF int\n" +
+		"\t\t/src/p/p.go:4:2\n" +
+		"/src/p/q.go:7:2\n"
+	var buf bytes.Buffer
+	if err := formatPlain(&buf, res); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != exp {
+		t.Errorf("got: %q want: %q", got, exp)
+	}
+}
+
+func TestFormatters_NotFound(t *testing.T) {
+	res := &Result{Reason: "no identifier found"}
+	for _, name := range FormatterNames() {
+		f, _ := LookupFormatter(name)
+		var buf bytes.Buffer
+		err := f.Format(&buf, res)
+		if name == "json" {
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: got error: %v want: %v", name, err, ErrNotFound)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%s: unexpected output: %q", name, buf.String())
+		}
+	}
+}
+
+func TestRegisterFormatter(t *testing.T) {
+	RegisterFormatter("test", FormatterFunc(func(w io.Writer, res *Result) error {
+		_, err := io.WriteString(w, res.Description)
+		return err
+	}))
+	defer func() {
+		formatters.Lock()
+		delete(formatters.m, "test")
+		formatters.Unlock()
+	}()
+	f, ok := LookupFormatter("test")
+	if !ok {
+		t.Fatal("formatter not registered")
+	}
+	var buf bytes.Buffer
+	if err := f.Format(&buf, &Result{Description: "d"}); err != nil || buf.String() != "d" {
+		t.Errorf("got: %q, %v want: %q", buf.String(), err, "d")
+	}
+}
+
+func TestFormatJSON_Stats(t *testing.T) {
+	res := &Result{
+		Found: true,
+		Stats: Stats{
+			Strategy:    StrategyTypeChecker,
+			ParseTime:   time.Millisecond,
+			LoadTime:    1500 * time.Microsecond,
+			Total:       3 * time.Millisecond,
+			FilesParsed: 12,
+		},
+	}
+	want := JSONStats{
+		Strategy:    StrategyTypeChecker,
+		ParseTime:   1,
+		LoadTime:    1.5,
+		Total:       3,
+		FilesParsed: 12,
+	}
+	if got := FormatJSON(res).Stats; got == nil || *got != want {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+	if got := FormatJSON(&Result{Found: true}).Stats; got != nil {
+		t.Errorf("got: %+v want: nil", got)
+	}
+}
+
+func TestFormatJSON_Errors(t *testing.T) {
+	res := &Result{
+		Found: true,
+		Errors: []TypeCheckError{
+			{Position: Position{Filename: filepath.FromSlash("/p/p.go"), Line: 8, Column: 7}, Message: "undefined: missing"},
+			{Position: Position{Filename: filepath.FromSlash("/p/p.go"), Line: 9, Column: 2}, Message: "declared and not used: x", Soft: true},
+		},
+	}
+	want := []JSONTypeCheckError{
+		{Filename: "/p/p.go", Line: 8, Column: 7, Message: "undefined: missing"},
+		{Filename: "/p/p.go", Line: 9, Column: 2, Message: "declared and not used: x", Soft: true},
+	}
+	if got := FormatJSON(res).Errors; !reflect.DeepEqual(got, want) {
+		t.Errorf("got: %+v want: %+v", got, want)
+	}
+	if got := FormatJSON(&Result{Found: true}).Errors; got != nil {
+		t.Errorf("got: %+v want: nil", got)
+	}
+}
@@ -0,0 +1,81 @@
+//go:build go1.16
+// +build go1.16
+
+package godef
+
+import (
+	"go/build"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ContextFromFS returns a copy of build.Default whose file system
+// functions (OpenFile, ReadDir and IsDir) read the files beneath the
+// absolute directory root from fsys, e.g. root/p/p.go is the file
+// "p/p.go" of fsys.  Files outside root are read from the operating
+// system, so that the standard library is found in GOROOT, unless root
+// is the root directory, in which case fsys is the only file system.
+// The result is used as Config.Context, e.g. to query synthetic file
+// trees in tests or the files embedded in a program.
+//
+// The caches of a Config (see ProgramCache) validate their entries by
+// the contents of the files read through the build context of each
+// query, so they may be shared by the Configs of different file
+// systems.
+func ContextFromFS(fsys fs.FS, root string) *build.Context {
+	root = filepath.Clean(root)
+	// name returns the name of path in fsys, or false if it is not
+	// beneath root.
+	name := func(path string) (string, bool) {
+		path = filepath.Clean(path)
+		if path == root {
+			return ".", true
+		}
+		rel, ok := hasFilePathPrefix(path, root)
+		if !ok {
+			return "", false
+		}
+		return filepath.ToSlash(rel), true
+	}
+
+	ctxt := build.Default // make a copy
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		if name, ok := name(path); ok {
+			return fsys.Open(name)
+		}
+		return os.Open(path)
+	}
+	ctxt.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		name, ok := name(dir)
+		if !ok {
+			return ioutil.ReadDir(dir)
+		}
+		entries, err := fs.ReadDir(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]os.FileInfo, 0, len(entries))
+		for _, e := range entries {
+			fi, err := e.Info()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, fi)
+		}
+		return list, nil
+	}
+	ctxt.IsDir = func(path string) bool {
+		var fi os.FileInfo
+		var err error
+		if name, ok := name(path); ok {
+			fi, err = fs.Stat(fsys, name)
+		} else {
+			fi, err = os.Stat(path)
+		}
+		return err == nil && fi.IsDir()
+	}
+	return &ctxt
+}
@@ -0,0 +1,75 @@
+//go:build go1.16
+// +build go1.16
+
+package godef
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestContextFromFS(t *testing.T) {
+	if programBackend == "packages" {
+		t.Skip("the go command does not use the file system of the build context")
+	}
+	const psrc = `package p
+
+import (
+	"strings"
+
+	"example.com/q"
+)
+
+var _ = q.V.F + strings.TrimSpace("")
+`
+	// Two file systems with different declarations of the field F.
+	fsys := []fstest.MapFS{
+		{
+			"src/example.com/p/p.go": {Data: []byte(psrc)},
+			"src/example.com/q/q.go": {Data: []byte("package q\n\nvar V struct{ F string }\n")},
+		},
+		{
+			"src/example.com/p/p.go": {Data: []byte(psrc)},
+			"src/example.com/q/q.go": {Data: []byte("package q\n\n// V has a field F.\nvar V struct {\n\tF string\n}\n")},
+		},
+	}
+	// The caches are shared by the queries of both file systems.
+	caches := Config{
+		ProgramCache: NewProgramCache(0),
+		ASTCache:     NewASTCache(0),
+		PackageIndex: NewPackageIndex(0),
+	}
+	const root = "/godef-test-fs"
+	filename := root + "/src/example.com/p/p.go"
+	tests := []struct {
+		fs     int
+		marker string
+		file   string
+		line   int
+	}{
+		{0, "F +", "q/q.go", 3},
+		{1, "F +", "q/q.go", 5},
+		{0, "F +", "q/q.go", 3},
+		{1, "V.F", "q/q.go", 4},
+		{0, "V.F", "q/q.go", 3},
+		{1, "TrimSpace", "strings/strings.go", 0}, // from GOROOT
+	}
+	for _, x := range tests {
+		conf := caches
+		conf.Context = *ContextFromFS(fsys[x.fs], root)
+		conf.Context.GOPATH = root
+		conf.Workspace.Disabled = true
+		res, err := conf.Lookup(filename, strings.Index(psrc, x.marker), nil)
+		if err != nil {
+			t.Errorf("fs %d %q: %v", x.fs, x.marker, err)
+			continue
+		}
+		if !strings.HasSuffix(res.Position.Filename, "/"+x.file) || (x.line != 0 && res.Position.Line != x.line) {
+			t.Errorf("fs %d %q: got %s:%d; want: %s:%d", x.fs, x.marker, res.Position.Filename, res.Position.Line, x.file, x.line)
+		}
+	}
+	if n := caches.ProgramCache.Len(); n == 0 {
+		t.Error("no programs were cached")
+	}
+}
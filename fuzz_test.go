@@ -0,0 +1,59 @@
+//go:build go1.18
+// +build go1.18
+
+package godef
+
+import (
+	"context"
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// fuzzSrc is the seed source of FuzzQuery, its identifiers include
+// multi-byte runes so that offsets may fall within a rune.
+const fuzzSrc = `package p
+
+type Ťype struct{ Fïeld int }
+
+func (t Ťype) Mëthod() int { return t.Fïeld }
+
+func f() {
+	var v Ťype
+	_ = v.Fïeld + v.Mëthod() // "日本"
+}
+`
+
+// FuzzQuery checks that queries of arbitrary sources and positions
+// return an error, rather than panic, for positions that are not in
+// the queried file.
+func FuzzQuery(f *testing.F) {
+	dir := f.TempDir()
+	filename := filepath.Join(dir, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(fuzzSrc), 0644); err != nil {
+		f.Fatal(err)
+	}
+	for _, pos := range []string{":#0", ":#-1", ":#1,#0", ":#60", ":#61", ":#1000", ":#", ":12", ":#3,#40"} {
+		f.Add([]byte(fuzzSrc), pos)
+	}
+	f.Add([]byte("package p; var x = y."), ":#20")
+	f.Add([]byte("\xff\xfe"), ":#1")
+	f.Add([]byte(""), ":#0")
+
+	e := NewEngine(&Config{Context: build.Default})
+	f.Fuzz(func(t *testing.T, src []byte, pos string) {
+		for _, mode := range []string{"definition", "references", "receivertype", "typedef", "implements", "describe", "decl", "complete"} {
+			q := Query{Mode: mode, Pos: filename + pos, Src: src, Engine: e}
+			_, err := q.Run(context.Background())
+			sp, perr := parsePos(q.Pos)
+			if perr != nil || sp.End.Offset > len(src) {
+				var want *PositionError
+				if !errors.As(err, &want) {
+					t.Fatalf("%s query %q: got error %v; want a *PositionError", mode, pos, err)
+				}
+			}
+		}
+	})
+}
@@ -8,6 +8,8 @@ package godef
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/build"
@@ -19,13 +21,11 @@ import (
 	"os"
 	pathpkg "path"
 	"path/filepath"
-	"runtime"
 	"strconv"
 	"strings"
 
+	"github.com/charlievieth/godef/internal/span"
 	"golang.org/x/tools/go/ast/astutil"
-	"golang.org/x/tools/go/buildutil"
-	"golang.org/x/tools/go/loader"
 )
 
 // A QueryPos represents the position provided as input to a query:
@@ -34,10 +34,11 @@ import (
 // Instances are created by parseQueryPos.
 type queryPos struct {
 	fset       *token.FileSet
-	start, end token.Pos           // source extent of query
-	path       []ast.Node          // AST path from query node to root of ast.File
-	exact      bool                // 2nd result of PathEnclosingInterval
-	info       *loader.PackageInfo // type info for the queried package (nil for fastQueryPos)
+	start, end token.Pos    // source extent of query
+	path       []ast.Node   // AST path from query node to root of ast.File
+	exact      bool         // 2nd result of PathEnclosingInterval
+	info       *packageInfo // type info for the queried package (nil for fastQueryPos)
+	parseErr   error        // syntax error of the queried file (fastQueryPos only)
 }
 
 // TypeString prints type T relative to the query position.
@@ -55,26 +56,122 @@ func (qpos *queryPos) selectionString(sel *types.Selection) string {
 	return types.SelectionString(sel, types.RelativeTo(qpos.info.Pkg))
 }
 
+// A Query is a query of one of the modes answered by an Engine, e.g. a
+// definition query, see Run.
 type Query struct {
-	Mode  string         // query mode ("callers", etc)
-	Pos   string         // query position
+	Mode  string         // query mode ("definition", "describe", etc), see Run
+	Pos   string         // query position, "file:#offset" or "file:#start,#end"
 	Build *build.Context // package loading configuration
+	Env   Environment    // (optional) process environment, defaults to OSEnvironment
+	Dir   string         // (optional) working directory, defaults to that of Env
+
+	// Engine answers the query, if nil an Engine configured with
+	// Build, Env, Dir and Resolver is used.  Servers should set it, so
+	// that queries share its caches and roots.
+	Engine *Engine
+
+	// Src is the source of the queried file, if non-nil it is used in
+	// place of the file (see Engine.Lookup).
+	Src interface{}
+
+	// Resolver resolves the import path of the queried file, if nil
+	// a GOPATHResolver for Build is used.
+	Resolver Resolver
 
 	// pointer analysis options
 	Scope      []string  // main packages in (*loader.Config).FromArgs syntax
 	PTALog     io.Writer // (optional) pointer-analysis log file
 	Reflection bool      // model reflection soundly (currently slow).
 
+	// overlay is the contents of files that replace, or are added
+	// to, the file system, it must also be observed by Build.
+	overlay map[string][]byte
+
+	// describe adds the type and members of the object to the result,
+	// including unexported members if allMembers is set.
+	describe   bool
+	allMembers bool
+
+	logger Logger        // (optional) receives debug messages
+	cache  *ProgramCache // (optional) reuses loaded programs
+	stats  Stats
+
+	// hooks trace the phases of the query, info, as children of the
+	// span in spanCtx (see Hooks.StartSpan).
+	hooks   *Hooks
+	spanCtx context.Context
+	info    QueryInfo
+
+	// exportData loads the imports of the queried package from export
+	// data (see Config.ExportData).
+	exportData bool
+
+	parseWorkers int           // maximum files parsed concurrently, see parseWorkers
+	astCache     *ASTCache     // (optional) reuses parsed files
+	packageIndex *PackageIndex // (optional) reuses package declarations
+
+	// physical reports positions in the parsed files, ignoring //line
+	// directives (see Config.IgnoreLineDirectives).
+	physical bool
+
+	// identEnd accepts a query offset immediately after an identifier
+	// (see Config.AcceptIdentifierEnd).
+	identEnd bool
+
+	// resolveAliases follows type aliases to the type they denote (see
+	// Config.ResolveAliases).
+	resolveAliases bool
+
+	// implementations is the scope searched for the implementations of
+	// interface methods (see Config.Implementations).
+	implementations ImplementationScope
+
+	// docLinks resolves the doc links and identifiers in comments (see
+	// Config.ResolveDocLinks).
+	docLinks bool
+
+	// bestEffort falls back on a textual search for the declaration of
+	// an identifier (see Config.BestEffort).
+	bestEffort bool
+
+	// typeErrors are the type errors of the queried package, set if
+	// the query is answered with the type checker (see Result.Errors).
+	typeErrors []types.Error
+
+	// prog, if non-nil, is the program of the query, which is not
+	// loaded (see Engine.LookupChecked).
+	prog program
+
+	// lprog and files are the syntax trees of the query: its program,
+	// if loaded, and the files it parsed otherwise, e.g. the queried
+	// file.  They are reused to find the extent of the definition.
+	lprog program
+	files []parsedFile
+
 	// Populated during Run()
 	Fset   *token.FileSet
 	result *definitionResult
 }
 
+// A parsedFile is a file parsed by a query and its file set.
+type parsedFile struct {
+	fset *token.FileSet
+	f    *ast.File
+}
+
 func (q *Query) Output(fset *token.FileSet, res *definitionResult) {
 	q.Fset = fset
 	q.result = res
 }
 
+func (q *Query) env() Environment {
+	env := OSEnvironment
+	if q.Env != nil {
+		env = q.Env
+	}
+	return withDir(env, q.Dir)
+}
+
 // definition reports the location of the definition of an identifier.
 func definition(q *Query) error {
 	// First try the simple resolution done by parser.
@@ -82,64 +179,136 @@ func definition(q *Query) error {
 	// (Extending this approach to all the files of the package,
 	// resolved using ast.NewPackage, was not worth the effort.)
 	{
-		qpos, err := fastQueryPos(q.Build, q.Pos)
+		start := q.env().Now()
+		qpos, err := q.parseQueryPos(q.identEnd)
 		if err != nil {
 			return err
 		}
+		q.stats.ParseTime = since(q.env(), start)
+		q.stats.FilesParsed = 1
+		q.logf("parsed query file in %v", q.stats.ParseTime)
+
+		// Package clause, import spec or qualifier?
+		if ok, err := packageDefinition(q, qpos); ok {
+			q.logf("resolved package clause, import spec or qualifier")
+			q.stats.Strategy = StrategyPackage
+			return err
+		}
+
+		// File name in a directive, or a struct tag?
+		if ok, err := directiveDefinition(q, qpos); ok {
+			q.logf("resolved directive or struct tag")
+			q.stats.Strategy = StrategyDirective
+			return err
+		}
+
+		// A doc link or identifier in a comment?
+		if q.docLinks {
+			if ok, err := docLinkDefinition(q, qpos); ok {
+				return err
+			}
+		}
 
 		id, _ := qpos.path[0].(*ast.Ident)
+		if id == nil && qpos.parseErr != nil {
+			// Identifier lost to a syntax error, e.g. while typing?
+			if ok, err := scannedDefinition(q, qpos); ok {
+				return err
+			}
+		}
 		if id == nil {
-			return fmt.Errorf("no identifier here")
+			return &NotFoundError{Err: ErrNoIdentifier}
+		}
+
+		// A label?  The parser does not resolve labels lost to
+		// syntax errors.
+		if !q.describe {
+			if ok, err := labelDefinition(q, qpos); ok {
+				q.logf("resolved label %s", id.Name)
+				q.stats.Strategy = StrategyParser
+				return err
+			}
 		}
 
 		// Did the parser resolve it to a local object?
-		if obj := id.Obj; obj != nil && obj.Pos().IsValid() {
+		// Descriptions and aliases require the type checker.
+		if obj := id.Obj; obj != nil && obj.Pos().IsValid() && !q.describe && !(q.resolveAliases && isAliasDecl(obj)) {
+			q.logf("resolved %s with the parser", id.Name)
+			q.stats.Strategy = StrategyParser
+			f := qpos.path[len(qpos.path)-1].(*ast.File)
+			pos := obj.Pos()
+			if clause := typeSwitchClause(qpos.path, obj.Decl); clause != nil {
+				pos = clause.Case // y of "switch y := x.(type)" has the type of its case
+			}
 			q.Output(qpos.fset, &definitionResult{
-				pos:   obj.Pos(),
+				pos:   pos,
 				descr: fmt.Sprintf("%s %s", obj.Kind, obj.Name),
+				kind:  astObjectKind(obj),
+				id:    astObjectID(q, qpos.fset.File(f.Pos()).Name(), f, obj),
 			})
 			return nil // success
 		}
 
 		// Qualified identifier?
-		if pkg := packageForQualIdent(qpos.path, id); pkg != "" {
+		if pkg := packageForQualIdent(qpos.path, id); pkg != "" && !q.describe {
 			srcdir := filepath.Dir(qpos.fset.File(qpos.start).Name())
-			tok, pos, err := findPackageMember(q.Build, qpos.fset, srcdir, pkg, id.Name)
-			if err != nil {
-				return err
+			start := q.env().Now()
+			base := qpos.fset.Base()
+			tok, pos, err := findPackageMember(q, qpos.fset, srcdir, pkg, id.Name)
+			q.stats.FilesParsed += countFiles(qpos.fset, base)
+			switch {
+			case err == nil && tok == token.TYPE && q.resolveAliases:
+				// The type may be an alias, which only the type
+				// checker resolves.
+				q.logf("found type %s.%s by scanning package %q", pkg, id.Name, pkg)
+			case err == nil:
+				q.logf("resolved %s.%s by scanning package %q in %v", pkg, id.Name, pkg, since(q.env(), start))
+				q.stats.Strategy = StrategyPackageScan
+				q.Output(qpos.fset, &definitionResult{
+					pos:   pos,
+					descr: fmt.Sprintf("%s %s.%s", tok, pkg, id.Name),
+					kind:  tokenKind(tok),
+					id:    ObjectID{PkgPath: pkg, Name: id.Name},
+				})
+				return nil // success
+			default:
+				// The package may only be found with q.Resolver (e.g.
+				// a module), fall back on the type checker.
+				q.logf("scanning package %q: %v", pkg, err)
 			}
-			q.Output(qpos.fset, &definitionResult{
-				pos:   pos,
-				descr: fmt.Sprintf("%s %s.%s", tok, pkg, id.Name),
-			})
-			return nil // success
 		}
 
 		// Fall back on the type checker.
-	}
-
-	// Run the type checker.
-	lconf := loader.Config{Build: q.Build}
-	allowErrors(&lconf)
-
-	if _, err := importQueryPackage(q.Pos, &lconf); err != nil {
+		q.logf("falling back on the type checker")
+		err = checkedDefinition(q)
+		if err != nil && q.bestEffort && !errors.Is(err, ErrBuiltin) {
+			q.logf("type checker: %v", err)
+			if approximateDefinition(q, qpos, id) {
+				q.logf("found an approximate definition of %s", id.Name)
+				return nil
+			}
+		}
 		return err
 	}
+}
 
+// checkedDefinition answers a definition query with the type checker.
+func checkedDefinition(q *Query) error {
 	// Load/parse/type-check the program.
-	lprog, err := lconf.Load()
+	lprog, err := q.loadProgram()
 	if err != nil {
 		return err
 	}
 
-	qpos, err := parseQueryPos(lprog, q.Pos, false)
+	qpos, err := parseQueryPos(lprog, q.Pos, false, q.identEnd)
 	if err != nil {
 		return err
 	}
+	q.typeErrors = qpos.info.TypeErrors
 
 	id, _ := qpos.path[0].(*ast.Ident)
 	if id == nil {
-		return fmt.Errorf("no identifier here")
+		return &NotFoundError{Err: ErrNoIdentifier}
 	}
 
 	// Look up the declaration of this identifier.
@@ -153,21 +322,92 @@ func definition(q *Query) error {
 			// Happens for y in "switch y := x.(type)",
 			// and the package declaration,
 			// but I think that's all.
-			return fmt.Errorf("no object for identifier")
+			if typeSwitchDefinition(q, lprog, qpos, id) {
+				return nil
+			}
+			if ok, err := labelDefinition(q, qpos); ok {
+				return err
+			}
+			// Or for identifiers involved in type errors.
+			if recoverDefinition(q, lprog, qpos, id) {
+				return nil
+			}
+			return &NotFoundError{Err: ErrNoObject}
 		}
 	}
 
+	// A package qualifier denotes the imported package, not its import
+	// spec.
+	if pkgName, ok := obj.(*types.PkgName); ok && qpos.info.Uses[id] == obj {
+		ok, err := importedPackageDefinition(q, qpos, pkgName.Imported().Path())
+		if ok {
+			if err == nil {
+				describeDefinition(q, lprog, qpos, obj)
+			}
+			return err
+		}
+	}
+
+	var aliases []*types.TypeName
+	if q.resolveAliases {
+		obj, aliases = resolveAlias(lprog, obj)
+	}
+
 	if !obj.Pos().IsValid() {
-		return fmt.Errorf("%s is built in", obj.Name())
+		builtinDefinition(q, lprog.Fset(), obj, qpos.objectString(obj))
+		addAliases(q, lprog, qpos, aliases)
+		describeDefinition(q, lprog, qpos, obj)
+		return nil
+	}
+
+	var declPath []ast.Node
+	if v, ok := obj.(*types.Var); ok && !v.IsField() {
+		_, declPath, _ = lprog.PathEnclosingInterval(obj.Pos(), obj.Pos())
 	}
 
-	q.Output(lprog.Fset, &definitionResult{
-		pos:   obj.Pos(),
-		descr: qpos.objectString(obj),
+	descr := qpos.objectString(obj)
+	var notes []string
+	if sel := methodSelection(qpos); sel != "" {
+		notes = append(notes, sel)
+	}
+	if path := promotionPath(qpos); path != "" {
+		notes = append(notes, "promoted via "+path)
+	}
+	if len(notes) != 0 {
+		descr += " (" + strings.Join(notes, ", ") + ")"
+	}
+	pos := q.objectPos(lprog, obj)
+	if clause := implicitClause(qpos, obj); clause != nil {
+		pos = clause.Case // y of "switch y := x.(type)" has the type of its case
+	}
+	q.Output(lprog.Fset(), &definitionResult{
+		pos:   pos,
+		descr: descr,
+		kind:  objectKind(obj, declPath),
+		id:    objectID(obj),
 	})
+	addAliases(q, lprog, qpos, aliases)
+	addImplementations(q, lprog, qpos, obj)
+	describeDefinition(q, lprog, qpos, obj)
 	return nil
 }
 
+// builtinDefinition outputs the declaration of obj, a predeclared object
+// or member of package unsafe, in the source of the documentation-only
+// "builtin" or "unsafe" package.  If the source is not available, e.g.
+// GOROOT/src is missing, only the description of obj is output.
+func builtinDefinition(q *Query, fset *token.FileSet, obj types.Object, descr string) {
+	pkg := "builtin"
+	if obj.Pkg() != nil && obj.Pkg().Path() == "unsafe" {
+		pkg = "unsafe"
+	}
+	res := &definitionResult{descr: descr, kind: objectKind(obj, nil), id: objectID(obj)}
+	if _, pos, err := findPackageMember(q, fset, "", pkg, obj.Name()); err == nil {
+		res.pos = pos
+	}
+	q.Output(fset, res)
+}
+
 // packageForQualIdent returns the package p if id is X in a qualified
 // identifier p.X; it returns "" otherwise.
 //
@@ -178,17 +418,23 @@ func definition(q *Query) error {
 func packageForQualIdent(path []ast.Node, id *ast.Ident) string {
 	if sel, ok := path[1].(*ast.SelectorExpr); ok && sel.Sel == id && ast.IsExported(id.Name) {
 		if pkgid, ok := sel.X.(*ast.Ident); ok && pkgid.Obj == nil {
-			f := path[len(path)-1].(*ast.File)
-			for _, imp := range f.Imports {
-				path, _ := strconv.Unquote(imp.Path.Value)
-				if imp.Name != nil {
-					if imp.Name.Name == pkgid.Name {
-						return path // renaming import
-					}
-				} else if pathpkg.Base(path) == pkgid.Name {
-					return path // ordinary import
-				}
+			return importedPackage(path[len(path)-1].(*ast.File), pkgid.Name)
+		}
+	}
+	return ""
+}
+
+// importedPackage returns the path of the package imported by f as name,
+// or "".
+func importedPackage(f *ast.File, name string) string {
+	for _, imp := range f.Imports {
+		path, _ := strconv.Unquote(imp.Path.Value)
+		if imp.Name != nil {
+			if imp.Name.Name == name {
+				return path // renaming import
 			}
+		} else if pathpkg.Base(path) == name {
+			return path // ordinary import
 		}
 	}
 	return ""
@@ -196,27 +442,37 @@ func packageForQualIdent(path []ast.Node, id *ast.Ident) string {
 
 // findPackageMember returns the type and position of the declaration of
 // pkg.member by loading and parsing the files of that package.
-// srcdir is the directory in which the import appears.
-func findPackageMember(ctxt *build.Context, fset *token.FileSet, srcdir, pkg, member string) (token.Token, token.Pos, error) {
+// srcdir is the directory in which the import appears.  At most
+// q.parseWorkers files are parsed concurrently (see parseWorkers).
+func findPackageMember(q *Query, fset *token.FileSet, srcdir, pkg, member string) (token.Token, token.Pos, error) {
+	ctxt := q.Build
+	if q.packageIndex != nil {
+		return q.packageIndex.findMember(q, fset, srcdir, pkg, member)
+	}
 	bp, err := ctxt.Import(pkg, srcdir, 0)
 	if err != nil {
 		return 0, token.NoPos, err // no files for package
 	}
 
 	type result struct {
-		tok token.Token
-		pos token.Pos
+		tok  token.Token
+		pos  token.Pos
+		file *ast.File
+	}
+	type fileResult struct {
+		i int     // index of the file in bp.GoFiles
+		r *result // nil if the file does not declare member
 	}
-	ch := make(chan *result, len(bp.GoFiles))
-	gate := make(chan struct{}, runtime.NumCPU())
+	ch := make(chan fileResult, len(bp.GoFiles))
+	gate := make(chan struct{}, parseWorkers(q.parseWorkers))
 	done := make(chan struct{})
 
-	for _, fname := range bp.GoFiles {
-		go func(fname string) {
+	for i, fname := range bp.GoFiles {
+		go func(i int, fname string) {
 			select {
 			case gate <- struct{}{}:
 			case <-done:
-				ch <- nil
+				ch <- fileResult{i, nil}
 				return
 			}
 			defer func() { <-gate }()
@@ -225,105 +481,103 @@ func findPackageMember(ctxt *build.Context, fset *token.FileSet, srcdir, pkg, me
 
 			// Parse the file, opening it the file via the build.Context
 			// so that we observe the effects of the -modified flag.
-			f, _ := buildutil.ParseFile(fset, ctxt, nil, ".", filename, parser.Mode(0))
+			f, _ := parseFile(fset, ctxt, q.astCache, ".", filename, parser.Mode(0))
 			if f == nil {
-				ch <- nil
+				ch <- fileResult{i, nil}
 				return
 			}
 
 			// Find a package-level decl called 'member'.
-			for _, decl := range f.Decls {
-				switch decl := decl.(type) {
-				case *ast.GenDecl:
-					for _, spec := range decl.Specs {
-						switch spec := spec.(type) {
-						case *ast.ValueSpec:
-							// const or var
-							for _, id := range spec.Names {
-								if id.Name == member {
-									ch <- &result{decl.Tok, id.Pos()}
-									return
-								}
-							}
-						case *ast.TypeSpec:
-							if spec.Name.Name == member {
-								ch <- &result{token.TYPE, spec.Name.Pos()}
-								return
-							}
+			var r *result
+			packageDecls(f, func(tok token.Token, id *ast.Ident) bool {
+				if id.Name == member {
+					r = &result{tok, id.Pos(), f}
+					return false
+				}
+				return true
+			})
+			ch <- fileResult{i, r}
+		}(i, fname)
+	}
+
+	// Return the declaration in the first file that declares member
+	// (normally there is only one) so that the result does not depend
+	// on the order the files are parsed in.
+	results := make([]*result, len(bp.GoFiles))
+	reported := make([]bool, len(bp.GoFiles))
+	next := 0 // files before next do not declare member
+	for i := 0; i < len(bp.GoFiles); i++ {
+		fr := <-ch
+		results[fr.i], reported[fr.i] = fr.r, true
+		for ; next < len(results) && reported[next]; next++ {
+			if r := results[next]; r != nil {
+				close(done)
+				q.files = append(q.files, parsedFile{fset, r.file})
+				return r.tok, r.pos, nil
+			}
+		}
+	}
+
+	return 0, token.NoPos, memberNotFound(pkg, member)
+}
+
+// memberNotFound returns the error of findPackageMember for a member
+// that is not declared by pkg.
+func memberNotFound(pkg, member string) error {
+	return &NotFoundError{
+		Err:    ErrNotFound,
+		Reason: fmt.Sprintf("couldn't find declaration of %s in %q", member, pkg),
+	}
+}
+
+// packageDecls calls fn with the token (CONST, VAR, TYPE or FUNC) and
+// name of each package-level declaration of f, in order, until fn
+// returns false.
+func packageDecls(f *ast.File, fn func(tok token.Token, id *ast.Ident) bool) {
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.ValueSpec:
+					// const or var
+					for _, id := range spec.Names {
+						if !fn(decl.Tok, id) {
+							return
 						}
 					}
-				case *ast.FuncDecl:
-					if decl.Recv == nil && decl.Name.Name == member {
-						ch <- &result{token.FUNC, decl.Name.Pos()}
+				case *ast.TypeSpec:
+					if !fn(token.TYPE, spec.Name) {
 						return
 					}
 				}
 			}
-			ch <- nil
-		}(fname)
-	}
-
-	for i := 0; i < len(bp.GoFiles); i++ {
-		if r := <-ch; r != nil {
-			close(done)
-			return r.tok, r.pos, nil
+		case *ast.FuncDecl:
+			if decl.Recv == nil && !fn(token.FUNC, decl.Name) {
+				return
+			}
 		}
 	}
-
-	return 0, token.NoPos, fmt.Errorf("couldn't find declaration of %s in %q", member, pkg)
 }
 
 type definitionResult struct {
-	pos   token.Pos // (nonzero) location of definition
+	pos   token.Pos // location of definition, zero for built-ins without source
 	descr string    // description of object it denotes
-}
+	kind  Kind      // semantic classification of the identifier
+	id    ObjectID  // identifier of the object, zero for local objects
 
-// importQueryPackage finds the package P containing the
-// query position and tells conf to import it.
-// It returns the package's path.
-func importQueryPackage(pos string, conf *loader.Config) (string, error) {
-	fqpos, err := fastQueryPos(conf.Build, pos)
-	if err != nil {
-		return "", err // bad query
-	}
-	filename := fqpos.fset.File(fqpos.start).Name()
+	// Only set if Query.describe is set.
+	typ     string             // type of the object (see describeObject)
+	members []definitionResult // members of the object's type
 
-	_, importPath, err := guessImportPath(filename, conf.Build)
-	if err != nil {
-		// Can't find GOPATH dir.
-		// Treat the query file as its own package.
-		importPath = "command-line-arguments"
-		conf.CreateFromFilenames(importPath, filename)
-	} else {
-		// Check that it's possible to load the queried package.
-		// (e.g. guru tests contain different 'package' decls in same dir.)
-		// Keep consistent with logic in loader/util.go!
-		cfg2 := *conf.Build
-		cfg2.CgoEnabled = false
-		bp, err := cfg2.Import(importPath, "", 0)
-		if err != nil {
-			return "", err // no files for package
-		}
+	aliases []definitionResult // type aliases resolved to the object (see Query.resolveAliases)
 
-		switch pkgContainsFile(bp, filename) {
-		case 'T':
-			conf.ImportWithTests(importPath)
-		case 'X':
-			conf.ImportWithTests(importPath)
-			importPath += "_test" // for TypeCheckFuncBodies
-		case 'G':
-			conf.Import(importPath)
-		default:
-			// This happens for ad-hoc packages like
-			// $GOROOT/src/net/http/triv.go.
-			return "", fmt.Errorf("package %q doesn't contain file %s",
-				importPath, filename)
-		}
-	}
+	candidates []definitionResult // additional definitions, e.g. the implementations of an interface method
+	reason     string             // why a candidate is included
 
-	conf.TypeCheckFuncBodies = func(p string) bool { return p == importPath }
+	references []definitionResult // uses of the object (see references)
 
-	return importPath, nil
+	typeErr error // type error of a recovered object (see recoverDefinition)
 }
 
 type PathError struct {
@@ -343,8 +597,8 @@ func (p *PathError) Error() string {
 // TODO(adonovan): what about _test.go files that are not part of the
 // package?
 //
-func guessImportPath(filename string, buildContext *build.Context) (srcdir, importPath string, err error) {
-	absFile, err := filepath.Abs(filename)
+func guessImportPath(env Environment, filename string, buildContext *build.Context) (srcdir, importPath string, err error) {
+	absFile, err := absPath(env, filename)
 	if err != nil {
 		return "", "", fmt.Errorf("can't form absolute path of %s: %v", filename, err)
 	}
@@ -359,7 +613,7 @@ func guessImportPath(filename string, buildContext *build.Context) (srcdir, impo
 	// Find the innermost directory in $GOPATH that encloses filename.
 	minD := 1024
 	for _, gopathDir := range buildContext.SrcDirs() {
-		absDir, err := filepath.Abs(gopathDir)
+		absDir, err := absPath(env, gopathDir)
 		if err != nil {
 			continue // e.g. non-existent dir on $GOPATH
 		}
@@ -412,7 +666,10 @@ func prefixLen(x, y []string) int {
 func pkgContainsFile(bp *build.Package, filename string) byte {
 	for i, files := range [][]string{bp.GoFiles, bp.TestGoFiles, bp.XTestGoFiles} {
 		for _, file := range files {
-			if sameFile(filepath.Join(bp.Dir, file), filename) {
+			if !filepath.IsAbs(file) {
+				file = filepath.Join(bp.Dir, file)
+			}
+			if sameFile(file, filename) {
 				return "GTX"[i]
 			}
 		}
@@ -426,165 +683,153 @@ func pkgContainsFile(bp *build.Package, filename string) byte {
 // this is appropriate for queries that allow fairly arbitrary syntax,
 // e.g. "describe".
 //
-func parseQueryPos(lprog *loader.Program, pos string, needExact bool) (*queryPos, error) {
-	filename, startOffset, endOffset, err := parsePos(pos)
+func parseQueryPos(lprog program, pos string, needExact, identEnd bool) (*queryPos, error) {
+	sp, err := parsePos(pos)
 	if err != nil {
 		return nil, err
 	}
+	filename := sp.Filename
 
-	// Find the named file among those in the loaded program.
-	var file *token.File
-	lprog.Fset.Iterate(func(f *token.File) bool {
+	// Find the named file among those in the loaded program.  The
+	// file set may be shared with an ASTCache, so it may contain other
+	// versions of the file, or the file parsed in another mode.
+	var files []*token.File
+	lprog.Fset().Iterate(func(f *token.File) bool {
 		if sameFile(filename, f.Name()) {
-			file = f
-			return false // done
+			files = append(files, f)
 		}
 		return true // continue
 	})
-	if file == nil {
-		return nil, fmt.Errorf("file %s not found in loaded program", filename)
-	}
-
-	start, end, err := fileOffsetToPos(file, startOffset, endOffset)
-	if err != nil {
-		return nil, err
+	if len(files) == 0 {
+		return nil, &FileNotInPackageError{Filename: filename}
+	}
+
+	var (
+		start, end token.Pos
+		info       *packageInfo
+		path       []ast.Node
+		exact      bool
+	)
+	for _, file := range files {
+		if start, end, err = sp.Range(file); err != nil {
+			continue
+		}
+		info, path, exact = lprog.PathEnclosingInterval(start, end)
+		if identEnd && start == end && start > token.Pos(file.Base()) && !isIdent(path) {
+			if info1, path1, exact1 := lprog.PathEnclosingInterval(start-1, end-1); endsIdent(path1, start) {
+				start, end = start-1, end-1
+				info, path, exact = info1, path1, exact1
+			}
+		}
+		if path != nil {
+			break
+		}
 	}
-	info, path, exact := lprog.PathEnclosingInterval(start, end)
 	if path == nil {
-		return nil, fmt.Errorf("no syntax here")
+		if err != nil {
+			return nil, &PositionError{Pos: pos, Err: err}
+		}
+		return nil, &NotFoundError{Err: ErrNoSyntax}
 	}
 	if needExact && !exact {
-		return nil, fmt.Errorf("ambiguous selection within %s", astutil.NodeDescription(path[0]))
+		return nil, &AmbiguousSelectionError{Node: astutil.NodeDescription(path[0])}
 	}
-	return &queryPos{lprog.Fset, start, end, path, exact, info}, nil
+	return &queryPos{lprog.Fset(), start, end, path, exact, info, nil}, nil
 }
 
-// parseOctothorpDecimal returns the numeric value if s matches "#%d",
-// otherwise -1.
-func parseOctothorpDecimal(s string) int {
-	if s != "" && s[0] == '#' {
-		if s, err := strconv.ParseInt(s[1:], 10, 32); err == nil {
-			return int(s)
+// parseQueryPos is fastQueryPos for the position of q, traced as the
+// SpanParse phase of q.
+func (q *Query) parseQueryPos(identEnd bool) (*queryPos, error) {
+	end := q.startSpan(SpanParse)
+	qpos, err := fastQueryPos(q.Build, q.env(), q.Pos, q.astCache, identEnd)
+	end(err)
+	if err == nil {
+		if f, ok := qpos.path[len(qpos.path)-1].(*ast.File); ok {
+			q.files = append(q.files, parsedFile{qpos.fset, f})
 		}
 	}
-	return -1
-}
-
-// parsePos parses a string of the form "file:pos" or
-// file:start,end" where pos, start, end match #%d and represent byte
-// offsets, and returns its components.
-//
-// (Numbers without a '#' prefix are reserved for future use,
-// e.g. to indicate line/column positions.)
-//
-func parsePos(pos string) (filename string, startOffset, endOffset int, err error) {
-	if pos == "" {
-		err = fmt.Errorf("no source position specified")
-		return
-	}
-
-	colon := strings.LastIndex(pos, ":")
-	if colon < 0 {
-		err = fmt.Errorf("bad position syntax %q", pos)
-		return
-	}
-	filename, offset := pos[:colon], pos[colon+1:]
-	startOffset = -1
-	endOffset = -1
-	if comma := strings.Index(offset, ","); comma < 0 {
-		// e.g. "foo.go:#123"
-		startOffset = parseOctothorpDecimal(offset)
-		endOffset = startOffset
-	} else {
-		// e.g. "foo.go:#123,#456"
-		startOffset = parseOctothorpDecimal(offset[:comma])
-		endOffset = parseOctothorpDecimal(offset[comma+1:])
-	}
-	if startOffset < 0 || endOffset < 0 {
-		err = fmt.Errorf("invalid offset %q in query position", offset)
-		return
-	}
-	return
-}
-
-// fileOffsetToPos translates the specified file-relative byte offsets
-// into token.Pos form.  It returns an error if the file was not found
-// or the offsets were out of bounds.
-//
-func fileOffsetToPos(file *token.File, startOffset, endOffset int) (start, end token.Pos, err error) {
-	// Range check [start..end], inclusive of both end-points.
-
-	if 0 <= startOffset && startOffset <= file.Size() {
-		start = file.Pos(int(startOffset))
-	} else {
-		err = fmt.Errorf("start position is beyond end of file")
-		return
-	}
-
-	if 0 <= endOffset && endOffset <= file.Size() {
-		end = file.Pos(int(endOffset))
-	} else {
-		err = fmt.Errorf("end position is beyond end of file")
-		return
-	}
-
-	return
+	return qpos, err
 }
 
 // fastQueryPos parses the position string and returns a queryPos.
 // It parses only a single file and does not run the type checker.
-func fastQueryPos(ctxt *build.Context, pos string) (*queryPos, error) {
-	filename, startOffset, endOffset, err := parsePos(pos)
+func fastQueryPos(ctxt *build.Context, env Environment, pos string, cache *ASTCache, identEnd bool) (*queryPos, error) {
+	sp, err := parsePos(pos)
 	if err != nil {
 		return nil, err
 	}
+	filename := sp.Filename
 
 	// Parse the file, opening it the file via the build.Context
 	// so that we observe the effects of the -modified flag.
 	fset := token.NewFileSet()
-	cwd, _ := os.Getwd()
-	f, err := buildutil.ParseFile(fset, ctxt, nil, cwd, filename, parser.Mode(0))
+	if cache != nil {
+		fset = cache.fileSet()
+	}
+	cwd, _ := env.Getwd()
+	f, parseErr := parseFile(fset, ctxt, cache, cwd, filename, parser.Mode(0))
 	// ParseFile usually returns a partial file along with an error.
 	// Only fail if there is no file.
 	if f == nil {
-		return nil, err
+		return nil, parseErr
 	}
 	if !f.Pos().IsValid() {
-		return nil, fmt.Errorf("%s is not a Go source file", filename)
+		return nil, fmt.Errorf("%s: %w", filename, ErrNotGoFile)
 	}
 
-	start, end, err := fileOffsetToPos(fset.File(f.Pos()), startOffset, endOffset)
+	start, end, err := sp.Range(fset.File(f.Pos()))
 	if err != nil {
-		return nil, err
+		return nil, &PositionError{Pos: pos, Err: err}
 	}
 
 	path, exact := astutil.PathEnclosingInterval(f, start, end)
+	if identEnd && start == end && start > f.Pos() && !isIdent(path) {
+		if path1, exact1 := astutil.PathEnclosingInterval(f, start-1, end-1); endsIdent(path1, start) {
+			start, end = start-1, end-1
+			path, exact = path1, exact1
+		}
+	}
 	if path == nil {
-		return nil, fmt.Errorf("no syntax here")
+		return nil, &NotFoundError{Err: ErrNoSyntax}
 	}
 
-	return &queryPos{fset, start, end, path, exact, nil}, nil
+	return &queryPos{fset, start, end, path, exact, nil, parseErr}, nil
 }
 
 // ---------- Utilities ----------
 
-// allowErrors causes type errors to be silently ignored.
-// (Not suitable if SSA construction follows.)
-func allowErrors(lconf *loader.Config) {
-	ctxt := *lconf.Build // copy
-	ctxt.CgoEnabled = false
-	lconf.Build = &ctxt
-	lconf.AllowErrors = true
-	// AllErrors makes the parser always return an AST instead of
-	// bailing out after 10 errors and returning an empty ast.File.
-	lconf.ParserMode = parser.AllErrors
-	lconf.TypeChecker.Error = func(err error) {}
+// parsePos is span.Parse, but returns a *PositionError if pos is
+// malformed.
+func parsePos(pos string) (span.Span, error) {
+	sp, err := span.Parse(pos)
+	if err != nil {
+		return span.Span{}, &PositionError{Pos: pos, Err: err}
+	}
+	return sp, nil
+}
+
+// isIdent reports whether the innermost node of path is an identifier.
+func isIdent(path []ast.Node) bool {
+	if len(path) == 0 {
+		return false
+	}
+	_, ok := path[0].(*ast.Ident)
+	return ok
+}
+
+// endsIdent reports whether the innermost node of path is an identifier
+// that ends at pos, that is, pos is immediately after the identifier.
+func endsIdent(path []ast.Node, pos token.Pos) bool {
+	return isIdent(path) && path[0].End() == pos
 }
 
 // sameFile returns true if x and y have the same basename and denote
 // the same file.
 //
 func sameFile(x, y string) bool {
+	if filepath.Clean(x) == filepath.Clean(y) {
+		return true // e.g. files that only exist in an overlay
+	}
 	if filepath.Base(x) == filepath.Base(y) { // (optimisation)
 		if xi, err := os.Stat(x); err == nil {
 			if yi, err := os.Stat(y); err == nil {
@@ -608,6 +853,9 @@ func useModifiedFiles(orig *build.Context, modified map[string][]byte) *build.Co
 		if content, ok := modified[path]; ok {
 			return rc(content)
 		}
+		if orig.OpenFile != nil {
+			return orig.OpenFile(path)
+		}
 		return os.Open(path)
 	}
 	return ctxt
@@ -624,15 +872,14 @@ func useModifiedFile(orig *build.Context, modified string, content []byte) *buil
 		if path == modified {
 			return ioutil.NopCloser(bytes.NewReader(content)), nil
 		}
-		fi, err := os.Stat(path)
-		if err != nil {
-			return nil, err
-		}
 		if info != nil && filepath.Base(path) == base {
-			if os.SameFile(info, fi) {
+			if fi, err := os.Stat(path); err == nil && os.SameFile(info, fi) {
 				return ioutil.NopCloser(bytes.NewReader(content)), nil
 			}
 		}
+		if orig.OpenFile != nil {
+			return orig.OpenFile(path)
+		}
 		return os.Open(path)
 	}
 
@@ -0,0 +1,272 @@
+// Package godeftest runs definition queries described by txtar
+// archives, so that regression cases can be written as small synthetic
+// programs rather than copies of real packages.
+//
+// An archive is a set of files, written to a temporary directory, with
+// markers in their contents that are removed before they are written:
+//
+//	@cursor  the identifier that follows is queried
+//	@target  the identifier that follows is the expected definition
+//
+// A marker may be followed by a name, e.g. @cursor:x and @target:x, to
+// write several cases in one archive.  Each cursor expects the target of
+// the same name, or no definition (a *godef.NotFoundError) if there is
+// none.  Lines of the comment of the archive starting with a directive
+// set other expectations of the case of the same name:
+//
+//	@goroot file        the definition is in the file of GOROOT/src, e.g. fmt/print.go
+//	@kind kind          the Kind of the definition, e.g. function
+//	@description text   the Description of the definition
+//
+// Other lines of the comment are not interpreted.
+//
+// Archives with a go.mod file are queried with a godef.ModuleResolver,
+// unless another Resolver is configured.  Otherwise the directory of the
+// archive is used as GOPATH, so its files should be beneath src.
+//
+// For example:
+//
+//	-- go.mod --
+//	module example.com/m
+//	-- p.go --
+//	package p
+//
+//	func @target F() {}
+//
+//	var _ = @cursor F
+package godeftest
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/charlievieth/godef"
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/txtar"
+)
+
+// OffsetOf returns the byte offset immediately after marker in src, for
+// tests that write their sources inline, e.g. with a /*caret*/ comment
+// before the queried identifier.  Marker must occur exactly once in src.
+func OffsetOf(src []byte, marker string) (int, error) {
+	return span.MarkerOffset(src, marker)
+}
+
+// A Case is a query of an archive and its expected result.
+type Case struct {
+	Name     string         // name of the markers, empty for @cursor and @target
+	Filename string         // absolute name of the queried file
+	Offset   int            // byte offset of the queried identifier
+	Target   godef.Position // expected definition, invalid if there is none
+
+	// The other expectations of the case, set by the directives of
+	// the comment of the archive, if not empty.
+	GOROOTFile  string     // slash-separated name of the file of the definition in GOROOT/src
+	Kind        godef.Kind // kind of the definition
+	Description string     // description of the definition
+}
+
+var (
+	markerRx    = regexp.MustCompile(`@(cursor|target)(:\w+)? ?`)
+	directiveRx = regexp.MustCompile(`^@(goroot|kind|description)(:\w+)? (.+)$`)
+)
+
+// Extract writes the files of ar to dir, with the markers removed, and
+// returns the cases they describe sorted by name.
+func Extract(ar *txtar.Archive, dir string) ([]Case, error) {
+	cursors := make(map[string]godef.Position)
+	targets := make(map[string]godef.Position)
+	for _, f := range ar.Files {
+		filename := filepath.Join(dir, filepath.FromSlash(f.Name))
+		var src []byte
+		line, column := 1, 1
+		data := f.Data
+		for len(data) > 0 {
+			loc := markerRx.FindSubmatchIndex(data)
+			n := len(data)
+			if loc != nil {
+				n = loc[0]
+			}
+			for _, c := range data[:n] {
+				if c == '\n' {
+					line, column = line+1, 1
+				} else {
+					column++
+				}
+			}
+			src = append(src, data[:n]...)
+			if loc == nil {
+				break
+			}
+			kind := string(data[loc[2]:loc[3]])
+			name := ""
+			if loc[4] >= 0 {
+				name = string(data[loc[4]+1 : loc[5]])
+			}
+			pos := godef.Position{Filename: filename, Offset: len(src), Line: line, Column: column}
+			m := cursors
+			if kind == "target" {
+				m = targets
+			}
+			if _, dup := m[name]; dup {
+				return nil, fmt.Errorf("%s: duplicate @%s marker %q", f.Name, kind, name)
+			}
+			m[name] = pos
+			data = data[loc[1]:]
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(filename, src, 0644); err != nil {
+			return nil, err
+		}
+	}
+	for name := range targets {
+		if _, ok := cursors[name]; !ok {
+			return nil, fmt.Errorf("@target marker %q has no @cursor", name)
+		}
+	}
+	directives := make(map[string]map[string]string) // case name => directive => value
+	for _, line := range strings.Split(string(ar.Comment), "\n") {
+		m := directiveRx.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		name := strings.TrimPrefix(m[2], ":")
+		if _, ok := cursors[name]; !ok {
+			return nil, fmt.Errorf("@%s directive %q has no @cursor", m[1], name)
+		}
+		if directives[name] == nil {
+			directives[name] = make(map[string]string)
+		}
+		if _, dup := directives[name][m[1]]; dup {
+			return nil, fmt.Errorf("duplicate @%s directive %q", m[1], name)
+		}
+		directives[name][m[1]] = m[3]
+	}
+	var cases []Case
+	for name, pos := range cursors {
+		d := directives[name]
+		if d["goroot"] != "" && targets[name].IsValid() {
+			return nil, fmt.Errorf("@goroot directive %q has a @target", name)
+		}
+		cases = append(cases, Case{
+			Name:        name,
+			Filename:    pos.Filename,
+			Offset:      pos.Offset,
+			Target:      targets[name],
+			GOROOTFile:  d["goroot"],
+			Kind:        godef.Kind(d["kind"]),
+			Description: d["description"],
+		})
+	}
+	sort.Slice(cases, func(i, j int) bool { return cases[i].Name < cases[j].Name })
+	return cases, nil
+}
+
+// Run runs the cases of the txtar archive filename as subtests of t,
+// querying them with conf, or build.Default if conf.Context is unset.
+func Run(t *testing.T, conf godef.Config, filename string) {
+	ar, err := txtar.ParseFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "godeftest-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases, err := Extract(ar, dir)
+	if err != nil {
+		t.Fatalf("%s: %v", filename, err)
+	}
+	if len(cases) == 0 {
+		t.Fatalf("%s: no @cursor markers", filename)
+	}
+	if conf.Context.GOROOT == "" {
+		conf.Context = build.Default
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+		if conf.Resolver == nil {
+			conf.Resolver = &godef.ModuleResolver{}
+		}
+		conf.Dir = dir
+	} else {
+		conf.Context.GOPATH = dir
+	}
+	for _, c := range cases {
+		c := c
+		name := c.Name
+		if name == "" {
+			name = "cursor"
+		}
+		t.Run(name, func(t *testing.T) {
+			res, err := conf.Lookup(c.Filename, c.Offset, nil)
+			if !c.Target.IsValid() && c.GOROOTFile == "" {
+				var nf *godef.NotFoundError
+				if !errors.As(err, &nf) {
+					t.Errorf("%s: got %v, %v; want no definition", relPos(dir, c.Filename, c.Offset), res, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", relPos(dir, c.Filename, c.Offset), err)
+			}
+			got := res.Position
+			if c.GOROOTFile != "" {
+				want := filepath.Join(conf.Context.GOROOT, "src", filepath.FromSlash(c.GOROOTFile))
+				if got.Filename != want {
+					t.Errorf("%s: got %s; want a definition in %s", relPos(dir, c.Filename, c.Offset), got, want)
+				}
+			} else if got.Filename != c.Target.Filename || got.Line != c.Target.Line || got.Column != c.Target.Column {
+				t.Errorf("%s: got %s; want %s", relPos(dir, c.Filename, c.Offset),
+					relName(dir, got.String()), relName(dir, c.Target.String()))
+			}
+			if c.Kind != "" && res.Kind != c.Kind {
+				t.Errorf("%s: got kind %q; want %q", relPos(dir, c.Filename, c.Offset), res.Kind, c.Kind)
+			}
+			if c.Description != "" && res.Description != c.Description {
+				t.Errorf("%s: got description %q; want %q", relPos(dir, c.Filename, c.Offset), res.Description, c.Description)
+			}
+		})
+	}
+}
+
+// RunDir runs the txtar archives matching pattern (see filepath.Glob)
+// as subtests of t, named by their base names without extension.
+func RunDir(t *testing.T, conf godef.Config, pattern string) {
+	names, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) == 0 {
+		t.Fatalf("no archives match %s", pattern)
+	}
+	for _, name := range names {
+		name := name
+		base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+		t.Run(base, func(t *testing.T) {
+			Run(t, conf, name)
+		})
+	}
+}
+
+func relPos(dir, filename string, offset int) string {
+	return fmt.Sprintf("%s:#%d", relName(dir, filename), offset)
+}
+
+func relName(dir, name string) string {
+	return strings.TrimPrefix(name, dir+string(filepath.Separator))
+}
@@ -0,0 +1,79 @@
+package godeftest
+
+import (
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/charlievieth/godef"
+	"golang.org/x/tools/txtar"
+)
+
+func TestArchives(t *testing.T) {
+	RunDir(t, godef.Config{}, filepath.Join("testdata", "*.txtar"))
+}
+
+func TestExtract(t *testing.T) {
+	ar := txtar.Parse([]byte("-- p.go --\npackage p\n\nvar @target:v é, @cursor:v é = 1, 2\n"))
+	dir := t.TempDir()
+	cases, err := Extract(ar, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "p.go")
+	want := []Case{{
+		Name:     "v",
+		Filename: filename,
+		Offset:   19,
+		Target:   godef.Position{Filename: filename, Offset: 15, Line: 3, Column: 5},
+	}}
+	if !reflect.DeepEqual(cases, want) {
+		t.Errorf("got %+v; want %+v", cases, want)
+	}
+
+	ar = txtar.Parse([]byte("@kind:v variable\n@description:v var v int\n-- p.go --\npackage p\n\nvar v = 1\n\nvar _ = @cursor:v v\n"))
+	cases, err = Extract(ar, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 1 || cases[0].Kind != godef.KindVariable || cases[0].Description != "var v int" {
+		t.Errorf("got %+v; want a case with the kind and description of v", cases)
+	}
+
+	for _, src := range []string{
+		"-- p.go --\n@cursor a @cursor b\n",
+		"-- p.go --\n@cursor:a a @target:b b\n",
+		"@kind:b variable\n-- p.go --\n@cursor:a a\n",
+		"@goroot fmt/print.go\n-- p.go --\n@target a @cursor a\n",
+	} {
+		if _, err := Extract(txtar.Parse([]byte(src)), t.TempDir()); err == nil {
+			t.Errorf("%q: expected an error", src)
+		}
+	}
+}
+
+func TestOffsetOf(t *testing.T) {
+	dir := t.TempDir()
+	src := []byte("package p\n\nfunc F() {}\n\nvar _ = /*caret*/F\n")
+	filename := filepath.Join(dir, "p.go")
+	if err := ioutil.WriteFile(filename, src, 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset, err := OffsetOf(src, "/*caret*/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := godef.Config{Context: build.Default, Dir: dir}
+	res, err := conf.Lookup(filename, offset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Position.Line != 3 || res.Position.Column != 6 {
+		t.Errorf("got %s; want %s:3:6", res.Position, filename)
+	}
+	if _, err := OffsetOf(src, "F"); err == nil {
+		t.Error("OffsetOf: expected an error for a marker that is not unique")
+	}
+}
@@ -0,0 +1,19 @@
+package godef_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/charlievieth/godef"
+	"github.com/charlievieth/godef/godeftest"
+)
+
+func TestArchives(t *testing.T) {
+	godeftest.RunDir(t, godef.Config{}, filepath.Join("godeftest", "testdata", "lookup", "*.txtar"))
+}
+
+// Labels are resolved by the parser, or by the type checker with
+// Describe.
+func TestArchives_Describe(t *testing.T) {
+	godeftest.RunDir(t, godef.Config{Describe: true}, filepath.Join("godeftest", "testdata", "lookup", "labels.txtar"))
+}
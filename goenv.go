@@ -0,0 +1,186 @@
+package godef
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// goEnv is the subset of `go env -json` used to configure a build.Context.
+type goEnv struct {
+	GOROOT      string
+	GOPATH      string
+	GOOS        string
+	GOARCH      string
+	GOFLAGS     string
+	CGO_ENABLED string
+}
+
+// goEnvVars are the variables of the environment of a query that are
+// passed to go env, so that it reports the settings of the query: those
+// it reports, and those that select its configuration file, module mode
+// and toolchain.
+var goEnvVars = []string{
+	"GOROOT", "GOPATH", "GOOS", "GOARCH", "CGO_ENABLED", "GOFLAGS",
+	"GOMODCACHE", "GO111MODULE", "GOWORK", "GOENV", "GOTOOLCHAIN", "HOME",
+}
+
+// A goEnvKey identifies the output of go env: that of the go command
+// run in dir (the working directory of the process if empty) with the
+// variables env, which replace those of the process.
+type goEnvKey struct {
+	gocmd string
+	dir   string
+	env   string // goEnvVars set in the environment, NAME=value separated by NULs
+}
+
+var goEnvCache struct {
+	sync.Mutex
+	m map[goEnvKey]*goEnvCall
+}
+
+// A goEnvCall is a run of go env, which callers of readGoEnv with the
+// same key wait for rather than running go env again.
+type goEnvCall struct {
+	done chan struct{} // closed when env and err are set
+	env  *goEnv
+	err  error
+}
+
+// readGoEnv returns the output of `go env -json` for the go command gocmd
+// run in dir with the goEnvVars of env.  The result is cached for the
+// lifetime of the process, errors are not.  The cache is not locked while
+// go env runs, so that queries of other keys do not wait for it.
+func readGoEnv(gocmd, dir string, env Environment) (*goEnv, error) {
+	vars := goEnvironment(env)
+	key := goEnvKey{gocmd, dir, strings.Join(vars, "\x00")}
+
+	goEnvCache.Lock()
+	if c := goEnvCache.m[key]; c != nil {
+		goEnvCache.Unlock()
+		<-c.done
+		return c.env, c.err
+	}
+	if goEnvCache.m == nil {
+		goEnvCache.m = make(map[goEnvKey]*goEnvCall)
+	}
+	c := &goEnvCall{done: make(chan struct{})}
+	goEnvCache.m[key] = c
+	goEnvCache.Unlock()
+
+	c.env, c.err = runGoEnv(gocmd, dir, vars)
+	if c.err != nil {
+		goEnvCache.Lock()
+		delete(goEnvCache.m, key)
+		goEnvCache.Unlock()
+	}
+	close(c.done)
+	return c.env, c.err
+}
+
+// runGoEnv runs `go env -json` for readGoEnv.
+func runGoEnv(gocmd, dir string, vars []string) (*goEnv, error) {
+	cmd := exec.Command(gocmd, "env", "-json")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), vars...)
+	out, err := cmd.Output()
+	if err != nil {
+		if e, ok := err.(*exec.ExitError); ok && len(e.Stderr) != 0 {
+			return nil, fmt.Errorf("go env: %s", strings.TrimSpace(string(e.Stderr)))
+		}
+		return nil, fmt.Errorf("go env: %v", err)
+	}
+	var goenv goEnv
+	if err := json.Unmarshal(out, &goenv); err != nil {
+		return nil, fmt.Errorf("go env: %v", err)
+	}
+	return &goenv, nil
+}
+
+// goEnvironment returns the goEnvVars set in env, as NAME=value, to be
+// added to the environment of the process for the go command.
+func goEnvironment(env Environment) []string {
+	var vars []string
+	for _, name := range goEnvVars {
+		if v := env.Getenv(name); v != "" {
+			vars = append(vars, name+"="+v)
+		}
+	}
+	return vars
+}
+
+// goCommand returns the go command of ctxt's GOROOT, if it exists,
+// otherwise "go" is looked up in PATH.
+func goCommand(ctxt *build.Context) string {
+	if ctxt.GOROOT != "" {
+		name := filepath.Join(ctxt.GOROOT, "bin", "go")
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return "go"
+}
+
+// GoEnvContext returns a copy of ctxt with the GOROOT, GOPATH, GOOS,
+// GOARCH, CGO_ENABLED and build tags (from GOFLAGS) reported by
+// `go env -json`, which includes settings made with `go env -w`.
+// The output of go env is cached for the lifetime of the process.
+func GoEnvContext(ctxt *build.Context) (*build.Context, error) {
+	return goEnvContext(ctxt, OSEnvironment, "")
+}
+
+// goEnvContext is GoEnvContext for go env run in dir, if not empty,
+// with the environment env (see readGoEnv).
+func goEnvContext(ctxt *build.Context, environ Environment, dir string) (*build.Context, error) {
+	env, err := readGoEnv(goCommand(ctxt), dir, environ)
+	if err != nil {
+		return nil, err
+	}
+	copy := *ctxt // make a copy
+	c := &copy
+	if env.GOROOT != "" {
+		c.GOROOT = env.GOROOT
+	}
+	if env.GOPATH != "" {
+		c.GOPATH = env.GOPATH
+	}
+	if env.GOOS != "" {
+		c.GOOS = env.GOOS
+	}
+	if env.GOARCH != "" {
+		c.GOARCH = env.GOARCH
+	}
+	switch env.CGO_ENABLED {
+	case "0":
+		c.CgoEnabled = false
+	case "1":
+		c.CgoEnabled = true
+	}
+	if tags := goFlagsTags(env.GOFLAGS); len(tags) != 0 {
+		c.BuildTags = append(append([]string(nil), c.BuildTags...), tags...)
+	}
+	return c, nil
+}
+
+// goFlagsTags returns the build tags set by the -tags flag in GOFLAGS.
+func goFlagsTags(goflags string) []string {
+	var tags []string
+	for _, f := range strings.Fields(goflags) {
+		f = strings.TrimPrefix(f, "-")
+		if !strings.HasPrefix(f, "-tags=") && !strings.HasPrefix(f, "tags=") {
+			continue
+		}
+		tags = tags[:0] // last flag wins
+		for _, t := range strings.Split(f[strings.IndexByte(f, '=')+1:], ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
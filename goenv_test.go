@@ -0,0 +1,129 @@
+package godef
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGoFlagsTags(t *testing.T) {
+	tests := []struct {
+		goflags string
+		exp     []string
+	}{
+		{"", nil},
+		{"-mod=mod", nil},
+		{"-tags=a,b", []string{"a", "b"}},
+		{"-mod=mod --tags=integration", []string{"integration"}},
+		{"-tags=a -tags=b,c", []string{"b", "c"}},
+	}
+	for _, x := range tests {
+		tags := goFlagsTags(x.goflags)
+		if !reflect.DeepEqual(tags, x.exp) {
+			t.Errorf("goFlagsTags(%q): exp %q got %q", x.goflags, x.exp, tags)
+		}
+	}
+}
+
+func TestGoEnvContext_Env(t *testing.T) {
+	if _, err := GoEnvContext(&build.Default); err != nil {
+		t.Skipf("go env: %v", err)
+	}
+	// The output of go env is cached per environment.
+	for _, tags := range []string{"a", "b", "a"} {
+		env := &testEnv{env: map[string]string{"GOFLAGS": "-tags=" + tags}}
+		ctxt, err := goEnvContext(&build.Default, env, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{tags}; !reflect.DeepEqual(ctxt.BuildTags, want) {
+			t.Errorf("GOFLAGS=-tags=%s: got build tags %q; want: %q", tags, ctxt.BuildTags, want)
+		}
+	}
+}
+
+func TestGoEnvironment(t *testing.T) {
+	env := &testEnv{env: map[string]string{"GO111MODULE": "on", "GOFLAGS": "-mod=mod", "PATH": "/bin"}}
+	want := []string{"GOFLAGS=-mod=mod", "GO111MODULE=on"}
+	if got := goEnvironment(env); !reflect.DeepEqual(got, want) {
+		t.Errorf("goEnvironment() = %q; want: %q", got, want)
+	}
+}
+
+func TestReadGoEnv_Concurrent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell script")
+	}
+	dir := writeFiles(t, map[string]string{
+		"slow": "#!/bin/sh\necho run >> \"$0.runs\"\n" +
+			"while [ ! -f \"$0.release\" ]; do sleep 0.01; done\necho '{\"GOOS\": \"slow\"}'\n",
+		"fast": "#!/bin/sh\necho '{\"GOOS\": \"fast\"}'\n",
+	})
+	slow, fast := filepath.Join(dir, "slow"), filepath.Join(dir, "fast")
+	for _, name := range []string{slow, fast} {
+		if err := os.Chmod(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := &testEnv{}
+
+	// Callers of the same key share one run of go env.
+	var wg sync.WaitGroup
+	results := make([]string, 4)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if goenv, err := readGoEnv(slow, "", env); err == nil {
+				results[i] = goenv.GOOS
+			} else {
+				results[i] = err.Error()
+			}
+		}(i)
+	}
+	for {
+		if _, err := os.Stat(slow + ".runs"); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Other keys do not wait for it.
+	done := make(chan error, 1)
+	go func() {
+		_, err := readGoEnv(fast, "", env)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("go env of another command waited for a running go env")
+	}
+
+	if err := ioutil.WriteFile(slow+".release", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	for i, goos := range results {
+		if goos != "slow" {
+			t.Errorf("caller %d: got GOOS %q; want: slow", i, goos)
+		}
+	}
+	runs, err := ioutil.ReadFile(slow + ".runs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(runs), "\n"); n != 1 {
+		t.Errorf("go env: got %d runs; want: 1", n)
+	}
+}
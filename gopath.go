@@ -0,0 +1,90 @@
+package godef
+
+import (
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A GOPATHError reports a GOPATH entry that cannot be used, e.g. because
+// it does not exist or cannot be read.
+type GOPATHError struct {
+	Dir string
+	Err error
+}
+
+func (e *GOPATHError) Error() string {
+	return fmt.Sprintf("GOPATH entry %s: %v", e.Dir, e.Err)
+}
+
+func (e *GOPATHError) Unwrap() error { return e.Err }
+
+// checkGOPATH returns gopath without the entries that cannot be used in
+// the file system of ctxt and a *GOPATHError for each of them.  If the
+// GOPATH environment variable is not set, a missing default GOPATH (e.g.
+// $HOME/go) is removed without error.
+func checkGOPATH(ctxt *build.Context, env Environment, gopath string) (string, []error) {
+	var list []string
+	var errs []error
+	skipped := false
+	for _, dir := range filepath.SplitList(gopath) {
+		if dir == "" {
+			continue
+		}
+		err := checkGOPATHEntry(ctxt, dir)
+		if err == nil {
+			list = append(list, dir)
+			continue
+		}
+		skipped = true
+		if os.IsNotExist(err) && env.Getenv("GOPATH") == "" {
+			continue // default GOPATH
+		}
+		errs = append(errs, &GOPATHError{Dir: dir, Err: err})
+	}
+	if !skipped {
+		return gopath, nil
+	}
+	return joinPathList(list), errs
+}
+
+// checkGOPATHEntry returns an error if dir is not an absolute path to a
+// readable directory.
+func checkGOPATHEntry(ctxt *build.Context, dir string) error {
+	if !filepath.IsAbs(dir) {
+		return errors.New("path is relative")
+	}
+	if ctxt.ReadDir != nil {
+		_, err := ctxt.ReadDir(dir)
+		var pe *os.PathError
+		if errors.As(err, &pe) {
+			return pe.Err
+		}
+		return err
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			return pe.Err
+		}
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New("not a directory")
+	}
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		if pe, ok := err.(*os.PathError); ok {
+			return pe.Err
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package godef
+
+import (
+	"errors"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckGOPATH(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	good := filepath.Join(tmp, "good")
+	if err := os.Mkdir(good, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(tmp, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(tmp, "missing")
+	join := func(list ...string) string {
+		return strings.Join(list, string(filepath.ListSeparator))
+	}
+
+	tests := []struct {
+		env    map[string]string
+		gopath string
+		want   string
+		bad    []string
+	}{
+		{nil, good, good, nil},
+		{nil, join(good, missing), good, nil}, // default GOPATH
+		{map[string]string{"GOPATH": "x"}, join(good, missing), good, []string{missing}},
+		{nil, join(file, good), good, []string{file}},
+		{nil, join("rel", good), good, []string{"rel"}},
+	}
+	for _, x := range tests {
+		got, errs := checkGOPATH(&build.Default, &testEnv{env: x.env}, x.gopath)
+		if got != x.want {
+			t.Errorf("checkGOPATH(%q) = %q; want: %q", x.gopath, got, x.want)
+		}
+		var bad []string
+		for _, err := range errs {
+			var e *GOPATHError
+			if !errors.As(err, &e) {
+				t.Fatalf("checkGOPATH(%q): unexpected error: %#v", x.gopath, err)
+			}
+			bad = append(bad, e.Dir)
+		}
+		if strings.Join(bad, ",") != strings.Join(x.bad, ",") {
+			t.Errorf("checkGOPATH(%q): bad entries %q; want: %q", x.gopath, bad, x.bad)
+		}
+	}
+}
+
+func TestLookup_StrictGOPATH(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	filename := filepath.Join(tmp, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(kindSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset := strings.Index(kindSrc, "C, v")
+
+	conf := Config{Context: build.Default}
+	conf.Context.GOPATH = filename // not a directory
+	res, err := conf.Lookup(filename, offset, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e *GOPATHError
+	if len(res.Warnings) == 0 || !errors.As(res.Warnings[0], &e) {
+		t.Errorf("expected GOPATHError warning, got: %v", res.Warnings)
+	}
+
+	conf.StrictGOPATH = true
+	if _, err := conf.Lookup(filename, offset, nil); !errors.As(err, &e) {
+		t.Errorf("expected GOPATHError, got: %v", err)
+	}
+}
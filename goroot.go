@@ -0,0 +1,43 @@
+package godef
+
+import (
+	"go/build"
+	"path/filepath"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// goSourceTree returns the root of the Go source tree (a GOROOT, e.g. a
+// checkout of the Go repository) enclosing filename or "" if there is
+// none.  The root of a Go source tree contains src/go.mod declaring the
+// "std" module or, for older trees, src/runtime and src/cmd/go.  The
+// files are read through ctxt.
+func goSourceTree(ctxt *build.Context, filename string) string {
+	dir := filepath.Dir(filename)
+	for {
+		if filepath.Base(dir) == "src" {
+			root := filepath.Dir(dir)
+			if isGoSourceTree(ctxt, root) {
+				return root
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+func isGoSourceTree(ctxt *build.Context, root string) bool {
+	src := filepath.Join(root, "src")
+	if data, err := readFile(ctxt, filepath.Join(src, "go.mod")); err == nil {
+		return moduleDirective(data) == "std"
+	}
+	for _, name := range []string{"runtime", filepath.Join("cmd", "go")} {
+		if !buildutil.IsDir(ctxt, filepath.Join(src, name)) {
+			return false
+		}
+	}
+	return true
+}
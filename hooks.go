@@ -0,0 +1,74 @@
+package godef
+
+import "context"
+
+// Hooks are callbacks that observe the queries of an Engine, e.g. to
+// export metrics to a telemetry system.  Any of the callbacks may be
+// nil.  They are called by the goroutine running the query and must be
+// safe for concurrent use if the Engine is.
+type Hooks struct {
+	// OnQueryStart is called before a query is run.
+	OnQueryStart func(info QueryInfo)
+
+	// OnQueryEnd is called after a query with its statistics, including
+	// the Strategy that answered it, and the error it returned, if any.
+	OnQueryEnd func(info QueryInfo, stats Stats, err error)
+
+	// StartSpan traces the phases of a query, e.g. as OpenTelemetry
+	// spans.  It is called at the start of the span name, a child of
+	// the span in ctx, and returns the context of the new span and the
+	// function called with the error of the phase, if any, when it
+	// ends.  A query is traced as a span named SpanQuery, whose parent
+	// is the context passed to Query.Run (or context.Background for
+	// the methods of Engine), with a child span for each of the other
+	// phases it runs, some of which may be run more than once.
+	StartSpan func(ctx context.Context, name string, info QueryInfo) (context.Context, func(err error))
+
+	// OnCacheEvent is called when a query looks up its program in
+	// Config.ProgramCache (cache is CacheProgram) or a package in
+	// Config.PackageIndex (CacheIndex), hit reports whether it was
+	// reused.  Lookups in Config.ASTCache are not reported: a query
+	// makes one for each file it parses, from several goroutines, and
+	// Stats.FilesParsed counts the misses.
+	OnCacheEvent func(info QueryInfo, cache string, hit bool)
+}
+
+// The names of the spans passed to Hooks.StartSpan.
+const (
+	SpanQuery = "godef.query" // a query
+	SpanParse = "godef.parse" // parsing the queried file
+	SpanCache = "godef.cache" // looking up the program in Config.ProgramCache
+	SpanLoad  = "godef.load"  // loading, parsing and type-checking the program
+	SpanIndex = "godef.index" // looking up, or indexing, a package in Config.PackageIndex
+)
+
+// The caches passed to Hooks.OnCacheEvent.
+const (
+	CacheProgram = "program" // Config.ProgramCache
+	CacheIndex   = "index"   // Config.PackageIndex
+)
+
+// QueryInfo identifies a query passed to Hooks.
+type QueryInfo struct {
+	Mode       string // "definition", "receivertype", "references", "describe", "decl" or "complete"
+	Filename   string
+	Start, End int // byte offsets of the queried range
+}
+
+// startSpan starts the span name of a phase of q as a child of the
+// span of q, see Hooks.StartSpan, and returns the function that ends
+// it.  It does nothing if q is not traced.
+func (q *Query) startSpan(name string) func(err error) {
+	if q.hooks == nil || q.hooks.StartSpan == nil {
+		return func(error) {}
+	}
+	_, end := q.hooks.StartSpan(q.spanCtx, name, q.info)
+	return end
+}
+
+// cacheEvent reports a lookup of q in cache, see Hooks.OnCacheEvent.
+func (q *Query) cacheEvent(cache string, hit bool) {
+	if q.hooks != nil && q.hooks.OnCacheEvent != nil {
+		q.hooks.OnCacheEvent(q.info, cache, hit)
+	}
+}
@@ -0,0 +1,293 @@
+package godef
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/build"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/buildutil"
+
+	"github.com/charlievieth/godef/internal/span"
+)
+
+// maxRequestSize is the maximum size of the body of an HTTP request,
+// which includes the contents of its overlay.
+const maxRequestSize = 64 << 20
+
+// An httpRequest is the body of a POST /definition request.
+type httpRequest struct {
+	File    string            `json:"file"`
+	Offset  int               `json:"offset"`
+	Overlay map[string]string `json:"overlay,omitempty"`
+}
+
+// An httpError is the body of the response to a failed request.
+type httpError struct {
+	Error string `json:"error"`
+	Code  int    `json:"code,omitempty"` // see ExitCode
+}
+
+// NewHTTPHandler returns an http.Handler that answers definition queries
+// with e, for tools that cannot run godef or link this package.  The
+// body of a POST /definition request is a JSON object:
+//
+//	{"file": "/src/p/p.go", "offset": 42, "overlay": {"/src/p/p.go": "package p ..."}}
+//
+// The overlay, which is optional, maps absolute file names to contents
+// that are used in place of the file on disk for the request, as
+// Config.Overlay.  The response is the JSONResult of the query, as
+// written by the "json" Formatter, including if the definition is not
+// found.  Other errors are reported with a 4xx or 5xx status and an
+// object with the error message and its ExitCode:
+//
+//	{"error": "p.go:1:1: expected 'package', found 'EOF'", "code": 6}
+//
+// A query is abandoned if its client disconnects.
+//
+// The handler answers queries of any file the process can read, use an
+// HTTPHandler with AllowedDirs to restrict them.
+//
+// The handler is the only network front end of the package.  A gRPC
+// service is deliberately not provided: it would make every importer
+// depend on google.golang.org/grpc and google.golang.org/protobuf.  Such
+// a service belongs in its own module, wrapping an Engine as this
+// handler does so that it shares the Engine's caches.
+func NewHTTPHandler(e *Engine) http.Handler {
+	return &HTTPHandler{Engine: e}
+}
+
+// An HTTPHandler is the http.Handler returned by NewHTTPHandler.
+type HTTPHandler struct {
+	Engine *Engine
+
+	// AllowedDirs, if not empty, restricts the files of queries and
+	// overlays to those beneath the directories, e.g. workspace roots,
+	// or beneath GOROOT and the module cache of the Config answering
+	// the query.  Symbolic links are followed before files are checked,
+	// and requests of other files are rejected with 403 Forbidden.
+	// Queries do not read other files either, e.g. the dependencies of
+	// a go.mod file with a replace directive, although the go command
+	// run by the "packages" backend may list them.
+	AllowedDirs []string
+}
+
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/definition":
+		h.serveDefinition(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (h *HTTPHandler) serveDefinition(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeHTTPError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	var req httpRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if req.File == "" || req.Offset < 0 {
+		writeHTTPError(w, http.StatusBadRequest, errors.New("invalid request: file and a non-negative offset are required"))
+		return
+	}
+	overlay := make(map[string][]byte, len(req.Overlay))
+	for name, src := range req.Overlay {
+		if !filepath.IsAbs(name) {
+			writeHTTPError(w, http.StatusBadRequest, fmt.Errorf("invalid request: overlay file %q is not absolute", name))
+			return
+		}
+		overlay[name] = []byte(src)
+	}
+	if len(h.AllowedDirs) != 0 {
+		names := []string{req.File}
+		for name := range overlay {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			if !h.allowed(name) {
+				writeHTTPError(w, http.StatusForbidden, fmt.Errorf("file %s is not in an allowed directory", name))
+				return
+			}
+		}
+	}
+
+	q := &Query{
+		Mode:   "definition",
+		Pos:    span.New(req.File, req.Offset).String(),
+		Engine: h.engine(overlay),
+	}
+	qres, err := q.Run(r.Context())
+	var nf *NotFoundError
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, FormatJSON(qres.(*Result)))
+	case errors.As(err, &nf):
+		writeJSON(w, http.StatusOK, FormatJSON(&Result{Reason: nf.Error()}))
+	case errors.Is(err, context.Canceled):
+		// The client is gone.
+	default:
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			status = http.StatusNotFound
+		case ExitCode(err) == ExitParseError, ExitCode(err) == ExitUsage:
+			status = http.StatusBadRequest
+		case ExitCode(err) == ExitTimeout:
+			status = http.StatusGatewayTimeout
+		}
+		writeHTTPError(w, status, err)
+	}
+}
+
+// allowed reports whether filename is beneath h.AllowedDirs, or the
+// GOROOT or module cache of the Config of its queries.
+func (h *HTTPHandler) allowed(filename string) bool {
+	c := h.Engine.configFor(filename)
+	return inDirs(c.env(), filename, h.allowedDirs(c))
+}
+
+// allowedDirs returns the directories of the files that the queries of
+// c may read: h.AllowedDirs and the GOROOT and module cache of c, with
+// symbolic links evaluated.
+func (h *HTTPHandler) allowedDirs(c *Config) []string {
+	dirs := h.AllowedDirs
+	if ctxt, err := c.buildContext(); err == nil {
+		dirs = append(append([]string(nil), dirs...), ctxt.GOROOT)
+		if modcache := modCacheDir(ctxt, c.env()); modcache != "" {
+			dirs = append(dirs, modcache)
+		}
+	}
+	var list []string
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		if dir, err := absPath(c.env(), dir); err == nil {
+			list = append(list, realPath(dir))
+		}
+	}
+	return list
+}
+
+// inDirs reports whether filename, whose symbolic links are evaluated,
+// is beneath one of dirs.
+func inDirs(env Environment, filename string, dirs []string) bool {
+	abs, err := absPath(env, filename)
+	if err != nil {
+		return false
+	}
+	abs = realPath(abs)
+	for _, dir := range dirs {
+		if _, ok := hasFilePathPrefix(abs, dir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// engine returns the Engine answering a request with overlay: h.Engine
+// whose Configs also use the files of overlay and, if h.AllowedDirs is
+// set, only read the files of the allowed directories.
+func (h *HTTPHandler) engine(overlay map[string][]byte) *Engine {
+	e := h.Engine.withOverlay(overlay)
+	if len(h.AllowedDirs) == 0 {
+		return e
+	}
+	return e.withConfigs(func(c Config) Config {
+		env, dirs := c.env(), h.allowedDirs(&c)
+		c.Context = restrictContext(&c.Context, func(name string) bool {
+			return inDirs(env, name, dirs)
+		})
+		return c
+	})
+}
+
+// restrictContext returns a copy of ctxt that fails to read the files
+// and directories for which allowed returns false, as if they did not
+// exist.
+func restrictContext(ctxt *build.Context, allowed func(name string) bool) build.Context {
+	orig := *ctxt // make a copy
+	restricted := orig
+	restricted.OpenFile = func(name string) (io.ReadCloser, error) {
+		if !allowed(name) {
+			return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+		}
+		return buildutil.OpenFile(&orig, name)
+	}
+	restricted.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		if !allowed(dir) {
+			return nil, &os.PathError{Op: "open", Path: dir, Err: os.ErrNotExist}
+		}
+		return buildutil.ReadDir(&orig, dir)
+	}
+	restricted.IsDir = func(name string) bool {
+		return allowed(name) && buildutil.IsDir(&orig, name)
+	}
+	return restricted
+}
+
+// realPath returns filename with symbolic links evaluated, those of its
+// directory if it does not exist, e.g. a new file of an overlay.
+func realPath(filename string) string {
+	if path, err := filepath.EvalSymlinks(filename); err == nil {
+		return path
+	}
+	if dir, err := filepath.EvalSymlinks(filepath.Dir(filename)); err == nil {
+		return filepath.Join(dir, filepath.Base(filename))
+	}
+	return filepath.Clean(filename)
+}
+
+// withOverlay returns a copy of e, including its roots, whose Configs
+// also use the files of overlay, or e if overlay is empty.
+func (e *Engine) withOverlay(overlay map[string][]byte) *Engine {
+	if len(overlay) == 0 {
+		return e
+	}
+	return e.withConfigs(func(c Config) Config {
+		m := make(map[string][]byte, len(c.Overlay)+len(overlay))
+		for name, src := range c.Overlay {
+			m[name] = src
+		}
+		for name, src := range overlay {
+			m[name] = src
+		}
+		c.Overlay = m
+		return c
+	})
+}
+
+// withConfigs returns a copy of e, including its roots, whose Configs
+// are those of e updated by update.
+func (e *Engine) withConfigs(update func(c Config) Config) *Engine {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	copy := &Engine{conf: update(e.conf), ctx: e.ctx}
+	for _, r := range e.roots {
+		copy.roots = append(copy.roots, engineRoot{dir: r.dir, conf: update(r.conf)})
+	}
+	return copy
+}
+
+func writeHTTPError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, &httpError{Error: err.Error(), Code: ExitCode(err)})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,217 @@
+package godef
+
+import (
+	"encoding/json"
+	"go/build"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHTTPHandler(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	const src = "package p\n\ntype T struct{ X int }\n\nfunc F(t T) int {\n\treturn t.X + 1\n}\n"
+	const modified = "package p\n\ntype T struct {\n\tX int\n}\n\nfunc F(t T) int {\n\treturn t.X + 1\n}\n"
+	filename := filepath.Join(tmp, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(NewHTTPHandler(NewEngine(&Config{Context: build.Default})))
+	defer srv.Close()
+
+	request := func(t *testing.T, method, body string, status int, v interface{}) {
+		t.Helper()
+		req, err := http.NewRequest(method, srv.URL+"/definition", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if res.StatusCode != status {
+			t.Errorf("got status %d; want: %d", res.StatusCode, status)
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("got Content-Type %q; want: application/json", ct)
+		}
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(file string, offset int, overlay map[string]string) string {
+		b, err := json.Marshal(&httpRequest{File: file, Offset: offset, Overlay: overlay})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	tests := []struct {
+		name string
+		body string
+		line int // of the definition, 0 if not found
+	}{
+		{"Disk", query(filename, strings.Index(src, "X +"), nil), 3},
+		{"Overlay", query(filename, strings.Index(modified, "X +"), map[string]string{filename: modified}), 4},
+		{"NotFound", query(filename, strings.Index(src, " + 1"), nil), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res JSONResult
+			request(t, http.MethodPost, test.body, http.StatusOK, &res)
+			if res.Found != (test.line != 0) || res.Line != test.line {
+				t.Errorf("got found: %t line: %d; want: line %d", res.Found, res.Line, test.line)
+			}
+			if test.line == 0 && res.Reason == "" {
+				t.Error("no reason for a result that was not found")
+			}
+			if test.line != 0 && res.Filename != filepath.ToSlash(filename) {
+				t.Errorf("got file %s; want: %s", res.Filename, filename)
+			}
+		})
+	}
+
+	errorTests := []struct {
+		name   string
+		method string
+		body   string
+		status int
+		code   int
+	}{
+		{"Method", http.MethodGet, "", http.StatusMethodNotAllowed, ExitError},
+		{"JSON", http.MethodPost, "{", http.StatusBadRequest, ExitError},
+		{"UnknownField", http.MethodPost, `{"filename": "p.go"}`, http.StatusBadRequest, ExitError},
+		{"NoFile", http.MethodPost, `{"offset": 1}`, http.StatusBadRequest, ExitError},
+		{"RelativeOverlay", http.MethodPost, query(filename, 0, map[string]string{"p.go": src}), http.StatusBadRequest, ExitError},
+		{"Missing", http.MethodPost, query(filepath.Join(tmp, "q.go"), 0, nil), http.StatusNotFound, ExitError},
+		{"NotGoFile", http.MethodPost, query(filepath.Join(tmp, "p.txt"), 0, map[string]string{filepath.Join(tmp, "p.txt"): "not Go"}), http.StatusBadRequest, ExitParseError},
+		{"PastEOF", http.MethodPost, query(filename, len(src)+1, nil), http.StatusBadRequest, ExitUsage},
+	}
+	for _, test := range errorTests {
+		t.Run(test.name, func(t *testing.T) {
+			var res httpError
+			request(t, test.method, test.body, test.status, &res)
+			if res.Error == "" || res.Code != test.code {
+				t.Errorf("got error %q code: %d; want code: %d", res.Error, res.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestHTTPHandler_AllowedDirs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	const src = "package p\n\nvar V int\n\nvar _ = V\n"
+	allowed := filepath.Join(tmp, "allowed")
+	secret := filepath.Join(tmp, "secret")
+	for _, dir := range []string{allowed, secret} {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "p.go"), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(secret, "p.go"), filepath.Join(allowed, "link.go")); err != nil {
+		t.Skip(err)
+	}
+	goroot := filepath.Join(build.Default.GOROOT, "src", "strings", "strings.go")
+
+	h := &HTTPHandler{
+		Engine:      NewEngine(&Config{Context: build.Default}),
+		AllowedDirs: []string{allowed},
+	}
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		file    string
+		overlay map[string]string
+		status  int
+	}{
+		{"Allowed", filepath.Join(allowed, "p.go"), nil, http.StatusOK},
+		{"NewOverlayFile", filepath.Join(allowed, "q.go"), map[string]string{filepath.Join(allowed, "q.go"): src}, http.StatusOK},
+		{"GOROOT", goroot, nil, http.StatusOK},
+		{"Outside", filepath.Join(secret, "p.go"), nil, http.StatusForbidden},
+		{"Symlink", filepath.Join(allowed, "link.go"), nil, http.StatusForbidden},
+		{"DotDot", filepath.Join(allowed, "..", "secret", "p.go"), nil, http.StatusForbidden},
+		{"Overlay", filepath.Join(allowed, "p.go"), map[string]string{filepath.Join(secret, "p.go"): src}, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.name == "GOROOT" {
+				if _, err := os.Stat(goroot); err != nil {
+					t.Skip(err)
+				}
+			}
+			b, err := json.Marshal(&httpRequest{File: test.file, Offset: strings.LastIndex(src, "V"), Overlay: test.overlay})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.Post(srv.URL+"/definition", "application/json", strings.NewReader(string(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			if res.StatusCode != test.status {
+				t.Errorf("got status %d; want: %d", res.StatusCode, test.status)
+			}
+		})
+	}
+
+	// The dependencies of the queried file are not read outside of the
+	// allowed directories either, e.g. those of a replace directive.
+	const rSrc = "package r\n\nimport \"example.com/s\"\n\nvar _ = p.V\n"
+	rfile := filepath.Join(allowed, "r", "r.go")
+	if err := os.Mkdir(filepath.Dir(rfile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range map[string]string{
+		filepath.Join(secret, "go.mod"):       "module example.com/s\n",
+		filepath.Join(allowed, "r", "go.mod"): "module example.com/r\n\nrequire example.com/s v0.0.0\n\nreplace example.com/s => " + secret + "\n",
+	} {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := json.Marshal(&httpRequest{File: rfile, Offset: strings.LastIndex(rSrc, "V"), Overlay: map[string]string{rfile: rSrc}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.Post(srv.URL+"/definition", "application/json", strings.NewReader(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out JSONResult
+	err = json.NewDecoder(res.Body).Decode(&out)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hasFilePathPrefix(filepath.FromSlash(out.Filename), realPath(secret)); ok || out.Found {
+		t.Errorf("Replace: got %s (found: %t); want the definition not to be found", out.Filename, out.Found)
+	}
+
+	res, err = http.Get(srv.URL + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("/other: got status %d; want: %d", res.StatusCode, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,172 @@
+package godef
+
+import (
+	"fmt"
+	"go/build"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// An ImplementationScope is the set of packages that are searched for
+// the implementations of an interface method called through an
+// interface value (see Config.Implementations).
+type ImplementationScope int
+
+const (
+	// ImplementationsNone does not search for implementations.
+	ImplementationsNone ImplementationScope = iota
+
+	// ImplementationsPackage searches the package of the query.
+	ImplementationsPackage
+
+	// ImplementationsModule searches the packages of the module
+	// containing the query, which are all loaded and type-checked.
+	// It is the same as ImplementationsPackage outside of a module.
+	ImplementationsModule
+)
+
+var implementationScopeNames = [...]string{
+	ImplementationsNone:    "none",
+	ImplementationsPackage: "package",
+	ImplementationsModule:  "module",
+}
+
+func (s ImplementationScope) String() string {
+	if 0 <= int(s) && int(s) < len(implementationScopeNames) {
+		return implementationScopeNames[s]
+	}
+	return fmt.Sprintf("ImplementationScope(%d)", int(s))
+}
+
+// ParseImplementationScope returns the ImplementationScope named s,
+// which is one of "none", "package" or "module".
+func ParseImplementationScope(s string) (ImplementationScope, error) {
+	for i, name := range implementationScopeNames {
+		if s == name {
+			return ImplementationScope(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid implementation scope: %q", s)
+}
+
+// addImplementations adds the concrete methods that may be called by the
+// interface method call x.f at the query position, whose method is
+// obj, to the result of q.  They are the methods f of the named types
+// of the packages in the scope of q.implementations that implement the
+// type of x.
+func addImplementations(q *Query, lprog program, qpos *queryPos, obj types.Object) {
+	fn, ok := obj.(*types.Func)
+	if !ok || q.implementations == ImplementationsNone {
+		return
+	}
+	sel := enclosingSelector(qpos.path)
+	if sel == nil || len(qpos.path) == 0 || qpos.path[0] != sel.Sel {
+		return
+	}
+	s := qpos.info.Selections[sel]
+	if s == nil || s.Kind() != types.MethodVal {
+		return
+	}
+	iface, ok := s.Recv().Underlying().(*types.Interface)
+	if !ok {
+		return
+	}
+
+	pkgs := []*types.Package{qpos.info.Pkg}
+	if q.implementations == ImplementationsModule {
+		if modpath, _ := queryModule(q); modpath != "" {
+			pkgs = nil
+			for _, pkg := range lprog.Packages() {
+				if path := pkg.Path(); path == modpath || strings.HasPrefix(path, modpath+"/") {
+					pkgs = append(pkgs, pkg)
+				}
+			}
+		}
+	}
+
+	reason := "implements " + qpos.typeString(s.Recv())
+	seen := make(map[string]bool) // positions, the test variants of packages declare the same methods
+	for _, pkg := range pkgs {
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			tn, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok || tn.IsAlias() || types.IsInterface(tn.Type()) {
+				continue
+			}
+			T := tn.Type()
+			if !types.Implements(T, iface) && !types.Implements(types.NewPointer(T), iface) {
+				continue
+			}
+			m, _, _ := types.LookupFieldOrMethod(T, true, fn.Pkg(), fn.Name())
+			if m == nil {
+				continue
+			}
+			pos := lprog.Fset().Position(m.Pos()).String()
+			if seen[pos] {
+				continue
+			}
+			seen[pos] = true
+			q.result.candidates = append(q.result.candidates, definitionResult{
+				pos:    q.objectPos(lprog, m),
+				descr:  qpos.objectString(m),
+				kind:   KindMethod,
+				id:     objectID(m),
+				reason: reason,
+			})
+		}
+	}
+}
+
+// queryModule returns the path and root directory of the module
+// containing the queried file, or empty strings if it is not in a
+// module.
+func queryModule(q *Query) (modpath, root string) {
+	sp, err := span.Parse(q.Pos)
+	if err != nil {
+		return "", ""
+	}
+	filename, err := absPath(q.env(), sp.Filename)
+	if err != nil {
+		return "", ""
+	}
+	gomod, data := findGoMod(q.Build, filepath.Dir(filename))
+	if gomod == "" {
+		return "", ""
+	}
+	return moduleDirective(data), filepath.Dir(gomod)
+}
+
+// packageDirs returns the directories beneath root, including root,
+// that contain the Go files of a package of ctxt.  Testdata and vendor
+// directories and those ignored by the go command are excluded, as are
+// nested modules if root is the root of a module.
+func packageDirs(ctxt *build.Context, root string, module bool) []string {
+	var dirs []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		if _, err := ctxt.ImportDir(dir, 0); err == nil {
+			dirs = append(dirs, dir)
+		}
+		fis, err := buildutil.ReadDir(ctxt, dir)
+		if err != nil {
+			return
+		}
+		for _, fi := range fis {
+			name := fi.Name()
+			if !fi.IsDir() || name == "testdata" || name == "vendor" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				continue
+			}
+			path := filepath.Join(dir, name)
+			if module && buildutil.FileExists(ctxt, filepath.Join(path, "go.mod")) {
+				continue
+			}
+			walk(path)
+		}
+	}
+	walk(root)
+	return dirs
+}
@@ -0,0 +1,352 @@
+package godef
+
+import (
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// DefaultPackageIndexSize is the number of packages kept by a
+// PackageIndex created with a size of zero.
+const DefaultPackageIndexSize = 64
+
+// A PackageIndex reuses the package-level declarations of the packages
+// that are scanned to find the definition of a qualified identifier,
+// e.g. os.Open, so that later queries of the members of a package do
+// not parse its files again.  The index of a package is reused while
+// the listing of its directory, including the sizes and modification
+// times of the files, is unchanged, so using it does not read the files
+// of the package.  Only the files whose contents the listing does not
+// reflect, those of Config.Overlay, the queried file and files listed
+// without a modification time, are compared by the hash of their
+// contents.
+//
+// A PackageIndex may be shared by Engines and used by multiple
+// goroutines simultaneously.
+type PackageIndex struct {
+	mu    sync.Mutex
+	size  int
+	clock uint64 // incremented on each use of an entry
+	pkgs  map[string]*packageIndex
+}
+
+// A packageIndex is the index of the declarations of a package.
+type packageIndex struct {
+	listing string                       // see dirListing
+	files   []string                     // Go files of the package
+	hashes  map[string][sha256.Size]byte // file name => hash of contents, see Query.contentFiles
+	members map[string]indexedMember
+	used    uint64
+}
+
+// An indexedMember is a package-level declaration.
+type indexedMember struct {
+	tok      token.Token
+	filename string
+	offset   int
+}
+
+// NewPackageIndex returns a PackageIndex that keeps the size most
+// recently used packages, or DefaultPackageIndexSize if size is zero.
+func NewPackageIndex(size int) *PackageIndex {
+	if size <= 0 {
+		size = DefaultPackageIndexSize
+	}
+	return &PackageIndex{size: size, pkgs: make(map[string]*packageIndex)}
+}
+
+// Len returns the number of packages in the index.
+func (x *PackageIndex) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return len(x.pkgs)
+}
+
+// findMember is findPackageMember using the index of pkg, imported
+// from srcdir.  The file of the declaration is added to fset.
+func (x *PackageIndex) findMember(q *Query, fset *token.FileSet, srcdir, pkg, member string) (token.Token, token.Pos, error) {
+	// Only the directory of the package is needed to validate its
+	// index, finding its files requires reading all of them.
+	bp, err := q.Build.Import(pkg, srcdir, build.FindOnly)
+	if err != nil {
+		return 0, token.NoPos, err // no files for package
+	}
+	pi, err := x.get(q, bp.Dir)
+	if err != nil {
+		return 0, token.NoPos, err // no files for package
+	}
+	m, ok := pi.members[member]
+	if !ok {
+		return 0, token.NoPos, memberNotFound(pkg, member)
+	}
+	return m.position(q.Build, fset)
+}
+
+// position returns the token and position of m.  The file of the
+// declaration is read in ctxt and added to fset.
+func (m indexedMember) position(ctxt *build.Context, fset *token.FileSet) (token.Token, token.Pos, error) {
+	src, err := readFile(ctxt, m.filename)
+	if err != nil {
+		return 0, token.NoPos, err
+	}
+	if m.offset > len(src) {
+		return 0, token.NoPos, fmt.Errorf("%s: file changed while indexing", m.filename)
+	}
+	tf := fset.AddFile(m.filename, -1, len(src))
+	tf.SetLinesForContent(src)
+	return m.tok, tf.Pos(m.offset), nil
+}
+
+// get returns the index of the package in dir, indexing it if it is not
+// in x or has changed.
+func (x *PackageIndex) get(q *Query, dir string) (_ *packageIndex, err error) {
+	end := q.startSpan(SpanIndex)
+	defer func() { end(err) }()
+
+	ctxt := q.Build
+	key := fmt.Sprintf("%s\x00%s/%s\x00%t\x00%q\x00%q", dir, ctxt.GOOS, ctxt.GOARCH,
+		ctxt.CgoEnabled, ctxt.BuildTags, ctxt.ReleaseTags)
+	listing := dirListing(ctxt, dir)
+
+	x.mu.Lock()
+	pi := x.pkgs[key]
+	x.mu.Unlock()
+	if pi != nil && pi.valid(q, listing) {
+		x.mu.Lock()
+		x.clock++
+		pi.used = x.clock
+		x.mu.Unlock()
+		q.logf("reused index of package in %s", dir)
+		q.cacheEvent(CacheIndex, true)
+		return pi, nil
+	}
+	q.cacheEvent(CacheIndex, false)
+
+	bp, err := ctxt.ImportDir(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	pi = &packageIndex{
+		listing: listing,
+		members: make(map[string]indexedMember),
+	}
+	for _, name := range bp.GoFiles {
+		pi.files = append(pi.files, filepath.Join(bp.Dir, name))
+	}
+	pi.hashes = fileHashes(ctxt, q.contentFiles(pi.files))
+	fset := token.NewFileSet()
+	for _, f := range parseFiles(fset, ctxt, nil, pi.files, parser.Mode(0), q.parseWorkers) {
+		filename := fset.File(f.Pos()).Name()
+		packageDecls(f, func(tok token.Token, id *ast.Ident) bool {
+			// The first declaration in the order of the files is
+			// reported, as by findPackageMember.
+			if _, ok := pi.members[id.Name]; !ok {
+				pi.members[id.Name] = indexedMember{tok, filename, fset.Position(id.Pos()).Offset}
+			}
+			return true
+		})
+	}
+	q.logf("indexed package %q", bp.ImportPath)
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.clock++
+	pi.used = x.clock
+	x.pkgs[key] = pi
+	x.trimLocked(x.size)
+	return pi, nil
+}
+
+// trim evicts the least recently used packages until n are left.
+func (x *PackageIndex) trim(n int) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.trimLocked(n)
+}
+
+// trimLocked is trim with x.mu held.
+func (x *PackageIndex) trimLocked(n int) {
+	for len(x.pkgs) > n {
+		var oldest string
+		for k, pi := range x.pkgs {
+			if oldest == "" || pi.used < x.pkgs[oldest].used {
+				oldest = k
+			}
+		}
+		delete(x.pkgs, oldest)
+	}
+}
+
+// valid reports whether the package of pi is unchanged for q, listing
+// is the current listing of its directory.  Only the files whose
+// contents the listing does not reflect are read.
+func (pi *packageIndex) valid(q *Query, listing string) bool {
+	if pi.listing != listing {
+		return false
+	}
+	hashed := q.contentFiles(pi.files)
+	if len(hashed) == 0 && len(pi.hashes) == 0 {
+		return true // the files are compared by the listing
+	}
+	return sameHashes(pi.hashes, fileHashes(q.Build, hashed))
+}
+
+// fileHashes returns the hashes of the contents of the named files in
+// ctxt, files that cannot be read are omitted.
+func fileHashes(ctxt *build.Context, filenames []string) map[string][sha256.Size]byte {
+	hashes := make(map[string][sha256.Size]byte, len(filenames))
+	for _, name := range filenames {
+		if h, err := hashFile(ctxt, name); err == nil {
+			hashes[name] = h
+		}
+	}
+	return hashes
+}
+
+// dirListing returns the names, sizes and modification times of the
+// files of dir in ctxt, one per line.
+func dirListing(ctxt *build.Context, dir string) string {
+	fis, err := buildutil.ReadDir(ctxt, dir)
+	if err != nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			fmt.Fprintf(&b, "%s %d %d\n", fi.Name(), fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+	return b.String()
+}
+
+// sameHashes reports whether x and y contain the same file hashes.
+func sameHashes(x, y map[string][sha256.Size]byte) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for name, h := range x {
+		if hy, ok := y[name]; !ok || hy != h {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFormat is the version of the format written by PackageIndex.Save.
+const indexFormat = 1
+
+// A savedIndex is a PackageIndex as written by Save.
+type savedIndex struct {
+	Format   int
+	Packages []savedPackage // least recently used first
+}
+
+type savedPackage struct {
+	Key     string
+	Listing string
+	Files   []string
+	Hashes  map[string][sha256.Size]byte
+	Members map[string]savedMember
+}
+
+type savedMember struct {
+	Tok      token.Token
+	Filename string
+	Offset   int
+}
+
+// Save writes the packages of x to w, to be restored by Load, e.g. by a
+// later run of the program.
+func (x *PackageIndex) Save(w io.Writer) error {
+	// The entries are immutable, except for their use, which is copied
+	// while x is locked.
+	type entry struct {
+		key  string
+		pi   *packageIndex
+		used uint64
+	}
+	x.mu.Lock()
+	pkgs := make([]entry, 0, len(x.pkgs))
+	for key, pi := range x.pkgs {
+		pkgs = append(pkgs, entry{key, pi, pi.used})
+	}
+	x.mu.Unlock()
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].used < pkgs[j].used })
+
+	saved := savedIndex{Format: indexFormat}
+	for _, e := range pkgs {
+		pi := e.pi
+		sp := savedPackage{
+			Key:     e.key,
+			Listing: pi.listing,
+			Files:   pi.files,
+			Hashes:  pi.hashes,
+			Members: make(map[string]savedMember, len(pi.members)),
+		}
+		for name, m := range pi.members {
+			sp.Members[name] = savedMember{m.tok, m.filename, m.offset}
+		}
+		saved.Packages = append(saved.Packages, sp)
+	}
+	return gob.NewEncoder(w).Encode(&saved)
+}
+
+// Load adds the packages saved by Save to x and returns the number of
+// packages added.  Packages already in x are not added.  The saved
+// packages are not compared with their directories until they are used,
+// in the build context of the query, which may differ from that of the
+// program loading the index; changed packages are then indexed again.
+func (x *PackageIndex) Load(r io.Reader) (int, error) {
+	var saved savedIndex
+	if err := gob.NewDecoder(r).Decode(&saved); err != nil {
+		return 0, fmt.Errorf("reading package index: %w", err)
+	}
+	if saved.Format != indexFormat {
+		return 0, fmt.Errorf("reading package index: unsupported format %d", saved.Format)
+	}
+	var pkgs []*packageIndex
+	var keys []string
+	for _, sp := range saved.Packages {
+		i := strings.IndexByte(sp.Key, 0)
+		if i < 0 || !filepath.IsAbs(sp.Key[:i]) {
+			return 0, fmt.Errorf("reading package index: invalid key %q", sp.Key)
+		}
+		pi := &packageIndex{
+			listing: sp.Listing,
+			files:   sp.Files,
+			hashes:  sp.Hashes,
+			members: make(map[string]indexedMember, len(sp.Members)),
+		}
+		for name, m := range sp.Members {
+			pi.members[name] = indexedMember{m.Tok, m.Filename, m.Offset}
+		}
+		pkgs = append(pkgs, pi)
+		keys = append(keys, sp.Key)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	n := 0
+	for i, pi := range pkgs {
+		if _, ok := x.pkgs[keys[i]]; ok {
+			continue
+		}
+		x.clock++
+		pi.used = x.clock
+		x.pkgs[keys[i]] = pi
+		n++
+	}
+	x.trimLocked(x.size)
+	return n, nil
+}
@@ -0,0 +1,260 @@
+package godef
+
+import (
+	"bytes"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackageIndex(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pSrc = "package p\n\nimport \"q\"\n\nvar _ = q.F\n"
+	pfile := filepath.Join(tmp, "src", "p", "p.go")
+	qfile := filepath.Join(tmp, "src", "q", "q.go")
+	write := func(name, src string) {
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{pfile, qfile} {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(pfile, pSrc)
+	write(qfile, "package q\n\nfunc F() {}\n")
+
+	ctxt := build.Default
+	ctxt.GOPATH = tmp
+	index := NewPackageIndex(0)
+	var logger testLogger
+	conf := Config{
+		Context:      ctxt,
+		PackageIndex: index,
+		Logger:       &logger,
+	}
+	lookup := func(reused bool, line int) {
+		t.Helper()
+		logger.msgs = nil
+		res, err := conf.Lookup(pfile, strings.Index(pSrc, "F"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Position.Filename != qfile || res.Position.Line != line {
+			t.Errorf("got position %s; want: %s:%d", res.Position, qfile, line)
+		}
+		if got := strings.Contains(strings.Join(logger.msgs, "\n"), "reused index"); got != reused {
+			t.Errorf("reused index: %t; want: %t", got, reused)
+		}
+	}
+
+	lookup(false, 3)
+	lookup(true, 3)
+
+	// Changed file
+	write(qfile, "package q\n\n// F is a function.\nfunc F() {}\n")
+	lookup(false, 4)
+	lookup(true, 4)
+
+	// Added file
+	write(filepath.Join(filepath.Dir(qfile), "a.go"), "package q\n\nvar V = 1\n")
+	lookup(false, 4)
+	lookup(true, 4)
+
+	// Overlay
+	conf.Overlay = map[string][]byte{qfile: []byte("package q\n\n\n\nfunc F() {}\n")}
+	lookup(false, 5)
+	lookup(true, 5)
+
+	if n := index.Len(); n != 1 {
+		t.Errorf("got %d indexed packages; want: 1", n)
+	}
+
+	// A reused index is validated by the listing of the directory, the
+	// other files of the package are not read.
+	conf.Overlay = nil
+	lookup(false, 4)
+	opened := make(map[string]int)
+	conf.Context.OpenFile = func(name string) (io.ReadCloser, error) {
+		opened[name]++
+		return os.Open(name)
+	}
+	lookup(true, 4)
+	afile := filepath.Join(filepath.Dir(qfile), "a.go")
+	if n := opened[afile]; n != 0 {
+		t.Errorf("reused index: read a.go %d times; want: 0", n)
+	}
+}
+
+func TestConfig_Warm(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pSrc = "package p\n\nimport \"q\"\n\nvar _ = q.F\n"
+	files := map[string]string{
+		"src/p/p.go":          pSrc,
+		"src/q/q.go":          "package q\n\nfunc F() {}\n",
+		"src/q/r/r.go":        "package r\n",
+		"src/q/testdata/x.go": "package x\n",
+		"src/q/_skip/y.go":    "package y\n",
+	}
+	for name, src := range files {
+		name = filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctxt := build.Default
+	ctxt.GOPATH = tmp
+	var logger testLogger
+	conf := Config{
+		Context:      ctxt,
+		PackageIndex: NewPackageIndex(0),
+		ASTCache:     NewASTCache(0),
+		Logger:       &logger,
+	}
+	n, err := conf.Warm([]string{filepath.Join(tmp, "src", "p"), filepath.Join(tmp, "src", "q") + "/..."})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("warmed %d packages; want: 3", n)
+	}
+	if n := conf.PackageIndex.Len(); n != 3 {
+		t.Errorf("PackageIndex.Len() = %d; want: 3", n)
+	}
+	if n := conf.ASTCache.Len(); n != 3 {
+		t.Errorf("ASTCache.Len() = %d; want: 3", n)
+	}
+
+	logger.msgs = nil
+	pfile := filepath.Join(tmp, "src", "p", "p.go")
+	if _, err := conf.Lookup(pfile, strings.Index(pSrc, "F"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(strings.Join(logger.msgs, "\n"), "reused index") {
+		t.Errorf("the first query after Warm did not reuse the package index:\n%s", strings.Join(logger.msgs, "\n"))
+	}
+
+	if _, err := conf.Warm([]string{filepath.Join(tmp, "missing")}); err != nil {
+		t.Errorf("missing directories should be skipped: %v", err)
+	}
+}
+
+func TestPackageIndex_SaveLoad(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const pSrc = "package p\n\nimport \"q\"\n\nvar _ = q.F\n"
+	pfile := filepath.Join(tmp, "src", "p", "p.go")
+	qfile := filepath.Join(tmp, "src", "q", "q.go")
+	rfile := filepath.Join(tmp, "src", "r", "r.go")
+	write := func(name, src string) {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(pfile, pSrc)
+	write(qfile, "package q\n\nfunc F() {}\n")
+	write(rfile, "package r\n")
+
+	ctxt := build.Default
+	ctxt.GOPATH = tmp
+	var logger testLogger
+	conf := Config{
+		Context:      ctxt,
+		PackageIndex: NewPackageIndex(0),
+		Logger:       &logger,
+	}
+	if _, err := conf.Warm([]string{filepath.Join(tmp, "src") + "/..."}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := conf.PackageIndex.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+
+	load := func(want int) {
+		t.Helper()
+		conf.PackageIndex = NewPackageIndex(0)
+		n, err := conf.PackageIndex.Load(bytes.NewReader(saved))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Errorf("loaded %d packages; want: %d", n, want)
+		}
+	}
+	lookup := func(reused bool) {
+		t.Helper()
+		logger.msgs = nil
+		res, err := conf.Lookup(pfile, strings.Index(pSrc, "F"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Position.Filename != qfile || res.Position.Line != 3 {
+			t.Errorf("got position %s; want: %s:3", res.Position, qfile)
+		}
+		if got := strings.Contains(strings.Join(logger.msgs, "\n"), "reused index"); got != reused {
+			t.Errorf("reused index: %t; want: %t", got, reused)
+		}
+	}
+
+	load(3)
+	lookup(true)
+	if n, err := conf.PackageIndex.Load(bytes.NewReader(saved)); err != nil || n != 0 {
+		t.Errorf("Load() = %d, %v; want: 0, <nil>", n, err)
+	}
+
+	// Changed directory in the context of the query, the packages are
+	// validated when they are used rather than by Load.
+	load(3)
+	conf.Overlay = map[string][]byte{
+		filepath.Join(filepath.Dir(qfile), "a.go"): []byte("package q\n"),
+	}
+	lookup(false)
+	conf.Overlay = nil
+
+	// Changed directory
+	write(filepath.Join(filepath.Dir(qfile), "a.go"), "package q\n")
+	load(3)
+	lookup(false)
+
+	// Invalid input
+	if _, err := NewPackageIndex(0).Load(strings.NewReader("godef")); err == nil {
+		t.Error("expected an error loading an invalid index")
+	}
+}
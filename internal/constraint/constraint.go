@@ -0,0 +1,325 @@
+// Package constraint parses and evaluates //go:build lines, the build
+// constraint syntax introduced by Go 1.17, for the build contexts of
+// queries.  It implements the subset of go/build/constraint, which
+// requires Go 1.16, that godef needs.
+package constraint
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// An Expr is a build constraint expression.
+type Expr interface {
+	// Eval reports whether the expression is satisfied when ok
+	// reports which tags are set.
+	Eval(ok func(tag string) bool) bool
+
+	// String returns the expression in //go:build syntax.
+	String() string
+}
+
+// A TagExpr is a single tag, e.g. "linux".
+type TagExpr struct {
+	Tag string
+}
+
+func (x *TagExpr) Eval(ok func(tag string) bool) bool { return ok(x.Tag) }
+func (x *TagExpr) String() string                     { return x.Tag }
+
+// A NotExpr is the negation !X.
+type NotExpr struct {
+	X Expr
+}
+
+func (x *NotExpr) Eval(ok func(tag string) bool) bool { return !x.X.Eval(ok) }
+
+func (x *NotExpr) String() string {
+	if _, ok := x.X.(*TagExpr); ok {
+		return "!" + x.X.String()
+	}
+	return "!(" + x.X.String() + ")"
+}
+
+// An AndExpr is the conjunction X && Y.
+type AndExpr struct {
+	X, Y Expr
+}
+
+func (x *AndExpr) Eval(ok func(tag string) bool) bool {
+	// Evaluate both sides so that ok sees every tag.
+	xok := x.X.Eval(ok)
+	yok := x.Y.Eval(ok)
+	return xok && yok
+}
+
+func (x *AndExpr) String() string { return andArg(x.X) + " && " + andArg(x.Y) }
+
+func andArg(x Expr) string {
+	if _, ok := x.(*OrExpr); ok {
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// An OrExpr is the disjunction X || Y.
+type OrExpr struct {
+	X, Y Expr
+}
+
+func (x *OrExpr) Eval(ok func(tag string) bool) bool {
+	xok := x.X.Eval(ok)
+	yok := x.Y.Eval(ok)
+	return xok || yok
+}
+
+func (x *OrExpr) String() string { return x.X.String() + " || " + x.Y.String() }
+
+const prefix = "//go:build"
+
+// IsGoBuild reports whether line is a //go:build line.
+func IsGoBuild(line string) bool {
+	line = strings.TrimSpace(line)
+	return strings.HasPrefix(line, prefix) &&
+		(len(line) == len(prefix) || line[len(prefix)] == ' ' || line[len(prefix)] == '\t')
+}
+
+// Parse parses the //go:build line.
+func Parse(line string) (Expr, error) {
+	if !IsGoBuild(line) {
+		return nil, errors.New("not a //go:build line")
+	}
+	p := &parser{s: strings.TrimSpace(line)[len(prefix):]}
+	p.next()
+	x := p.or()
+	if p.err == nil && p.tok != "" {
+		p.err = fmt.Errorf("unexpected %q", p.tok)
+	}
+	if p.err != nil {
+		return nil, fmt.Errorf("parsing //go:build line: %v", p.err)
+	}
+	return x, nil
+}
+
+// GoBuildLine returns the //go:build line of the Go source src, which
+// must be in the run of comments and blank lines at its start.
+func GoBuildLine(src []byte) (string, bool) {
+	for len(src) > 0 {
+		line := src
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, src = line[:i], src[i+1:]
+		} else {
+			src = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("//")) {
+			break
+		}
+		if IsGoBuild(string(line)) {
+			return string(line), true
+		}
+	}
+	return "", false
+}
+
+// IsPlusBuild reports whether line is a // +build line.
+func IsPlusBuild(line string) bool {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "//") {
+		return false
+	}
+	f := strings.Fields(line[len("//"):])
+	return len(f) > 0 && f[0] == "+build"
+}
+
+// ParsePlusBuild parses the // +build line: its space-separated options
+// are ORed, the comma-separated terms of an option are ANDed and a term
+// may be negated with a single "!".
+func ParsePlusBuild(line string) (Expr, error) {
+	if !IsPlusBuild(line) {
+		return nil, errors.New("not a // +build line")
+	}
+	var x Expr
+	for _, opt := range strings.Fields(strings.TrimSpace(line)[len("//"):])[1:] {
+		var y Expr
+		for _, term := range strings.Split(opt, ",") {
+			tag := strings.TrimPrefix(term, "!")
+			if tag == "" || !isTag(tag) {
+				return nil, fmt.Errorf("parsing // +build line: invalid term %q", term)
+			}
+			var z Expr = &TagExpr{Tag: tag}
+			if tag != term {
+				z = &NotExpr{X: z}
+			}
+			if y == nil {
+				y = z
+			} else {
+				y = &AndExpr{X: y, Y: z}
+			}
+		}
+		if x == nil {
+			x = y
+		} else {
+			x = &OrExpr{X: x, Y: y}
+		}
+	}
+	if x == nil {
+		return nil, errors.New("parsing // +build line: missing options")
+	}
+	return x, nil
+}
+
+// PlusBuildLines returns the // +build lines of the Go source src.  Like
+// go/build, it only considers the run of comments and blank lines at
+// the start of src that is followed by a blank line, so that the doc
+// comment of the package clause is not included.
+func PlusBuildLines(src []byte) []string {
+	end := 0
+	for p := src; len(p) > 0; {
+		line := p
+		if i := bytes.IndexByte(line, '\n'); i >= 0 {
+			line, p = line[:i], p[i+1:]
+		} else {
+			p = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			end = len(src) - len(p)
+			continue
+		}
+		if !bytes.HasPrefix(line, []byte("//")) {
+			break
+		}
+	}
+	var lines []string
+	for _, line := range strings.Split(string(src[:end]), "\n") {
+		if IsPlusBuild(line) {
+			lines = append(lines, strings.TrimSpace(line))
+		}
+	}
+	return lines
+}
+
+// Tags calls fn for each tag of x, with negated set if the tag appears
+// under an odd number of negations.
+func Tags(x Expr, fn func(tag string, negated bool)) {
+	var walk func(x Expr, negated bool)
+	walk = func(x Expr, negated bool) {
+		switch x := x.(type) {
+		case *TagExpr:
+			fn(x.Tag, negated)
+		case *NotExpr:
+			walk(x.X, !negated)
+		case *AndExpr:
+			walk(x.X, negated)
+			walk(x.Y, negated)
+		case *OrExpr:
+			walk(x.X, negated)
+			walk(x.Y, negated)
+		}
+	}
+	walk(x, false)
+}
+
+// A parser is a recursive descent parser of build constraint
+// expressions:
+//
+//	or   = and { "||" and }
+//	and  = not { "&&" not }
+//	not  = "!" not | "(" or ")" | tag
+type parser struct {
+	s   string // remaining input
+	tok string // current token, "" at the end of the input
+	err error
+}
+
+func (p *parser) next() {
+	p.s = strings.TrimLeft(p.s, " \t")
+	if p.s == "" {
+		p.tok = ""
+		return
+	}
+	switch {
+	case strings.HasPrefix(p.s, "&&"), strings.HasPrefix(p.s, "||"):
+		p.tok, p.s = p.s[:2], p.s[2:]
+	case p.s[0] == '!' || p.s[0] == '(' || p.s[0] == ')':
+		p.tok, p.s = p.s[:1], p.s[1:]
+	default:
+		i := 0
+		for i < len(p.s) && isTagByte(p.s[i]) {
+			i++
+		}
+		if i == 0 {
+			p.fail(fmt.Errorf("invalid syntax at %q", p.s))
+			return
+		}
+		p.tok, p.s = p.s[:i], p.s[i:]
+	}
+}
+
+func (p *parser) fail(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+	p.tok, p.s = "", ""
+}
+
+func (p *parser) or() Expr {
+	x := p.and()
+	for p.tok == "||" {
+		p.next()
+		x = &OrExpr{x, p.and()}
+	}
+	return x
+}
+
+func (p *parser) and() Expr {
+	x := p.not()
+	for p.tok == "&&" {
+		p.next()
+		x = &AndExpr{x, p.not()}
+	}
+	return x
+}
+
+func (p *parser) not() Expr {
+	switch p.tok {
+	case "!":
+		p.next()
+		return &NotExpr{p.not()}
+	case "(":
+		p.next()
+		x := p.or()
+		if p.tok != ")" {
+			p.fail(errors.New("missing )"))
+			return x
+		}
+		p.next()
+		return x
+	case "", "&&", "||", ")":
+		p.fail(errors.New("missing tag"))
+		return &TagExpr{}
+	}
+	x := &TagExpr{Tag: p.tok}
+	p.next()
+	return x
+}
+
+func isTag(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isTagByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isTagByte(c byte) bool {
+	return c == '_' || c == '.' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' || c >= 0x80
+}
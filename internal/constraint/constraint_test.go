@@ -0,0 +1,141 @@
+package constraint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	set := map[string]bool{"linux": true, "amd64": true, "cgo": true}
+	ok := func(tag string) bool { return set[tag] }
+	tests := []struct {
+		line string
+		str  string
+		eval bool
+	}{
+		{"//go:build linux", "linux", true},
+		{"//go:build !linux", "!linux", false},
+		{"//go:build linux && amd64", "linux && amd64", true},
+		{"//go:build darwin || linux", "darwin || linux", true},
+		{"//go:build (darwin || linux) && !cgo", "(darwin || linux) && !cgo", false},
+		{"//go:build !(windows || plan9)", "!(windows || plan9)", true},
+		{"//go:build go1.17 && !purego", "go1.17 && !purego", false},
+		{"  //go:build\tlinux || (arm64 && !ios)  ", "linux || arm64 && !ios", true},
+	}
+	for _, x := range tests {
+		expr, err := Parse(x.line)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", x.line, err)
+			continue
+		}
+		if s := expr.String(); s != x.str {
+			t.Errorf("Parse(%q).String() = %q; want: %q", x.line, s, x.str)
+		}
+		if eval := expr.Eval(ok); eval != x.eval {
+			t.Errorf("Parse(%q).Eval() = %t; want: %t", x.line, eval, x.eval)
+		}
+	}
+
+	for _, line := range []string{
+		"//go:build",
+		"//go:build linux &&",
+		"//go:build (linux",
+		"//go:build linux)",
+		"//go:build linux darwin",
+		"//go:build linux, darwin",
+		"//go:buildlinux",
+		"// +build linux",
+	} {
+		if _, err := Parse(line); err == nil {
+			t.Errorf("Parse(%q): expected an error", line)
+		}
+	}
+}
+
+func TestTags(t *testing.T) {
+	expr, err := Parse("//go:build linux && !(cgo || !purego)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := make(map[string]bool)
+	Tags(expr, func(tag string, negated bool) { tags[tag] = !negated })
+	want := map[string]bool{"linux": true, "cgo": false, "purego": true}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("got %v; want: %v", tags, want)
+	}
+}
+
+func TestGoBuildLine(t *testing.T) {
+	tests := []struct {
+		src  string
+		line string
+	}{
+		{"// Copyright\n\n//go:build linux\n// +build linux\n\npackage p\n", "//go:build linux"},
+		{"// +build linux\n\npackage p\n", ""},
+		{"package p\n\n//go:build linux\n", ""},
+	}
+	for _, x := range tests {
+		line, ok := GoBuildLine([]byte(x.src))
+		if line != x.line || ok != (x.line != "") {
+			t.Errorf("GoBuildLine(%q) = %q, %t; want: %q", x.src, line, ok, x.line)
+		}
+	}
+}
+
+func TestParsePlusBuild(t *testing.T) {
+	set := map[string]bool{"linux": true, "amd64": true}
+	ok := func(tag string) bool { return set[tag] }
+	tests := []struct {
+		line string
+		str  string
+		eval bool
+	}{
+		{"// +build linux", "linux", true},
+		{"// +build !linux", "!linux", false},
+		{"// +build darwin linux", "darwin || linux", true},
+		{"// +build linux,!amd64 windows", "linux && !amd64 || windows", false},
+		{"  //\t+build  unix,amd64  ", "unix && amd64", false},
+	}
+	for _, x := range tests {
+		expr, err := ParsePlusBuild(x.line)
+		if err != nil {
+			t.Errorf("ParsePlusBuild(%q): %v", x.line, err)
+			continue
+		}
+		if s := expr.String(); s != x.str {
+			t.Errorf("ParsePlusBuild(%q).String() = %q; want: %q", x.line, s, x.str)
+		}
+		if eval := expr.Eval(ok); eval != x.eval {
+			t.Errorf("ParsePlusBuild(%q).Eval() = %t; want: %t", x.line, eval, x.eval)
+		}
+	}
+
+	for _, line := range []string{
+		"// +build",
+		"// +build !!linux",
+		"// +build linux,",
+		"// +build lin-ux",
+		"//go:build linux",
+	} {
+		if _, err := ParsePlusBuild(line); err == nil {
+			t.Errorf("ParsePlusBuild(%q): expected an error", line)
+		}
+	}
+}
+
+func TestPlusBuildLines(t *testing.T) {
+	tests := []struct {
+		src   string
+		lines []string
+	}{
+		{"// Copyright\n\n// +build linux\n// +build amd64\n\npackage p\n", []string{"// +build linux", "// +build amd64"}},
+		{"// +build linux\npackage p\n", nil},
+		{"package p\n\n// +build linux\n", nil},
+	}
+	for _, x := range tests {
+		lines := PlusBuildLines([]byte(x.src))
+		if !reflect.DeepEqual(lines, x.lines) {
+			t.Errorf("PlusBuildLines(%q) = %q; want: %q", x.src, lines, x.lines)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+//go:build go1.18
+// +build go1.18
+
+package span
+
+import "testing"
+
+func FuzzParse(f *testing.F) {
+	for _, pos := range []string{"a.go:#12", "a.go:#1,#5", `C:\a.go:#3`, "a.go:#5,#1", "a.go:#-1", ":#", "a.go:#1,"} {
+		f.Add(pos)
+	}
+	f.Fuzz(func(t *testing.T, pos string) {
+		sp, err := Parse(pos)
+		if err != nil {
+			return
+		}
+		if sp.Start.Offset < 0 || sp.End.Offset < sp.Start.Offset {
+			t.Fatalf("Parse(%q) = %+v, an invalid span", pos, sp)
+		}
+		sp2, err := Parse(sp.String())
+		if err != nil || sp2 != sp {
+			t.Fatalf("Parse(%q) = %+v, %v, want %+v", sp.String(), sp2, err, sp)
+		}
+	})
+}
+
+func FuzzPointAt(f *testing.F) {
+	f.Add([]byte("a\nbc\n"), 3)
+	f.Add([]byte("\xe4\xb8\x96\n"), 1)
+	f.Add([]byte(""), -1)
+	f.Fuzz(func(t *testing.T, content []byte, offset int) {
+		p, err := PointAt(content, offset)
+		if err != nil {
+			return
+		}
+		off, err := OffsetOf(content, p.Line, p.Column)
+		if err != nil || off != offset {
+			t.Fatalf("OffsetOf(%d:%d) = %d, %v, want %d", p.Line, p.Column, off, err, offset)
+		}
+	})
+}
@@ -0,0 +1,244 @@
+// Package span provides the source positions and ranges shared by godef
+// and its commands, and conversions between their textual, byte offset,
+// line/column and token.Pos forms.
+package span
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Point is a position within a file.  The Line and Column are zero if
+// unknown.
+type Point struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number, starting at 1 (byte count)
+}
+
+// HasPosition reports whether the line and column of p are known.
+func (p Point) HasPosition() bool { return p.Line > 0 }
+
+// Compare returns -1, 0 or +1 if p is before, at, or after q.
+func (p Point) Compare(q Point) int {
+	switch {
+	case p.Offset < q.Offset:
+		return -1
+	case p.Offset > q.Offset:
+		return 1
+	}
+	return 0
+}
+
+// A Unit is the unit in which a column is counted.
+type Unit int
+
+const (
+	Bytes Unit = iota // bytes, as go/token counts columns
+	Runes             // Unicode code points
+	UTF16             // UTF-16 code units
+)
+
+// ColumnIn returns the column of p, a Point in content with a byte
+// Column, counted in unit u.  The byte Column is returned if p is not
+// within content, e.g. because the file changed since p was computed.
+func (p Point) ColumnIn(content []byte, u Unit) int {
+	start := p.Offset - (p.Column - 1)
+	if u == Bytes || p.Column < 1 || start < 0 || p.Offset > len(content) {
+		return p.Column
+	}
+	n := 1
+	for line := content[start:p.Offset]; len(line) > 0; {
+		r, size := utf8.DecodeRune(line)
+		if r == '\n' {
+			return p.Column // not the line of p
+		}
+		n++
+		if u == UTF16 && r >= 0x10000 {
+			n++ // surrogate pair
+		}
+		line = line[size:]
+	}
+	return n
+}
+
+// A Span is the range [Start, End] of a file.  A Span that identifies a
+// single position has equal Start and End.
+type Span struct {
+	Filename   string
+	Start, End Point
+}
+
+// New returns the span of a single offset in filename.
+func New(filename string, offset int) Span {
+	p := Point{Offset: offset}
+	return Span{Filename: filename, Start: p, End: p}
+}
+
+// FromPosition returns the span of a single token.Position.
+func FromPosition(pos token.Position) Span {
+	p := Point{Offset: pos.Offset, Line: pos.Line, Column: pos.Column}
+	return Span{Filename: pos.Filename, Start: p, End: p}
+}
+
+// IsPoint reports whether s identifies a single position.
+func (s Span) IsPoint() bool { return s.Start == s.End }
+
+// Contains reports whether t is within s.  Spans of different files never
+// contain each other.
+func (s Span) Contains(t Span) bool {
+	return s.Filename == t.Filename &&
+		s.Start.Compare(t.Start) <= 0 && t.End.Compare(s.End) <= 0
+}
+
+// Compare orders spans by filename, start and end offset.
+func Compare(a, b Span) int {
+	if a.Filename != b.Filename {
+		if a.Filename < b.Filename {
+			return -1
+		}
+		return 1
+	}
+	if c := a.Start.Compare(b.Start); c != 0 {
+		return c
+	}
+	return a.End.Compare(b.End)
+}
+
+// String returns s in the form accepted by Parse: "file:#start" or
+// "file:#start,#end".
+func (s Span) String() string {
+	if s.IsPoint() {
+		return fmt.Sprintf("%s:#%d", s.Filename, s.Start.Offset)
+	}
+	return fmt.Sprintf("%s:#%d,#%d", s.Filename, s.Start.Offset, s.End.Offset)
+}
+
+// Range returns the token.Pos range of s in file, which must be the file
+// named by s.  It returns an error if the offsets of s are out of bounds.
+func (s Span) Range(file *token.File) (start, end token.Pos, err error) {
+	// Range check [start..end], inclusive of both end-points.
+	if off := s.Start.Offset; 0 <= off && off <= file.Size() {
+		start = file.Pos(off)
+	} else {
+		return token.NoPos, token.NoPos, errors.New("start position is beyond end of file")
+	}
+	if off := s.End.Offset; 0 <= off && off <= file.Size() {
+		end = file.Pos(off)
+	} else {
+		return token.NoPos, token.NoPos, errors.New("end position is beyond end of file")
+	}
+	return start, end, nil
+}
+
+// parseOctothorpDecimal returns the numeric value if s matches "#%d",
+// otherwise -1.
+func parseOctothorpDecimal(s string) int {
+	if s != "" && s[0] == '#' {
+		if s, err := strconv.ParseInt(s[1:], 10, 32); err == nil {
+			return int(s)
+		}
+	}
+	return -1
+}
+
+// Parse parses a string of the form "file:#pos" or "file:#start,#end"
+// where pos, start and end are byte offsets and start <= end.
+//
+// (Numbers without a '#' prefix are reserved for future use,
+// e.g. to indicate line/column positions.)
+func Parse(pos string) (Span, error) {
+	if pos == "" {
+		return Span{}, errors.New("no source position specified")
+	}
+
+	colon := strings.LastIndex(pos, ":")
+	if colon < 0 {
+		return Span{}, fmt.Errorf("bad position syntax %q", pos)
+	}
+	filename, offset := pos[:colon], pos[colon+1:]
+	var start, end int
+	if comma := strings.Index(offset, ","); comma < 0 {
+		// e.g. "foo.go:#123"
+		start = parseOctothorpDecimal(offset)
+		end = start
+	} else {
+		// e.g. "foo.go:#123,#456"
+		start = parseOctothorpDecimal(offset[:comma])
+		end = parseOctothorpDecimal(offset[comma+1:])
+	}
+	if start < 0 || end < 0 {
+		return Span{}, fmt.Errorf("invalid offset %q", offset)
+	}
+	if end < start {
+		return Span{}, fmt.Errorf("range %q ends before it starts", offset)
+	}
+	return Span{
+		Filename: filename,
+		Start:    Point{Offset: start},
+		End:      Point{Offset: end},
+	}, nil
+}
+
+// PointAt returns the Point of byte offset in content.  The Column is a
+// byte count.
+func PointAt(content []byte, offset int) (Point, error) {
+	if offset < 0 || offset > len(content) {
+		return Point{}, fmt.Errorf("offset %d is beyond end of file", offset)
+	}
+	line, col := 1, 1
+	for _, c := range content[:offset] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return Point{Offset: offset, Line: line, Column: col}, nil
+}
+
+// OffsetOf returns the byte offset of line and column in content.
+func OffsetOf(content []byte, line, column int) (int, error) {
+	if line < 1 || column < 1 {
+		return -1, fmt.Errorf("invalid line:column %d:%d", line, column)
+	}
+	offset := 0
+	for l := 1; l < line; l++ {
+		i := bytes.IndexByte(content[offset:], '\n')
+		if i < 0 {
+			return -1, fmt.Errorf("line %d is beyond end of file", line)
+		}
+		offset += i + 1
+	}
+	end := bytes.IndexByte(content[offset:], '\n')
+	if end < 0 {
+		end = len(content) - offset
+	}
+	if column-1 > end {
+		return -1, fmt.Errorf("column %d is beyond end of line %d", column, line)
+	}
+	return offset + column - 1, nil
+}
+
+// MarkerOffset returns the byte offset immediately after marker in
+// content, so that a marker such as /*caret*/ written before an
+// identifier selects it.  Marker must occur exactly once in content.
+func MarkerOffset(content []byte, marker string) (int, error) {
+	if marker == "" {
+		return -1, errors.New("empty marker")
+	}
+	i := bytes.Index(content, []byte(marker))
+	if i < 0 {
+		return -1, fmt.Errorf("marker %q not found", marker)
+	}
+	if bytes.Contains(content[i+1:], []byte(marker)) {
+		return -1, fmt.Errorf("marker %q is not unique", marker)
+	}
+	return i + len(marker), nil
+}
@@ -0,0 +1,123 @@
+package span
+
+import (
+	"bytes"
+	"go/token"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		pos   string
+		exp   Span
+		valid bool
+	}{
+		{"a.go:#12", New("a.go", 12), true},
+		{"a.go:#1,#5", Span{"a.go", Point{Offset: 1}, Point{Offset: 5}}, true},
+		{`C:\a.go:#3`, New(`C:\a.go`, 3), true},
+		{"", Span{}, false},
+		{"a.go", Span{}, false},
+		{"a.go:12", Span{}, false},
+		{"a.go:#1,5", Span{}, false},
+		{"a.go:#5,#1", Span{}, false},
+		{"a.go:#-1", Span{}, false},
+	}
+	for _, x := range tests {
+		sp, err := Parse(x.pos)
+		if (err == nil) != x.valid {
+			t.Errorf("Parse(%q): unexpected error: %v", x.pos, err)
+			continue
+		}
+		if sp != x.exp {
+			t.Errorf("Parse(%q): exp %+v got %+v", x.pos, x.exp, sp)
+		}
+		if x.valid && sp.String() != x.pos {
+			t.Errorf("String(%q): got %q", x.pos, sp.String())
+		}
+	}
+}
+
+func TestPointAt(t *testing.T) {
+	src := []byte("ab\ncd\n\nef")
+	for offset := 0; offset <= len(src); offset++ {
+		p, err := PointAt(src, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		off, err := OffsetOf(src, p.Line, p.Column)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != offset {
+			t.Errorf("OffsetOf(%d:%d): exp %d got %d", p.Line, p.Column, offset, off)
+		}
+	}
+	if _, err := PointAt(src, len(src)+1); err == nil {
+		t.Error("PointAt: expected error for offset beyond end of file")
+	}
+	if _, err := OffsetOf(src, 2, 4); err == nil {
+		t.Error("OffsetOf: expected error for column beyond end of line")
+	}
+}
+
+func TestColumnIn(t *testing.T) {
+	// X follows a 2 byte rune, a tab and a rune that is 4 bytes in UTF-8
+	// and 2 code units in UTF-16.
+	src := []byte("package p\n\nvar é, \t𝔸, X = 1, 2, 3\n")
+	p, err := PointAt(src, bytes.IndexByte(src, 'X'))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, x := range []struct {
+		unit   Unit
+		column int
+	}{
+		{Bytes, 16},
+		{Runes, 12},
+		{UTF16, 13},
+	} {
+		if col := p.ColumnIn(src, x.unit); col != x.column {
+			t.Errorf("ColumnIn(%d): exp %d got %d", x.unit, x.column, col)
+		}
+	}
+	// A point beyond the end of content keeps its byte column.
+	if col := p.ColumnIn(src[:10], Runes); col != p.Column {
+		t.Errorf("ColumnIn(truncated): exp %d got %d", p.Column, col)
+	}
+}
+
+func TestMarkerOffset(t *testing.T) {
+	src := []byte("var x = /*caret*/y + z")
+	if off, err := MarkerOffset(src, "/*caret*/"); err != nil || off != 17 {
+		t.Errorf("MarkerOffset: exp 17 got %d, %v", off, err)
+	}
+	for _, marker := range []string{"", "/*missing*/", " "} {
+		if _, err := MarkerOffset(src, marker); err == nil {
+			t.Errorf("MarkerOffset(%q): expected error", marker)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("a.go", -1, 10)
+	if _, _, err := New("a.go", 10).Range(file); err != nil {
+		t.Errorf("Range: %v", err)
+	}
+	if _, _, err := New("a.go", 11).Range(file); err == nil {
+		t.Error("Range: expected error for offset beyond end of file")
+	}
+}
+
+func TestContains(t *testing.T) {
+	outer := Span{"a.go", Point{Offset: 2}, Point{Offset: 8}}
+	if !outer.Contains(New("a.go", 2)) || !outer.Contains(New("a.go", 8)) {
+		t.Error("Contains: span should contain its end points")
+	}
+	if outer.Contains(New("a.go", 9)) || outer.Contains(New("b.go", 4)) {
+		t.Error("Contains: span should not contain outside points")
+	}
+	if Compare(New("a.go", 1), New("b.go", 0)) != -1 || Compare(outer, outer) != 0 {
+		t.Error("Compare: unexpected ordering")
+	}
+}
@@ -0,0 +1,113 @@
+package godef
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// A Kind is the semantic classification of an identifier.  The names
+// match the semantic token types of the Language Server Protocol where
+// one exists.
+type Kind string
+
+const (
+	KindUnknown   Kind = ""
+	KindNamespace Kind = "namespace" // package name
+	KindType      Kind = "type"
+	KindParameter Kind = "parameter" // function parameter, result or receiver
+	KindVariable  Kind = "variable"
+	KindProperty  Kind = "property" // struct field
+	KindFunction  Kind = "function"
+	KindMethod    Kind = "method"
+	KindConst     Kind = "const"
+	KindLabel     Kind = "label"
+	KindFile      Kind = "file" // file named by a //go:embed or //go:generate directive
+)
+
+// astObjectKind returns the Kind of an object resolved by the parser.
+func astObjectKind(obj *ast.Object) Kind {
+	switch obj.Kind {
+	case ast.Pkg:
+		return KindNamespace
+	case ast.Con:
+		return KindConst
+	case ast.Typ:
+		return KindType
+	case ast.Var:
+		if field, ok := obj.Decl.(*ast.Field); ok && field != nil {
+			return KindParameter // struct fields are not resolved by the parser
+		}
+		return KindVariable
+	case ast.Fun:
+		return KindFunction
+	case ast.Lbl:
+		return KindLabel
+	}
+	return KindUnknown
+}
+
+// tokenKind returns the Kind of a package-level declaration of type tok.
+func tokenKind(tok token.Token) Kind {
+	switch tok {
+	case token.CONST:
+		return KindConst
+	case token.VAR:
+		return KindVariable
+	case token.TYPE:
+		return KindType
+	case token.FUNC:
+		return KindFunction
+	}
+	return KindUnknown
+}
+
+// objectKind returns the Kind of a type-checked object.  The path of the
+// object's declaration, if known, is used to identify parameters.
+func objectKind(obj types.Object, declPath []ast.Node) Kind {
+	switch obj := obj.(type) {
+	case *types.PkgName:
+		return KindNamespace
+	case *types.Const:
+		return KindConst
+	case *types.TypeName:
+		return KindType
+	case *types.Var:
+		if obj.IsField() {
+			return KindProperty
+		}
+		if isParam(declPath) {
+			return KindParameter
+		}
+		return KindVariable
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return KindMethod
+		}
+		return KindFunction
+	case *types.Builtin:
+		return KindFunction
+	case *types.Label:
+		return KindLabel
+	case *types.Nil:
+		return KindConst
+	}
+	return KindUnknown
+}
+
+// isParam reports whether path, the path to a declaring identifier, is
+// that of a function parameter, result or receiver.
+func isParam(path []ast.Node) bool {
+	for i := 0; i+2 < len(path); i++ {
+		if _, ok := path[i].(*ast.Field); ok {
+			if _, ok := path[i+1].(*ast.FieldList); ok {
+				switch path[i+2].(type) {
+				case *ast.FuncType, *ast.FuncDecl:
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
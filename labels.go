@@ -0,0 +1,128 @@
+package godef
+
+import (
+	"go/ast"
+	"go/scanner"
+	"go/token"
+)
+
+// labelDefinition answers a query of the label of a branch statement
+// (break, continue or goto) or of a labeled statement, which is resolved
+// syntactically in the innermost function enclosing it: labels are not
+// in the scopes of the parser or type checker, and a labeled statement
+// may be lost to a syntax error in the function, in which case its
+// source is scanned for a line beginning with the label.  It reports
+// whether the query was of a label.
+func labelDefinition(q *Query, qpos *queryPos) (bool, error) {
+	if len(qpos.path) < 2 {
+		return false, nil
+	}
+	id, ok := qpos.path[0].(*ast.Ident)
+	if !ok {
+		return false, nil
+	}
+	switch n := qpos.path[1].(type) {
+	case *ast.LabeledStmt:
+		if n.Label != id {
+			return false, nil
+		}
+		q.Output(qpos.fset, &definitionResult{
+			pos:   id.Pos(),
+			descr: "label " + id.Name,
+			kind:  KindLabel,
+		})
+		return true, nil
+	case *ast.BranchStmt:
+		if n.Label != id {
+			return false, nil
+		}
+	default:
+		return false, nil
+	}
+
+	var body *ast.BlockStmt
+	for _, n := range qpos.path {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			body = n.Body
+		case *ast.FuncLit:
+			body = n.Body
+		}
+		if body != nil {
+			break
+		}
+	}
+	if body == nil {
+		return true, &NotFoundError{Err: ErrNoObject}
+	}
+
+	pos := findLabel(body, id.Name)
+	if !pos.IsValid() {
+		pos = scanLabel(q, qpos.fset, body, id.Name)
+	}
+	if !pos.IsValid() {
+		return true, &NotFoundError{Err: ErrNotFound, Reason: "label " + id.Name + " not defined"}
+	}
+	q.Output(qpos.fset, &definitionResult{
+		pos:   pos,
+		descr: "label " + id.Name,
+		kind:  KindLabel,
+	})
+	return true, nil
+}
+
+// findLabel returns the position of the label name of a labeled
+// statement in body, excluding the bodies of function literals, or
+// token.NoPos.
+func findLabel(body *ast.BlockStmt, name string) token.Pos {
+	var pos token.Pos
+	ast.Inspect(body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.LabeledStmt:
+			if n.Label.Name == name {
+				pos = n.Label.Pos()
+			}
+		}
+		return !pos.IsValid()
+	})
+	return pos
+}
+
+// scanLabel returns the position of the first line of body, in the
+// source of its file, that begins with "name:", or token.NoPos.
+func scanLabel(q *Query, fset *token.FileSet, body *ast.BlockStmt, name string) token.Pos {
+	tf := fset.File(body.Pos())
+	if tf == nil {
+		return token.NoPos
+	}
+	src, err := readFile(q.Build, tf.Name())
+	if err != nil || len(src) != tf.Size() {
+		return token.NoPos
+	}
+	start, end := tf.Offset(body.Lbrace), tf.Offset(body.End())
+	if body.Rbrace.IsValid() {
+		end = tf.Offset(body.Rbrace)
+	}
+
+	file := token.NewFileSet().AddFile("", -1, end-start)
+	var s scanner.Scanner
+	s.Init(file, src[start:end], nil, 0) // syntax errors are expected
+	var label token.Pos                  // identifier at the beginning of the line
+	line := 0
+	for {
+		pos, tok, lit := s.Scan()
+		switch {
+		case tok == token.EOF:
+			return token.NoPos
+		case tok == token.COLON && label.IsValid():
+			return tf.Pos(start + file.Offset(label))
+		case file.Line(pos) != line && tok == token.IDENT && lit == name:
+			label = pos
+		default:
+			label = token.NoPos
+		}
+		line = file.Line(pos)
+	}
+}
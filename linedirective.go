@@ -0,0 +1,46 @@
+package godef
+
+import (
+	"go/token"
+	"io/ioutil"
+
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// position returns the position of pos in fset for results.  Unless
+// q.physical is set, positions in files with //line directives, e.g.
+// the output of cgo or goyacc, are those of the files the directives
+// name.  The parser only records byte offsets in the file it parsed,
+// often a temporary file, so the offset is recomputed from the line and
+// column in the named file, read from q.Build, and left unchanged if
+// that fails.
+func (q *Query) position(fset *token.FileSet, pos token.Pos) token.Position {
+	if q.physical {
+		return fset.PositionFor(pos, false)
+	}
+	posn := fset.PositionFor(pos, true)
+	if !posn.IsValid() || posn == fset.PositionFor(pos, false) {
+		return posn
+	}
+	rc, err := buildutil.OpenFile(q.Build, posn.Filename)
+	if err != nil {
+		q.logf("line directive: %v", err)
+		return posn
+	}
+	src, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		q.logf("line directive: %v", err)
+		return posn
+	}
+	if posn.Column == 0 {
+		posn.Column = 1 // the directive has no column
+	}
+	if offset, err := span.OffsetOf(src, posn.Line, posn.Column); err == nil {
+		posn.Offset = offset
+	} else {
+		q.logf("line directive: %s: %v", posn.Filename, err)
+	}
+	return posn
+}
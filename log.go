@@ -0,0 +1,29 @@
+package godef
+
+import "go/build"
+
+// A Logger receives debug messages describing how queries are answered:
+// the effective build context, the resolution path taken (the parser,
+// a scan of an imported package or the type checker) and the duration
+// of each phase.  A *log.Logger is a Logger.
+type Logger interface {
+	Printf(format string, args ...interface{})
+}
+
+func (c *Config) logf(format string, args ...interface{}) {
+	if c.Logger != nil {
+		c.Logger.Printf(format, args...)
+	}
+}
+
+func (q *Query) logf(format string, args ...interface{}) {
+	if q.logger != nil {
+		q.logger.Printf(format, args...)
+	}
+}
+
+// logContext logs the settings of ctxt that affect package loading.
+func (c *Config) logContext(ctxt *build.Context) {
+	c.logf("build context: GOROOT=%s GOPATH=%s GOOS=%s GOARCH=%s CGO_ENABLED=%t tags=%q",
+		ctxt.GOROOT, ctxt.GOPATH, ctxt.GOOS, ctxt.GOARCH, ctxt.CgoEnabled, ctxt.BuildTags)
+}
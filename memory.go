@@ -0,0 +1,147 @@
+package godef
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A MemoryBudget limits the memory used by the caches of a Config (its
+// ProgramCache, ASTCache and PackageIndex), so that a long running
+// process does not grow without bound on large workspaces.  The caches
+// are sized by their number of entries, not their memory, so the budget
+// is enforced on the heap of the process: after each query, if the
+// heap exceeds the limit, a goroutine collects garbage and, if it still
+// does, trims the caches until it does not, starting with the programs,
+// which are the largest entries, then the syntax trees and last the
+// package index.  Queries do not wait for it.  While the heap exceeds
+// the limit after trimming, queries do not add programs to the
+// ProgramCache and the caches are trimmed at most once every
+// minTrimInterval, by the clock of the Environment of the Config.
+//
+// A MemoryBudget may be shared by Engines and used by multiple
+// goroutines simultaneously; it should be shared by all of the Configs
+// using the same caches.
+type MemoryBudget struct {
+	limit    uint64
+	mu       sync.Mutex // held while trimming
+	trimmed  time.Time  // guarded by mu, when the caches were last trimmed
+	running  int32      // atomic, 1 while a goroutine enforces the budget
+	exceeded int32      // atomic, 1 if the heap exceeded the limit after trimming
+	trims    uint64     // atomic
+
+	heap func() uint64 // returns the bytes of the heap, heapAlloc if nil
+}
+
+// minTrimInterval is the minimum interval between trims of the caches
+// while the heap exceeds the limit after trimming, when collecting
+// garbage again would most likely not free enough memory.
+const minTrimInterval = 10 * time.Second
+
+// NewMemoryBudget returns a MemoryBudget limiting the heap to limit
+// bytes.
+func NewMemoryBudget(limit uint64) *MemoryBudget {
+	return &MemoryBudget{limit: limit}
+}
+
+// Limit returns the limit of b in bytes.
+func (b *MemoryBudget) Limit() uint64 { return b.limit }
+
+// Trims returns the number of times the caches were trimmed.
+func (b *MemoryBudget) Trims() uint64 { return atomic.LoadUint64(&b.trims) }
+
+// Exceeded reports whether the heap exceeded the limit of b after the
+// caches were last trimmed.
+func (b *MemoryBudget) Exceeded() bool {
+	return b != nil && atomic.LoadInt32(&b.exceeded) != 0
+}
+
+// heapAlloc returns the bytes of allocated heap objects.
+func heapAlloc() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// heapAlloc returns the bytes of the heap, as read by b.heap.
+func (b *MemoryBudget) heapAlloc() uint64 {
+	if b.heap != nil {
+		return b.heap()
+	}
+	return heapAlloc()
+}
+
+// enforceAsync enforces b on the caches of c in a new goroutine if the
+// heap exceeds the limit, unless a goroutine already does.
+func (b *MemoryBudget) enforceAsync(c *Config) {
+	if b == nil {
+		return
+	}
+	if b.heapAlloc() <= b.limit {
+		atomic.StoreInt32(&b.exceeded, 0)
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&b.running, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(&b.running, 0)
+		b.enforce(c)
+	}()
+}
+
+// enforce trims the caches of c if the heap exceeds the limit of b.
+func (b *MemoryBudget) enforce(c *Config) {
+	if b == nil {
+		return
+	}
+	env := c.env()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.Exceeded() && since(env, b.trimmed) < minTrimInterval {
+		return
+	}
+	if b.heapAlloc() <= b.limit {
+		atomic.StoreInt32(&b.exceeded, 0)
+		return
+	}
+
+	// The heap includes garbage, which may be all that exceeds the limit.
+	runtime.GC()
+	heap := b.heapAlloc()
+	if heap <= b.limit {
+		atomic.StoreInt32(&b.exceeded, 0)
+		return
+	}
+	var stages []func()
+	if progs := c.ProgramCache; progs != nil {
+		stages = append(stages,
+			func() { progs.trim(progs.Len() / 2) },
+			func() { progs.trim(0) })
+	}
+	if asts := c.ASTCache; asts != nil {
+		stages = append(stages, asts.reset)
+	}
+	if index := c.PackageIndex; index != nil {
+		stages = append(stages,
+			func() { index.trim(index.Len() / 2) },
+			func() { index.trim(0) })
+	}
+	atomic.AddUint64(&b.trims, 1)
+	b.trimmed = env.Now()
+	for _, trim := range stages {
+		if heap <= b.limit {
+			break
+		}
+		trim()
+		runtime.GC()
+		heap = b.heapAlloc()
+	}
+	var exceeded int32
+	if heap > b.limit {
+		exceeded = 1
+	}
+	atomic.StoreInt32(&b.exceeded, exceeded)
+	c.logf("trimmed caches: heap %d MiB, limit %d MiB", heap>>20, b.limit>>20)
+}
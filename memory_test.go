@@ -0,0 +1,149 @@
+package godef
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryBudget(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	const src = "package p\n\nimport \"strings\"\n\nvar V = struct{ X int }{}.X\n\nvar _ = strings.ToUpper\n"
+	var files []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		filename := filepath.Join(tmp, name, "p.go")
+		if err := os.Mkdir(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, filename)
+	}
+
+	clock := &clockEnv{Environment: OSEnvironment, now: time.Unix(1e9, 0)}
+	budget := NewMemoryBudget(1 << 30)
+	conf := Config{
+		Context:      build.Default,
+		Env:          clock,
+		ProgramCache: NewProgramCache(0),
+		ASTCache:     NewASTCache(0),
+		PackageIndex: NewPackageIndex(0),
+		MemoryBudget: budget,
+	}
+	fill := func() {
+		t.Helper()
+		budget.heap = func() uint64 { return 0 }
+		for _, name := range files {
+			for _, sel := range []string{"X", "ToUpper"} {
+				if _, err := conf.Lookup(name, strings.LastIndex(src, sel), nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if conf.ProgramCache.Len() != len(files) || conf.ASTCache.Len() == 0 || conf.PackageIndex.Len() == 0 {
+			t.Fatalf("caches not filled: %d programs, %d files, %d packages",
+				conf.ProgramCache.Len(), conf.ASTCache.Len(), conf.PackageIndex.Len())
+		}
+	}
+	type lens struct{ programs, files, packages int }
+	check := func(want lens, exceeded bool) {
+		t.Helper()
+		got := lens{conf.ProgramCache.Len(), conf.ASTCache.Len(), conf.PackageIndex.Len()}
+		if got != want {
+			t.Errorf("got cache lengths %+v; want: %+v", got, want)
+		}
+		if budget.Exceeded() != exceeded {
+			t.Errorf("Exceeded() = %t; want: %t", budget.Exceeded(), exceeded)
+		}
+	}
+
+	// Within the limit
+	fill()
+	files0, packages0 := conf.ASTCache.Len(), conf.PackageIndex.Len()
+	budget.enforce(&conf)
+	check(lens{len(files), files0, packages0}, false)
+
+	// Garbage: the heap is within the limit after a collection
+	calls := 0
+	budget.heap = func() uint64 {
+		calls++
+		if calls == 1 {
+			return 2 << 30
+		}
+		return 0
+	}
+	budget.enforce(&conf)
+	check(lens{len(files), files0, packages0}, false)
+	if n := budget.Trims(); n != 0 {
+		t.Errorf("got %d trims; want: 0", n)
+	}
+
+	// Trimming the programs is enough
+	budget.heap = func() uint64 {
+		if conf.ProgramCache.Len() > 0 {
+			return 2 << 30
+		}
+		return 0
+	}
+	budget.enforce(&conf)
+	check(lens{0, files0, packages0}, false)
+
+	// The limit is exceeded by more than the caches
+	fill()
+	budget.heap = func() uint64 { return 2 << 30 }
+	budget.enforce(&conf)
+	check(lens{0, 0, 0}, true)
+	if n := budget.Trims(); n != 2 {
+		t.Errorf("got %d trims; want: 2", n)
+	}
+
+	// The caches are not trimmed again right away
+	calls = 0
+	budget.heap = func() uint64 {
+		calls++
+		return 2 << 30
+	}
+	budget.enforce(&conf)
+	if n := budget.Trims(); n != 2 || calls != 0 {
+		t.Errorf("got %d trims and %d heap reads; want: 2 and 0", n, calls)
+	}
+	clock.now = clock.now.Add(minTrimInterval - 1)
+	budget.enforce(&conf)
+	if n := budget.Trims(); n != 2 {
+		t.Errorf("got %d trims; want: 2", n)
+	}
+	clock.now = clock.now.Add(1)
+	budget.enforce(&conf)
+	if n := budget.Trims(); n != 3 {
+		t.Errorf("got %d trims; want: 3", n)
+	}
+
+	// Programs are not cached while the limit is exceeded
+	if _, err := conf.Lookup(files[0], strings.LastIndex(src, "X"), nil); err != nil {
+		t.Fatal(err)
+	}
+	check(lens{0, conf.ASTCache.Len(), conf.PackageIndex.Len()}, true)
+}
+
+// clockEnv is the Environment of the process with the clock now, which
+// advances by step each time it is read.
+type clockEnv struct {
+	Environment
+	now  time.Time
+	step time.Duration
+}
+
+func (e *clockEnv) Now() time.Time {
+	now := e.now
+	e.now = e.now.Add(e.step)
+	return now
+}
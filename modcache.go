@@ -0,0 +1,134 @@
+package godef
+
+import (
+	"go/build"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// A modCacheFile is a file in the module cache.
+type modCacheFile struct {
+	Path    string // module path, e.g. "github.com/BurntSushi/toml"
+	Version string // module version, e.g. "v1.2.3"
+	Dir     string // module directory, e.g. "$GOMODCACHE/github.com/!burnt!sushi/toml@v1.2.3"
+	Rel     string // slash separated path of the file relative to Dir
+}
+
+// modCacheDir returns the module cache directory: $GOMODCACHE, if set,
+// otherwise the "pkg/mod" directory of the first GOPATH entry.
+func modCacheDir(ctxt *build.Context, env Environment) string {
+	if dir := env.Getenv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	if list := filepath.SplitList(ctxt.GOPATH); len(list) != 0 && list[0] != "" {
+		return filepath.Join(list[0], "pkg", "mod")
+	}
+	return ""
+}
+
+// parseModCacheFile returns the module of filename if it is beneath
+// modcache, the module cache directory.
+func parseModCacheFile(modcache, filename string) (*modCacheFile, bool) {
+	if modcache == "" {
+		return nil, false
+	}
+	rel, ok := hasFilePathPrefix(filepath.Clean(filename), modcache)
+	if !ok {
+		return nil, false
+	}
+	elems := strings.Split(filepath.ToSlash(rel), "/")
+	for i, elem := range elems[:len(elems)-1] {
+		j := strings.IndexByte(elem, '@')
+		if j < 0 {
+			continue
+		}
+		if elems[0] == "cache" {
+			return nil, false // download cache
+		}
+		escaped := strings.Join(append(elems[:i:i], elem[:j]), "/")
+		path, ok := unescapeModulePath(escaped)
+		if !ok {
+			return nil, false
+		}
+		return &modCacheFile{
+			Path:    path,
+			Version: elem[j+1:],
+			Dir:     filepath.Join(modcache, filepath.FromSlash(strings.Join(elems[:i+1], "/"))),
+			Rel:     strings.Join(elems[i+1:], "/"),
+		}, true
+	}
+	return nil, false
+}
+
+// unescapeModulePath reverses the case-encoding of module paths in the
+// module cache, in which upper-case letters are replaced by '!' followed
+// by the lower-case letter.
+func unescapeModulePath(escaped string) (string, bool) {
+	var b strings.Builder
+	bang := false
+	for _, r := range escaped {
+		switch {
+		case bang:
+			if r < 'a' || r > 'z' {
+				return "", false
+			}
+			b.WriteRune(unicode.ToUpper(r))
+			bang = false
+		case r == '!':
+			bang = true
+		case unicode.IsUpper(r):
+			return "", false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), !bang
+}
+
+// modCacheImportPath returns the import path of the package containing
+// filename and the directory its import path is relative to, if it is
+// in the module cache.
+func modCacheImportPath(ctxt *build.Context, env Environment, filename string) (importPath, srcdir string, ok bool) {
+	abs, err := absPath(env, filename)
+	if err != nil {
+		return "", "", false
+	}
+	m, ok := parseModCacheFile(modCacheDir(ctxt, env), abs)
+	if !ok {
+		return "", "", false
+	}
+	importPath = m.Path
+	if dir := pathDir(m.Rel); dir != "" {
+		importPath += "/" + dir
+	}
+	return importPath, m.Dir, true
+}
+
+// pathDir is like path.Dir, but returns "" for names without a directory.
+func pathDir(name string) string {
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		return name[:i]
+	}
+	return ""
+}
+
+// remapModCacheFile returns the path of filename, which is in the module
+// cache, in the source checkout of its module listed in checkouts (module
+// path => directory) if the checkout contains the file.  The returned
+// bool reports whether filename is in the module cache and was not
+// remapped, and is therefore read-only.
+func remapModCacheFile(modcache, filename string, checkouts map[string]string) (string, bool) {
+	m, ok := parseModCacheFile(modcache, filename)
+	if !ok {
+		return filename, false
+	}
+	if dir := checkouts[m.Path]; dir != "" {
+		name := filepath.Join(dir, filepath.FromSlash(m.Rel))
+		if _, err := os.Stat(name); err == nil {
+			return name, false
+		}
+	}
+	return filename, true
+}
@@ -0,0 +1,103 @@
+package godef
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseModCacheFile(t *testing.T) {
+	modcache := filepath.FromSlash("/go/pkg/mod")
+	tests := []struct {
+		filename string
+		want     *modCacheFile
+	}{
+		{
+			"/go/pkg/mod/github.com/!burnt!sushi/toml@v1.2.3/decode.go",
+			&modCacheFile{
+				Path:    "github.com/BurntSushi/toml",
+				Version: "v1.2.3",
+				Dir:     "/go/pkg/mod/github.com/!burnt!sushi/toml@v1.2.3",
+				Rel:     "decode.go",
+			},
+		},
+		{
+			"/go/pkg/mod/golang.org/x/tools@v0.1.0/go/loader/loader.go",
+			&modCacheFile{
+				Path:    "golang.org/x/tools",
+				Version: "v0.1.0",
+				Dir:     "/go/pkg/mod/golang.org/x/tools@v0.1.0",
+				Rel:     "go/loader/loader.go",
+			},
+		},
+		{"/go/pkg/mod/cache/download/x@v1/x.go", nil},
+		{"/go/pkg/mod/example.com/x/x.go", nil},
+		{"/go/src/example.com/x@v1/x.go", nil},
+	}
+	for _, x := range tests {
+		got, ok := parseModCacheFile(modcache, filepath.FromSlash(x.filename))
+		if x.want == nil {
+			if ok {
+				t.Errorf("parseModCacheFile(%q) = %+v; want: false", x.filename, got)
+			}
+			continue
+		}
+		x.want.Dir = filepath.FromSlash(x.want.Dir)
+		if !ok || !reflect.DeepEqual(got, x.want) {
+			t.Errorf("parseModCacheFile(%q) = %+v, %t; want: %+v", x.filename, got, ok, x.want)
+		}
+	}
+}
+
+func TestRemapModCacheFile(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	modcache := filepath.Join(tmp, "mod")
+	checkout := filepath.Join(tmp, "toml")
+	if err := os.MkdirAll(checkout, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(checkout, "decode.go"), []byte("package toml\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkouts := map[string]string{"github.com/BurntSushi/toml": checkout}
+	moddir := filepath.Join(modcache, "github.com", "!burnt!sushi", "toml@v1.2.3")
+	tests := []struct {
+		filename string
+		want     string
+		readOnly bool
+	}{
+		{filepath.Join(moddir, "decode.go"), filepath.Join(checkout, "decode.go"), false},
+		{filepath.Join(moddir, "encode.go"), filepath.Join(moddir, "encode.go"), true},
+		{filepath.Join(tmp, "src", "x.go"), filepath.Join(tmp, "src", "x.go"), false},
+	}
+	for _, x := range tests {
+		got, readOnly := remapModCacheFile(modcache, x.filename, checkouts)
+		if got != x.want || readOnly != x.readOnly {
+			t.Errorf("remapModCacheFile(%q) = %q, %t; want: %q, %t",
+				x.filename, got, readOnly, x.want, x.readOnly)
+		}
+	}
+}
+
+func TestGOPATHResolver_ModCache(t *testing.T) {
+	env := &testEnv{env: map[string]string{"GOMODCACHE": filepath.FromSlash("/go/pkg/mod")}, wd: "/"}
+	r := &GOPATHResolver{Context: &build.Default, Env: env}
+	filename := filepath.FromSlash("/go/pkg/mod/github.com/!burnt!sushi/toml@v1.2.3/internal/tz.go")
+	importPath, srcdir, err := r.ResolveImportPath(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "github.com/BurntSushi/toml/internal"; importPath != want {
+		t.Errorf("importPath = %q; want: %q", importPath, want)
+	}
+	if want := filepath.FromSlash("/go/pkg/mod/github.com/!burnt!sushi/toml@v1.2.3"); srcdir != want {
+		t.Errorf("srcdir = %q; want: %q", srcdir, want)
+	}
+}
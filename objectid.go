@@ -0,0 +1,115 @@
+package godef
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// An ObjectID identifies the object denoted by the result of a query
+// independently of its position, so that results can be correlated
+// across queries and edits that move declarations.  Only package-level
+// objects, fields and methods of package-level types, packages and
+// predeclared objects have an ObjectID, local objects have the zero
+// ObjectID.
+type ObjectID struct {
+	PkgPath string // import path of the package, empty for predeclared objects
+	Recv    string // name of the type of a field or method
+	Name    string // name of the object, empty for a package
+}
+
+// IsZero reports whether id is the zero ObjectID.
+func (id ObjectID) IsZero() bool { return id == ObjectID{} }
+
+// String returns id in the form "path.Name", "path.Recv.Name", "path"
+// for a package or "Name" for a predeclared object, e.g. "fmt.Println",
+// "bytes.Buffer.Write" or "len".
+func (id ObjectID) String() string {
+	var parts []string
+	for _, s := range []string{id.PkgPath, id.Recv, id.Name} {
+		if s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// objectID returns the ObjectID of obj.
+func objectID(obj types.Object) ObjectID {
+	if pn, ok := obj.(*types.PkgName); ok {
+		return ObjectID{PkgPath: pn.Imported().Path()}
+	}
+	var path string
+	if obj.Pkg() != nil {
+		path = obj.Pkg().Path()
+	}
+	if obj.Parent() != nil && (obj.Parent() == types.Universe ||
+		obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope()) {
+		return ObjectID{PkgPath: path, Name: obj.Name()}
+	}
+	switch obj := obj.(type) {
+	case *types.Func:
+		if recv := obj.Type().(*types.Signature).Recv(); recv != nil {
+			if named := namedType(recv.Type()); named != nil {
+				return ObjectID{PkgPath: path, Recv: named.Obj().Name(), Name: obj.Name()}
+			}
+		}
+	case *types.Var:
+		if obj.IsField() && obj.Pkg() != nil {
+			if recv := fieldOwner(obj); recv != "" {
+				return ObjectID{PkgPath: path, Recv: recv, Name: obj.Name()}
+			}
+		}
+	}
+	return ObjectID{}
+}
+
+// namedType returns the named type of T or *T, or nil.
+func namedType(T types.Type) *types.Named {
+	if ptr, ok := T.(*types.Pointer); ok {
+		T = ptr.Elem()
+	}
+	named, _ := T.(*types.Named)
+	return named
+}
+
+// fieldOwner returns the name of the package-level struct type that
+// declares field, or "" if it is a field of an unnamed or local type.
+func fieldOwner(field *types.Var) string {
+	scope := field.Pkg().Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if st, ok := tn.Type().Underlying().(*types.Struct); ok {
+			for i := 0; i < st.NumFields(); i++ {
+				if st.Field(i) == field {
+					return name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// astObjectID returns the ObjectID of obj, an object resolved by the
+// parser in f, the file of the query, or the zero ObjectID if obj is
+// not declared at package level or the package of f is not known.
+func astObjectID(q *Query, filename string, f *ast.File, obj *ast.Object) ObjectID {
+	if f.Scope == nil || f.Scope.Lookup(obj.Name) != obj {
+		return ObjectID{}
+	}
+	resolver := q.Resolver
+	if resolver == nil {
+		resolver = &GOPATHResolver{Context: q.Build, Env: q.env()}
+	}
+	path, _, err := resolver.ResolveImportPath(filename)
+	if err != nil {
+		return ObjectID{}
+	}
+	if strings.HasSuffix(f.Name.Name, "_test") && !strings.HasSuffix(path, "_test") {
+		path += "_test" // external test package
+	}
+	return ObjectID{PkgPath: path, Name: obj.Name}
+}
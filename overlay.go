@@ -0,0 +1,95 @@
+package godef
+
+import (
+	"bytes"
+	"go/build"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// useOverlay returns a copy of orig that reads files from overlay, a
+// mapping from absolute file names to contents, instead of the file
+// system.  Files in overlay that do not exist are added to the listings
+// of their directory so that they are part of the package loaded from
+// that directory.
+func useOverlay(orig *build.Context, overlay map[string][]byte) *build.Context {
+	if len(overlay) == 0 {
+		return orig
+	}
+	files := make(map[string][]byte, len(overlay))
+	dirs := make(map[string][]string) // dir => base names of files
+	for name, content := range overlay {
+		name = filepath.Clean(name)
+		files[name] = content
+		dir := filepath.Dir(name)
+		dirs[dir] = append(dirs[dir], filepath.Base(name))
+	}
+
+	copy := *orig // make a copy
+	ctxt := &copy
+	ctxt.OpenFile = func(path string) (io.ReadCloser, error) {
+		if content, ok := files[filepath.Clean(path)]; ok {
+			return ioutil.NopCloser(bytes.NewReader(content)), nil
+		}
+		if orig.OpenFile != nil {
+			return orig.OpenFile(path)
+		}
+		return os.Open(path)
+	}
+	ctxt.ReadDir = func(dir string) ([]os.FileInfo, error) {
+		var list []os.FileInfo
+		var err error
+		if orig.ReadDir != nil {
+			list, err = orig.ReadDir(dir)
+		} else {
+			list, err = ioutil.ReadDir(dir)
+		}
+		names := dirs[filepath.Clean(dir)]
+		if len(names) == 0 {
+			return list, err
+		}
+		seen := make(map[string]bool, len(list))
+		for _, fi := range list {
+			seen[fi.Name()] = true
+		}
+		for _, name := range names {
+			if !seen[name] {
+				size := int64(len(files[filepath.Join(dir, name)]))
+				list = append(list, overlayFileInfo{name: name, size: size})
+			}
+		}
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].Name() < list[j].Name()
+		})
+		return list, nil
+	}
+	ctxt.IsDir = func(path string) bool {
+		if len(dirs[filepath.Clean(path)]) != 0 {
+			return true
+		}
+		if orig.IsDir != nil {
+			return orig.IsDir(path)
+		}
+		fi, err := os.Stat(path)
+		return err == nil && fi.IsDir()
+	}
+	return ctxt
+}
+
+// overlayFileInfo is the os.FileInfo of a file that exists only in an
+// overlay.
+type overlayFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi overlayFileInfo) Name() string       { return fi.name }
+func (fi overlayFileInfo) Size() int64        { return fi.size }
+func (fi overlayFileInfo) Mode() os.FileMode  { return 0444 }
+func (fi overlayFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi overlayFileInfo) IsDir() bool        { return false }
+func (fi overlayFileInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,159 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/tools/go/buildutil"
+)
+
+// packageDefinition outputs the definition of the package denoted by the
+// package clause, import spec or package qualifier (fmt in fmt.Println)
+// at qpos: the package clause of the package's documentation.  It
+// reports whether qpos is a package clause, import spec or qualifier.
+// Qualifiers are only recognized if the parser did not resolve them to
+// a local object and Query.describe is not set, the type checker
+// resolves the others (see importedPackageDefinition).
+func packageDefinition(q *Query, qpos *queryPos) (bool, error) {
+	if len(qpos.path) < 2 {
+		return false, nil
+	}
+	f, _ := qpos.path[len(qpos.path)-1].(*ast.File)
+	if f == nil {
+		return false, nil
+	}
+	filename := qpos.fset.File(f.Pos()).Name()
+	dir := filepath.Dir(filename)
+
+	var bp *build.Package
+	switch n := qpos.path[1].(type) {
+	case *ast.File:
+		if qpos.path[0] != n.Name {
+			return false, nil
+		}
+		bp, _ = q.Build.ImportDir(dir, 0)
+		if bp == nil || bp.Dir == "" {
+			// Report the queried package clause itself.
+			q.Output(qpos.fset, &definitionResult{
+				pos:   n.Name.Pos(),
+				descr: "package " + n.Name.Name,
+				kind:  KindNamespace,
+			})
+			return true, nil
+		}
+	case *ast.ImportSpec:
+		path, err := strconv.Unquote(n.Path.Value)
+		if err != nil {
+			return true, err
+		}
+		bp, err = q.Build.Import(path, dir, 0)
+		if err != nil {
+			return false, nil // e.g. a module, fall back on the type checker
+		}
+	case *ast.SelectorExpr:
+		id, _ := qpos.path[0].(*ast.Ident)
+		if id == nil || n.X != ast.Expr(id) || id.Obj != nil || q.describe {
+			return false, nil
+		}
+		path := importedPackage(f, id.Name)
+		if path == "" {
+			return false, nil
+		}
+		var err error
+		bp, err = q.Build.Import(path, dir, 0)
+		if err != nil {
+			return false, nil // e.g. a module, fall back on the type checker
+		}
+	default:
+		return false, nil
+	}
+
+	name := bp.Name
+	if _, ok := qpos.path[1].(*ast.File); ok {
+		name = f.Name.Name // e.g. an external test package
+	}
+	return true, outputPackage(q, qpos.fset, bp, name)
+}
+
+// importedPackageDefinition outputs the definition of the package
+// imported as path by the file of qpos, as packageDefinition does, for a
+// package name that the type checker resolved, e.g. a qualifier the
+// parser did not.  It reports whether the package was found.
+func importedPackageDefinition(q *Query, qpos *queryPos, path string) (bool, error) {
+	dir := filepath.Dir(qpos.fset.File(qpos.start).Name())
+	bp, err := q.Build.Import(path, dir, 0)
+	if err != nil {
+		q.logf("package %q: %v", path, err)
+		return false, nil
+	}
+	return true, outputPackage(q, qpos.fset, bp, bp.Name)
+}
+
+// outputPackage outputs the package clause documenting bp, whose files
+// belong to the package named name (see packageFiles), as the
+// definition of q.
+func outputPackage(q *Query, fset *token.FileSet, bp *build.Package, name string) error {
+	pos, name, err := packageDoc(q.Build, fset, bp, packageFiles(bp, name))
+	if err != nil {
+		return err
+	}
+	descr := "package " + name
+	var id ObjectID
+	if bp.ImportPath != "" && bp.ImportPath != "." {
+		descr += fmt.Sprintf(" (%q)", bp.ImportPath)
+		id.PkgPath = bp.ImportPath
+	}
+	q.Output(fset, &definitionResult{
+		pos:   pos,
+		descr: descr,
+		kind:  KindNamespace,
+		id:    id,
+	})
+	return nil
+}
+
+// packageFiles returns the files of bp that belong to the package named
+// name, which is either bp.Name or its external test package.
+func packageFiles(bp *build.Package, name string) []string {
+	if name != bp.Name && len(bp.XTestGoFiles) != 0 {
+		return bp.XTestGoFiles
+	}
+	return append(append([]string(nil), bp.GoFiles...), bp.TestGoFiles...)
+}
+
+// packageDoc returns the position and name of the package clause of the
+// file documenting a package: doc.go if it exists, otherwise the first
+// file with a package comment or, failing that, the first file.
+func packageDoc(ctxt *build.Context, fset *token.FileSet, bp *build.Package, files []string) (token.Pos, string, error) {
+	if len(files) == 0 {
+		return token.NoPos, "", fmt.Errorf("package %s has no Go files", bp.ImportPath)
+	}
+	var first *ast.File
+	for _, name := range files {
+		if name == "doc.go" {
+			files = []string{name} // only check doc.go
+			break
+		}
+	}
+	for _, name := range files {
+		if !filepath.IsAbs(name) {
+			name = filepath.Join(bp.Dir, name)
+		}
+		f, err := buildutil.ParseFile(fset, ctxt, nil, "", name, parser.PackageClauseOnly|parser.ParseComments)
+		if f == nil {
+			return token.NoPos, "", err
+		}
+		if f.Doc != nil {
+			return f.Name.Pos(), f.Name.Name, nil
+		}
+		if first == nil {
+			first = f
+		}
+	}
+	return first.Name.Pos(), first.Name.Name, nil
+}
@@ -0,0 +1,50 @@
+package godef
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"runtime"
+	"sync"
+)
+
+// parseWorkers returns the number of files that are parsed concurrently
+// for the Config.ParseWorkers setting n.
+func parseWorkers(n int) int {
+	if n <= 0 {
+		return runtime.NumCPU()
+	}
+	return n
+}
+
+// parseFiles parses the named files, opening them via ctxt so that the
+// effects of overlays are observed, with cache, if non-nil, and at most
+// workers files parsed concurrently (see parseWorkers).  The files are
+// returned in the order of filenames, files that could not be parsed at
+// all are omitted.
+func parseFiles(fset *token.FileSet, ctxt *build.Context, cache *ASTCache, filenames []string, mode parser.Mode, workers int) []*ast.File {
+	files := make([]*ast.File, len(filenames))
+	gate := make(chan struct{}, parseWorkers(workers))
+	var wg sync.WaitGroup
+	for i, name := range filenames {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			gate <- struct{}{}
+			defer func() { <-gate }()
+			files[i], _ = parseFile(fset, ctxt, cache, "", name, mode)
+		}(i, name)
+	}
+	wg.Wait()
+
+	// Eliminate nils, preserving order.
+	n := 0
+	for _, f := range files {
+		if f != nil {
+			files[n] = f
+			n++
+		}
+	}
+	return files[:n]
+}
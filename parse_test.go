@@ -0,0 +1,80 @@
+package godef
+
+import (
+	"fmt"
+	"go/build"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeGeneratedPackage writes a package of n files resembling a
+// generated API client to a temporary directory and returns the names
+// of the files.  The caller must remove the directory.
+func writeGeneratedPackage(tb testing.TB, n int) (dir string, filenames []string) {
+	dir, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		var src strings.Builder
+		fmt.Fprintf(&src, "package client\n\n")
+		for j := 0; j < 20; j++ {
+			fmt.Fprintf(&src, "// Type%[1]d_%[2]d is a generated type.\n"+
+				"type Type%[1]d_%[2]d struct {\n\tID string `json:\"id\"`\n\tCount int `json:\"count\"`\n}\n\n"+
+				"func (t *Type%[1]d_%[2]d) GetID() string {\n\tif t == nil {\n\t\treturn \"\"\n\t}\n\treturn t.ID\n}\n\n",
+				i, j)
+		}
+		name := filepath.Join(dir, fmt.Sprintf("zz_generated_%03d.go", i))
+		if err := ioutil.WriteFile(name, []byte(src.String()), 0644); err != nil {
+			os.RemoveAll(dir)
+			tb.Fatal(err)
+		}
+		filenames = append(filenames, name)
+	}
+	return dir, filenames
+}
+
+func TestParseFiles(t *testing.T) {
+	dir, filenames := writeGeneratedPackage(t, 20)
+	defer os.RemoveAll(dir)
+	missing := filepath.Join(dir, "missing.go")
+	names := append([]string{missing}, filenames...)
+
+	for _, workers := range []int{0, 1, 3} {
+		fset := token.NewFileSet()
+		files := parseFiles(fset, &build.Default, nil, names, 0, workers)
+		if len(files) != len(filenames) {
+			t.Fatalf("workers %d: parsed %d files; want %d", workers, len(files), len(filenames))
+		}
+		for i, f := range files {
+			if name := fset.File(f.Pos()).Name(); name != filenames[i] {
+				t.Errorf("workers %d: file %d is %s; want %s", workers, i, name, filenames[i])
+			}
+		}
+	}
+}
+
+func BenchmarkParseFiles(b *testing.B) {
+	dir, filenames := writeGeneratedPackage(b, 150)
+	defer os.RemoveAll(dir)
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"Serial", 1},
+		{"Parallel", 0}, // runtime.NumCPU()
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				fset := token.NewFileSet()
+				if files := parseFiles(fset, &build.Default, nil, filenames, 0, bench.workers); len(files) != len(filenames) {
+					b.Fatalf("parsed %d files; want %d", len(files), len(filenames))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package godef
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// A program is a loaded and type-checked program that contains the
+// package of a query position.
+//
+// The package loading backend is selected at build time: by default
+// programs are loaded with golang.org/x/tools/go/loader, building with
+// the "godef_packages" tag selects golang.org/x/tools/go/packages.
+type program interface {
+	// Fset returns the file set of the program.
+	Fset() *token.FileSet
+
+	// Files returns the names of the Go files the program was loaded
+	// from.  The file set of the program may contain other files.
+	Files() []string
+
+	// PathEnclosingInterval returns the package and AST path of the
+	// innermost node enclosing [start, end].  The result exact is
+	// as for astutil.PathEnclosingInterval.
+	PathEnclosingInterval(start, end token.Pos) (pkg *packageInfo, path []ast.Node, exact bool)
+
+	// Packages returns the type-checked packages of the program.
+	Packages() []*types.Package
+}
+
+// packageInfo holds the syntax trees and type information of a
+// type-checked package.
+type packageInfo struct {
+	Pkg   *types.Package
+	Files []*ast.File
+	types.Info
+
+	// TypeErrors are the errors reported by the type checker, in the
+	// order they were reported.  They are only recorded for the
+	// packages that were type-checked from source.
+	TypeErrors []types.Error
+}
+
+// appendError records err if it is a type error, it is the Error
+// function of the type checker of the package.
+func (pi *packageInfo) appendError(err error) {
+	if terr, ok := err.(types.Error); ok {
+		pi.TypeErrors = append(pi.TypeErrors, terr)
+	}
+}
+
+// importerFunc is a types.Importer.
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
@@ -0,0 +1,234 @@
+//go:build !godef_packages
+// +build !godef_packages
+
+// The following uses portions of golang.org/x/tools/cmd/guru.
+
+// Copyright 2014 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package godef
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/loader"
+)
+
+// programBackend is the name of the package loading backend.
+const programBackend = "loader"
+
+// exportDataSupported reports whether the backend supports
+// Config.ExportData.
+const exportDataSupported = false
+
+// loaderProgram is a program loaded by golang.org/x/tools/go/loader.
+type loaderProgram struct {
+	prog *loader.Program
+
+	mu    sync.Mutex // guards infos, programs may be cached
+	infos map[*loader.PackageInfo]*packageInfo
+}
+
+// loadProgram loads, parses and type-checks the package containing the
+// query position.
+func loadProgram(q *Query) (program, error) {
+	lconf := loader.Config{Build: q.Build}
+	if cwd, err := q.env().Getwd(); err == nil {
+		lconf.Cwd = cwd // not the working directory of the process
+	}
+	allowErrors(&lconf)
+
+	if _, err := importQueryPackage(q.env(), q.Resolver, q.Pos, q.astCache, &lconf); err != nil {
+		return nil, err
+	}
+	if q.implementations == ImplementationsModule {
+		importModule(q, &lconf)
+	}
+
+	lprog, err := lconf.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &loaderProgram{
+		prog:  lprog,
+		infos: make(map[*loader.PackageInfo]*packageInfo),
+	}, nil
+}
+
+func (p *loaderProgram) Fset() *token.FileSet { return p.prog.Fset }
+
+func (p *loaderProgram) Files() []string {
+	var names []string
+	p.prog.Fset.Iterate(func(f *token.File) bool {
+		names = append(names, f.Name())
+		return true
+	})
+	return names
+}
+
+func (p *loaderProgram) Packages() []*types.Package {
+	var pkgs []*types.Package
+	for pkg := range p.prog.AllPackages {
+		pkgs = append(pkgs, pkg)
+	}
+	sort.Slice(pkgs, func(i, j int) bool { return pkgs[i].Path() < pkgs[j].Path() })
+	return pkgs
+}
+
+func (p *loaderProgram) PathEnclosingInterval(start, end token.Pos) (*packageInfo, []ast.Node, bool) {
+	info, path, exact := p.prog.PathEnclosingInterval(start, end)
+	if info == nil {
+		return nil, path, exact
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pi := p.infos[info]
+	if pi == nil {
+		pi = &packageInfo{Pkg: info.Pkg, Files: info.Files, Info: info.Info}
+		for _, err := range info.Errors {
+			pi.appendError(err)
+		}
+		p.infos[info] = pi
+	}
+	return pi, path, exact
+}
+
+// importQueryPackage finds the package P containing the
+// query position and tells conf to import it.
+// It returns the package's path.
+func importQueryPackage(env Environment, resolver Resolver, pos string, cache *ASTCache, conf *loader.Config) (string, error) {
+	fqpos, err := fastQueryPos(conf.Build, env, pos, cache, false)
+	if err != nil {
+		return "", err // bad query
+	}
+	filename := fqpos.fset.File(fqpos.start).Name()
+
+	if resolver == nil {
+		resolver = &GOPATHResolver{Context: conf.Build, Env: env}
+	}
+	var importPath, srcdir string
+	if dr, ok := resolver.(*DriverResolver); ok {
+		// Find packages with the driver, which knows the location
+		// of generated files.
+		var find findPackageFunc
+		importPath, srcdir, find, err = dr.findPackages(env, filename)
+		if err == nil {
+			conf.FindPackage = find
+		}
+	} else {
+		importPath, srcdir, err = resolver.ResolveImportPath(filename)
+	}
+	if err != nil {
+		// Can't find GOPATH dir.
+		// Treat the query file as its own package.
+		importPath = adHocPackage
+		conf.CreateFromFilenames(importPath, adHocFiles(conf.Build, filename)...)
+	} else {
+		// Check that it's possible to load the queried package.
+		// (e.g. guru tests contain different 'package' decls in same dir.)
+		// Keep consistent with logic in loader/util.go!
+		// Import paths that are not relative to a GOPATH directory
+		// (e.g. modules) are found in their source directory.
+		if dir, err := absPath(env, filepath.Dir(filename)); err == nil && conf.FindPackage == nil {
+			if prefix := importPrefix(importPath, srcdir, dir); prefix != "" {
+				conf.FindPackage = findPackageUnder(prefix, srcdir)
+			}
+		}
+		find := conf.FindPackage
+		if find == nil {
+			find = findPackage
+		}
+		cfg2 := *conf.Build
+		cfg2.CgoEnabled = false
+		bp, err := find(&cfg2, importPath, "", 0)
+		if err != nil {
+			return "", err // no files for package
+		}
+
+		switch pkgContainsFile(bp, filename) {
+		case 'T':
+			conf.ImportWithTests(importPath)
+		case 'X':
+			conf.ImportWithTests(importPath)
+			importPath += "_test" // for TypeCheckFuncBodies
+		case 'G':
+			conf.Import(importPath)
+		default:
+			// This happens for ad-hoc packages like
+			// $GOROOT/src/net/http/triv.go.
+			importPath = adHocPackage
+			conf.CreateFromFilenames(importPath, adHocFiles(conf.Build, filename)...)
+		}
+	}
+
+	conf.TypeCheckFuncBodies = func(p string) bool { return p == importPath }
+
+	return importPath, nil
+}
+
+// importModule tells conf to import the packages of the module
+// containing the query position, without their function bodies.
+func importModule(q *Query, conf *loader.Config) {
+	modpath, root := queryModule(q)
+	if modpath == "" {
+		return
+	}
+	if conf.FindPackage == nil {
+		conf.FindPackage = findPackageUnder(modpath, root)
+	}
+	for _, dir := range packageDirs(conf.Build, root, true) {
+		rel, err := filepath.Rel(root, dir)
+		if err != nil {
+			continue
+		}
+		if rel == "." {
+			conf.Import(modpath)
+		} else {
+			conf.Import(modpath + "/" + filepath.ToSlash(rel))
+		}
+	}
+}
+
+// findPackage is the default loader.Config.FindPackage function.
+func findPackage(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
+	return ctxt.Import(importPath, fromDir, mode)
+}
+
+// findPackageUnder returns a loader.Config.FindPackage function that
+// finds packages with import paths beneath prefix in the corresponding
+// directory of root and all other packages with findPackage.
+func findPackageUnder(prefix, root string) findPackageFunc {
+	return func(ctxt *build.Context, importPath, fromDir string, mode build.ImportMode) (*build.Package, error) {
+		if importPath != prefix && !strings.HasPrefix(importPath, prefix+"/") {
+			return findPackage(ctxt, importPath, fromDir, mode)
+		}
+		rel := strings.TrimPrefix(importPath[len(prefix):], "/")
+		bp, err := ctxt.ImportDir(filepath.Join(root, filepath.FromSlash(rel)), mode)
+		if bp != nil {
+			bp.ImportPath = importPath
+		}
+		return bp, err
+	}
+}
+
+// allowErrors causes type errors to be silently ignored.
+// (Not suitable if SSA construction follows.)
+func allowErrors(lconf *loader.Config) {
+	ctxt := *lconf.Build // copy
+	ctxt.CgoEnabled = false
+	lconf.Build = &ctxt
+	lconf.AllowErrors = true
+	// AllErrors makes the parser always return an AST instead of
+	// bailing out after 10 errors and returning an empty ast.File.
+	lconf.ParserMode = parser.AllErrors
+	lconf.TypeChecker.Error = func(err error) {}
+}
@@ -0,0 +1,353 @@
+//go:build godef_packages
+// +build godef_packages
+
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+)
+
+// programBackend is the name of the package loading backend.
+const programBackend = "packages"
+
+// exportDataSupported reports whether the backend supports
+// Config.ExportData.
+const exportDataSupported = true
+
+// packagesProgram is a program loaded by golang.org/x/tools/go/packages.
+type packagesProgram struct {
+	fset  *token.FileSet
+	pkgs  []*packages.Package // all packages, in dependency order
+	infos map[*packages.Package]*packageInfo
+	files []string // Go files of all packages
+}
+
+// loadProgram loads, parses and type-checks the package containing the
+// query position.
+func loadProgram(q *Query) (program, error) {
+	sp, err := parsePos(q.Pos)
+	if err != nil {
+		return nil, err
+	}
+	filename, err := absPath(q.env(), sp.Filename)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(filename)
+
+	// Only load the syntax of packages, they are type-checked below
+	// so that types.Config can be controlled.
+	fset := q.newFileSet()
+	gate := make(chan struct{}, parseWorkers(q.parseWorkers))
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedImports | packages.NeedDeps | packages.NeedSyntax,
+		Dir:        dir,
+		Env:        packagesEnv(q.Build, q.env(), dir),
+		BuildFlags: packagesBuildFlags(q.Build),
+		Fset:       fset,
+		Tests:      true,
+		ParseFile: func(fset *token.FileSet, name string, src []byte) (*ast.File, error) {
+			// The go command reads files wherever they are, only
+			// parse those that q.Build can read (see HTTPHandler).
+			if q.Build.IsDir != nil && !q.Build.IsDir(filepath.Dir(name)) {
+				return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+			}
+			gate <- struct{}{}
+			defer func() { <-gate }()
+			if q.astCache != nil {
+				return q.astCache.parseFile(fset, name, src, parser.AllErrors)
+			}
+			return parser.ParseFile(fset, name, src, parser.AllErrors)
+		},
+	}
+	// Observe the effects of any modified files.
+	cfg.Overlay = make(map[string][]byte, len(q.overlay)+1)
+	for name, src := range q.overlay {
+		cfg.Overlay[name] = src
+	}
+	if q.Build.OpenFile != nil {
+		if rc, err := q.Build.OpenFile(filename); err == nil {
+			src, err := ioutil.ReadAll(rc)
+			rc.Close()
+			if err == nil {
+				cfg.Overlay[filename] = src
+			}
+		}
+	}
+
+	if q.exportData {
+		// Only the queried packages are parsed, see loadExportData.
+		cfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedImports | packages.NeedDeps | packages.NeedExportsFile
+	}
+	patterns := []string{"file=" + filename}
+	if q.implementations == ImplementationsModule && !q.exportData {
+		if _, root := queryModule(q); root != "" {
+			patterns = append(patterns, filepath.Join(root, "..."))
+		}
+	}
+	roots, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	queried := queriedPackages(roots, filename)
+	if isAdHoc(queried) {
+		// The go command only loads the queried file of a directory
+		// that is not in a package, load the files of its package.
+		if files := adHocFiles(q.Build, filename); len(files) > 1 {
+			if roots, err = packages.Load(cfg, files...); err != nil {
+				return nil, err
+			}
+			queried = queriedPackages(roots, filename)
+		}
+	}
+	if len(queried) == 0 {
+		return nil, &FileNotInPackageError{Filename: filename}
+	}
+	var files []string
+	seen := make(map[string]bool)
+	packages.Visit(roots, nil, func(pkg *packages.Package) {
+		for _, name := range pkg.CompiledGoFiles {
+			if !seen[name] {
+				seen[name] = true
+				files = append(files, name)
+			}
+		}
+	})
+	if q.exportData {
+		return loadExportData(q, fset, roots, queried, files)
+	}
+
+	prog := &packagesProgram{
+		fset:  fset,
+		infos: make(map[*packages.Package]*packageInfo),
+		files: files,
+	}
+	packages.Visit(roots, nil, func(pkg *packages.Package) {
+		prog.pkgs = append(prog.pkgs, pkg)
+	})
+
+	// Type-check the packages in dependency order, only the queried
+	// packages need function bodies and type information.
+	sizes := types.SizesFor("gc", q.Build.GOARCH)
+	for _, pkg := range prog.pkgs {
+		pi := &packageInfo{Files: pkg.Syntax}
+		if queried[pkg] {
+			pi.Info = types.Info{
+				Types:      make(map[ast.Expr]types.TypeAndValue),
+				Defs:       make(map[*ast.Ident]types.Object),
+				Uses:       make(map[*ast.Ident]types.Object),
+				Implicits:  make(map[ast.Node]types.Object),
+				Selections: make(map[*ast.SelectorExpr]*types.Selection),
+				Scopes:     make(map[ast.Node]*types.Scope),
+			}
+		}
+		imports := pkg.Imports
+		tconf := types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				if path == "unsafe" {
+					return types.Unsafe, nil
+				}
+				if imp := imports[path]; imp != nil && prog.infos[imp] != nil {
+					return prog.infos[imp].Pkg, nil
+				}
+				return nil, fmt.Errorf("can't find import: %q", path)
+			}),
+			IgnoreFuncBodies: !queried[pkg],
+			Sizes:            sizes,
+			Error:            pi.appendError,
+		}
+		pi.Pkg, _ = tconf.Check(pkg.PkgPath, fset, pkg.Syntax, &pi.Info)
+		prog.infos[pkg] = pi
+	}
+	return prog, nil
+}
+
+// loadExportData parses and type-checks only the queried packages of
+// roots, their imports are read from the export data produced by the go
+// command (go list -export).  Overlays of imported packages are not
+// observed.  The files are the Go files of all packages of roots.
+func loadExportData(q *Query, fset *token.FileSet, roots []*packages.Package, queried map[*packages.Package]bool, files []string) (program, error) {
+	// The export data of each import path, the test variants of
+	// packages are only used by the packages that import them.
+	exports := make(map[string]string)
+	packages.Visit(roots, nil, func(pkg *packages.Package) {
+		if pkg.ExportFile != "" && (exports[pkg.PkgPath] == "" || !isTestVariant(pkg)) {
+			exports[pkg.PkgPath] = pkg.ExportFile
+		}
+	})
+
+	prog := &packagesProgram{
+		fset:  fset,
+		infos: make(map[*packages.Package]*packageInfo),
+		files: files,
+	}
+	// Type-check the queried packages in dependency order: the
+	// external test package imports the test variant of its package.
+	packages.Visit(roots, nil, func(pkg *packages.Package) {
+		if queried[pkg] {
+			prog.pkgs = append(prog.pkgs, pkg)
+		}
+	})
+	sizes := types.SizesFor("gc", q.Build.GOARCH)
+	for _, pkg := range prog.pkgs {
+		pi := &packageInfo{
+			Info: types.Info{
+				Types:      make(map[ast.Expr]types.TypeAndValue),
+				Defs:       make(map[*ast.Ident]types.Object),
+				Uses:       make(map[*ast.Ident]types.Object),
+				Implicits:  make(map[ast.Node]types.Object),
+				Selections: make(map[*ast.SelectorExpr]*types.Selection),
+				Scopes:     make(map[ast.Node]*types.Scope),
+			},
+		}
+		pi.Files = parseFiles(fset, q.Build, q.astCache, pkg.CompiledGoFiles, parser.AllErrors, q.parseWorkers)
+		pkg.Syntax = pi.Files
+
+		// Prefer the export data of the exact variant of the
+		// packages imported by pkg.
+		pkgExports := make(map[string]string, len(exports))
+		for path, file := range exports {
+			pkgExports[path] = file
+		}
+		for _, imp := range pkg.Imports {
+			if imp.ExportFile != "" {
+				pkgExports[imp.PkgPath] = imp.ExportFile
+			}
+		}
+		gc := importer.ForCompiler(fset, "gc", func(path string) (io.ReadCloser, error) {
+			if file := pkgExports[path]; file != "" {
+				return os.Open(file)
+			}
+			return nil, fmt.Errorf("no export data for %q", path)
+		})
+		imports := pkg.Imports
+		tconf := types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				if path == "unsafe" {
+					return types.Unsafe, nil
+				}
+				imp := imports[path]
+				if imp == nil {
+					return nil, fmt.Errorf("can't find import: %q", path)
+				}
+				if pi := prog.infos[imp]; pi != nil {
+					return pi.Pkg, nil
+				}
+				return gc.Import(imp.PkgPath)
+			}),
+			Sizes: sizes,
+			Error: pi.appendError,
+		}
+		pi.Pkg, _ = tconf.Check(pkg.PkgPath, fset, pi.Files, &pi.Info)
+		prog.infos[pkg] = pi
+	}
+	return prog, nil
+}
+
+// queriedPackages returns the packages of roots containing filename.
+func queriedPackages(roots []*packages.Package, filename string) map[*packages.Package]bool {
+	queried := make(map[*packages.Package]bool)
+	for _, pkg := range roots {
+		for _, name := range pkg.CompiledGoFiles {
+			if sameFile(name, filename) {
+				queried[pkg] = true
+			}
+		}
+	}
+	return queried
+}
+
+// isAdHoc reports whether the queried packages are an ad-hoc package of
+// files (see adHocFiles), or there are none.
+func isAdHoc(queried map[*packages.Package]bool) bool {
+	for pkg := range queried {
+		if pkg.PkgPath != adHocPackage {
+			return false
+		}
+	}
+	return true
+}
+
+// isTestVariant reports whether pkg is a variant of a package that is
+// compiled with its tests, e.g. "p [p.test]".
+func isTestVariant(pkg *packages.Package) bool {
+	return strings.HasSuffix(pkg.ID, ".test]")
+}
+
+// packagesEnv returns the environment of the go command for ctxt run in
+// dir: that of the process with the go variables of env (see
+// goEnvironment) and ctxt.  GOPATH mode is used if dir is not in a
+// module, unless the GO111MODULE variable of env is set.
+func packagesEnv(ctxt *build.Context, env Environment, dir string) []string {
+	vars := append(os.Environ(), goEnvironment(env)...)
+	vars = append(vars,
+		"GOOS="+ctxt.GOOS,
+		"GOARCH="+ctxt.GOARCH,
+		"GOROOT="+ctxt.GOROOT,
+		"GOPATH="+ctxt.GOPATH,
+		"CGO_ENABLED=0",
+	)
+	if gomod, _ := findGoMod(nil, dir); gomod == "" && env.Getenv("GO111MODULE") == "" {
+		vars = append(vars, "GO111MODULE=off")
+	}
+	return vars
+}
+
+// packagesBuildFlags returns the go command build flags for ctxt.
+func packagesBuildFlags(ctxt *build.Context) []string {
+	if len(ctxt.BuildTags) == 0 {
+		return nil
+	}
+	return []string{"-tags=" + strings.Join(ctxt.BuildTags, ",")}
+}
+
+func (p *packagesProgram) Fset() *token.FileSet { return p.fset }
+
+func (p *packagesProgram) Files() []string { return p.files }
+
+func (p *packagesProgram) Packages() []*types.Package {
+	var pkgs []*types.Package
+	for _, pkg := range p.pkgs {
+		if pi := p.infos[pkg]; pi != nil && pi.Pkg != nil {
+			pkgs = append(pkgs, pi.Pkg)
+		}
+	}
+	return pkgs
+}
+
+func (p *packagesProgram) PathEnclosingInterval(start, end token.Pos) (*packageInfo, []ast.Node, bool) {
+	for _, pkg := range p.pkgs {
+		for _, f := range pkg.Syntax {
+			if f.Pos() == token.NoPos {
+				continue // parse error
+			}
+			tf := p.fset.File(f.Pos())
+			if tf == nil {
+				continue
+			}
+			if base := token.Pos(tf.Base()); base <= start && end <= base+token.Pos(tf.Size()) {
+				path, exact := astutil.PathEnclosingInterval(f, start, end)
+				if path != nil {
+					return p.infos[pkg], path, exact
+				}
+			}
+		}
+	}
+	return nil, nil, false
+}
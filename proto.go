@@ -0,0 +1,174 @@
+package godef
+
+import (
+	"bytes"
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// ProtoAnalyzer is an Analyzer that adds the definition in a .proto file
+// of the message, enum, field or enum value declared in a file generated
+// by protoc-gen-go (a .pb.go file) whose header names its source, e.g.
+// "// source: api/v1/user.proto".  The .proto file is looked for in the
+// directory of the .pb.go file (the paths=source_relative option of
+// protoc) and relative to its parent directories.
+type ProtoAnalyzer struct{}
+
+func (ProtoAnalyzer) Name() string { return "proto" }
+
+var (
+	protoSourceRx = regexp.MustCompile(`(?m)^// source: (\S+\.proto)\s*$`)
+	protoTagRx    = regexp.MustCompile(`protobuf:"\w+,(\d+),[^"]*\bname=(\w+)`)
+	protoEnumRx   = regexp.MustCompile(`=\s*(-?\d+)\s*$`)
+)
+
+func (ProtoAnalyzer) Analyze(res *Result) ([]Candidate, error) {
+	if !strings.HasSuffix(res.Position.Filename, ".pb.go") || res.Position.Line == 0 {
+		return nil, nil
+	}
+	src, err := res.ReadSource()
+	if err != nil {
+		return nil, err
+	}
+	header := src
+	if i := bytes.Index(src, []byte("\npackage ")); i >= 0 {
+		header = src[:i]
+	}
+	m := protoSourceRx.FindSubmatch(header)
+	if m == nil {
+		return nil, nil // not generated by protoc-gen-go
+	}
+	ctxt := res.ctxt
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	filename := findProtoFile(useOverlay(ctxt, res.overlay), filepath.Dir(res.Position.Filename), string(m[1]))
+	if filename == "" {
+		return nil, fmt.Errorf("source %s of %s not found", m[1], filepath.Base(res.Position.Filename))
+	}
+	proto, err := readOverlayFile(res.ctxt, res.overlay, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	decl := sourceLine(src, res.Position.Line)
+	name := decl
+	if col := res.Position.Column - 1; 0 <= col && col <= len(decl) {
+		name = decl[col:]
+	}
+	if i := strings.IndexFunc(name, func(r rune) bool { return !isIdentRune(r) }); i >= 0 {
+		name = name[:i]
+	}
+
+	var offset int
+	var descr string
+	switch res.Kind {
+	case KindType:
+		// Nested messages and enums are named Outer_Inner.
+		name = name[strings.LastIndexByte(name, '_')+1:]
+		offset = protoIndex(proto, 0, `\b(?:message|enum)\s+(`+name+`)\b`)
+		descr = "message or enum " + name
+	case KindProperty:
+		m := protoTagRx.FindStringSubmatch(decl)
+		if m == nil {
+			return nil, nil // e.g. an internal field
+		}
+		number, name := m[1], m[2]
+		start := 0
+		if recv := res.Object.Recv; recv != "" {
+			recv = recv[strings.LastIndexByte(recv, '_')+1:]
+			start = protoIndex(proto, 0, `\bmessage\s+(`+recv+`)\b`)
+			if start < 0 {
+				start = 0
+			}
+		}
+		offset = protoIndex(proto, start, `\b(`+name+`)\s*=\s*`+number+`\b`)
+		descr = "field " + name
+	case KindConst:
+		// Enum values are named Enum_VALUE, or Outer_VALUE for an
+		// enum nested in the message Outer.
+		m := protoEnumRx.FindStringSubmatch(decl)
+		if m == nil {
+			return nil, nil
+		}
+		rx := regexp.MustCompile(`(?m)^\s*(\w+)\s*=\s*` + regexp.QuoteMeta(m[1]) + `\s*[;\[]`)
+		offset = -1
+		for _, loc := range rx.FindAllSubmatchIndex(proto, -1) {
+			if value := string(proto[loc[2]:loc[3]]); strings.HasSuffix(name, "_"+value) {
+				offset, descr = loc[2], "enum value "+value
+				break
+			}
+		}
+	default:
+		return nil, nil
+	}
+	if offset < 0 {
+		return nil, nil
+	}
+	p, err := span.PointAt(proto, offset)
+	if err != nil {
+		return nil, err
+	}
+	return []Candidate{{
+		Position:    pointPosition(filename, p),
+		Description: descr,
+		Kind:        res.Kind,
+	}}, nil
+}
+
+// findProtoFile returns the .proto file named source in ctxt, a path
+// relative to dir or one of its parents, or "" if it is not found.
+func findProtoFile(ctxt *build.Context, dir, source string) string {
+	exists := func(name string) bool {
+		return buildutil.FileExists(ctxt, name) && !buildutil.IsDir(ctxt, name)
+	}
+	source = filepath.FromSlash(source)
+	if name := filepath.Join(dir, filepath.Base(source)); exists(name) {
+		return name
+	}
+	for {
+		if name := filepath.Join(dir, source); exists(name) {
+			return name
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// protoIndex returns the offset of the first submatch of the regular
+// expression expr in src after start, or -1.
+func protoIndex(src []byte, start int, expr string) int {
+	loc := regexp.MustCompile(expr).FindSubmatchIndex(src[start:])
+	if loc == nil {
+		return -1
+	}
+	return start + loc[2]
+}
+
+// sourceLine returns the line n, counting from 1, of src.
+func sourceLine(src []byte, n int) string {
+	for ; n > 1; n-- {
+		i := bytes.IndexByte(src, '\n')
+		if i < 0 {
+			return ""
+		}
+		src = src[i+1:]
+	}
+	if i := bytes.IndexByte(src, '\n'); i >= 0 {
+		src = src[:i]
+	}
+	return string(src)
+}
+
+func isIdentRune(r rune) bool {
+	return r == '_' || '0' <= r && r <= '9' || 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
@@ -0,0 +1,137 @@
+package godef
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+
+	"github.com/charlievieth/godef/internal/span"
+)
+
+// A QueryResult is the result of Query.Run, its type depends on the
+// mode of the query: a *Result, *Expression, *DeclRange or Completions.
+type QueryResult interface {
+	queryResult()
+}
+
+func (*Result) queryResult()     {}
+func (*Expression) queryResult() {}
+func (*DeclRange) queryResult()  {}
+func (Completions) queryResult() {}
+
+// Completions are the result of a "complete" query (see Engine.Complete).
+type Completions []Completion
+
+// Run answers q with the method of q.Engine for its mode:
+//
+//	"definition" (or "")  Engine.Lookup, a *Result
+//	"receivertype"        Engine.ReceiverTypeAt, a *Result
+//	"typedef"             Engine.ReceiverTypeAt, a *Result
+//	"implements"          Engine.Lookup with Config.Implementations, a *Result
+//	"references"          Engine.References, a *Result
+//	"describe"            Engine.DescribeRange, an *Expression
+//	"decl"                Engine.EnclosingDecl, a *DeclRange
+//	"complete"            Engine.Complete, Completions
+//
+// An "implements" query adds the methods that may be called by an
+// interface method call at q.Pos to Result.Candidates, in the package
+// of the query unless the Engine searches the module (see
+// ImplementationScope).  Unlike the "implements" mode of guru it does
+// not list the types implementing the type at q.Pos.
+// Except for "describe", q.Pos must be a single offset.
+//
+// If ctx is done before the query is answered Run returns ctx.Err().
+// The spans of the query are children of the span in ctx, see
+// Hooks.StartSpan.
+// The query is abandoned rather than stopped: loading its program runs
+// to completion in the background.
+func (q *Query) Run(ctx context.Context) (QueryResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sp, err := parsePos(q.Pos)
+	if err != nil {
+		return nil, err
+	}
+	e := q.Engine
+	if e == nil {
+		conf := &Config{Context: build.Default, Env: q.Env, Dir: q.Dir, Resolver: q.Resolver}
+		if q.Build != nil {
+			conf.Context = *q.Build
+		}
+		e = NewEngine(conf)
+	}
+
+	type result struct {
+		res QueryResult
+		err error
+	}
+	ch := make(chan result, 1)
+	e = e.withContext(ctx)
+	go func() {
+		res, err := q.run(e, sp)
+		ch <- result{res, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.res, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run answers q with e, see Run.
+func (q *Query) run(e *Engine, sp span.Span) (QueryResult, error) {
+	filename, start, end := sp.Filename, sp.Start.Offset, sp.End.Offset
+	if q.Mode != "describe" && start != end {
+		return nil, fmt.Errorf("%s query: position %s is not a single offset", q.Mode, q.Pos)
+	}
+	var res QueryResult
+	var err error
+	switch q.Mode {
+	case "", "definition":
+		var r *Result
+		if r, err = e.Lookup(filename, start, q.Src); err == nil {
+			res = r
+		}
+	case "implements":
+		e = e.withConfigs(func(c Config) Config {
+			if c.Implementations == ImplementationsNone {
+				c.Implementations = ImplementationsPackage
+			}
+			return c
+		})
+		var r *Result
+		if r, err = e.Lookup(filename, start, q.Src); err == nil {
+			res = r
+		}
+	case "references":
+		var r *Result
+		if r, err = e.References(filename, start, q.Src); err == nil {
+			res = r
+		}
+	case "receivertype", "typedef":
+		var r *Result
+		if r, err = e.ReceiverTypeAt(filename, start, q.Src); err == nil {
+			res = r
+		}
+	case "describe":
+		var x *Expression
+		if x, err = e.DescribeRange(filename, start, end, q.Src); err == nil {
+			res = x
+		}
+	case "decl":
+		var d *DeclRange
+		if d, err = e.EnclosingDecl(filename, start, q.Src); err == nil {
+			res = d
+		}
+	case "complete":
+		var list []Completion
+		if list, err = e.Complete(filename, start, q.Src); err == nil {
+			res = Completions(list)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported query mode %q", q.Mode)
+	}
+	return res, err
+}
@@ -0,0 +1,94 @@
+package godef
+
+import (
+	"context"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQuery_Run(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	const src = "package p\n\ntype T struct{ X int }\n\nfunc F(t T) int {\n\treturn t.X + 1\n}\n\n" +
+		"type I interface{ M() }\n\ntype U struct{}\n\nfunc (U) M() {}\n\nfunc G(i I) {\n\ti.M()\n}\n"
+	filename := filepath.Join(tmp, "p.go")
+	if err := ioutil.WriteFile(filename, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	at := func(s string) int { return strings.Index(src, s) }
+	pos := func(start, end int) string {
+		return fmt.Sprintf("%s:#%d,#%d", filename, start, end)
+	}
+
+	tests := []struct {
+		mode       string
+		start, end int
+		check      func(QueryResult) bool
+	}{
+		{"definition", at("X +"), at("X +"), func(r QueryResult) bool {
+			res, ok := r.(*Result)
+			return ok && res.Position.Offset == at("X int")
+		}},
+		{"receivertype", at("X +"), at("X +"), func(r QueryResult) bool {
+			res, ok := r.(*Result)
+			return ok && res.Position.Offset == at("T struct")
+		}},
+		{"typedef", at("X +"), at("X +"), func(r QueryResult) bool {
+			res, ok := r.(*Result)
+			return ok && res.Position.Offset == at("T struct")
+		}},
+		{"implements", at("M()\n}"), at("M()\n}"), func(r QueryResult) bool {
+			res, ok := r.(*Result)
+			return ok && res.Position.Offset == at("M() }") &&
+				len(res.Candidates) == 1 && res.Candidates[0].Position.Offset == at("M() {}")
+		}},
+		{"describe", at("t.X"), at(" + 1"), func(r QueryResult) bool {
+			x, ok := r.(*Expression)
+			return ok && x.Source == "t.X" && x.Type == "int"
+		}},
+		{"decl", at("return"), at("return"), func(r QueryResult) bool {
+			d, ok := r.(*DeclRange)
+			return ok && d.Name == "F" && d.Kind == KindFunction
+		}},
+		{"complete", at("X +"), at("X +"), func(r QueryResult) bool {
+			list, ok := r.(Completions)
+			return ok && len(list) == 1 && list[0].Name == "X"
+		}},
+	}
+	for _, test := range tests {
+		q := &Query{Mode: test.mode, Pos: pos(test.start, test.end), Build: &build.Default}
+		res, err := q.Run(context.Background())
+		if err != nil {
+			t.Errorf("%s: %v", test.mode, err)
+			continue
+		}
+		if !test.check(res) {
+			t.Errorf("%s: unexpected result %#v", test.mode, res)
+		}
+	}
+
+	e := NewEngine(&Config{Context: build.Default})
+	for _, q := range []*Query{
+		{Mode: "callers", Pos: pos(at("T"), at("T")), Engine: e},
+		{Mode: "definition", Pos: pos(at("t.X"), at(" + 1")), Engine: e},
+	} {
+		if _, err := q.Run(context.Background()); err == nil {
+			t.Errorf("%s %s: expected an error", q.Mode, q.Pos)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	q := &Query{Mode: "definition", Pos: pos(at("X +"), at("X +")), Engine: e}
+	if _, err := q.Run(ctx); err != context.Canceled {
+		t.Errorf("got error %v; want: %v", err, context.Canceled)
+	}
+}
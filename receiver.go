@@ -0,0 +1,94 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+)
+
+// receiverTypeDefinition reports the location of the definition of the
+// type of x, the operand of the selector x.Sel enclosing the query
+// position.  A pointer is followed to its element type, so that for a
+// p of type *T the definition of T is reported.  A type alias is
+// followed to the type it denotes if Query.resolveAliases is set.
+func receiverTypeDefinition(q *Query) error {
+	lprog, err := q.loadProgram()
+	if err != nil {
+		return err
+	}
+
+	qpos, err := parseQueryPos(lprog, q.Pos, false, q.identEnd)
+	if err != nil {
+		return err
+	}
+
+	sel := enclosingSelector(qpos.path)
+	if sel == nil {
+		return &NotFoundError{Err: ErrNoSelector}
+	}
+	if id, ok := sel.X.(*ast.Ident); ok {
+		if _, ok := qpos.info.Uses[id].(*types.PkgName); ok {
+			return &NotFoundError{
+				Err:    ErrNoSelector,
+				Reason: fmt.Sprintf("%s is a package, not a value", id.Name),
+			}
+		}
+	}
+
+	tv, ok := qpos.info.Types[sel.X]
+	if !ok || tv.Type == nil {
+		return &NotFoundError{Err: ErrNoObject}
+	}
+	T := tv.Type
+	if ptr, ok := T.(*types.Pointer); ok {
+		T = ptr.Elem()
+	}
+
+	var obj types.Object
+	switch T := T.(type) {
+	case *types.Named:
+		obj = T.Obj()
+	case *types.Basic:
+		obj = types.Universe.Lookup(T.Name()) // nil for untyped types
+	case interface{ Obj() *types.TypeName }: // *types.Alias, Go 1.22 and later
+		obj = T.Obj()
+	}
+	if obj == nil {
+		return &NotFoundError{
+			Err:    ErrNotFound,
+			Reason: fmt.Sprintf("%s has unnamed type %s", types.ExprString(sel.X), qpos.typeString(T)),
+		}
+	}
+
+	var aliases []*types.TypeName
+	if q.resolveAliases {
+		obj, aliases = resolveAlias(lprog, obj)
+	}
+	if !obj.Pos().IsValid() {
+		builtinDefinition(q, lprog.Fset(), obj, qpos.objectString(obj))
+		addAliases(q, lprog, qpos, aliases)
+		return nil
+	}
+	q.Output(lprog.Fset(), &definitionResult{
+		pos:   q.objectPos(lprog, obj),
+		descr: qpos.objectString(obj),
+		kind:  KindType,
+	})
+	addAliases(q, lprog, qpos, aliases)
+	return nil
+}
+
+// enclosingSelector returns the selector expression of which path[0],
+// or the identifier at path[0], is a part; it returns nil otherwise.
+func enclosingSelector(path []ast.Node) *ast.SelectorExpr {
+	if len(path) != 0 {
+		if _, ok := path[0].(*ast.Ident); ok {
+			path = path[1:]
+		}
+	}
+	if len(path) != 0 {
+		sel, _ := path[0].(*ast.SelectorExpr)
+		return sel
+	}
+	return nil
+}
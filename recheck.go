@@ -0,0 +1,208 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// A cachedPackage is the queried package of a cached program, which is
+// type-checked again if only its files change (see ProgramCache).
+type cachedPackage struct {
+	info    *packageInfo
+	files   []string                  // names of the files of info
+	imports map[string]*types.Package // import path in the files => imported package
+}
+
+// newCachedPackage returns the queried package of prog, loaded by q, or
+// nil if it cannot be type-checked again on its own: if it imports "C"
+// or a package that was not found, or if another package of prog
+// imports it, e.g. its external test package.
+func newCachedPackage(q *Query, prog program) *cachedPackage {
+	sp, err := parsePos(q.Pos)
+	if err != nil {
+		return nil
+	}
+	fset := prog.Fset()
+	var info *packageInfo
+	fset.Iterate(func(f *token.File) bool {
+		if sameFile(sp.Filename, f.Name()) {
+			pos := token.Pos(f.Base())
+			info, _, _ = prog.PathEnclosingInterval(pos, pos)
+		}
+		return info == nil
+	})
+	if info == nil || info.Pkg == nil {
+		return nil
+	}
+	for _, pkg := range prog.Packages() {
+		for _, imp := range pkg.Imports() {
+			if imp == info.Pkg {
+				return nil
+			}
+		}
+	}
+
+	cp := &cachedPackage{info: info, imports: make(map[string]*types.Package)}
+	for _, f := range info.Files {
+		tf := fset.File(f.Pos())
+		if tf == nil {
+			return nil
+		}
+		cp.files = append(cp.files, tf.Name())
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || path == "C" {
+				return nil
+			}
+			obj := info.Implicits[spec]
+			if spec.Name != nil {
+				obj = info.Defs[spec.Name]
+			}
+			pkgName, ok := obj.(*types.PkgName)
+			if !ok {
+				return nil
+			}
+			cp.imports[path] = pkgName.Imported()
+		}
+	}
+	return cp
+}
+
+// contains reports whether the named files are files of cp.
+func (cp *cachedPackage) contains(filenames []string) bool {
+	files := make(map[string]bool, len(cp.files))
+	for _, name := range cp.files {
+		files[name] = true
+	}
+	for _, name := range filenames {
+		if !files[name] {
+			return false
+		}
+	}
+	return true
+}
+
+// maxRechecks is the number of times the queried package of a cached
+// program is type-checked again before the program is loaded again.
+// The files of each recheck are added to the FileSet of the program,
+// which is shared with the packages it imports, so a program that is
+// rechecked indefinitely, e.g. by a server, would grow without bound.
+const maxRechecks = 32
+
+// recheck returns the cache entry of the program of e whose queried
+// package is type-checked again, against the packages it imports in
+// the program, with the current contents of its files in q.Build.  It
+// returns nil if the package must be loaded again, e.g. because it
+// imports another package or a file no longer matches the build
+// constraints, or after maxRechecks.
+func (e *cachedProgram) recheck(q *Query) *cachedProgram {
+	cp := e.pkg
+	base, old, rechecks := e.prog, cp.info, 1
+	if r, ok := base.(*recheckedProgram); ok {
+		base, old, rechecks = r.program, r.old, r.rechecks+1
+	}
+	if rechecks > maxRechecks {
+		q.logf("loading program again after %d rechecks", maxRechecks)
+		return nil
+	}
+	for _, name := range cp.files {
+		if ok, err := q.Build.MatchFile(filepath.Dir(name), filepath.Base(name)); err != nil || !ok {
+			return nil
+		}
+	}
+	fset := base.Fset()
+	files := parseFiles(fset, q.Build, q.astCache, cp.files, parser.AllErrors, q.parseWorkers)
+	if len(files) != len(cp.files) {
+		return nil
+	}
+	for _, f := range files {
+		for _, spec := range f.Imports {
+			path, err := strconv.Unquote(spec.Path.Value)
+			if err != nil || cp.imports[path] == nil {
+				return nil
+			}
+		}
+	}
+
+	pi := &packageInfo{
+		Files: files,
+		Info: types.Info{
+			Types:      make(map[ast.Expr]types.TypeAndValue),
+			Defs:       make(map[*ast.Ident]types.Object),
+			Uses:       make(map[*ast.Ident]types.Object),
+			Implicits:  make(map[ast.Node]types.Object),
+			Selections: make(map[*ast.SelectorExpr]*types.Selection),
+			Scopes:     make(map[ast.Node]*types.Scope),
+		},
+	}
+	tconf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if imp := cp.imports[path]; imp != nil {
+				return imp, nil
+			}
+			return nil, fmt.Errorf("can't find import: %q", path)
+		}),
+		Sizes: types.SizesFor("gc", q.Build.GOARCH),
+		Error: pi.appendError,
+	}
+	pi.Pkg, _ = tconf.Check(cp.info.Pkg.Path(), fset, files, &pi.Info)
+	q.stats.FilesParsed += len(files)
+	q.stats.Rechecks++
+	q.logf("type-checked package %s again", pi.Pkg.Path())
+
+	e1 := newCachedProgram(q, &recheckedProgram{program: base, old: old, info: pi, rechecks: rechecks})
+	if e1 == nil {
+		return nil
+	}
+	e1.pkg = &cachedPackage{info: pi, files: cp.files, imports: cp.imports}
+	return e1
+}
+
+// A recheckedProgram is a cached program whose queried package was
+// type-checked again, after its files changed (see ProgramCache).
+type recheckedProgram struct {
+	program               // the cached program
+	old      *packageInfo // the queried package in program
+	info     *packageInfo // the queried package type-checked again
+	rechecks int          // times the package of program was type-checked again
+}
+
+func (p *recheckedProgram) PathEnclosingInterval(start, end token.Pos) (*packageInfo, []ast.Node, bool) {
+	fset := p.Fset()
+	in := func(f *ast.File) bool {
+		tf := fset.File(f.Pos())
+		base := token.Pos(tf.Base())
+		return base <= start && end <= base+token.Pos(tf.Size())
+	}
+	for _, f := range p.info.Files {
+		if in(f) {
+			path, exact := astutil.PathEnclosingInterval(f, start, end)
+			return p.info, path, exact
+		}
+	}
+	for _, f := range p.old.Files {
+		if in(f) {
+			return nil, nil, false // an old version of a file
+		}
+	}
+	return p.program.PathEnclosingInterval(start, end)
+}
+
+func (p *recheckedProgram) Packages() []*types.Package {
+	pkgs := p.program.Packages()
+	list := make([]*types.Package, len(pkgs))
+	for i, pkg := range pkgs {
+		if pkg == p.old.Pkg {
+			pkg = p.info.Pkg
+		}
+		list[i] = pkg
+	}
+	return list
+}
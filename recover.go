@@ -0,0 +1,126 @@
+package godef
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// recoverDefinition is the fallback of checkedDefinition for an
+// identifier id that the type checker did not resolve because its
+// package has type errors, e.g. the selector of an operand of invalid
+// type or a key of a composite literal of invalid type.  The object is
+// looked up by the name of id: in the package of a qualified
+// identifier, in the scope enclosing id, or among the fields and methods
+// of the operand of a selector or else of the named types of the
+// package.  As it may not be the object id would denote, it is output
+// with StrategyRecovered and the type error nearest to id.  It reports
+// whether an object was found.
+func recoverDefinition(q *Query, lprog program, qpos *queryPos, id *ast.Ident) bool {
+	terr, ok := nearestTypeError(qpos)
+	if !ok {
+		return false // the package has no type errors
+	}
+	obj := recoverObject(qpos, id)
+	if obj == nil || !obj.Pos().IsValid() {
+		return false
+	}
+	q.logf("recovered %s by name, the package has type errors: %v", id.Name, terr)
+
+	var declPath []ast.Node
+	if v, ok := obj.(*types.Var); ok && !v.IsField() {
+		_, declPath, _ = lprog.PathEnclosingInterval(obj.Pos(), obj.Pos())
+	}
+	q.stats.Strategy = StrategyRecovered
+	q.Output(lprog.Fset(), &definitionResult{
+		pos:     q.objectPos(lprog, obj),
+		descr:   qpos.objectString(obj),
+		kind:    objectKind(obj, declPath),
+		id:      objectID(obj),
+		typeErr: terr,
+	})
+	return true
+}
+
+// recoverObject returns the object named id, the query identifier of
+// qpos, as described by recoverDefinition, or nil.
+func recoverObject(qpos *queryPos, id *ast.Ident) types.Object {
+	info := qpos.info
+	switch parent := qpos.path[1].(type) {
+	case *ast.SelectorExpr:
+		if parent.Sel != id {
+			break
+		}
+		if x, ok := parent.X.(*ast.Ident); ok {
+			if pkgName, ok := info.Uses[x].(*types.PkgName); ok {
+				return pkgName.Imported().Scope().Lookup(id.Name)
+			}
+		}
+		if tv, ok := info.Types[parent.X]; ok && tv.Type != nil && tv.Type != types.Typ[types.Invalid] {
+			if obj, _, _ := types.LookupFieldOrMethod(tv.Type, true, info.Pkg, id.Name); obj != nil {
+				return obj
+			}
+		}
+		return memberByName(info.Pkg, id.Name)
+
+	case *ast.KeyValueExpr:
+		// The key of a composite literal of invalid type may be an
+		// expression or a field name.
+		if parent.Key != id {
+			break
+		}
+		if obj := lookupParent(info.Pkg, id); obj != nil {
+			return obj
+		}
+		return memberByName(info.Pkg, id.Name)
+	}
+	return lookupParent(info.Pkg, id)
+}
+
+// lookupParent returns the object named id in the scope of pkg
+// enclosing id, or nil.
+func lookupParent(pkg *types.Package, id *ast.Ident) types.Object {
+	if scope := pkg.Scope().Innermost(id.Pos()); scope != nil {
+		_, obj := scope.LookupParent(id.Name, id.Pos())
+		return obj
+	}
+	return pkg.Scope().Lookup(id.Name)
+}
+
+// memberByName returns the first field or method named name of the
+// package-level named types of pkg, in the order of their names, or nil.
+func memberByName(pkg *types.Package, name string) types.Object {
+	scope := pkg.Scope()
+	for _, tname := range scope.Names() {
+		if tn, ok := scope.Lookup(tname).(*types.TypeName); ok {
+			if obj, _, _ := types.LookupFieldOrMethod(tn.Type(), true, pkg, name); obj != nil {
+				return obj
+			}
+		}
+	}
+	return nil
+}
+
+// nearestTypeError returns the first type error of the package of qpos
+// reported within the innermost node of qpos.path that contains one, or
+// else the first type error of the package.  Soft errors, e.g. unused
+// variables, are ignored as they do not prevent identifiers from being
+// resolved.  It returns false if there are none.
+func nearestTypeError(qpos *queryPos) (types.Error, bool) {
+	var errs []types.Error
+	for _, err := range qpos.info.TypeErrors {
+		if !err.Soft {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return types.Error{}, false
+	}
+	for _, n := range qpos.path {
+		for _, err := range errs {
+			if n.Pos() <= err.Pos && err.Pos < n.End() {
+				return err, true
+			}
+		}
+	}
+	return errs[0], true
+}
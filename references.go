@@ -0,0 +1,95 @@
+package godef
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"sort"
+)
+
+// references answers a references query: the definition of the
+// identifier at the query position, as for a definition query with the
+// type checker, and the uses of the object it denotes in the package of
+// the queried file.  Uses in other packages, e.g. those importing the
+// package of the definition, are not searched for.
+func references(q *Query) error {
+	lprog, err := q.loadProgram()
+	if err != nil {
+		return err
+	}
+	q.prog = lprog // reused by checkedDefinition
+	if err := checkedDefinition(q); err != nil {
+		return err
+	}
+
+	qpos, err := parseQueryPos(lprog, q.Pos, false, q.identEnd)
+	if err != nil {
+		return err
+	}
+	id, _ := qpos.path[0].(*ast.Ident)
+	if id == nil {
+		return nil
+	}
+	obj := qpos.info.Uses[id]
+	if obj == nil {
+		obj = qpos.info.Defs[id]
+	}
+	if obj == nil {
+		return nil // e.g. a label or the variable of a type switch
+	}
+
+	var uses []*ast.Ident
+	for use, o := range qpos.info.Uses {
+		if o == obj {
+			uses = append(uses, use)
+		}
+	}
+	// The order of the files in the file set depends on how they were
+	// parsed, sort by file name.
+	fset := lprog.Fset()
+	sort.Slice(uses, func(i, j int) bool {
+		pi, pj := fset.Position(uses[i].Pos()), fset.Position(uses[j].Pos())
+		if pi.Filename != pj.Filename {
+			return pi.Filename < pj.Filename
+		}
+		return pi.Offset < pj.Offset
+	})
+	kind := objectKind(obj, nil)
+	for _, use := range uses {
+		q.result.references = append(q.result.references, definitionResult{
+			pos:   use.Pos(),
+			descr: enclosingDeclString(lprog, use.Pos()),
+			kind:  kind,
+		})
+	}
+	q.logf("found %d uses of %s", len(uses), obj.Name())
+	return nil
+}
+
+// enclosingDeclString describes the package-level declaration enclosing
+// pos, e.g. "func F", "method (*T).M" or "var x".
+func enclosingDeclString(lprog program, pos token.Pos) string {
+	_, path, _ := lprog.PathEnclosingInterval(pos, pos)
+	for _, n := range path {
+		switch decl := n.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil || len(decl.Recv.List) == 0 {
+				return "func " + decl.Name.Name
+			}
+			return "method (" + types.ExprString(decl.Recv.List[0].Type) + ")." + decl.Name.Name
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				if spec.Pos() <= pos && pos < spec.End() {
+					switch spec := spec.(type) {
+					case *ast.ValueSpec:
+						return decl.Tok.String() + " " + spec.Names[0].Name
+					case *ast.TypeSpec:
+						return "type " + spec.Name.Name
+					}
+				}
+			}
+			return decl.Tok.String()
+		}
+	}
+	return ""
+}
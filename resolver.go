@@ -0,0 +1,194 @@
+package godef
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A Resolver resolves the import path of the package containing a file
+// and the source directory it is relative to.
+//
+// Resolvers are used by the default (golang.org/x/tools/go/loader)
+// package loading backend; the go/packages backend resolves packages
+// with the go command or GOPACKAGESDRIVER.
+type Resolver interface {
+	ResolveImportPath(filename string) (importPath, srcdir string, err error)
+}
+
+// A GOPATHResolver resolves import paths relative to the GOROOT and
+// GOPATH directories of a build.Context, or the module cache.
+type GOPATHResolver struct {
+	Context *build.Context
+	Env     Environment // (optional) process environment, defaults to OSEnvironment
+}
+
+func (r *GOPATHResolver) ResolveImportPath(filename string) (string, string, error) {
+	env := r.Env
+	if env == nil {
+		env = OSEnvironment
+	}
+	if importPath, srcdir, ok := modCacheImportPath(r.Context, env, filename); ok {
+		return importPath, srcdir, nil
+	}
+	srcdir, importPath, err := guessImportPath(env, filename, r.Context)
+	return importPath, srcdir, err
+}
+
+// A ModuleResolver resolves import paths relative to the module root
+// (the directory containing the go.mod file) enclosing a file, or the
+// module cache.
+type ModuleResolver struct {
+	Env Environment // (optional) process environment, defaults to OSEnvironment
+
+	// Context, if non-nil, is the file system in which go.mod files are
+	// found (see build.Context.OpenFile), e.g. Config.Context, and its
+	// GOPATH locates the module cache unless GOMODCACHE is set in Env.
+	// It defaults to build.Default.
+	Context *build.Context
+}
+
+func (r *ModuleResolver) ResolveImportPath(filename string) (string, string, error) {
+	env := r.Env
+	if env == nil {
+		env = OSEnvironment
+	}
+	ctxt := r.Context
+	if ctxt == nil {
+		ctxt = &build.Default
+	}
+	if importPath, srcdir, ok := modCacheImportPath(ctxt, env, filename); ok {
+		return importPath, srcdir, nil
+	}
+	abs, err := absPath(env, filename)
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Dir(abs)
+	gomod, data := findGoMod(r.Context, dir)
+	if gomod == "" {
+		return "", "", fmt.Errorf("%s is not in a module: go.mod file not found", filename)
+	}
+	modpath := moduleDirective(data)
+	if modpath == "" {
+		return "", "", fmt.Errorf("%s: no module directive", gomod)
+	}
+	root := filepath.Dir(gomod)
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return "", "", err
+	}
+	if rel == "." {
+		return modpath, root, nil
+	}
+	return modpath + "/" + filepath.ToSlash(rel), root, nil
+}
+
+// moduleDirective returns the module path of the "module" directive in
+// data, the contents of a go.mod file.
+func moduleDirective(data []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) >= 2 && f[0] == "module" {
+			if s, err := strconv.Unquote(f[1]); err == nil {
+				return s
+			}
+			return f[1]
+		}
+	}
+	return ""
+}
+
+// A DriverResolver resolves import paths with an external
+// GOPACKAGESDRIVER program (e.g. the Bazel rules_go driver).
+// When used with the default loading backend the driver also supplies
+// the files of the queried package and its dependencies, which allows
+// generated files outside the source tree to be found.
+type DriverResolver struct {
+	// Driver is the driver program, if empty the GOPACKAGESDRIVER
+	// variable of the environment of the query (see Config.Env) or a
+	// "gopackagesdriver" binary in PATH is used.
+	Driver string
+	Env    []string // (optional) environment of the driver
+}
+
+func (r *DriverResolver) ResolveImportPath(filename string) (string, string, error) {
+	importPath, srcdir, _, err := r.findPackages(OSEnvironment, filename)
+	return importPath, srcdir, err
+}
+
+// findPackages is like ResolveImportPath in the environment env, but
+// also returns a function that finds the packages of the file's
+// dependencies with the driver.
+func (r *DriverResolver) findPackages(env Environment, filename string) (string, string, findPackageFunc, error) {
+	abs, err := absPath(env, filename)
+	if err != nil {
+		return "", "", nil, err
+	}
+	driver, err := findDriver(env, r.Driver)
+	if err != nil {
+		return "", "", nil, err
+	}
+	dir := filepath.Dir(abs)
+	mode := driverModeName | driverModeFiles | driverModeCompiledGoFiles |
+		driverModeImports | driverModeDeps
+	resp, err := runDriver(driver, dir, r.Env, mode, "file="+abs)
+	if err != nil {
+		return "", "", nil, err
+	}
+	for _, pkg := range resp.Packages {
+		if !pkg.isTestVariant() && pkg.containsFile(abs) && pkg.PkgPath != "" {
+			return pkg.PkgPath, importRoot(pkg.PkgPath, dir), driverFindPackage(resp), nil
+		}
+	}
+	for _, pkg := range resp.Packages {
+		if pkg.containsFile(abs) && pkg.PkgPath != "" {
+			// Test file
+			path := strings.TrimSuffix(pkg.PkgPath, "_test")
+			return path, importRoot(path, dir), driverFindPackage(resp), nil
+		}
+	}
+	return "", "", nil, errors.New("gopackagesdriver: no package contains file " + filename)
+}
+
+// importRoot returns the directory that importPath, the import path of
+// dir, is relative to.  It is the parent of the trailing elements of dir
+// that match importPath, e.g. the root of "example.com/m/a" in
+// "/repo/m/a" is "/repo".
+func importRoot(importPath, dir string) string {
+	for importPath != "" && importPath != "." {
+		base := filepath.Base(dir)
+		if importPath != base && !strings.HasSuffix(importPath, "/"+base) {
+			break
+		}
+		importPath = strings.TrimSuffix(strings.TrimSuffix(importPath, base), "/")
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// importPrefix returns the prefix that must be prepended to the path of
+// a directory relative to srcdir to form its import path, given that
+// importPath is the import path of dir.  For example, the prefix of a
+// module is the module path.  The prefix is empty if import paths are
+// relative to srcdir, as in GOPATH.
+func importPrefix(importPath, srcdir, dir string) string {
+	rel, err := filepath.Rel(srcdir, dir)
+	if err != nil {
+		return ""
+	}
+	rel = filepath.ToSlash(rel)
+	switch {
+	case rel == ".":
+		return importPath
+	case strings.HasSuffix(importPath, "/"+rel):
+		return strings.TrimSuffix(importPath, "/"+rel)
+	}
+	return ""
+}
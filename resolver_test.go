@@ -0,0 +1,142 @@
+package godef
+
+import (
+	"go/build"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestModuleResolver(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "a", "b")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	gomod := []byte("module \"example.com/m\"\n\ngo 1.15\n")
+	if err := ioutil.WriteFile(filepath.Join(tmp, "go.mod"), gomod, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var r ModuleResolver
+	tests := []struct {
+		filename   string
+		importPath string
+	}{
+		{filepath.Join(tmp, "m.go"), "example.com/m"},
+		{filepath.Join(dir, "b.go"), "example.com/m/a/b"},
+	}
+	for _, x := range tests {
+		importPath, srcdir, err := r.ResolveImportPath(x.filename)
+		if err != nil {
+			t.Errorf("%s: %v", x.filename, err)
+			continue
+		}
+		if importPath != x.importPath || srcdir != tmp {
+			t.Errorf("%s: exp (%q, %q) got (%q, %q)", x.filename, x.importPath, tmp,
+				importPath, srcdir)
+		}
+		dir := filepath.Dir(x.filename)
+		if prefix := importPrefix(importPath, srcdir, dir); prefix != "example.com/m" {
+			t.Errorf("importPrefix(%q, %q, %q): got %q", importPath, srcdir, dir, prefix)
+		}
+	}
+}
+
+func TestModuleResolver_ModCache(t *testing.T) {
+	// The module cache is that of the GOPATH of the resolver's Context,
+	// not that of build.Default.
+	gopath := filepath.Join(string(filepath.Separator)+"gopath", "x")
+	ctxt := build.Default
+	ctxt.GOPATH = gopath
+	r := &ModuleResolver{Context: &ctxt, Env: &testEnv{wd: "/"}}
+	filename := filepath.Join(gopath, "pkg", "mod", "example.com", "m@v1.0.0", "a", "a.go")
+	importPath, srcdir, err := r.ResolveImportPath(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(gopath, "pkg", "mod", "example.com", "m@v1.0.0"); importPath != "example.com/m/a" || srcdir != want {
+		t.Errorf("got (%q, %q); want: (%q, %q)", importPath, srcdir, "example.com/m/a", want)
+	}
+}
+
+func TestImportRoot(t *testing.T) {
+	sep := string(filepath.Separator)
+	tests := []struct {
+		importPath, dir, root string
+	}{
+		{"example.com/m/a", sep + filepath.Join("repo", "m", "a"), sep + "repo"},
+		{"example.com/x/a", sep + filepath.Join("repo", "a"), sep + "repo"},
+		{"a/b", sep + filepath.Join("src", "a", "b"), sep + "src"},
+		{"c", sep + filepath.Join("src", "a", "b"), sep + filepath.Join("src", "a", "b")},
+	}
+	for _, x := range tests {
+		if root := importRoot(x.importPath, x.dir); root != x.root {
+			t.Errorf("importRoot(%q, %q): exp %q got %q", x.importPath, x.dir, x.root, root)
+		}
+	}
+}
+
+func TestDriverFindPackage(t *testing.T) {
+	resp := &driverResponse{
+		Packages: []*driverPackage{
+			{
+				ID:              "//p:go_default_library",
+				Name:            "p",
+				PkgPath:         "example.com/p",
+				GoFiles:         []string{"/repo/p/p.go"},
+				CompiledGoFiles: []string{"/repo/p/p.go", "/out/p/gen.go"},
+			},
+			{
+				ID:              "//p:go_default_test [//p:go_default_test.test]",
+				Name:            "p",
+				PkgPath:         "example.com/p",
+				CompiledGoFiles: []string{"/repo/p/p.go", "/out/p/gen.go", "/repo/p/p_test.go"},
+			},
+			{
+				ID:      "//q:go_default_library",
+				Name:    "q",
+				PkgPath: "example.com/q",
+				GoFiles: []string{"/repo/q/q.go"},
+				Imports: map[string]string{"p": "//p:go_default_library"},
+			},
+		},
+	}
+	find := driverFindPackage(resp)
+	for _, path := range []string{"example.com/p", "p"} {
+		bp, err := find(&build.Default, path, "", 0)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if bp.Dir != "/repo/p" || bp.Name != "p" || bp.ImportPath != "example.com/p" {
+			t.Errorf("%s: got Dir=%q Name=%q ImportPath=%q", path, bp.Dir, bp.Name, bp.ImportPath)
+		}
+		if want := []string{"/repo/p/p.go", "/out/p/gen.go"}; !reflect.DeepEqual(bp.GoFiles, want) {
+			t.Errorf("%s: GoFiles = %q; want: %q", path, bp.GoFiles, want)
+		}
+		if want := []string{"/repo/p/p_test.go"}; !reflect.DeepEqual(bp.TestGoFiles, want) {
+			t.Errorf("%s: TestGoFiles = %q; want: %q", path, bp.TestGoFiles, want)
+		}
+	}
+	if _, err := find(&build.Default, "example.com/missing", "", 0); err == nil {
+		t.Error("expected error for package unknown to driver")
+	}
+}
+
+func TestDriverResolver_Env(t *testing.T) {
+	// The driver is found in the environment of the query, not that of
+	// the process.
+	env := &testEnv{env: map[string]string{"GOPACKAGESDRIVER": "off"}, wd: "/repo"}
+	r := &DriverResolver{}
+	_, _, _, err := r.findPackages(env, "p/p.go")
+	if err == nil || !strings.Contains(err.Error(), "GOPACKAGESDRIVER=off") {
+		t.Errorf("got error %v; want the driver to be disabled", err)
+	}
+}
@@ -0,0 +1,121 @@
+package godef
+
+import (
+	"fmt"
+	"go/ast"
+	"go/scanner"
+	"go/token"
+	"path/filepath"
+)
+
+// scannedDefinition answers a query whose identifier is missing from the
+// AST of the queried file because of a syntax error near it, as is
+// common while typing.  The identifier at the query offset is found with
+// the scanner and resolved by name: as a member of the imported package
+// that qualifies it, as the last declaration of the name before it in
+// the enclosing function, or as a package-level declaration of the file.
+// It reports whether the query was answered.
+func scannedDefinition(q *Query, qpos *queryPos) (bool, error) {
+	f := qpos.path[len(qpos.path)-1].(*ast.File)
+	tf := qpos.fset.File(f.Pos())
+	src, err := readFile(q.Build, tf.Name())
+	if err != nil || len(src) != tf.Size() {
+		return false, nil
+	}
+	name, qual := scanIdent(src, tf.Offset(qpos.start), q.identEnd)
+	if name == "" {
+		return false, nil
+	}
+	q.logf("scanned identifier %s near syntax error: %v", name, qpos.parseErr)
+
+	if qual != "" {
+		pkg := importedPackage(f, qual)
+		if pkg == "" {
+			return false, nil // e.g. a field or method selection
+		}
+		base := qpos.fset.Base()
+		tok, pos, err := findPackageMember(q, qpos.fset, filepath.Dir(tf.Name()), pkg, name)
+		q.stats.FilesParsed += countFiles(qpos.fset, base)
+		if err != nil {
+			q.logf("scanning package %q: %v", pkg, err)
+			return false, nil
+		}
+		q.stats.Strategy = StrategyPackageScan
+		q.Output(qpos.fset, &definitionResult{
+			pos:   pos,
+			descr: fmt.Sprintf("%s %s.%s", tok, pkg, name),
+			kind:  tokenKind(tok),
+			id:    ObjectID{PkgPath: pkg, Name: name},
+		})
+		return true, nil
+	}
+
+	obj := lastDeclared(f, qpos.path, qpos.start, name)
+	if obj == nil {
+		return false, nil
+	}
+	q.stats.Strategy = StrategyParser
+	q.Output(qpos.fset, &definitionResult{
+		pos:   obj.Pos(),
+		descr: fmt.Sprintf("%s %s", obj.Kind, obj.Name),
+		kind:  astObjectKind(obj),
+		id:    astObjectID(q, tf.Name(), f, obj),
+	})
+	return true, nil
+}
+
+// scanIdent returns the identifier at offset in src, or ending at offset
+// if identEnd is set, and the name that qualifies it, if it is preceded
+// by "name.".
+func scanIdent(src []byte, offset int, identEnd bool) (name, qual string) {
+	fset := token.NewFileSet()
+	file := fset.AddFile("", -1, len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, 0) // syntax errors are expected
+	var prev [2]string        // literals of the previous two tokens, "" if not an identifier
+	var period bool           // the previous token is a period
+	for {
+		pos, tok, lit := s.Scan()
+		off := file.Offset(pos)
+		if tok == token.EOF || off > offset {
+			return "", ""
+		}
+		if tok == token.IDENT && (offset < off+len(lit) || identEnd && offset == off+len(lit)) {
+			if period {
+				qual = prev[0]
+			}
+			return lit, qual
+		}
+		if tok != token.IDENT {
+			lit = ""
+		}
+		prev[0], prev[1] = prev[1], lit
+		period = tok == token.PERIOD
+	}
+}
+
+// lastDeclared returns the object of the last declaration of name before
+// pos in the innermost function of path, or the package-level object of
+// f named name.
+func lastDeclared(f *ast.File, path []ast.Node, pos token.Pos, name string) *ast.Object {
+	for _, n := range path {
+		switch n.(type) {
+		case *ast.FuncDecl, *ast.FuncLit:
+		default:
+			continue
+		}
+		var obj *ast.Object
+		ast.Inspect(n, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok && id.Name == name && id.Obj != nil {
+				if p := id.Obj.Pos(); p.IsValid() && p < pos && (obj == nil || p > obj.Pos()) {
+					obj = id.Obj
+				}
+			}
+			return true
+		})
+		if obj != nil {
+			return obj
+		}
+	}
+	return f.Scope.Lookup(name)
+}
@@ -0,0 +1,149 @@
+package godef
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// DefaultSnippetLines is the default maximum number of lines in a snippet.
+const DefaultSnippetLines = 40
+
+var snippetPrinter = printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+
+// Snippet returns the formatted source of the declaration that declares
+// the identifier at offset in src, the contents of filename.  Function
+// bodies are omitted and, if maxLines is greater than zero, snippets
+// longer than maxLines are truncated and end with a marker comment.
+//
+// Snippet is intended to format the Position returned by Define for
+// display (e.g. in a hover).
+func Snippet(filename string, src []byte, offset, maxLines int) (string, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if f == nil {
+		return "", err
+	}
+	tf := fset.File(f.Pos())
+	if offset < 0 || offset > tf.Size() {
+		return "", fmt.Errorf("offset %d is beyond end of file", offset)
+	}
+	pos := tf.Pos(offset)
+
+	path, _ := astutil.PathEnclosingInterval(f, pos, pos)
+	node, prefix := snippetNode(path)
+	if node == nil {
+		return "", errors.New("no declaration here")
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(prefix)
+	if field, ok := node.(*ast.Field); ok {
+		err = printField(&buf, fset, field)
+	} else {
+		err = snippetPrinter.Fprint(&buf, fset, &printer.CommentedNode{
+			Node:     node,
+			Comments: f.Comments,
+		})
+	}
+	if err != nil {
+		return "", err
+	}
+	return truncateLines(strings.TrimRight(buf.String(), "\n"), maxLines), nil
+}
+
+// printField prints a struct field or parameter, which go/printer does not
+// support printing directly.
+func printField(buf *bytes.Buffer, fset *token.FileSet, field *ast.Field) error {
+	for i, id := range field.Names {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(id.Name)
+	}
+	if len(field.Names) != 0 {
+		buf.WriteByte(' ')
+	}
+	if err := snippetPrinter.Fprint(buf, fset, field.Type); err != nil {
+		return err
+	}
+	if field.Tag != nil {
+		buf.WriteByte(' ')
+		buf.WriteString(field.Tag.Value)
+	}
+	if field.Comment != nil {
+		for _, c := range field.Comment.List {
+			buf.WriteByte(' ')
+			buf.WriteString(c.Text)
+		}
+	}
+	return nil
+}
+
+// snippetNode returns the innermost declaration in path and the text,
+// if any, that must precede it when printed.
+func snippetNode(path []ast.Node) (ast.Node, string) {
+	for i, n := range path {
+		switch n := n.(type) {
+		case *ast.Field:
+			return n, ""
+		case *ast.FuncDecl:
+			fn := *n // copy
+			fn.Body = nil
+			return &fn, ""
+		case *ast.ValueSpec, *ast.TypeSpec:
+			// Print the entire declaration if it declares a single
+			// spec, otherwise print just the spec.
+			if i+1 < len(path) {
+				if decl, ok := path[i+1].(*ast.GenDecl); ok {
+					if !decl.Lparen.IsValid() {
+						return decl, ""
+					}
+					return n, decl.Tok.String() + " "
+				}
+			}
+			return n, ""
+		case *ast.AssignStmt:
+			if n.Tok == token.DEFINE {
+				return n, ""
+			}
+		case *ast.ImportSpec:
+			return n, "import "
+		case *ast.File:
+			if i > 0 && path[i-1] == n.Name {
+				// Package clause and its documentation
+				return &ast.File{Doc: n.Doc, Package: n.Package, Name: n.Name}, ""
+			}
+		case *ast.LabeledStmt:
+			return &ast.LabeledStmt{Label: n.Label, Colon: n.Colon, Stmt: &ast.EmptyStmt{}}, ""
+		}
+	}
+	return nil, ""
+}
+
+// truncateLines truncates s to at most max lines, if max is greater
+// than zero, replacing the trailing lines with a truncation marker.  If
+// max is one the marker is appended to the first line.
+func truncateLines(s string, max int) string {
+	if max <= 0 {
+		return s
+	}
+	n := strings.Count(s, "\n") + 1
+	if n <= max {
+		return s
+	}
+	if max == 1 {
+		first := s[:strings.IndexByte(s, '\n')]
+		return first + fmt.Sprintf(" // ... %d lines omitted", n-1)
+	}
+	lines := strings.SplitN(s, "\n", max)
+	lines = lines[:max-1]
+	return strings.Join(lines, "\n") + fmt.Sprintf("\n\t// ... %d lines omitted", n-len(lines))
+}
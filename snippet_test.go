@@ -0,0 +1,105 @@
+package godef
+
+import (
+	"strings"
+	"testing"
+)
+
+const snippetSrc = `// Package p is a test.
+package p
+
+import f "fmt"
+
+type T struct {
+	A int    // A comment
+	Bb string // Bb comment
+}
+
+const (
+	X = 1
+	Y = 2 // Y comment
+)
+
+func F(a, b int) int {
+	return a + b
+}
+
+func G() {
+	v := T{}
+	_ = v
+}
+`
+
+var snippetTests = []struct {
+	marker   string // declared identifier
+	maxLines int
+	exp      string
+}{
+	{
+		marker: "T struct",
+		exp: "type T struct {\n" +
+			"\tA  int    // A comment\n" +
+			"\tBb string // Bb comment\n" +
+			"}",
+	},
+	{
+		marker:   "T struct",
+		maxLines: 2,
+		exp:      "type T struct {\n\t// ... 3 lines omitted",
+	},
+	{
+		marker:   "T struct",
+		maxLines: 1,
+		exp:      "type T struct { // ... 3 lines omitted",
+	},
+	{
+		marker:   "p\n\nimport",
+		maxLines: 1,
+		exp:      "// Package p is a test. // ... 1 lines omitted",
+	},
+	{
+		marker: "Bb string",
+		exp:    "Bb string // Bb comment",
+	},
+	{
+		marker: "Y = 2",
+		exp:    "const Y = 2 // Y comment",
+	},
+	{
+		marker: "F(a, b",
+		exp:    "func F(a, b int) int",
+	},
+	{
+		marker: "v := ",
+		exp:    "v := T{}",
+	},
+	{
+		marker: "p\n\nimport",
+		exp:    "// Package p is a test.\npackage p",
+	},
+	{
+		marker: "\"fmt\"",
+		exp:    "import f \"fmt\"",
+	},
+	{
+		marker: "f \"fmt\"",
+		exp:    "import f \"fmt\"",
+	},
+}
+
+func TestSnippet(t *testing.T) {
+	for _, x := range snippetTests {
+		offset := strings.Index(snippetSrc, x.marker)
+		if offset < 0 {
+			t.Fatalf("marker %q not found", x.marker)
+		}
+		s, err := Snippet("p.go", []byte(snippetSrc), offset, x.maxLines)
+		if err != nil {
+			t.Errorf("(%+v): %v", x, err)
+			continue
+		}
+		if s != x.exp {
+			t.Errorf("(%+v):\nexp: %q\ngot: %q", x, x.exp, s)
+		}
+	}
+}
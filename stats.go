@@ -0,0 +1,95 @@
+package godef
+
+import (
+	"go/token"
+	"time"
+)
+
+// A Strategy is how a query was answered.
+type Strategy string
+
+const (
+	StrategyPackage     Strategy = "package"      // a package clause, import spec or qualifier
+	StrategyParser      Strategy = "parser"       // an object resolved by the parser
+	StrategyPackageScan Strategy = "package scan" // a scan of the declarations of an imported package
+	StrategyTypeChecker Strategy = "type checker" // the type-checked program
+	StrategyDirective   Strategy = "directive"    // a file named by a //go:embed or //go:generate directive
+	StrategyApproximate Strategy = "approximate"  // a declaration of the name found by a textual search (see Config.BestEffort)
+	StrategyRecovered   Strategy = "recovered"    // an object of the name found in a package with type errors (see Result.TypeError)
+)
+
+// Stats are the timings and counts of the phases of a query.
+type Stats struct {
+	Strategy    Strategy
+	ParseTime   time.Duration // parsing the queried file
+	LoadTime    time.Duration // loading and type-checking the program, zero if not needed
+	Total       time.Duration // the whole query, including the extent, candidates and analyzers of the result
+	FilesParsed int           // varies between queries as packages are scanned concurrently
+	CacheHits   int           // programs reused from Config.ProgramCache
+	Rechecks    int           // packages type-checked again in a program reused from Config.ProgramCache
+}
+
+// loadProgram loads the program of q (see loadProgram), or reuses q.prog
+// or the program in q.cache, and records the time it took and the files parsed in
+// q.stats.  The program is kept in q.lprog.
+func (q *Query) loadProgram() (program, error) {
+	if q.prog != nil {
+		q.stats.Strategy = StrategyTypeChecker
+		q.lprog = q.prog
+		return q.prog, nil
+	}
+	start := q.env().Now()
+	var key string
+	if q.cache != nil {
+		end := q.startSpan(SpanCache)
+		var err error
+		key, err = programCacheKey(q)
+		if err != nil {
+			end(err)
+			return nil, err
+		}
+		lprog := q.cache.get(q, key)
+		end(nil)
+		q.cacheEvent(CacheProgram, lprog != nil)
+		if lprog != nil {
+			q.stats.LoadTime += since(q.env(), start)
+			q.stats.Strategy = StrategyTypeChecker
+			q.stats.CacheHits++
+			q.logf("reused cached program in %v", q.stats.LoadTime)
+			q.lprog = lprog
+			return lprog, nil
+		}
+	}
+	base := 0
+	if q.astCache != nil {
+		base = q.astCache.fileSet().Base()
+	}
+	end := q.startSpan(SpanLoad)
+	lprog, err := loadProgram(q)
+	end(err)
+	q.stats.LoadTime += since(q.env(), start)
+	if err != nil {
+		return nil, err
+	}
+	q.stats.Strategy = StrategyTypeChecker
+	q.stats.FilesParsed += countFiles(lprog.Fset(), base)
+	q.logf("loaded program with the %s backend in %v", programBackend, q.stats.LoadTime)
+	if q.cache != nil {
+		q.cache.put(q, key, lprog)
+	}
+	q.lprog = lprog
+	return lprog, nil
+}
+
+// countFiles returns the number of files in fset added at or after
+// base, e.g. fset.Base() before the files were parsed.
+func countFiles(fset *token.FileSet, base int) int {
+	n := 0
+	fset.Iterate(func(f *token.File) bool {
+		if f.Base() >= base {
+			n++
+		}
+		return true
+	})
+	return n
+}
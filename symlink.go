@@ -0,0 +1,112 @@
+package godef
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// A SymlinkPolicy determines how symbolic links in the paths of results
+// are handled.
+type SymlinkPolicy int
+
+const (
+	// SymlinkPreserve reports paths as they were loaded, which may be
+	// either the real path or a path through a symbolic link.
+	SymlinkPreserve SymlinkPolicy = iota
+
+	// SymlinkResolve reports the real path, with all symbolic links
+	// evaluated (e.g. the module cache rather than a linked workspace).
+	SymlinkResolve
+
+	// SymlinkPreferWorkspace reports paths beneath the directory of the
+	// queried file, or one of its parents or a GOPATH entry, through
+	// that directory even if it is reached by a symbolic link.  Other
+	// paths are resolved.
+	SymlinkPreferWorkspace
+)
+
+var symlinkPolicyNames = [...]string{
+	SymlinkPreserve:        "preserve",
+	SymlinkResolve:         "resolve",
+	SymlinkPreferWorkspace: "workspace",
+}
+
+func (p SymlinkPolicy) String() string {
+	if 0 <= int(p) && int(p) < len(symlinkPolicyNames) {
+		return symlinkPolicyNames[p]
+	}
+	return fmt.Sprintf("SymlinkPolicy(%d)", int(p))
+}
+
+// ParseSymlinkPolicy returns the SymlinkPolicy named s, which is one of
+// "preserve", "resolve" or "workspace".
+func ParseSymlinkPolicy(s string) (SymlinkPolicy, error) {
+	for p, name := range symlinkPolicyNames {
+		if s == name {
+			return SymlinkPolicy(p), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid symlink policy: %q", s)
+}
+
+// apply returns filename rewritten according to policy p.  Workspaces
+// are the directories, in priority order, that results should be
+// reported relative to with SymlinkPreferWorkspace.
+func (p SymlinkPolicy) apply(filename string, workspaces []string) string {
+	if p == SymlinkPreserve || filename == "" {
+		return filename
+	}
+	real, err := filepath.EvalSymlinks(filename)
+	if err != nil {
+		return filename
+	}
+	if p == SymlinkPreferWorkspace {
+		for _, dir := range workspaces {
+			realDir, err := filepath.EvalSymlinks(dir)
+			if err != nil {
+				continue
+			}
+			if rel, ok := hasFilePathPrefix(real, realDir); ok {
+				return filepath.Join(dir, rel)
+			}
+		}
+	}
+	return real
+}
+
+// hasFilePathPrefix reports whether filename is beneath dir and returns
+// its path relative to dir.
+func hasFilePathPrefix(filename, dir string) (string, bool) {
+	dir = filepath.Clean(dir)
+	if !strings.HasSuffix(dir, string(filepath.Separator)) {
+		dir += string(filepath.Separator)
+	}
+	if !strings.HasPrefix(filename, dir) {
+		return "", false
+	}
+	return filename[len(dir):], true
+}
+
+// symlinkWorkspaces returns the directories that results of a query of
+// filename are reported relative to with SymlinkPreferWorkspace: the
+// directory of filename and its parents, innermost first, followed by
+// the entries of gopath.
+func symlinkWorkspaces(env Environment, filename, gopath string) []string {
+	var dirs []string
+	if abs, err := absPath(env, filename); err == nil {
+		dir := filepath.Dir(abs)
+		for {
+			dirs = append(dirs, dir)
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	if len(dirs) > 1 {
+		dirs = dirs[:len(dirs)-1] // ignore the root directory
+	}
+	return append(dirs, filepath.SplitList(gopath)...)
+}
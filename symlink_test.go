@@ -0,0 +1,72 @@
+package godef
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinkPolicy(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	tmp, err = filepath.EvalSymlinks(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	real := filepath.Join(tmp, "real")
+	if err := os.MkdirAll(filepath.Join(real, "p"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(tmp, "link")
+	if err := os.Symlink(real, link); err != nil {
+		t.Skip("symlinks not supported:", err)
+	}
+	other := filepath.Join(tmp, "other")
+	if err := os.Mkdir(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		filepath.Join(real, "p", "p.go"),
+		filepath.Join(other, "o.go"),
+	} {
+		if err := ioutil.WriteFile(name, []byte("package p\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	workspaces := []string{link}
+	tests := []struct {
+		policy   SymlinkPolicy
+		filename string
+		want     string
+	}{
+		{SymlinkPreserve, filepath.Join(link, "p", "p.go"), filepath.Join(link, "p", "p.go")},
+		{SymlinkPreserve, filepath.Join(real, "p", "p.go"), filepath.Join(real, "p", "p.go")},
+		{SymlinkResolve, filepath.Join(link, "p", "p.go"), filepath.Join(real, "p", "p.go")},
+		{SymlinkResolve, filepath.Join(real, "p", "p.go"), filepath.Join(real, "p", "p.go")},
+		{SymlinkPreferWorkspace, filepath.Join(real, "p", "p.go"), filepath.Join(link, "p", "p.go")},
+		{SymlinkPreferWorkspace, filepath.Join(link, "p", "p.go"), filepath.Join(link, "p", "p.go")},
+		{SymlinkPreferWorkspace, filepath.Join(other, "o.go"), filepath.Join(other, "o.go")},
+		{SymlinkResolve, filepath.Join(tmp, "missing.go"), filepath.Join(tmp, "missing.go")},
+	}
+	for _, x := range tests {
+		if got := x.policy.apply(x.filename, workspaces); got != x.want {
+			t.Errorf("%s.apply(%q) = %q; want: %q", x.policy, x.filename, got, x.want)
+		}
+	}
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for _, p := range []SymlinkPolicy{SymlinkPreserve, SymlinkResolve, SymlinkPreferWorkspace} {
+		got, err := ParseSymlinkPolicy(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseSymlinkPolicy(%q) = %v, %v; want: %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseSymlinkPolicy("invalid"); err == nil {
+		t.Error("expected error for invalid policy")
+	}
+}
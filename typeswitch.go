@@ -0,0 +1,94 @@
+package godef
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// typeSwitchClause returns the case clause enclosing path[0] of the type
+// switch "switch y := x.(type)" whose assignment is decl, the Decl of an
+// *ast.Object, or nil.  The symbolic variable y has the type of its
+// case in each clause.
+func typeSwitchClause(path []ast.Node, decl interface{}) *ast.CaseClause {
+	assign, ok := decl.(*ast.AssignStmt)
+	if !ok {
+		return nil
+	}
+	for i := 0; i+2 < len(path); i++ {
+		clause, ok := path[i].(*ast.CaseClause)
+		if !ok {
+			continue
+		}
+		if ts, ok := path[i+2].(*ast.TypeSwitchStmt); ok && ts.Assign == ast.Stmt(assign) {
+			return clause
+		}
+	}
+	return nil
+}
+
+// implicitClause returns the case clause of a type switch that
+// implicitly declares obj, the symbolic variable y of
+// "switch y := x.(type)" in the clause enclosing the query, or nil.
+func implicitClause(qpos *queryPos, obj types.Object) *ast.CaseClause {
+	for _, n := range qpos.path {
+		if clause, ok := n.(*ast.CaseClause); ok && qpos.info.Implicits[clause] == obj {
+			return clause
+		}
+	}
+	return nil
+}
+
+// typeSwitchDefinition reports the definition of id if it is the
+// symbolic variable y of "switch y := x.(type)", which the type checker
+// does not define, but implicitly declares in each case clause.  The
+// objects of the clauses are added to the result as candidates, at
+// their clauses.  It returns false if id is not such a variable.
+func typeSwitchDefinition(q *Query, lprog program, qpos *queryPos, id *ast.Ident) bool {
+	if len(qpos.path) < 3 || qpos.path[0] != ast.Node(id) {
+		return false
+	}
+	assign, ok := qpos.path[1].(*ast.AssignStmt)
+	if !ok || len(assign.Lhs) != 1 || assign.Lhs[0] != id {
+		return false
+	}
+	ts, ok := qpos.path[2].(*ast.TypeSwitchStmt)
+	if !ok || ts.Assign != assign {
+		return false
+	}
+	q.Output(lprog.Fset(), &definitionResult{
+		pos:   id.Pos(),
+		descr: "var " + id.Name,
+		kind:  KindVariable,
+	})
+	for _, stmt := range ts.Body.List {
+		clause := stmt.(*ast.CaseClause)
+		obj := qpos.info.Implicits[clause]
+		if obj == nil {
+			continue
+		}
+		reason := "default"
+		if clause.List != nil {
+			reason = "case " + exprListString(clause.List)
+		}
+		q.result.candidates = append(q.result.candidates, definitionResult{
+			pos:    clause.Case,
+			descr:  qpos.objectString(obj),
+			kind:   KindVariable,
+			reason: reason,
+		})
+	}
+	return true
+}
+
+// exprListString returns the comma-separated list of the expressions
+// of list.
+func exprListString(list []ast.Expr) string {
+	var s string
+	for i, x := range list {
+		if i > 0 {
+			s += ", "
+		}
+		s += types.ExprString(x)
+	}
+	return s
+}
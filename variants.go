@@ -0,0 +1,179 @@
+package godef
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	util "github.com/charlievieth/buildutil"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// variantCandidates returns the other declarations of the object id,
+// declared at pos, in the files of its package that are excluded by the
+// build context of q, e.g. the declarations of a function for other
+// operating systems.  The Reason of each is the constraint that selects
+// its file.
+func variantCandidates(q *Query, pos token.Position, id ObjectID) []Candidate {
+	if id.Name == "" || pos.Filename == "" {
+		return nil
+	}
+	src, err := readFile(q.Build, pos.Filename)
+	if err != nil {
+		return nil
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), pos.Filename, src, parser.PackageClauseOnly)
+	if err != nil {
+		return nil
+	}
+	pkgName := f.Name.Name
+
+	dir := filepath.Dir(pos.Filename)
+	fis, err := buildutil.ReadDir(q.Build, dir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, fi := range fis {
+		name := fi.Name()
+		if fi.IsDir() || !strings.HasSuffix(name, ".go") ||
+			strings.HasSuffix(name, "_test.go") != strings.HasSuffix(pos.Filename, "_test.go") {
+			continue
+		}
+		if filename := filepath.Join(dir, name); filename != pos.Filename {
+			names = append(names, filename)
+		}
+	}
+	sort.Strings(names)
+
+	var results []Candidate
+	fset := q.newFileSet()
+	for _, filename := range names {
+		if ok, err := q.Build.MatchFile(dir, filepath.Base(filename)); ok || err != nil {
+			continue // the declaration would conflict with that at pos
+		}
+		src, err := readFile(q.Build, filename)
+		if err != nil {
+			continue
+		}
+		f, err := parseVariant(q, fset, filename, src)
+		if err != nil || f.Name.Name != pkgName {
+			continue
+		}
+		if declPos, descr := findVariantDecl(f, id); declPos.IsValid() {
+			results = append(results, Candidate{
+				Position:    tokenPosition(fset.Position(declPos)),
+				Description: descr,
+				Kind:        q.result.kind,
+				Reason:      fileConstraint(q, filename, src),
+			})
+		}
+	}
+	return results
+}
+
+func parseVariant(q *Query, fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+	if q.astCache != nil {
+		return q.astCache.parseFile(fset, filename, src, parser.ParseComments)
+	}
+	return parser.ParseFile(fset, filename, src, parser.ParseComments)
+}
+
+// findVariantDecl returns the position of the declaration of id in f and
+// a description of it, or token.NoPos.
+func findVariantDecl(f *ast.File, id ObjectID) (token.Pos, string) {
+	if id.Recv == "" {
+		var pos token.Pos
+		var descr string
+		packageDecls(f, func(tok token.Token, ident *ast.Ident) bool {
+			if ident.Name == id.Name {
+				pos, descr = ident.Pos(), tok.String()+" "+ident.Name
+				return false
+			}
+			return true
+		})
+		return pos, descr
+	}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv != nil && len(decl.Recv.List) == 1 && decl.Name.Name == id.Name &&
+				recvTypeName(decl.Recv.List[0].Type) == id.Recv {
+				return decl.Name.Pos(), "func (" + id.Recv + ") " + id.Name
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				ts, ok := spec.(*ast.TypeSpec)
+				if !ok || ts.Name.Name != id.Recv {
+					continue
+				}
+				st, ok := ts.Type.(*ast.StructType)
+				if !ok {
+					continue
+				}
+				for _, field := range st.Fields.List {
+					for _, name := range field.Names {
+						if name.Name == id.Name {
+							return name.Pos(), "field " + id.Recv + "." + id.Name
+						}
+					}
+				}
+			}
+		}
+	}
+	return token.NoPos, ""
+}
+
+// recvTypeName returns the name of the receiver type expr, T or *T.
+func recvTypeName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if id, ok := expr.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+// fileConstraint returns a description of the constraints that select
+// filename, with contents src: the GOOS and GOARCH of its name and its
+// build constraint lines, e.g. "file name: windows,amd64" or
+// "//go:build linux && !purego".
+func fileConstraint(q *Query, filename string, src []byte) string {
+	var parts []string
+	tags := make(map[string]bool)
+	util.GoodOSArchFile(q.Build, filename, tags)
+	if len(tags) != 0 {
+		var terms []string
+		for tag := range tags {
+			terms = append(terms, tag)
+		}
+		sort.Strings(terms)
+		parts = append(parts, "file name: "+strings.Join(terms, ","))
+	}
+	var goBuild string
+	var plusBuild []string
+	for _, line := range strings.Split(string(src), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "package ") {
+			break
+		}
+		if strings.HasPrefix(line, "//go:build ") {
+			goBuild = line
+		} else if strings.HasPrefix(line, "// +build ") {
+			plusBuild = append(plusBuild, line)
+		}
+	}
+	if goBuild != "" {
+		parts = append(parts, goBuild)
+	} else {
+		parts = append(parts, plusBuild...)
+	}
+	if len(parts) == 0 {
+		return "build variant"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,120 @@
+package godef
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/build"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// A VersionWarning reports that the module containing the queried file
+// requires a newer version of Go than godef was built with, which may
+// cause syntax or standard library APIs to be misunderstood.
+type VersionWarning struct {
+	GoMod     string // path of the go.mod file
+	GoVersion string // version from the go.mod "go" directive
+	Runtime   string // version godef was built with (runtime.Version)
+}
+
+func (w *VersionWarning) Error() string {
+	return fmt.Sprintf("%s requires go %s but godef was built with %s",
+		w.GoMod, w.GoVersion, w.Runtime)
+}
+
+// checkGoVersion returns a *VersionWarning if the go.mod file enclosing
+// filename requires a newer version of Go than runtime.Version.
+func checkGoVersion(ctxt *build.Context, filename string) *VersionWarning {
+	return checkGoVersionFor(ctxt, filename, runtime.Version())
+}
+
+func checkGoVersionFor(ctxt *build.Context, filename, goVersion string) *VersionWarning {
+	current, ok := parseGoVersion(strings.TrimPrefix(goVersion, "go"))
+	if !ok {
+		return nil // development version
+	}
+	gomod, data := findGoMod(ctxt, filepath.Dir(filename))
+	if gomod == "" {
+		return nil
+	}
+	want := goDirective(data)
+	required, ok := parseGoVersion(want)
+	if !ok {
+		return nil
+	}
+	for i := range required {
+		if required[i] != current[i] {
+			if required[i] > current[i] {
+				return &VersionWarning{GoMod: gomod, GoVersion: want, Runtime: goVersion}
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// findGoMod returns the path and contents of the go.mod file in dir or
+// its closest parent directory in the file system of ctxt, if non-nil.
+func findGoMod(ctxt *build.Context, dir string) (string, []byte) {
+	for {
+		name := filepath.Join(dir, "go.mod")
+		if data, err := readFile(ctxt, name); err == nil {
+			return name, data
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// moduleRoot returns the directory of the go.mod file in dir or its
+// closest parent directory, or "" if there is none.
+func moduleRoot(ctxt *build.Context, dir string) string {
+	if name, _ := findGoMod(ctxt, dir); name != "" {
+		return filepath.Dir(name)
+	}
+	return ""
+}
+
+// goDirective returns the version of the "go" directive in data, the
+// contents of a go.mod file.
+func goDirective(data []byte) string {
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) >= 2 && f[0] == "go" {
+			return f[1]
+		}
+	}
+	return ""
+}
+
+// parseGoVersion parses Go versions of the form "1.N", "1.N.P" and
+// "1.NrcP" into their major, minor and patch numbers.
+func parseGoVersion(s string) ([3]int, bool) {
+	var v [3]int
+	if i := strings.IndexAny(s, "-+ "); i >= 0 {
+		s = s[:i]
+	}
+	// Prereleases sort before the release, ignore them.
+	if i := strings.IndexAny(s, "abcdefghijklmnopqrstuvwxyz"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
@@ -0,0 +1,48 @@
+package godef
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckGoVersion(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "godef-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "a", "b")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	gomod := filepath.Join(tmp, "go.mod")
+	filename := filepath.Join(dir, "b.go")
+
+	tests := []struct {
+		directive string
+		runtime   string
+		warn      bool
+	}{
+		{"1.15", "go1.15", false},
+		{"1.15", "go1.16.3", false},
+		{"1.21", "go1.20.14", true},
+		{"1.21.0", "go1.21rc2", false},
+		{"1.21.2", "go1.21.1", true},
+		{"1.30", "devel go1.31-abcdef", false},
+	}
+	for _, x := range tests {
+		data := []byte("module p\n\ngo " + x.directive + "\n")
+		if err := ioutil.WriteFile(gomod, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		w := checkGoVersionFor(nil, filename, x.runtime)
+		if (w != nil) != x.warn {
+			t.Errorf("(%+v): exp warning %t got %v", x, x.warn, w)
+		}
+		if w != nil && (w.GoMod != gomod || w.GoVersion != x.directive) {
+			t.Errorf("(%+v): unexpected warning: %+v", x, w)
+		}
+	}
+}
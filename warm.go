@@ -0,0 +1,78 @@
+package godef
+
+import (
+	"go/parser"
+	"path/filepath"
+	"strings"
+)
+
+// Warm populates Config.PackageIndex and Config.ASTCache, those that are
+// set, with the packages in dirs, so that the first queries of their
+// files and of their members are fast.  A directory ending in "/..."
+// includes the packages beneath it, as for the go command.  Relative
+// directories are resolved against Config.Dir.  Files are only added to
+// the ASTCache while it has room for them.  Warm returns the number of
+// packages that were warmed; packages that cannot be read are skipped.
+func (c *Config) Warm(dirs []string) (int, error) {
+	base, err := c.buildContext()
+	if err != nil {
+		return 0, err
+	}
+	// Read files as queries do, so that the indexed packages are valid
+	// for them; the index hashes the files of the overlay.
+	ctxt := useOverlay(base, c.Overlay)
+	q := &Query{
+		Build:        ctxt,
+		overlay:      c.Overlay,
+		Env:          c.env(),
+		logger:       c.Logger,
+		parseWorkers: c.ParseWorkers,
+		astCache:     c.ASTCache,
+		packageIndex: c.PackageIndex,
+	}
+	var pkgDirs []string
+	for _, dir := range dirs {
+		recursive := dir == "..." || strings.HasSuffix(dir, "/...")
+		dir = strings.TrimSuffix(strings.TrimSuffix(dir, "..."), "/")
+		if dir == "" {
+			dir = "."
+		}
+		abs, err := absPath(c.env(), filepath.FromSlash(dir))
+		if err != nil {
+			return 0, err
+		}
+		if recursive {
+			pkgDirs = append(pkgDirs, packageDirs(ctxt, abs, false)...)
+		} else {
+			pkgDirs = append(pkgDirs, abs)
+		}
+	}
+
+	n := 0
+	for _, dir := range pkgDirs {
+		bp, err := ctxt.ImportDir(dir, 0)
+		if err != nil {
+			c.logf("warming %s: %v", dir, err)
+			continue
+		}
+		if c.PackageIndex != nil {
+			if _, err := c.PackageIndex.get(q, dir); err != nil {
+				c.logf("warming %s: %v", dir, err)
+				continue
+			}
+		}
+		if c.ASTCache != nil {
+			fset := c.ASTCache.fileSet()
+			for _, name := range bp.GoFiles {
+				if c.ASTCache.Len() >= c.ASTCache.size {
+					break // warming more files would empty the cache
+				}
+				// The mode of the queried file, see fastQueryPos.
+				parseFile(fset, ctxt, c.ASTCache, ".", filepath.Join(dir, name), parser.Mode(0))
+			}
+		}
+		n++
+	}
+	c.logf("warmed %d packages", n)
+	return n, nil
+}
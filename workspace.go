@@ -0,0 +1,74 @@
+package godef
+
+import (
+	"go/build"
+	"path/filepath"
+	"strings"
+)
+
+// A WorkspaceResolver infers the GOPATH workspace of queried files that
+// are not beneath any GOROOT or GOPATH directory.  The workspace of such
+// a file is the parent of its innermost enclosing "src" directory, e.g.
+// the workspace of /home/u/proj/src/example.com/p/p.go is /home/u/proj.
+//
+// The zero value prepends the inferred workspace to GOPATH.
+type WorkspaceResolver struct {
+	// Disabled disables workspace inference, GOPATH is only
+	// deduplicated.
+	Disabled bool
+
+	// Append adds the inferred workspace to the end of GOPATH so
+	// that it cannot shadow packages in the existing GOPATH entries.
+	Append bool
+}
+
+// Resolve returns the GOPATH of ctxt with the workspace of filename, if
+// any, added and duplicate or empty entries removed.
+func (r *WorkspaceResolver) Resolve(ctxt *build.Context, env Environment, filename string) string {
+	list := filepath.SplitList(ctxt.GOPATH)
+	if !r.Disabled {
+		if ws := r.workspace(ctxt, env, filename); ws != "" {
+			if r.Append {
+				list = append(list, ws)
+			} else {
+				list = append([]string{ws}, list...)
+			}
+		}
+	}
+	return joinPathList(list)
+}
+
+// workspace returns the inferred workspace of filename or "" if filename
+// is in a GOROOT or GOPATH directory or has no enclosing "src" directory.
+func (r *WorkspaceResolver) workspace(ctxt *build.Context, env Environment, filename string) string {
+	_, _, err := guessImportPath(env, filename, ctxt)
+	if err == nil {
+		return ""
+	}
+	if e, ok := err.(*PathError); ok && strings.Contains(e.Dir, "src") {
+		dirs := segments(e.Dir)
+		for i := len(dirs) - 1; i > 0; i-- {
+			if dirs[i] == "src" {
+				return strings.Join(dirs[:i], string(filepath.Separator))
+			}
+		}
+	}
+	return ""
+}
+
+// joinPathList joins the non-empty, unique (after cleaning) elements of
+// list, which is in priority order, into a path list.
+func joinPathList(list []string) string {
+	seen := make(map[string]bool, len(list))
+	uniq := list[:0:0]
+	for _, s := range list {
+		if s == "" {
+			continue
+		}
+		if c := filepath.Clean(s); !seen[c] {
+			seen[c] = true
+			uniq = append(uniq, s)
+		}
+	}
+	return strings.Join(uniq, string(filepath.ListSeparator))
+}
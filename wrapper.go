@@ -0,0 +1,96 @@
+package godef
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+
+	"github.com/charlievieth/godef/internal/span"
+	"golang.org/x/tools/go/buildutil"
+)
+
+// maxWrapperDepth is the maximum number of wrapper functions followed.
+const maxWrapperDepth = 8
+
+// followWrappers returns the functions forwarded to by the function
+// declared at pos, if it is a trivial wrapper (its body is a single
+// call or return of a call), and recursively by those functions.
+func followWrappers(q *Query, pos token.Position) []Candidate {
+	var results []Candidate
+	seen := map[token.Position]bool{pos: true}
+	for i := 0; i < maxWrapperDepth; i++ {
+		offset, ok := forwardedCall(q.Build, pos)
+		if !ok {
+			break
+		}
+		wq := *q // copy
+		wq.describe = false
+		wq.Pos = span.New(pos.Filename, offset).String()
+		if err := definition(&wq); err != nil {
+			break
+		}
+		switch wq.result.kind {
+		case KindFunction, KindMethod:
+		default:
+			return results // e.g. a type conversion
+		}
+		next := wq.position(wq.Fset, wq.result.pos)
+		if seen[next] {
+			break
+		}
+		seen[next] = true
+		results = append(results, Candidate{
+			Position:    tokenPosition(next),
+			Description: wq.result.descr,
+			Kind:        wq.result.kind,
+			Reason:      "called by wrapper " + pos.String(),
+		})
+		pos = next
+	}
+	return results
+}
+
+// forwardedCall returns the offset of the name of the function called
+// by the function declared at pos if the function's body consists of
+// only that call or a return of that call.
+func forwardedCall(ctxt *build.Context, pos token.Position) (int, bool) {
+	fset := token.NewFileSet()
+	f, _ := buildutil.ParseFile(fset, ctxt, nil, "", pos.Filename, parser.Mode(0))
+	if f == nil {
+		return 0, false
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Body == nil || len(fn.Body.List) != 1 {
+			continue
+		}
+		if fset.Position(fn.Name.Pos()).Offset != pos.Offset {
+			continue
+		}
+		var call *ast.CallExpr
+		switch stmt := fn.Body.List[0].(type) {
+		case *ast.ReturnStmt:
+			if len(stmt.Results) == 1 {
+				call, _ = stmt.Results[0].(*ast.CallExpr)
+			}
+		case *ast.ExprStmt:
+			call, _ = stmt.X.(*ast.CallExpr)
+		}
+		if call == nil {
+			return 0, false
+		}
+		var id *ast.Ident
+		switch fun := call.Fun.(type) {
+		case *ast.Ident:
+			id = fun
+		case *ast.SelectorExpr:
+			id = fun.Sel
+		}
+		if id == nil {
+			return 0, false
+		}
+		return fset.Position(id.Pos()).Offset, true
+	}
+	return 0, false
+}